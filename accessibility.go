@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// slideDescription describes the current slide in words, used as the alt text of the photo and
+// shown as a caption in accessibility mode
+func slideDescription(current slide, imageDirectory string, messages *messageCatalog) string {
+	var parts []string
+	switch {
+	case current.Countdown != nil:
+		parts = append(parts, messages.text("describe.countdown", current.Countdown.Title, messages.date(current.Countdown.Target, "date.weekday")))
+	case current.Intro != nil:
+		parts = append(parts, messages.text("describe.intro", current.Intro.Name, current.Intro.Images))
+		if dates := messages.dateRange(current.Intro.DateFrom, current.Intro.DateTo); dates != "" {
+			parts = append(parts, dates)
+		}
+	default:
+		parts = append(parts, photoDescription(current.Image, imageDirectory, messages))
+	}
+	if current.Note != nil && current.Note.Text != "" {
+		parts = append(parts, current.Note.Text)
+	}
+	return strings.Join(parts, ". ")
+}
+
+// photoDescription names the album a photo is from and when it was taken, when that is known, followed by its caption
+func photoDescription(file, imageDirectory string, messages *messageCatalog) string {
+	if file == "" {
+		return messages.text("describe.noPhoto")
+	}
+
+	var album, taken string
+	if rel, err := filepath.Rel(imageDirectory, filepath.Dir(file)); err == nil && rel != "." {
+		album = filepath.ToSlash(rel)
+	}
+	if meta, err := imageMetadataCache().get(file); err == nil && meta.DateTaken != 0 {
+		taken = messages.date(time.Unix(meta.DateTaken, 0), "date.long")
+	}
+	var description string
+	switch {
+	case album != "" && taken != "":
+		description = messages.text("describe.photoFromTaken", album, taken)
+	case album != "":
+		description = messages.text("describe.photoFrom", album)
+	case taken != "":
+		description = messages.text("describe.photoTaken", taken)
+	default:
+		description = messages.text("describe.photo")
+	}
+	if caption := photoCaption(file); caption != "" {
+		description += ". " + caption
+	}
+	return description
+}
+
+// accessibilityHandler reads (GET) or sets (POST) whether a screen uses accessibility mode, a high contrast
+// and reduced motion view with large captions describing each photo
+func accessibilityHandler(w http.ResponseWriter, r *http.Request) {
+	screen := r.URL.Query().Get("screen")
+	if screen == "" {
+		http.Error(w, "The screen parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	type accessibilitySetting struct {
+		Enabled bool `json:"enabled"`
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		preset, _ := getScreenPreset(screen)
+		writeJSON(w, accessibilitySetting{Enabled: preset.Accessibility})
+
+	case http.MethodPost:
+		var setting accessibilitySetting
+		if err := json.NewDecoder(r.Body).Decode(&setting); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		preset, _ := getScreenPreset(screen)
+		preset.Accessibility = setting.Enabled
+		if err := saveScreenPreset(screen, preset); err != nil {
+			http.Error(w, "Error saving screen preset: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error saving screen preset: %v", err)
+			return
+		}
+		log.Printf("Accessibility mode for screen %q set to %t", screen, setting.Enabled)
+		writeJSON(w, setting)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
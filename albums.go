@@ -0,0 +1,189 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlbumConfig is a named collection of images with a slideshow of its own, served at /album/<name>
+type AlbumConfig struct {
+	Name           string `json:"name" desc:"Name of the album, its slideshow is served at /album/<name>" required:"true"`
+	Directory      string `json:"directory" desc:"Images in the album, a directory relative to imageDirectory, clusters/<id> for a photo cluster or starred for the favorites" required:"true"`
+	DateFrom       string `json:"dateFrom" desc:"Only photos taken on or after this date (YYYY-MM-DD) are in the album" format:"date"`
+	DateTo         string `json:"dateTo" desc:"Only photos taken on or before this date (YYYY-MM-DD) are in the album" format:"date"`
+	DisplaySeconds int    `json:"displaySeconds" desc:"Number of seconds each image is displayed, 0 for the main displaySeconds" default:"0"`
+	SelectionMode  string `json:"selectionMode" desc:"How the next image is chosen, the main selectionMode when empty" enum:"random,shuffle,weighted,rated,sequential"`
+	FitMode        string `json:"fitMode" desc:"How photos are fitted to the screen, the display fitMode when empty" enum:"contain,cover,smart-crop,top-crop"`
+}
+
+// albumRotation is the slideshow of a named album, running alongside the main rotation
+type albumRotation struct {
+	cfg      AlbumConfig
+	selector imageSelector
+	current  slide
+	mutex    sync.Mutex // To ensure thread-safe access to `current` and the state of `selector`
+
+	dates      *albumDates
+	datesMutex sync.Mutex // To ensure thread-safe access to `dates`
+}
+
+// albumDates is whether each of an album's images looked at so far was taken inside the album's dates, kept as
+// working it out reads the photo's metadata, and dropped when the library changes
+type albumDates struct {
+	dateFrom, dateTo string    // as configured, to notice when they change
+	from, to         time.Time // as parsed
+	inRange          map[string]bool
+	err              error
+}
+
+var (
+	albumRotations      = map[string]*albumRotation{}
+	albumRotationsMutex sync.Mutex // To ensure thread-safe access to `albumRotations`
+)
+
+// startAlbumRotations starts the slideshow of each named album.  Albums are only read at startup, so adding or
+// changing one takes a restart.
+func startAlbumRotations(config *Config) {
+	albumRotationsMutex.Lock()
+	defer albumRotationsMutex.Unlock()
+
+	for _, cfg := range config.Albums {
+		if cfg.Name == "" || strings.Contains(cfg.Name, "/") {
+			log.Printf("Skipping album %q, its name must be set and can't contain a slash", cfg.Name)
+			continue
+		}
+		if albumRotations[cfg.Name] != nil {
+			log.Printf("Skipping album %q, another album has the same name", cfg.Name)
+			continue
+		}
+		rotation := &albumRotation{cfg: cfg}
+		rotation.selector = newImageSelector(rotation.config(config))
+		albumRotations[cfg.Name] = rotation
+		go rotation.run(config)
+	}
+}
+
+// findAlbumRotation returns the slideshow of a named album, nil when there is no album by that name
+func findAlbumRotation(name string) *albumRotation {
+	albumRotationsMutex.Lock()
+	defer albumRotationsMutex.Unlock()
+	return albumRotations[name]
+}
+
+// config returns config with the album's interval and selection mode in place of the main ones
+func (a *albumRotation) config(config *Config) *Config {
+	updated := *config
+	if a.cfg.DisplaySeconds > 0 {
+		updated.DisplaySeconds = a.cfg.DisplaySeconds
+	}
+	if a.cfg.SelectionMode != "" {
+		updated.SelectionMode = a.cfg.SelectionMode
+	}
+	return &updated
+}
+
+// currentSlide returns the slide the album's slideshow is on, empty when the album has no images
+func (a *albumRotation) currentSlide() slide {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.current
+}
+
+// files returns the images in the album, recomputed each time as favorites and the library change
+func (a *albumRotation) files(config *Config) []string {
+	files := albumFiles(currentLibrary(), config.ImageDirectory, a.cfg.Directory)
+	if a.cfg.DateFrom == "" && a.cfg.DateTo == "" {
+		return files
+	}
+	return a.datedFiles(files)
+}
+
+// datedFiles returns the files taken inside the album's dates, reading the dates of only those not looked at since
+// the library or the dates last changed.  Invalid dates are logged once and the album shows every image.
+func (a *albumRotation) datedFiles(files []string) []string {
+	a.datesMutex.Lock()
+	defer a.datesMutex.Unlock()
+	if a.dates == nil || a.dates.dateFrom != a.cfg.DateFrom || a.dates.dateTo != a.cfg.DateTo {
+		dates := &albumDates{dateFrom: a.cfg.DateFrom, dateTo: a.cfg.DateTo, inRange: map[string]bool{}}
+		dates.from, dates.to, dates.err = parseDateRange(a.cfg.DateFrom, a.cfg.DateTo)
+		if dates.err != nil {
+			log.Printf("Error in the dates of album %q: %v", a.cfg.Name, dates.err)
+		}
+		a.dates = dates
+	}
+	if a.dates.err != nil {
+		return files
+	}
+
+	var unchecked []string
+	for _, file := range files {
+		if _, ok := a.dates.inRange[file]; !ok {
+			unchecked = append(unchecked, file)
+		}
+	}
+	if len(unchecked) > 0 {
+		for _, file := range unchecked {
+			a.dates.inRange[file] = false
+		}
+		for _, file := range filterByDate(unchecked, a.dates.from, a.dates.to) {
+			a.dates.inRange[file] = true
+		}
+	}
+
+	var matched []string
+	for _, file := range files {
+		if a.dates.inRange[file] {
+			matched = append(matched, file)
+		}
+	}
+	return matched
+}
+
+// forgetAlbumDates drops the dates looked up for each album's images, so a changed library is looked at again
+func forgetAlbumDates() {
+	albumRotationsMutex.Lock()
+	defer albumRotationsMutex.Unlock()
+	for _, rotation := range albumRotations {
+		rotation.datesMutex.Lock()
+		rotation.dates = nil
+		rotation.datesMutex.Unlock()
+	}
+}
+
+// run moves the album's slideshow on each display interval for as long as the app runs, keeping the slide on
+// screen while the display is powered off
+func (a *albumRotation) run(config *Config) {
+	config = a.config(config)
+	interval := time.Duration(config.DisplaySeconds) * time.Second
+	var empty bool // logged once rather than each interval until images are added
+	for {
+		if !rotationPaused.Load() {
+			files := a.files(config)
+			if len(files) == 0 {
+				if !empty {
+					log.Printf("Album %q contains no images", a.cfg.Name)
+				}
+				empty = true
+				time.Sleep(interval)
+				continue
+			}
+			empty = false
+
+			a.mutex.Lock()
+			image := a.selector.next(files)
+			if image != "" {
+				a.current = slide{Image: image}
+			}
+			a.mutex.Unlock()
+
+			if image != "" {
+				log.Printf("Displaying image in album %q: %s", a.cfg.Name, image)
+				recordShown(image)
+				broadcastAlbumSlide(a.cfg.Name, slide{Image: image}, config)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
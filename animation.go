@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// minFrameDelay is how long browsers show a frame whose delay is shorter, many animations leave the delay at 0
+const minFrameDelay = 100 * time.Millisecond
+
+// animationLoop returns how long one loop of an animated GIF or PNG takes to play, 0 for a still image
+func animationLoop(file string) time.Duration {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(8)
+	if err != nil {
+		return 0
+	}
+	var loop time.Duration
+	switch {
+	case bytes.HasPrefix(magic, []byte("GIF8")):
+		loop, err = gifLoop(r)
+	case bytes.Equal(magic, []byte("\x89PNG\r\n\x1a\n")):
+		loop, err = apngLoop(r)
+	}
+	if err != nil {
+		return 0
+	}
+	return loop
+}
+
+// frameDelay returns how long browsers show a frame for
+func frameDelay(delay time.Duration) time.Duration {
+	if delay <= 10*time.Millisecond {
+		return minFrameDelay
+	}
+	return delay
+}
+
+// gifLoop adds up the delays of the frames of a GIF, walking its blocks without decoding them
+func gifLoop(r *bufio.Reader) (time.Duration, error) {
+	header := make([]byte, 13) // signature and logical screen descriptor
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	if header[10]&0x80 != 0 {
+		if _, err := r.Discard(3 << (header[10]&0x07 + 1)); err != nil {
+			return 0, err
+		}
+	}
+
+	frames := 0
+	var loop, delay time.Duration
+	for {
+		introducer, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch introducer {
+		case 0x21: // extension, the graphic control extension holds the delay of the next frame
+			label, err := r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			if label == 0xF9 {
+				control := make([]byte, 6)
+				if _, err := io.ReadFull(r, control); err != nil {
+					return 0, err
+				}
+				delay = time.Duration(binary.LittleEndian.Uint16(control[2:])) * 10 * time.Millisecond
+				if control[5] != 0 {
+					if err := skipGIFSubBlocks(r, control[5]); err != nil {
+						return 0, err
+					}
+				}
+				continue
+			}
+			if err := skipGIFSubBlocks(r, 0xFF); err != nil {
+				return 0, err
+			}
+		case 0x2C: // image descriptor
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(r, descriptor); err != nil {
+				return 0, err
+			}
+			if descriptor[8]&0x80 != 0 {
+				if _, err := r.Discard(3 << (descriptor[8]&0x07 + 1)); err != nil {
+					return 0, err
+				}
+			}
+			if _, err := r.ReadByte(); err != nil { // LZW minimum code size
+				return 0, err
+			}
+			if err := skipGIFSubBlocks(r, 0xFF); err != nil {
+				return 0, err
+			}
+			frames++
+			loop += frameDelay(delay)
+			delay = 0
+		case 0x3B: // trailer
+			if frames < 2 {
+				return 0, nil
+			}
+			return loop, nil
+		default:
+			return 0, errors.New("invalid GIF block")
+		}
+	}
+}
+
+// skipGIFSubBlocks skips a run of data sub blocks up to the empty one ending it.  first is the size of the first
+// block when it has already been read, 0xFF when it hasn't.
+func skipGIFSubBlocks(r *bufio.Reader, first byte) error {
+	size := int(first)
+	if first == 0xFF {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		size = int(b)
+	}
+	for size != 0 {
+		if _, err := r.Discard(size); err != nil {
+			return err
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		size = int(b)
+	}
+	return nil
+}
+
+// apngLoop adds up the delays of the frames of an animated PNG, 0 for a still PNG
+func apngLoop(r *bufio.Reader) (time.Duration, error) {
+	if _, err := r.Discard(8); err != nil {
+		return 0, err
+	}
+
+	animated := false
+	var loop time.Duration
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, err
+		}
+		length := int(binary.BigEndian.Uint32(header))
+		switch string(header[4:]) {
+		case "acTL": // animation control, which must come before the image data
+			control := make([]byte, length)
+			if _, err := io.ReadFull(r, control); err != nil {
+				return 0, err
+			}
+			animated = length >= 4 && binary.BigEndian.Uint32(control) > 1
+			length = 0
+		case "fcTL": // frame control, holding the frame's delay as a fraction of a second
+			control := make([]byte, length)
+			if _, err := io.ReadFull(r, control); err != nil {
+				return 0, err
+			}
+			if length >= 24 {
+				numerator, denominator := binary.BigEndian.Uint16(control[20:]), binary.BigEndian.Uint16(control[22:])
+				if denominator == 0 {
+					denominator = 100
+				}
+				loop += frameDelay(time.Duration(numerator) * time.Second / time.Duration(denominator))
+			}
+			length = 0
+		case "IDAT":
+			if !animated {
+				return 0, nil
+			}
+		case "IEND":
+			if !animated {
+				return 0, nil
+			}
+			return loop, nil
+		}
+		if _, err := r.Discard(length + 4); err != nil { // the chunk's data and CRC
+			return 0, err
+		}
+	}
+}
+
+// isAnimated reports whether a photo is an animated GIF or PNG, which are sent as they are rather than scaled or
+// turned so they keep playing
+func isAnimated(file string) bool {
+	meta, err := imageMetadataCache().get(file)
+	return err == nil && meta.AnimationMs > 0
+}
+
+// animatedInterval returns how long a slide is shown: the interval, or with animationTiming set to extend at least
+// one whole loop of an animated photo
+func animatedInterval(interval time.Duration, file string, config *Config) time.Duration {
+	if config.AnimationTiming != "extend" || file == "" {
+		return interval
+	}
+	meta, err := imageMetadataCache().get(file)
+	if err != nil || meta.AnimationMs <= 0 {
+		return interval
+	}
+	return max(interval, time.Duration(meta.AnimationMs)*time.Millisecond)
+}
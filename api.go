@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"randompic/client"
+)
+
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+// maxRequestBody bounds the size of JSON request bodies
+const maxRequestBody = 1 << 20
+
+// readBody reads the request body, up to maxRequestBody bytes
+func readBody(r *http.Request) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(r.Body, maxRequestBody))
+}
+
+// apiVersionPrefix is where the versioned control and status API is served.  Its routes and the types in the
+// client package only ever gain fields, anything incompatible belongs in a new version.
+const apiVersionPrefix = "/api/v1/"
+
+// legacyAPIPrefix is where the routes marked legacy are also served, at the unversioned paths the admin page and
+// older scripts use
+const legacyAPIPrefix = "/api/"
+
+// apiRoutes are the routes of the versioned API, relative to apiVersionPrefix, and whether each is also served
+// relative to legacyAPIPrefix
+var apiRoutes = []struct {
+	path    string
+	handler http.HandlerFunc
+	legacy  bool
+}{
+	{"health", healthzHandler, false},
+	{"stats", statsHandler, false},
+	{"current", currentHandler, true},
+	{"upcoming", upcomingHandler, true},
+	{"history", historyHandler, true},
+	{"next", nextHandler, true},
+	{"prev", prevHandler, true},
+	{"skip", skipHandler, true},
+	{"pause", pauseHandler, true},
+	{"resume", resumeHandler, true},
+	{"hold", holdHandler, true},
+	{"presence", presenceHandler, true},
+	{"ws", websocketHandler, false},
+	{"events", eventStreamHandler, false},
+	{"speed", speedHandler, true},
+	{"rescan", rescanHandler, true},
+	{"gc", gcHandler, true},
+	{"config", configHandler, false},
+	{"config/schema", configSchemaHandler, true},
+	{"config/validate", configValidateHandler, true},
+	{"profiles", profilesHandler, true},
+	{"preview", previewHandler, true},
+	{"event", eventHandler, true},
+	{"aspect", aspectHandler, true},
+	{"accessibility", accessibilityHandler, true},
+	{"clusters", clustersHandler, true},
+	{"notes", notesHandler, true},
+	{"blacklist", blacklistHandler, true},
+	{"private", privateHandler, true},
+	{"favorites", favoritesHandler, true},
+	{"ratings", ratingsHandler, true},
+	{"digest", digestHandler, true},
+	{"music", musicHandler, true},
+	{"problems", problemsHandler, true},
+	{"duplicates", duplicatesHandler, true},
+	{"filtered", filteredHandler, true},
+	{"pool", poolHandler, true},
+	{"pool/diff", poolDiffHandler, true},
+}
+
+var (
+	startedAt   = time.Now()
+	slidesShown atomic.Int64 // slides put on screen since the app started
+)
+
+// newAPIHandler returns the router for the versioned API and its legacy paths, which share the handlers, answering
+// every error with a client.ErrorBody
+func newAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	for _, route := range apiRoutes {
+		mux.HandleFunc(apiVersionPrefix+route.path, route.handler)
+		if route.legacy {
+			mux.HandleFunc(legacyAPIPrefix+route.path, route.handler)
+		}
+	}
+	mux.HandleFunc(legacyAPIPrefix, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "No such API endpoint: "+r.URL.Path, http.StatusNotFound)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errors := &jsonErrorWriter{ResponseWriter: w}
+		mux.ServeHTTP(errors, r)
+		errors.finish()
+	})
+}
+
+// jsonErrorWriter turns the plain text error responses written by http.Error into JSON error bodies,
+// leaving successful responses and JSON errors such as an unhealthy /health untouched
+type jsonErrorWriter struct {
+	http.ResponseWriter
+	status  int // set when an error response is being captured
+	message bytes.Buffer
+}
+
+func (w *jsonErrorWriter) WriteHeader(status int) {
+	if status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.status = status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *jsonErrorWriter) Write(data []byte) (int, error) {
+	if w.status != 0 {
+		return w.message.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so /ws can hijack the connection
+func (w *jsonErrorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes the captured error, if any, as JSON
+func (w *jsonErrorWriter) finish() {
+	if w.status == 0 {
+		return
+	}
+	w.Header().Del("X-Content-Type-Options")
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.status)
+	if err := json.NewEncoder(w.ResponseWriter).Encode(client.ErrorBody{
+		Status: w.status,
+		Error:  strings.TrimSpace(w.message.String()),
+	}); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+// configHandler returns the active configuration, with the profile applied and the options tagged secret left out
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+	redactSecrets(reflect.ValueOf(config))
+	writeJSON(w, config)
+}
+
+// statsHandler summarises the slideshow since the app started
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scanStatusLock.Lock()
+	lastScan := lastScanStatus
+	scanStatusLock.Unlock()
+
+	writeJSON(w, client.Stats{
+		StartedAt:        startedAt,
+		UptimeSeconds:    time.Since(startedAt).Seconds(),
+		Loaded:           libraryLoaded.Load(),
+		Images:           len(currentLibrary()),
+		SlidesShown:      slidesShown.Load(),
+		ConnectedDevices: connectedDevices(),
+		LastScan:         lastScan,
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// screenPresetsPath is where accepted per screen display presets are persisted
+const screenPresetsPath = "./randompic-screens.json"
+
+// screenPreset controls how images are fitted to a particular screen
+type screenPreset struct {
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	FitMode string  `json:"fitMode"` // contain shows the whole image, the other fit modes fill the screen by cropping
+	MaxCrop float64 `json:"maxCrop"` // when cropping, images needing a larger share cropped fall back to contain
+
+	Accessibility bool `json:"accessibility"` // high contrast, large captions and no motion, see accessibilityHandler
+}
+
+// screenPresets are the accepted presets, keyed by screen name
+var screenPresets = &jsonStore[screenPreset]{path: screenPresetsPath, what: "screen presets", userData: true}
+
+// getScreenPreset returns the saved preset for a screen, if there is one
+func getScreenPreset(name string) (screenPreset, bool) {
+	return screenPresets.get(name)
+}
+
+// saveScreenPreset stores the preset for a screen and writes all presets to disk
+func saveScreenPreset(name string, preset screenPreset) error {
+	screenPresets.set(name, preset)
+	return screenPresets.save()
+}
+
+// cropFraction is the share of an image of aspect ratio imageRatio cut off when it covers a screen of screenRatio.
+// The same share of the screen is left empty when the image is contained instead.
+func cropFraction(imageRatio, screenRatio float64) float64 {
+	return 1 - math.Min(imageRatio, screenRatio)/math.Max(imageRatio, screenRatio)
+}
+
+// aspectBucket counts the images falling into a named aspect ratio range
+type aspectBucket struct {
+	Label string  `json:"label"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// aspectReport summarises the aspect ratios of the library and the preset suggested for a screen
+type aspectReport struct {
+	Screen     string         `json:"screen"`
+	Images     int            `json:"images"`
+	Unknown    int            `json:"unknown"` // images whose dimensions could not be read
+	Portrait   int            `json:"portrait"`
+	Landscape  int            `json:"landscape"`
+	Square     int            `json:"square"`
+	Buckets    []aspectBucket `json:"buckets"`
+	MedianCrop float64        `json:"medianCrop"`
+	Suggested  screenPreset   `json:"suggested"`
+	Current    *screenPreset  `json:"current"`
+}
+
+// coverCropLimit is the median crop above which cover mode would cut off too much of a typical photo
+const coverCropLimit = 0.15
+
+// analyzeAspectRatios builds the aspect ratio report of the library for a screen of the given resolution
+func analyzeAspectRatios(files []string, width, height int) aspectReport {
+	report := aspectReport{
+		Buckets: []aspectBucket{
+			{Label: "tall portrait", Min: 0, Max: 0.7},
+			{Label: "portrait", Min: 0.7, Max: 0.95},
+			{Label: "square", Min: 0.95, Max: 1.05},
+			{Label: "landscape", Min: 1.05, Max: 1.6},
+			{Label: "wide", Min: 1.6, Max: 2.2},
+			{Label: "panorama", Min: 2.2, Max: 100},
+		},
+	}
+
+	screenRatio := float64(width) / float64(height)
+	cache := imageMetadataCache()
+	var crops []float64
+
+	for _, file := range files {
+		meta, err := cache.get(file)
+		if err != nil || meta.Width == 0 || meta.Height == 0 {
+			report.Unknown++
+			continue
+		}
+		report.Images++
+
+		ratio := float64(meta.Width) / float64(meta.Height)
+		for i := range report.Buckets {
+			if ratio >= report.Buckets[i].Min && ratio < report.Buckets[i].Max {
+				report.Buckets[i].Count++
+				break
+			}
+		}
+		switch {
+		case ratio < 0.95:
+			report.Portrait++
+		case ratio > 1.05:
+			report.Landscape++
+		default:
+			report.Square++
+		}
+		crops = append(crops, cropFraction(ratio, screenRatio))
+	}
+
+	if err := cache.save(); err != nil {
+		log.Printf("Error saving metadata cache: %v", err)
+	}
+
+	report.Suggested = screenPreset{Width: width, Height: height, FitMode: "contain"}
+	if len(crops) == 0 {
+		return report
+	}
+
+	sort.Float64s(crops)
+	report.MedianCrop = crops[len(crops)/2]
+	if report.MedianCrop <= coverCropLimit {
+		// most photos fit the screen closely, fill it and only fall back to contain for the outliers
+		report.Suggested.FitMode = "cover"
+		report.Suggested.MaxCrop = math.Max(coverCropLimit, crops[len(crops)*3/4])
+		report.Suggested.MaxCrop = math.Round(report.Suggested.MaxCrop*100) / 100
+	}
+	return report
+}
+
+// aspectHandler reports the aspect ratio statistics for a screen (GET) and applies a preset to it (POST)
+func aspectHandler(w http.ResponseWriter, r *http.Request) {
+	screen := r.URL.Query().Get("screen")
+	if screen == "" {
+		http.Error(w, "The screen parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var width, height int
+		if _, err := fmt.Sscanf(r.URL.Query().Get("resolution"), "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+			http.Error(w, "The resolution parameter must be in WIDTHxHEIGHT format", http.StatusBadRequest)
+			return
+		}
+
+		report := analyzeAspectRatios(currentLibrary(), width, height)
+		report.Screen = screen
+		if preset, ok := getScreenPreset(screen); ok {
+			report.Current = &preset
+		}
+		writeJSON(w, report)
+
+	case http.MethodPost:
+		// start from the saved preset so settings made elsewhere, such as accessibility, are kept
+		preset, _ := getScreenPreset(screen)
+		if err := json.NewDecoder(r.Body).Decode(&preset); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(fitModes, preset.FitMode) {
+			http.Error(w, "fitMode must be one of "+strings.Join(fitModes, ", "), http.StatusBadRequest)
+			return
+		}
+		if err := saveScreenPreset(screen, preset); err != nil {
+			http.Error(w, "Error saving screen preset: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error saving screen preset: %v", err)
+			return
+		}
+		log.Printf("Applied %s fit mode with max crop %.2f to screen %q", preset.FitMode, preset.MaxCrop, screen)
+		writeJSON(w, preset)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"randompic/client"
+)
+
+// blacklistPath is where the images that should never be shown again are persisted
+const blacklistPath = "./randompic-blacklist.json"
+
+var (
+	blacklist      map[string]bool // paths relative to imageDirectory
+	blacklistMutex sync.Mutex      // To ensure thread-safe access to `blacklist`
+)
+
+// loadBlacklist reads the blacklist from disk on first use
+func loadBlacklist() map[string]bool {
+	if blacklist != nil {
+		return blacklist
+	}
+
+	blacklist = map[string]bool{}
+	data, err := os.ReadFile(blacklistPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading blacklist: %v", err)
+		}
+		return blacklist
+	}
+	var images []string
+	if err := json.Unmarshal(data, &images); err != nil {
+		log.Printf("Error parsing blacklist: %v", err)
+	}
+	for _, image := range images {
+		blacklist[image] = true
+	}
+	return blacklist
+}
+
+// blacklistedImages returns the blacklisted paths in order, the caller must hold blacklistMutex
+func blacklistedImages() []string {
+	images := []string{}
+	for image := range loadBlacklist() {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// isBlacklisted reports whether an image has been marked to never be shown again
+func isBlacklisted(file, imageDirectory string) bool {
+	blacklistMutex.Lock()
+	defer blacklistMutex.Unlock()
+	return loadBlacklist()[relativeImagePath(file, imageDirectory)]
+}
+
+// setBlacklisted adds or removes an image, given relative to imageDirectory, and writes the blacklist to disk
+func setBlacklisted(image string, blacklisted bool) error {
+	blacklistMutex.Lock()
+	defer blacklistMutex.Unlock()
+
+	if blacklisted {
+		loadBlacklist()[image] = true
+	} else {
+		delete(loadBlacklist(), image)
+	}
+
+	data, err := json.MarshalIndent(blacklistedImages(), "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(blacklistPath, data)
+}
+
+// blacklistHandler lists the blacklisted images (GET), blacklists the current image or the one given (POST)
+// or allows an image to be shown again (DELETE ?image=)
+func blacklistHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		blacklistMutex.Lock()
+		images := blacklistedImages()
+		blacklistMutex.Unlock()
+		writeJSON(w, withoutPrivate(images))
+
+	case http.MethodPost:
+		var req client.ImageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		current := getCurrentSlide().Image
+		file := current
+		if req.Image != "" {
+			file = filepath.Join(config.ImageDirectory, filepath.FromSlash(req.Image))
+		}
+		if file == "" {
+			http.Error(w, "No image is being shown", http.StatusConflict)
+			return
+		}
+
+		image := relativeImagePath(file, config.ImageDirectory)
+		if err := setBlacklisted(image, true); err != nil {
+			http.Error(w, "Error saving blacklist: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error saving blacklist: %v", err)
+			return
+		}
+		log.Printf("Blacklisted %s", image)
+
+		// Drop it from the library straight away, and from the screen if it is showing
+		updateLibrary(nil, []string{file}, nil)
+		if file == current {
+			select {
+			case navigateRequests <- stepSkip:
+			default:
+			}
+		}
+		writeJSON(w, client.ImageResponse{Image: image})
+
+	case http.MethodDelete:
+		image := r.URL.Query().Get("image")
+		if image == "" {
+			http.Error(w, "The image parameter is required", http.StatusBadRequest)
+			return
+		}
+		file := filepath.Join(config.ImageDirectory, filepath.FromSlash(image))
+		if !isBlacklisted(file, config.ImageDirectory) {
+			http.Error(w, "The image is not blacklisted", http.StatusNotFound)
+			return
+		}
+		if err := setBlacklisted(image, false); err != nil {
+			http.Error(w, "Error saving blacklist: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error saving blacklist: %v", err)
+			return
+		}
+		log.Printf("Removed %s from the blacklist", image)
+
+		// Put it back in the library if it still exists and passes the filters
+		if _, err := os.Stat(file); err == nil {
+			if added := filterImages([]string{file}, config); len(added) > 0 {
+				addToLibrary(added, nil, nil, config)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
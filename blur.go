@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+const (
+	blurCacheDir    = "./randompic-blur"
+	blurImageSize   = 96   // pixels, the longest side of the blurred copy the browser stretches across the screen
+	blurRadius      = 4    // pixels of the blurred copy averaged on each side of a pixel
+	blurPasses      = 3    // box blurs applied, three look close to a gaussian blur
+	blurBrightness  = 0.75 // the copy is darkened so the photo stands out against it
+	blurJPEGQuality = 80
+)
+
+// blurredImageURL returns the URL of the blurred copy of a photo filling the screen around it
+func blurredImageURL(file, imageDirectory string) string {
+	return "/blur?path=" + url.QueryEscape(relativeImagePath(file, imageDirectory))
+}
+
+// blurredImageHandler serves a small, blurred and darkened copy of a photo from the image directory, which the page
+// stretches across the screen to fill the bars beside or above a photo that doesn't match the screen's shape.
+// Copies are cached on disk and made again when the photo changes.
+func blurredImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	relative := r.URL.Query().Get("path")
+	if relative == "" {
+		http.Error(w, "The path parameter is required", http.StatusBadRequest)
+		return
+	}
+	file := imageDirectoryFile(config.ImageDirectory, relative)
+	if isPrivate(file, config.ImageDirectory) {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !headerChecked(file) {
+		// a format the app can't decode, such as WebP, fills the screen around itself unblurred
+		setImageCacheHeaders(w, info, "")
+		http.ServeFile(w, r, file)
+		return
+	}
+
+	setImageCacheHeaders(w, info, "blur")
+	w.Header().Set("Content-Type", "image/jpeg")
+	cached := imageCopyPath(blurCacheDir, relativeImagePath(file, config.ImageDirectory))
+	if cachedInfo, err := os.Stat(cached); err == nil && !cachedInfo.ModTime().Before(info.ModTime()) {
+		http.ServeFile(w, r, cached)
+		return
+	}
+
+	blurred, err := blurredImage(file)
+	if err != nil {
+		http.Error(w, "Error reading image: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error blurring %s: %v", file, err)
+		quarantineFile(file, "blur", err)
+		return
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, blurred, &jpeg.Options{Quality: blurJPEGQuality}); err != nil {
+		http.Error(w, "Error encoding image: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error encoding the blurred copy of %s: %v", file, err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(cached), 0o755); err == nil {
+		if err := os.WriteFile(cached, encoded.Bytes(), 0o644); err != nil {
+			log.Printf("Error caching the blurred copy of %s: %v", file, err)
+		}
+	}
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(encoded.Bytes()))
+}
+
+// blurredImage scales an image down to a few dozen pixels, blurs and darkens it
+func blurredImage(file string) (*image.RGBA, error) {
+	imageWidth, imageHeight, err := probeDimensions(file)
+	if err != nil {
+		return nil, err
+	}
+	if imageWidth <= 0 || imageHeight <= 0 {
+		return nil, errors.New("image has no size")
+	}
+	scale := min(1, float64(blurImageSize)/float64(max(imageWidth, imageHeight)))
+	frame, err := renderFrame(file, max(int(float64(imageWidth)*scale), 1), max(int(float64(imageHeight)*scale), 1), "contain")
+	if err != nil {
+		return nil, err
+	}
+
+	for range blurPasses {
+		boxBlur(frame, blurRadius)
+	}
+	for i := 0; i < len(frame.Pix); i += 4 {
+		for c := range 3 {
+			frame.Pix[i+c] = uint8(float64(frame.Pix[i+c]) * blurBrightness)
+		}
+	}
+	return frame, nil
+}
+
+// boxBlur replaces each pixel of img with the average of the pixels up to radius away, horizontally and then
+// vertically, with the edge pixels repeated beyond the edges
+func boxBlur(img *image.RGBA, radius int) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	line := make([]uint8, max(width, height)*3)
+	blurLine := func(length int, offset func(i int) int) {
+		for i := range length {
+			var sum [3]int
+			for j := i - radius; j <= i+radius; j++ {
+				p := offset(min(max(j, 0), length-1))
+				sum[0] += int(img.Pix[p])
+				sum[1] += int(img.Pix[p+1])
+				sum[2] += int(img.Pix[p+2])
+			}
+			for c := range 3 {
+				line[i*3+c] = uint8(sum[c] / (2*radius + 1))
+			}
+		}
+		for i := range length {
+			p := offset(i)
+			copy(img.Pix[p:p+3], line[i*3:i*3+3])
+		}
+	}
+	for y := range height {
+		blurLine(width, func(x int) int { return img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y) })
+	}
+	for x := range width {
+		blurLine(height, func(y int) int { return img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y) })
+	}
+}
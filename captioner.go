@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCaptionPrompt  = "Describe this photo in one short sentence, as a caption for a photo frame. Answer with the caption only."
+	defaultOllamaURL      = "http://localhost:11434"
+	defaultOpenAIURL      = "https://api.openai.com/v1"
+	captionImageSize      = 768 // longest side in pixels of the copy of a photo sent to the model
+	captionJPEGQuality    = 85
+	maxGeneratedCaption   = 200 // characters kept of a caption, a model asked for one line can still ramble
+	captionRequestTimeout = 2 * time.Minute
+	captionRetry          = 5 * time.Minute
+	captionQueue          = 16
+)
+
+// AICaptionConfig writes a one line caption for each photo without one, using a vision model served locally
+// by Ollama or through an OpenAI compatible API
+type AICaptionConfig struct {
+	Provider string `json:"provider" desc:"API the model is served through, ollama for a local model or openai for OpenAI and other services with a compatible API" enum:"ollama,openai" required:"true"`
+	URL      string `json:"url" desc:"Base URL of the API, http://localhost:11434 for ollama and https://api.openai.com/v1 for openai when empty" format:"url"`
+	Model    string `json:"model" desc:"Vision model that writes the captions, such as llava or gpt-4o-mini" required:"true"`
+	APIKey   string `json:"apiKey" desc:"API key sent as a bearer token, not needed for a local model" secret:"true"`
+	Prompt   string `json:"prompt" desc:"What the model is asked for each photo"`
+}
+
+// captionProvider is implemented by each supported captioning API
+type captionProvider interface {
+	caption(ctx context.Context, model, prompt string, jpegData []byte) (string, error)
+}
+
+// newCaptionProvider returns the captioning API implementation for the configured provider
+func newCaptionProvider(cfg *AICaptionConfig) (captionProvider, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "ollama":
+		return ollamaCaptioner{baseURL: cmp.Or(cfg.URL, defaultOllamaURL), apiKey: cfg.APIKey}, nil
+	case "openai":
+		return openAICaptioner{baseURL: cmp.Or(cfg.URL, defaultOpenAIURL), apiKey: cfg.APIKey}, nil
+	default:
+		return nil, fmt.Errorf("unsupported caption provider %q", cfg.Provider)
+	}
+}
+
+var (
+	captionRequests     = make(chan string, captionQueue)
+	captionWorkerOnce   sync.Once
+	captionsQueued      = map[string]bool{}
+	captionsQueuedMutex sync.Mutex // To ensure thread-safe access to `captionsQueued`
+)
+
+var captionHTTPClient = &http.Client{Timeout: captionRequestTimeout}
+
+// generatedCaption returns the caption generated for a photo, empty when there is none yet or no caption generator
+// is configured.  A photo without one is queued to be captioned in the background, so the caption is there the next
+// time it is shown.
+func generatedCaption(file string, meta imageMetadata) string {
+	config, err := loadConfig(configPath)
+	if err != nil || config.AICaptions == nil {
+		return ""
+	}
+	if meta.GeneratedCaption != "" || isVideo(file) {
+		return meta.GeneratedCaption
+	}
+
+	captionsQueuedMutex.Lock()
+	defer captionsQueuedMutex.Unlock()
+	if captionsQueued[file] {
+		return ""
+	}
+	captionWorkerOnce.Do(func() { go runCaptionRequests() })
+	select {
+	case captionRequests <- file:
+		captionsQueued[file] = true
+	default:
+		// the queue is full of photos waiting their turn, this one is asked for again when next shown
+	}
+	return ""
+}
+
+// prefetchCaption has a caption generated for the photo shown next when it has none, so it is ready when the photo is
+func prefetchCaption(file string) {
+	photoCaption(file)
+}
+
+// runCaptionRequests captions each queued photo in turn
+func runCaptionRequests() {
+	for file := range captionRequests {
+		err := captionPhoto(file)
+		captionsQueuedMutex.Lock()
+		delete(captionsQueued, file)
+		captionsQueuedMutex.Unlock()
+		if err != nil {
+			// wait rather than failing again for each photo while the model is unavailable
+			log.Printf("Error generating a caption for %s, retrying in %s: %v", file, captionRetry, err)
+			time.Sleep(captionRetry)
+		}
+	}
+}
+
+// captionPhoto asks the configured model for a caption of a photo and records it in the metadata store
+func captionPhoto(file string) error {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	cfg := config.AICaptions
+	if cfg == nil {
+		return nil // turned off while the photo was waiting
+	}
+	store := imageMetadataCache()
+	if meta, err := store.get(file); err != nil || meta.GeneratedCaption != "" {
+		return err // captioned while it was waiting
+	}
+
+	provider, err := newCaptionProvider(cfg)
+	if err != nil {
+		return err
+	}
+	jpegData, err := captionImage(file)
+	if err != nil {
+		// the photo rather than the model is at fault, so the next photo is captioned straight away
+		log.Printf("Error preparing %s to be captioned: %v", file, err)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), captionRequestTimeout)
+	defer cancel()
+	caption, err := provider.caption(ctx, cfg.Model, cmp.Or(cfg.Prompt, defaultCaptionPrompt), jpegData)
+	if err != nil {
+		return err
+	}
+	caption = tidyGeneratedCaption(caption)
+	if caption == "" {
+		return fmt.Errorf("the model answered with an empty caption")
+	}
+
+	if err := store.setGeneratedCaption(file, caption); err != nil {
+		return err
+	}
+	if err := store.save(); err != nil {
+		log.Printf("Error saving metadata cache: %v", err)
+	}
+	log.Printf("Generated a caption for %s: %s", file, caption)
+	return nil
+}
+
+// captionImage returns a small JPEG of the photo shown upright, as models scale photos down anyway and it keeps
+// uploads to a cloud API quick
+func captionImage(file string) ([]byte, error) {
+	width, height, err := probeDimensions(file)
+	if err != nil {
+		return nil, err
+	}
+	scale := min(1, float64(captionImageSize)/float64(max(width, height, 1)))
+	frame, err := renderFrame(file, max(int(float64(width)*scale), 1), max(int(float64(height)*scale), 1), "contain")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, frame, &jpeg.Options{Quality: captionJPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tidyGeneratedCaption keeps the first line of a model's answer, without the quotes models like to add
+func tidyGeneratedCaption(caption string) string {
+	caption, _, _ = strings.Cut(strings.TrimSpace(caption), "\n")
+	caption = strings.TrimSpace(strings.Trim(strings.TrimSpace(caption), `"“”'`))
+	if runes := []rune(caption); len(runes) > maxGeneratedCaption {
+		caption = strings.TrimSpace(string(runes[:maxGeneratedCaption])) + "…"
+	}
+	return caption
+}
+
+// captionPost sends a JSON request to a captioning API and reads its JSON answer into reply
+func captionPost(ctx context.Context, rawURL, apiKey string, request, reply any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := captionHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("caption provider returned status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// ollamaCaptioner asks a vision model served by Ollama through its generate API
+type ollamaCaptioner struct {
+	baseURL string
+	apiKey  string // for an Ollama server behind an authenticating proxy
+}
+
+func (p ollamaCaptioner) caption(ctx context.Context, model, prompt string, jpegData []byte) (string, error) {
+	request := map[string]any{
+		"model":  model,
+		"prompt": prompt,
+		"images": []string{base64.StdEncoding.EncodeToString(jpegData)},
+		"stream": false,
+	}
+	var reply struct {
+		Response string `json:"response"`
+	}
+	if err := captionPost(ctx, strings.TrimSuffix(p.baseURL, "/")+"/api/generate", p.apiKey, request, &reply); err != nil {
+		return "", err
+	}
+	return reply.Response, nil
+}
+
+// openAICaptioner asks a vision model through the OpenAI chat completions API, which many other services and local
+// model servers offer too
+type openAICaptioner struct {
+	baseURL string
+	apiKey  string
+}
+
+func (p openAICaptioner) caption(ctx context.Context, model, prompt string, jpegData []byte) (string, error) {
+	request := map[string]any{
+		"model":      model,
+		"max_tokens": 100,
+		"messages": []map[string]any{{
+			"role": "user",
+			"content": []map[string]any{
+				{"type": "text", "text": prompt},
+				{"type": "image_url", "image_url": map[string]string{"url": "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(jpegData)}},
+			},
+		}},
+	}
+	var reply struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := captionPost(ctx, strings.TrimSuffix(p.baseURL, "/")+"/chat/completions", p.apiKey, request, &reply); err != nil {
+		return "", err
+	}
+	if len(reply.Choices) == 0 {
+		return "", fmt.Errorf("caption provider answered without a caption")
+	}
+	return reply.Choices[0].Message.Content, nil
+}
+
+// aiCaptionProblems reports caption generator settings that can't work
+func aiCaptionProblems(config *Config) []string {
+	if config.AICaptions == nil {
+		return nil
+	}
+	var problems []string
+	if _, err := newCaptionProvider(config.AICaptions); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if config.AICaptions.Model == "" {
+		problems = append(problems, "aiCaptions.model must be set")
+	}
+	if strings.EqualFold(config.AICaptions.Provider, "openai") && config.AICaptions.URL == "" && config.AICaptions.APIKey == "" {
+		problems = append(problems, "aiCaptions.apiKey must be set to use the OpenAI API")
+	}
+	return problems
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxCaptionLength bounds how much of a caption sidecar is read, a caption is a line or two rather than a document
+const maxCaptionLength = 2048
+
+// xmpDescriptionPattern matches the default language description in an XMP packet, as written by photo managers
+var xmpDescriptionPattern = regexp.MustCompile(`(?s)<dc:description>\s*<rdf:Alt>\s*<rdf:li[^>]*>(.*?)</rdf:li>`)
+
+// captionSidecars returns where a caption for the photo is looked for, photo.jpg.txt or photo.txt
+func captionSidecars(file string) []string {
+	return []string{file + ".txt", strings.TrimSuffix(file, filepath.Ext(file)) + ".txt"}
+}
+
+// hasSidecar reports whether a caption or XMP sidecar is alongside the photo
+func hasSidecar(file string) bool {
+	sidecars := append(captionSidecars(file), file+".xmp", strings.TrimSuffix(file, filepath.Ext(file))+".xmp")
+	for _, sidecar := range sidecars {
+		if _, err := os.Stat(sidecar); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// photoCaption returns the caption of a photo, from a text sidecar, the description in an XMP sidecar, the
+// description embedded in the photo or the caption generator, in that order.  Sidecars are read every time as
+// editing one doesn't change the photo, so a cached caption would go stale.
+func photoCaption(file string) string {
+	if file == "" {
+		return ""
+	}
+	for _, sidecar := range captionSidecars(file) {
+		if caption, ok := readCaptionSidecar(sidecar); ok {
+			return caption
+		}
+	}
+	if data, ok := readXMPSidecar(file); ok {
+		if caption := parseXMPDescription(data); caption != "" {
+			return caption
+		}
+	}
+	if meta, err := imageMetadataCache().get(file); err == nil {
+		if meta.Description != "" {
+			return meta.Description
+		}
+		return generatedCaption(file, meta)
+	}
+	return ""
+}
+
+// readCaptionSidecar returns the text of a caption sidecar and whether it exists
+func readCaptionSidecar(sidecar string) (string, bool) {
+	f, err := os.Open(sidecar)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxCaptionLength))
+	if err != nil {
+		return "", false
+	}
+	// editors on Windows save text with a byte order mark
+	return strings.TrimSpace(strings.TrimPrefix(string(data), "\ufeff")), true
+}
+
+// parseXMPDescription finds the description in XMP data, empty when there is none
+func parseXMPDescription(data []byte) string {
+	match := xmpDescriptionPattern.FindSubmatch(data)
+	if match == nil {
+		return ""
+	}
+	return strings.TrimSpace(html.UnescapeString(string(match[1])))
+}
@@ -0,0 +1,338 @@
+// Package client controls a randompic slideshow through its versioned HTTP API, served under /api/v1.
+//
+//	frame := client.New("http://frame.local")
+//	if err := frame.Next(ctx); err != nil {
+//		log.Print(err)
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Client calls the API of one slideshow
+type Client struct {
+	BaseURL    string       // e.g. http://frame.local
+	HTTPClient *http.Client // http.DefaultClient when nil
+}
+
+// New returns a client for the slideshow served at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Error is returned when the slideshow answers with an error status
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("randompic: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsStatus reports whether err is an Error with the given HTTP status, e.g. http.StatusConflict
+// when navigating while the display is powered off or the image is held
+func IsStatus(err error, status int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+// do sends a request with body encoded as JSON when not nil, and decodes the response into out when not nil
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// the health check reports an unreadable image directory as 503 but still describes it
+	if resp.StatusCode >= 300 && !(resp.StatusCode == http.StatusServiceUnavailable && path == "/api/v1/health") {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var body ErrorBody
+		if err := json.Unmarshal(data, &body); err != nil || body.Error == "" {
+			body.Error = strings.TrimSpace(string(data))
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: body.Error}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Health returns the state of the slideshow and its image directory
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	var health Health
+	if err := c.do(ctx, http.MethodGet, "/api/v1/health", nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// History returns the slides shown most recently, newest first, at most limit of them when limit is above 0
+func (c *Client) History(ctx context.Context, limit int) ([]HistoryEntry, error) {
+	path := "/api/v1/history"
+	if limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+	var entries []HistoryEntry
+	if err := c.do(ctx, http.MethodGet, path, nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Stats summarises the slideshow since it started
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var stats Stats
+	if err := c.do(ctx, http.MethodGet, "/api/v1/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Current returns the slide on screen and when it will change
+func (c *Client) Current(ctx context.Context) (*Current, error) {
+	var current Current
+	if err := c.do(ctx, http.MethodGet, "/api/v1/current", nil, &current); err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+// Upcoming returns the slide that will be shown next
+func (c *Client) Upcoming(ctx context.Context) (*Upcoming, error) {
+	var upcoming Upcoming
+	if err := c.do(ctx, http.MethodGet, "/api/v1/upcoming", nil, &upcoming); err != nil {
+		return nil, err
+	}
+	return &upcoming, nil
+}
+
+// Next moves on to the next slide
+func (c *Client) Next(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/next", nil, nil)
+}
+
+// Prev goes back to the previous slide
+func (c *Client) Prev(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/prev", nil, nil)
+}
+
+// Skip moves on to the next slide, leaving the current one out of the back history
+func (c *Client) Skip(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/skip", nil, nil)
+}
+
+// Pause stops the slideshow on the current slide
+func (c *Client) Pause(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/pause", nil, nil)
+}
+
+// Resume restarts a paused slideshow
+func (c *Client) Resume(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/resume", nil, nil)
+}
+
+// Speed returns the temporary change of the display interval, nil when there is none
+func (c *Client) Speed(ctx context.Context) (*SpeedChange, error) {
+	var change *SpeedChange
+	if err := c.do(ctx, http.MethodGet, "/api/v1/speed", nil, &change); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// SetSpeed temporarily changes the display interval
+func (c *Client) SetSpeed(ctx context.Context, req SpeedRequest) (*SpeedChange, error) {
+	var change SpeedChange
+	if err := c.do(ctx, http.MethodPost, "/api/v1/speed", req, &change); err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// ResetSpeed ramps the display interval back to normal
+func (c *Client) ResetSpeed(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/speed", nil, nil)
+}
+
+// Hold returns the hold on the image on screen, nil when there is none
+func (c *Client) Hold(ctx context.Context) (*Hold, error) {
+	var hold *Hold
+	if err := c.do(ctx, http.MethodGet, "/api/v1/hold", nil, &hold); err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// HoldImage keeps the image on screen, see HoldRequest for how long
+func (c *Client) HoldImage(ctx context.Context, req HoldRequest) (*Hold, error) {
+	var hold Hold
+	if err := c.do(ctx, http.MethodPost, "/api/v1/hold", req, &hold); err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// ReleaseHold lets the slideshow carry on
+func (c *Client) ReleaseHold(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/hold", nil, nil)
+}
+
+// ReportPresence passes on a report from a motion or presence sensor, see PresenceRequest
+func (c *Client) ReportPresence(ctx context.Context, req PresenceRequest) (*Presence, error) {
+	var presence Presence
+	if err := c.do(ctx, http.MethodPost, "/api/v1/presence", req, &presence); err != nil {
+		return nil, err
+	}
+	return &presence, nil
+}
+
+// Favorites returns the starred images, relative to imageDirectory
+func (c *Client) Favorites(ctx context.Context) ([]string, error) {
+	var images []string
+	if err := c.do(ctx, http.MethodGet, "/api/v1/favorites", nil, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// Star adds an image to the favorites, the image on screen when image is empty
+func (c *Client) Star(ctx context.Context, image string) (string, error) {
+	var resp ImageResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/favorites", ImageRequest{Image: image}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Image, nil
+}
+
+// Unstar removes an image from the favorites, the image on screen when image is empty
+func (c *Client) Unstar(ctx context.Context, image string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/favorites"+imageQuery(image), nil, nil)
+}
+
+// Blacklist returns the images that are never shown, relative to imageDirectory
+func (c *Client) Blacklist(ctx context.Context) ([]string, error) {
+	var images []string
+	if err := c.do(ctx, http.MethodGet, "/api/v1/blacklist", nil, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// NeverShow stops an image being shown again, the image on screen when image is empty
+func (c *Client) NeverShow(ctx context.Context, image string) (string, error) {
+	var resp ImageResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/blacklist", ImageRequest{Image: image}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Image, nil
+}
+
+// AllowShow lets a blacklisted image be shown again
+func (c *Client) AllowShow(ctx context.Context, image string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/blacklist"+imageQuery(image), nil, nil)
+}
+
+// Private returns the images marked private, relative to imageDirectory
+func (c *Client) Private(ctx context.Context) ([]string, error) {
+	var images []string
+	if err := c.do(ctx, http.MethodGet, "/api/v1/private", nil, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// MarkPrivate hides an image from every screen and API, the image on screen when image is empty
+func (c *Client) MarkPrivate(ctx context.Context, image string) (string, error) {
+	var resp ImageResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/private", ImageRequest{Image: image}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Image, nil
+}
+
+// MarkPublic lets a private image be shown again
+func (c *Client) MarkPublic(ctx context.Context, image string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/private"+imageQuery(image), nil, nil)
+}
+
+// Rating returns the rating of an image
+func (c *Client) Rating(ctx context.Context, image string) (*ImageRating, error) {
+	var rating ImageRating
+	if err := c.do(ctx, http.MethodGet, "/api/v1/ratings"+imageQuery(image), nil, &rating); err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+// Rate rates an image, see RatingRequest
+func (c *Client) Rate(ctx context.Context, req RatingRequest) (*ImageRating, error) {
+	var rating ImageRating
+	if err := c.do(ctx, http.MethodPost, "/api/v1/ratings", req, &rating); err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+// Profiles returns the profiles and the one that is active
+func (c *Client) Profiles(ctx context.Context) (*Profiles, error) {
+	var profiles Profiles
+	if err := c.do(ctx, http.MethodGet, "/api/v1/profiles", nil, &profiles); err != nil {
+		return nil, err
+	}
+	return &profiles, nil
+}
+
+// SwitchProfile switches to another profile, the main config when profile is empty
+func (c *Client) SwitchProfile(ctx context.Context, profile string) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/profiles", ProfileRequest{Profile: profile}, nil)
+}
+
+// Rescan rescans the image directory and returns the number of images found
+func (c *Client) Rescan(ctx context.Context) (int, error) {
+	var result RescanResult
+	if err := c.do(ctx, http.MethodPost, "/api/v1/rescan", nil, &result); err != nil {
+		return 0, err
+	}
+	return result.Images, nil
+}
+
+// imageQuery returns the ?image= query for an image, empty when image is empty
+func imageQuery(image string) string {
+	if image == "" {
+		return ""
+	}
+	return "?image=" + url.QueryEscape(image)
+}
@@ -0,0 +1,166 @@
+package client
+
+import "time"
+
+// The request and response types of the control and status API, shared by the server and this client so the two
+// can't drift apart.  Fields are only ever added, so programs built against an older version keep working.
+
+// ErrorBody is the body of every error response from /api/v1
+type ErrorBody struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// Stats is the response of /api/v1/stats
+type Stats struct {
+	StartedAt        time.Time       `json:"startedAt"`
+	UptimeSeconds    float64         `json:"uptimeSeconds"`
+	Loaded           bool            `json:"loaded"` // whether the first scan of the library has finished
+	Images           int             `json:"images"`
+	SlidesShown      int64           `json:"slidesShown"`      // since the app started
+	ConnectedDevices int             `json:"connectedDevices"` // browsers that loaded the page in the last 10 minutes
+	LastScan         DirectoryStatus `json:"lastScan"`
+}
+
+// Health is the response of /api/v1/health and /healthz
+type Health struct {
+	Status    string          `json:"status"` // ok, or error when images can't be read
+	Loaded    bool            `json:"loaded"` // whether the first scan of the library has finished
+	Images    int             `json:"images"`
+	Directory DirectoryStatus `json:"directory"`
+	LastScan  DirectoryStatus `json:"lastScan"`
+}
+
+// DirectoryStatus is the state of the image directory when it was last checked
+type DirectoryStatus struct {
+	State     string    `json:"state"` // ok, read-only, stale, missing, denied or unavailable
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// HistoryEntry is a slide that has been shown, from /api/v1/history
+type HistoryEntry struct {
+	Image   string    `json:"image"` // relative to imageDirectory
+	URL     string    `json:"url"`
+	ShownAt time.Time `json:"shownAt"`
+	Kind    string    `json:"kind"` // photo, countdown or intro
+}
+
+// Types of the messages pushed over /api/v1/ws and /api/v1/events
+const (
+	MessageSlide   = "slide"   // a new slide is on screen
+	MessageRefresh = "refresh" // the page changed without a new slide, e.g. the slideshow was paused or blanked
+)
+
+// Message is pushed to each browser connected to /api/v1/ws or /api/v1/events
+type Message struct {
+	Type  string        `json:"type"`
+	Slide *HistoryEntry `json:"slide,omitempty"` // set for MessageSlide
+}
+
+// Current is the slide on screen, from /api/v1/current
+type Current struct {
+	Image            string     `json:"image"` // relative to imageDirectory
+	URL              string     `json:"url"`
+	Kind             string     `json:"kind"`             // photo, countdown or intro
+	Width            int        `json:"width,omitempty"`  // zero when the image header could not be read
+	Height           int        `json:"height,omitempty"` // zero when the image header could not be read
+	DateTaken        *time.Time `json:"dateTaken,omitempty"`
+	ShownAt          time.Time  `json:"shownAt"`
+	State            string     `json:"state"`                      // playing, paused, held or off while the display is powered off
+	NextAt           *time.Time `json:"nextAt,omitempty"`           // when the next slide is shown, nil when it isn't known
+	SecondsUntilNext *float64   `json:"secondsUntilNext,omitempty"` // time left until NextAt
+}
+
+// Upcoming is the slide that will be shown next, from /api/v1/upcoming
+type Upcoming struct {
+	Image string     `json:"image"` // relative to imageDirectory
+	URL   string     `json:"url"`
+	Kind  string     `json:"kind"`         // photo, countdown or intro
+	At    *time.Time `json:"at,omitempty"` // when it will be shown, nil while paused, held until released or off
+}
+
+// SpeedRequest starts a temporary change of the display interval through /api/v1/speed
+type SpeedRequest struct {
+	IntervalSeconds float64  `json:"intervalSeconds"`
+	RampSeconds     *float64 `json:"rampSeconds,omitempty"`     // defaults to 30 seconds
+	DurationMinutes float64  `json:"durationMinutes,omitempty"` // defaults to 60 minutes
+}
+
+// SpeedChange is a temporary change of the display interval
+type SpeedChange struct {
+	IntervalSeconds float64   `json:"intervalSeconds"`
+	RampSeconds     float64   `json:"rampSeconds"`
+	Start           time.Time `json:"start"`
+	Until           time.Time `json:"until"` // when the ramp back to the normal interval begins
+}
+
+// PauseState is the response of /api/v1/pause and /api/v1/resume
+type PauseState struct {
+	Paused bool `json:"paused"`
+}
+
+// HoldRequest holds the image on screen through /api/v1/hold
+type HoldRequest struct {
+	Minutes *float64 `json:"minutes,omitempty"` // 0 holds until released, nil uses holdMinutes from the config
+}
+
+// Hold is an image kept on screen
+type Hold struct {
+	Image string     `json:"image"`
+	Since time.Time  `json:"since"`
+	Until *time.Time `json:"until,omitempty"` // nil when the image is held until released
+}
+
+// PresenceRequest reports a motion or presence sensor through /api/v1/presence.
+// Motion sensors leave Present nil, presence sensors set it when someone enters or leaves.
+type PresenceRequest struct {
+	Present *bool `json:"present,omitempty"`
+}
+
+// Presence is what the sensor last reported, from /api/v1/presence
+type Presence struct {
+	Occupied bool       `json:"occupied"` // set while a presence sensor reports someone in the room
+	LastSeen time.Time  `json:"lastSeen"` // the last motion, or when the room was last reported empty
+	Away     bool       `json:"away"`     // set while the display is blanked
+	BlankAt  *time.Time `json:"blankAt,omitempty"`
+}
+
+// ImageRequest names an image, relative to imageDirectory, for /api/v1/favorites, /api/v1/blacklist and
+// /api/v1/private.  The image on screen is used when Image is empty.
+type ImageRequest struct {
+	Image string `json:"image,omitempty"`
+}
+
+// ImageResponse is the image acted on by /api/v1/favorites, /api/v1/blacklist and /api/v1/private
+type ImageResponse struct {
+	Image string `json:"image"`
+}
+
+// RatingRequest rates an image through /api/v1/ratings
+type RatingRequest struct {
+	Image  string `json:"image,omitempty"` // relative to imageDirectory, the image on screen when empty
+	Rating int    `json:"rating"`          // 1 to 5 stars, -1 to reject, 0 to remove the rating
+}
+
+// ImageRating is the rating of an image, set through the API or read from its XMP metadata
+type ImageRating struct {
+	Image  string `json:"image"`
+	Rating int    `json:"rating"`
+}
+
+// Profiles is the response of GET /api/v1/profiles
+type Profiles struct {
+	Active   string   `json:"active"`
+	Profiles []string `json:"profiles"`
+}
+
+// ProfileRequest switches to another profile through /api/v1/profiles
+type ProfileRequest struct {
+	Profile string `json:"profile"`
+}
+
+// RescanResult is the response of /api/v1/rescan
+type RescanResult struct {
+	Images int `json:"images"`
+}
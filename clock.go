@@ -0,0 +1,44 @@
+package main
+
+const (
+	defaultClockPosition = "bottom-right"
+	defaultClockSize     = "medium"
+)
+
+// clockSizes maps each clock size to the height of its digits as a share of the screen
+var clockSizes = map[string]string{
+	"small":  "5vh",
+	"medium": "9vh",
+	"large":  "16vh",
+}
+
+// ClockConfig shows the time over the photo so the frame doubles as a wall clock
+type ClockConfig struct {
+	Position string `json:"position" desc:"Corner of the screen the clock is shown in, or center" default:"bottom-right" enum:"top-left,top-right,bottom-left,bottom-right,center"`
+	Format   string `json:"format" desc:"Whether the time is shown as 24 hour (18:30) or 12 hour (6:30 PM)" default:"24h" enum:"24h,12h"`
+	Size     string `json:"size" desc:"How large the clock is" default:"medium" enum:"small,medium,large"`
+	ShowDate bool   `json:"showDate" desc:"Show the day and date under the time" default:"false"`
+}
+
+// clockOverlay is the clock the page shows over the photo
+type clockOverlay struct {
+	Position string // class placing the clock on screen
+	Hour12   bool
+	FontSize string // CSS font size of the time
+	ShowDate bool
+}
+
+// clockOverlayFor returns the clock for cfg, with out of range settings replaced by the defaults
+func clockOverlayFor(cfg *ClockConfig) *clockOverlay {
+	clock := &clockOverlay{Position: cfg.Position, Hour12: cfg.Format == "12h", ShowDate: cfg.ShowDate}
+	switch clock.Position {
+	case "top-left", "top-right", "bottom-left", "bottom-right", "center":
+	default:
+		clock.Position = defaultClockPosition
+	}
+	var ok bool
+	if clock.FontSize, ok = clockSizes[cfg.Size]; !ok {
+		clock.FontSize = clockSizes[defaultClockSize]
+	}
+	return clock
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clusterAlbumPrefix marks album names that refer to a photo cluster rather than a directory
+const clusterAlbumPrefix = "clusters/"
+
+const (
+	defaultClusterGapHours   = 12
+	defaultClusterDistanceKm = 50
+	earthRadiusKm            = 6371.0
+)
+
+// photoCluster is a group of photos taken close together in time and place, exposed as a virtual album
+type photoCluster struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Images      int       `json:"images"`
+	HasLocation bool      `json:"hasLocation"`
+	files       []string  // in capture order
+}
+
+// clusterPosition locates a file within the chronological cluster ordering
+type clusterPosition struct {
+	cluster int
+	order   int
+}
+
+var (
+	photoClusters []photoCluster
+	clusterIndex  map[string]clusterPosition
+	clustersMutex sync.RWMutex // To ensure thread-safe access to `photoClusters` and `clusterIndex`
+)
+
+// clusteringEnabled reports whether photo clusters need to be built for the configuration
+func clusteringEnabled(config *Config) bool {
+	return config.ClusterPhotos || strings.EqualFold(config.SelectionMode, "events")
+}
+
+// distanceKm returns the great circle distance between two coordinates
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// buildClusters groups the files into clusters, starting a new cluster whenever the gap in capture time
+// or the distance between consecutive geotagged photos exceeds the limits
+func buildClusters(files []string, gap time.Duration, maxDistanceKm float64) []photoCluster {
+	type photo struct {
+		file string
+		meta imageMetadata
+	}
+
+	cache := imageMetadataCache()
+	photos := make([]photo, 0, len(files))
+	for _, file := range files {
+		meta, err := cache.get(file)
+		if err != nil {
+			log.Println("Error:", err)
+			continue
+		}
+		photos = append(photos, photo{file: file, meta: meta})
+	}
+	if err := cache.save(); err != nil {
+		log.Printf("Error saving metadata cache: %v", err)
+	}
+
+	sort.SliceStable(photos, func(i, j int) bool {
+		return photos[i].meta.captureTime().Before(photos[j].meta.captureTime())
+	})
+
+	var clusters []photoCluster
+	var lastLocated *imageMetadata
+	for i := range photos {
+		p := &photos[i]
+		taken := p.meta.captureTime()
+
+		split := len(clusters) == 0 || taken.Sub(clusters[len(clusters)-1].End) > gap
+		if !split && p.meta.HasLocation && lastLocated != nil {
+			split = distanceKm(lastLocated.Latitude, lastLocated.Longitude, p.meta.Latitude, p.meta.Longitude) > maxDistanceKm
+		}
+		if split {
+			clusters = append(clusters, photoCluster{Start: taken})
+			lastLocated = nil
+		}
+
+		c := &clusters[len(clusters)-1]
+		c.End = taken
+		c.files = append(c.files, p.file)
+		if p.meta.HasLocation {
+			c.HasLocation = true
+			lastLocated = &p.meta
+		}
+	}
+
+	for i := range clusters {
+		c := &clusters[i]
+		c.Images = len(c.files)
+		c.ID = c.Start.Format("20060102-150405")
+		// names identify clusters in the API, so they stay in English whatever the page language
+		c.Name = messagesFor(defaultLanguage).dateRange(c.Start, c.End)
+	}
+	return clusters
+}
+
+// refreshClusters rebuilds the photo clusters from the library when clustering is enabled
+func refreshClusters(config *Config, files []string) {
+	if !clusteringEnabled(config) {
+		return
+	}
+
+	gapHours := config.ClusterGapHours
+	if gapHours <= 0 {
+		gapHours = defaultClusterGapHours
+	}
+	maxDistance := config.ClusterDistanceKm
+	if maxDistance <= 0 {
+		maxDistance = defaultClusterDistanceKm
+	}
+
+	start := time.Now()
+	clusters := buildClusters(files, time.Duration(gapHours*float64(time.Hour)), maxDistance)
+
+	index := make(map[string]clusterPosition, len(files))
+	order := 0
+	for i, c := range clusters {
+		for _, file := range c.files {
+			index[file] = clusterPosition{cluster: i, order: order}
+			order++
+		}
+	}
+
+	clustersMutex.Lock()
+	photoClusters = clusters
+	clusterIndex = index
+	clustersMutex.Unlock()
+
+	log.Printf("Grouped %d images into %d clusters in %s", len(files), len(clusters), time.Since(start))
+}
+
+// findCluster returns the cluster with the given id
+func findCluster(id string) (photoCluster, bool) {
+	clustersMutex.RLock()
+	defer clustersMutex.RUnlock()
+
+	for _, c := range photoClusters {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return photoCluster{}, false
+}
+
+// clusterOf returns the cluster containing file
+func clusterOf(file string) (photoCluster, bool) {
+	clustersMutex.RLock()
+	defer clustersMutex.RUnlock()
+
+	pos, ok := clusterIndex[file]
+	if !ok {
+		return photoCluster{}, false
+	}
+	return photoClusters[pos.cluster], true
+}
+
+// clustersHandler lists the photo clusters available as virtual albums
+func clustersHandler(w http.ResponseWriter, r *http.Request) {
+	clustersMutex.RLock()
+	defer clustersMutex.RUnlock()
+
+	type clusterResponse struct {
+		photoCluster
+		Album string `json:"album"`
+		Cover string `json:"cover"`
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	clusters := make([]clusterResponse, 0, len(photoClusters))
+	for _, c := range photoClusters {
+		clusters = append(clusters, clusterResponse{
+			photoCluster: c,
+			Album:        clusterAlbumPrefix + c.ID,
+			Cover:        imageURL(folderCover(c.files), config.ImageDirectory),
+		})
+	}
+	writeJSON(w, clusters)
+}
+
+// clusterSelector plays the library cluster by cluster in the order the photos were taken
+type clusterSelector struct {
+	last   string
+	random *selectionRandom // nil for selectionRand, a clone draws from a copy
+}
+
+func (s *clusterSelector) next(files []string) string {
+	clustersMutex.RLock()
+	defer clustersMutex.RUnlock()
+
+	if len(clusterIndex) == 0 {
+		return s.random.pick(files)
+	}
+
+	// pick the file ordered straight after the last one shown, wrapping around to the first
+	lastOrder := -1
+	if pos, ok := clusterIndex[s.last]; ok {
+		lastOrder = pos.order
+	}
+	next, first := "", ""
+	nextOrder, firstOrder := math.MaxInt, math.MaxInt
+	for _, file := range files {
+		pos, ok := clusterIndex[file]
+		if !ok {
+			continue
+		}
+		if pos.order < firstOrder {
+			first, firstOrder = file, pos.order
+		}
+		if pos.order > lastOrder && pos.order < nextOrder {
+			next, nextOrder = file, pos.order
+		}
+	}
+	if next == "" {
+		next = first
+	}
+	if next == "" {
+		return selectRandomImage(files)
+	}
+
+	s.last = next
+	return next
+}
+
+func (s *clusterSelector) clone() imageSelector {
+	c := *s
+	c.random = s.random.copy()
+	return &c
+}
+
+func (s *clusterSelector) groupOf(image, imageDirectory string) string {
+	if c, ok := clusterOf(image); ok {
+		return clusterAlbumPrefix + c.ID
+	}
+	return ""
+}
+
+func (s *clusterSelector) introFor(image, imageDirectory string, library []string) (*folderIntro, string) {
+	c, ok := clusterOf(image)
+	if !ok {
+		return buildFolderIntro(image, imageDirectory, library)
+	}
+	// the cluster name is already its date range so it is not repeated
+	return &folderIntro{Name: c.Name, Images: c.Images}, folderCover(c.files)
+}
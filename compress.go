@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultCompressionLevel    = 5
+	defaultCompressionMinBytes = 1024
+)
+
+// CompressionConfig gzips the page, static files and API responses for browsers that accept it, which helps a frame
+// on a slow Wi-Fi link.  Photos and clips are already compressed and are always sent as they are.
+type CompressionConfig struct {
+	Level    int `json:"level" desc:"gzip compression level from 1, the fastest, to 9, the smallest" default:"5"`
+	MinBytes int `json:"minBytes" desc:"Responses smaller than this many bytes are sent as they are, as compressing them saves little" default:"1024"`
+}
+
+// compressibleTypes are the content types worth compressing, text and the formats made of it
+var compressibleTypes = []string{"text/", "application/json", "application/javascript", "application/xml", "application/manifest+json", "image/svg+xml"}
+
+// compressHandler gzips the responses of next for browsers that accept gzip, when they are of a compressible type and
+// at least cfg.MinBytes long
+func compressHandler(next http.Handler, cfg *CompressionConfig) http.Handler {
+	level := cfg.Level
+	if level < gzip.BestSpeed || level > gzip.BestCompression {
+		level = defaultCompressionLevel
+	}
+	minBytes := cfg.MinBytes
+	if minBytes <= 0 {
+		minBytes = defaultCompressionMinBytes
+	}
+	writers := &sync.Pool{New: func() any {
+		gz, _ := gzip.NewWriterLevel(nil, level)
+		return gz
+	}}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a WebSocket upgrade takes over the connection, and a HEAD response has no body to compress
+		if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		compressed := &compressWriter{
+			ResponseWriter: w,
+			accepted:       acceptsGzip(r),
+			minBytes:       minBytes,
+			writers:        writers,
+		}
+		defer compressed.finish()
+		next.ServeHTTP(compressed, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of a request allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		name = strings.TrimSpace(name)
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressWriter holds back the start of a response until it knows whether it is worth compressing: its type is
+// compressible and it reaches minBytes, or the handler flushes it.  Anything else is sent as it is.
+type compressWriter struct {
+	http.ResponseWriter
+	accepted bool // the browser accepts gzip
+	minBytes int
+	writers  *sync.Pool
+
+	status   int  // set once the handler has written the header
+	typed    bool // the content type has been checked
+	decided  bool // whether to compress has been settled, and the header sent
+	buffered bytes.Buffer
+	gz       *gzip.Writer // set when the response is being compressed
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status != 0 || w.decided {
+		return
+	}
+	w.status = status
+	// interim, empty and partial responses are never compressed, nor a response compressed by the handler itself
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusPartialContent ||
+		status == http.StatusNotModified || w.Header().Get("Content-Encoding") != "" {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided && !w.typed {
+		w.typed = true
+		if w.Header().Get("Content-Type") == "" {
+			// as net/http would, so the type can be checked
+			w.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		if !compressibleType(w.Header().Get("Content-Type")) {
+			w.decide(false)
+		} else {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !w.accepted {
+				w.decide(false)
+			}
+		}
+	}
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(data)
+	case w.decided:
+		return w.ResponseWriter.Write(data)
+	}
+
+	w.buffered.Write(data)
+	if w.buffered.Len() >= w.minBytes || w.Header().Get("Content-Length") != "" && w.contentLength() >= w.minBytes {
+		w.decide(true)
+	}
+	return len(data), nil
+}
+
+// contentLength returns the length the handler says the response is, zero when it doesn't say
+func (w *compressWriter) contentLength() int {
+	length, _ := strconv.Atoi(w.Header().Get("Content-Length"))
+	return length
+}
+
+// decide sends the header, set up to compress the response or not, and anything held back
+func (w *compressWriter) decide(compress bool) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	if compress {
+		w.Header().Del("Content-Length")
+		w.Header().Del("Accept-Ranges")
+		w.Header().Set("Content-Encoding", "gzip")
+		// the compressed bytes differ from those the ETag was made for
+		if etag := w.Header().Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			w.Header().Set("ETag", "W/"+etag)
+		}
+		w.gz = w.writers.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buffered.Len() > 0 {
+		if w.gz != nil {
+			w.gz.Write(w.buffered.Bytes())
+		} else {
+			w.ResponseWriter.Write(w.buffered.Bytes())
+		}
+		w.buffered.Reset()
+	}
+}
+
+// Flush sends what has been written so far, compressing it when the response is of a compressible type, so streamed
+// responses aren't held back
+func (w *compressWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.decide(w.accepted && compressibleType(w.Header().Get("Content-Type")))
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so /ws can hijack the connection
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sends a response too short to compress as it is, and the end of a compressed one
+func (w *compressWriter) finish() {
+	if w.status != 0 && !w.decided {
+		w.Header().Set("Content-Length", strconv.Itoa(w.buffered.Len()))
+		w.decide(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		w.writers.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// compressibleType reports whether a response of the given content type is worth compressing
+func compressibleType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "text/event-stream" {
+		return false // sent an event at a time, each flushed as it happens
+	}
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressionProblems checks the compression settings, which fall back to the defaults when invalid
+func compressionProblems(config *Config) []string {
+	if config.Compression == nil {
+		return nil
+	}
+	if level := config.Compression.Level; level != 0 && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return []string{fmt.Sprintf("compression level must be between %d and %d, %d is used", gzip.BestSpeed, gzip.BestCompression, defaultCompressionLevel)}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressWriter(t *testing.T) {
+	long := strings.Repeat("a photo frame on a slow link ", 100)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		handler        http.HandlerFunc
+		wantBody       string
+		wantGzip       bool
+		wantStatus     int
+		wantLength     string // the Content-Length sent, checked when set
+		wantVary       bool
+	}{
+		{
+			name:           "long text",
+			acceptEncoding: "gzip, deflate",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				io.WriteString(w, long)
+			},
+			wantBody: long, wantGzip: true, wantStatus: http.StatusOK, wantVary: true,
+		},
+		{
+			name:           "long text written in pieces",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				for i := 0; i < len(long); i += 100 {
+					io.WriteString(w, long[i:min(i+100, len(long))])
+				}
+			},
+			wantBody: long, wantGzip: true, wantStatus: http.StatusOK, wantVary: true,
+		},
+		{
+			name:           "short text",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				io.WriteString(w, "short")
+			},
+			wantBody: "short", wantStatus: http.StatusOK, wantLength: "5", wantVary: true,
+		},
+		{
+			name:           "type sniffed from the body",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "<html>"+long)
+			},
+			wantBody: "<html>" + long, wantGzip: true, wantStatus: http.StatusOK, wantVary: true,
+		},
+		{
+			name:           "photo",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/jpeg")
+				io.WriteString(w, long)
+			},
+			wantBody: long, wantStatus: http.StatusOK,
+		},
+		{
+			name: "gzip not accepted",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				io.WriteString(w, long)
+			},
+			wantBody: long, wantStatus: http.StatusOK, wantVary: true,
+		},
+		{
+			name:           "gzip refused",
+			acceptEncoding: "gzip;q=0, identity",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				io.WriteString(w, long)
+			},
+			wantBody: long, wantStatus: http.StatusOK, wantVary: true,
+		},
+		{
+			name:           "error page",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, long, http.StatusInternalServerError)
+			},
+			wantBody: long + "\n", wantGzip: true, wantStatus: http.StatusInternalServerError, wantVary: true,
+		},
+		{
+			name:           "no content",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:           "already compressed",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Header().Set("Content-Encoding", "br")
+				w.WriteHeader(http.StatusOK)
+				io.WriteString(w, long)
+			},
+			wantBody: long, wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			compressHandler(tt.handler, &CompressionConfig{}).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			gzipped := w.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Errorf("Content-Encoding = %q, want gzip %v", w.Header().Get("Content-Encoding"), tt.wantGzip)
+			}
+			if vary := w.Header().Get("Vary") == "Accept-Encoding"; vary != tt.wantVary {
+				t.Errorf("Vary = %q, want Accept-Encoding %v", w.Header().Get("Vary"), tt.wantVary)
+			}
+			if tt.wantLength != "" && w.Header().Get("Content-Length") != tt.wantLength {
+				t.Errorf("Content-Length = %q, want %q", w.Header().Get("Content-Length"), tt.wantLength)
+			}
+
+			body := w.Body.String()
+			if gzipped {
+				reader, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("reading the gzipped body: %v", err)
+				}
+				data, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatalf("reading the gzipped body: %v", err)
+				}
+				body = string(data)
+			}
+			if body != tt.wantBody {
+				t.Errorf("body = %.40q..., want %.40q...", body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	contentVerdictsPath          = "./randompic-filter.json"
+	defaultContentFilterTimeout  = 30
+	defaultContentFilterWorkers  = 1
+	contentFilterBatch           = 50          // images handed to the workers at a time
+	contentFilterApplyInterval   = time.Minute // between adding the allowed images to the library and saving the verdicts
+	maxContentFilterFailures     = 5           // checks in a row that fail before checking stops until the next scan
+	contentFilterVetoedExitCode  = 1
+	maxContentFilterReasonLength = 200
+)
+
+// ContentFilterConfig runs each image past an external command or HTTP endpoint, such as an NSFW classifier, which
+// can keep it out of the slideshow
+type ContentFilterConfig struct {
+	Command        string `json:"command" desc:"Command run for each image with the path of the image added as its last argument, exiting with 0 to show the image and 1 to keep it out"`
+	URL            string `json:"url" desc:"Endpoint each image is posted to, answering {\"allow\": false, \"reason\": \"...\"} to keep it out" format:"url"`
+	TimeoutSeconds int    `json:"timeoutSeconds" desc:"How long to wait for the command or endpoint to check one image" default:"30"`
+	Workers        int    `json:"workers" desc:"How many images are checked at the same time" default:"1"`
+}
+
+// filter identifies the command or endpoint, so images are checked again when it is changed
+func (c *ContentFilterConfig) filter() string {
+	if c.URL != "" {
+		return c.URL
+	}
+	return c.Command
+}
+
+// contentVerdict is what the content filter decided about an image.  Size and ModTime are recorded so a changed
+// image is checked again.
+type contentVerdict struct {
+	Filter  string    `json:"filter"` // the command or endpoint that checked it
+	Allowed bool      `json:"allowed"`
+	Reason  string    `json:"reason,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime int64     `json:"modTime"`
+	Since   time.Time `json:"since"`
+}
+
+// contentVerdicts are what the content filter decided about the images it checked, keyed by absolute path
+var contentVerdicts = &jsonStore[contentVerdict]{path: contentVerdictsPath, what: "content filter verdicts"}
+
+var (
+	contentChecksPending map[string]bool // images waiting to be checked, false once their check has started
+	contentChecking      bool            // set while the pending images are being checked
+	contentChecksMutex   sync.Mutex      // To ensure thread-safe access to `contentChecksPending` and `contentChecking`
+)
+
+var contentFilterHTTPClient = &http.Client{}
+
+// cachedContentVerdict returns the verdict on an image when the configured filter has checked it since it last
+// changed
+func cachedContentVerdict(file string, cfg *ContentFilterConfig) (contentVerdict, bool) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return contentVerdict{}, false
+	}
+	verdict, ok := contentVerdicts.get(file)
+	if !ok || verdict.Filter != cfg.filter() || verdict.Size != info.Size() || verdict.ModTime != info.ModTime().Unix() {
+		return contentVerdict{}, false
+	}
+	return verdict, true
+}
+
+// filterByContent keeps the images the content filter has allowed.  Images it hasn't checked yet are held back and
+// checked in the background, joining the library once they are allowed, so a vetoed image is never shown.
+func filterByContent(files []string, config *Config) []string {
+	cfg := config.ContentFilter
+	kept := make([]string, 0, len(files))
+	var unchecked []string
+	vetoed := 0
+	for _, file := range files {
+		verdict, ok := cachedContentVerdict(file, cfg)
+		switch {
+		case !ok:
+			unchecked = append(unchecked, file)
+		case verdict.Allowed:
+			kept = append(kept, file)
+		default:
+			vetoed++
+		}
+	}
+	if vetoed > 0 {
+		log.Printf("Left out %d images vetoed by the content filter", vetoed)
+	}
+	if len(unchecked) > 0 {
+		log.Printf("Holding back %d images until the content filter has checked them", len(unchecked))
+		queueContentChecks(unchecked)
+	}
+	return kept
+}
+
+// queueContentChecks adds images to those waiting to be checked, starting to check them unless that is under way
+func queueContentChecks(files []string) {
+	contentChecksMutex.Lock()
+	defer contentChecksMutex.Unlock()
+	if contentChecksPending == nil {
+		contentChecksPending = map[string]bool{}
+	}
+	for _, file := range files {
+		if _, ok := contentChecksPending[file]; !ok {
+			contentChecksPending[file] = true
+		}
+	}
+	if !contentChecking {
+		contentChecking = true
+		go checkPendingContent()
+	}
+}
+
+// nextContentChecks forgets the images checked last and takes up to n of those waiting to be checked, stopping the
+// checking when there are none
+func nextContentChecks(checked []string, n int) []string {
+	contentChecksMutex.Lock()
+	defer contentChecksMutex.Unlock()
+	for _, file := range checked {
+		delete(contentChecksPending, file)
+	}
+	var files []string
+	for file, waiting := range contentChecksPending {
+		if len(files) == n {
+			break
+		}
+		if waiting {
+			files = append(files, file)
+			contentChecksPending[file] = false
+		}
+	}
+	if len(files) == 0 {
+		contentChecking = false
+	}
+	return files
+}
+
+// checkPendingContent runs the waiting images past the content filter a batch at a time.  The allowed images are
+// added to the library, the vetoed ones dropped and the verdicts saved once a minute and when checking is done,
+// rather than after every batch, as each update goes over the whole library; the first batch is added straight
+// away so a first run has something to show.  Checking stops until the next scan when the filter keeps failing,
+// such as when its command is missing or its endpoint is down, leaving the images it couldn't check out of the
+// slideshow.
+func checkPendingContent() {
+	start := time.Now()
+	checked, allowed, failures := 0, 0, 0
+	var files, added, vetoed []string
+	var applied time.Time
+	apply := func(config *Config) {
+		if err := contentVerdicts.save(); err != nil {
+			log.Printf("Error saving content filter verdicts: %v", err)
+		}
+		if len(added) > 0 || len(vetoed) > 0 {
+			addToLibrary(added, vetoed, nil, config)
+		}
+		added, vetoed, applied = nil, nil, time.Now()
+	}
+	for {
+		config, err := loadConfig(configPath)
+		if err != nil || config.ContentFilter == nil {
+			// the filter was turned off, the next scan shows the images without checking them
+			if err := contentVerdicts.save(); err != nil {
+				log.Printf("Error saving content filter verdicts: %v", err)
+			}
+			contentChecksMutex.Lock()
+			contentChecksPending = nil
+			contentChecking = false
+			contentChecksMutex.Unlock()
+			return
+		}
+		cfg := config.ContentFilter
+		files = nextContentChecks(files, contentFilterBatch)
+		if len(files) == 0 {
+			apply(config)
+			break
+		}
+
+		workers := cfg.Workers
+		if workers <= 0 {
+			workers = defaultContentFilterWorkers
+		}
+		verdicts := make([]contentVerdict, len(files))
+		errs := make([]error, len(files))
+		jobs := make(chan int)
+		var wg sync.WaitGroup
+		for range min(workers, len(files)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					verdicts[i], errs[i] = checkContent(files[i], cfg, config.ImageDirectory)
+				}
+			}()
+		}
+		for i := range files {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+
+		for i, file := range files {
+			if errs[i] != nil {
+				log.Printf("Error checking %s with the content filter: %v", file, errs[i])
+				failures++
+				continue
+			}
+			failures = 0
+			checked++
+			contentVerdicts.set(file, verdicts[i])
+			if verdicts[i].Allowed {
+				added = append(added, file)
+				allowed++
+			} else {
+				vetoed = append(vetoed, file)
+				log.Printf("The content filter kept %s out of the slideshow: %s", file, verdicts[i].Reason)
+			}
+		}
+		if time.Since(applied) >= contentFilterApplyInterval {
+			apply(config)
+		}
+
+		if failures >= maxContentFilterFailures {
+			apply(config)
+			contentChecksMutex.Lock()
+			log.Printf("The content filter failed %d times in a row, leaving %d images unchecked until the next scan", failures, len(contentChecksPending))
+			contentChecksPending = nil
+			contentChecking = false
+			contentChecksMutex.Unlock()
+			return
+		}
+	}
+	log.Printf("The content filter checked %d images in %s and allowed %d", checked, time.Since(start).Round(time.Second), allowed)
+}
+
+// checkContent asks the configured command or endpoint whether an image may be shown.  HEIC and RAW photos are
+// checked as the JPEG they are shown as.
+func checkContent(file string, cfg *ContentFilterConfig, imageDirectory string) (contentVerdict, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return contentVerdict{}, err
+	}
+	decodable, err := decodableFile(file)
+	if err != nil {
+		return contentVerdict{}, err
+	}
+
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultContentFilterTimeout * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	verdict := contentVerdict{Filter: cfg.filter(), Size: info.Size(), ModTime: info.ModTime().Unix(), Since: time.Now()}
+	if cfg.URL != "" {
+		verdict.Allowed, verdict.Reason, err = askContentEndpoint(ctx, cfg.URL, decodable, relativeImagePath(file, imageDirectory))
+	} else {
+		verdict.Allowed, verdict.Reason, err = runContentCommand(ctx, cfg.Command, decodable)
+	}
+	if len(verdict.Reason) > maxContentFilterReasonLength {
+		verdict.Reason = verdict.Reason[:maxContentFilterReasonLength]
+	}
+	return verdict, err
+}
+
+// runContentCommand runs the filter command with the image as its last argument.  Exiting with 0 allows the image
+// and exiting with 1 vetoes it, with the first line it prints as the reason, while any other exit is a failure.
+func runContentCommand(ctx context.Context, command, file string) (bool, string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return false, "", fmt.Errorf("no command is set")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], file)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return true, "", nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == contentFilterVetoedExitCode {
+		reason, _, _ := strings.Cut(strings.TrimSpace(stdout.String()), "\n")
+		return false, reason, nil
+	}
+	return false, "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+}
+
+// askContentEndpoint posts the image to the filter endpoint, with its path relative to the image directory in the
+// image query parameter, and reads the verdict from its JSON answer
+func askContentEndpoint(ctx context.Context, endpoint, file, image string) (bool, string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, "", err
+	}
+
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return false, "", err
+	}
+	query := target.Query()
+	query.Set("image", image)
+	target.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), f)
+	if err != nil {
+		return false, "", err
+	}
+	// send the length rather than chunks, which simple classifier servers often can't read
+	req.ContentLength = info.Size()
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(file)))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := contentFilterHTTPClient.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return false, "", fmt.Errorf("content filter returned status %s", resp.Status)
+	}
+
+	var answer struct {
+		Allow  *bool  `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return false, "", fmt.Errorf("error parsing the content filter's answer: %v", err)
+	}
+	if answer.Allow == nil {
+		return false, "", fmt.Errorf("the content filter's answer has no allow field")
+	}
+	return *answer.Allow, answer.Reason, nil
+}
+
+// contentFilterProblems reports content filter settings that can't work
+func contentFilterProblems(config *Config) []string {
+	if config.ContentFilter == nil {
+		return nil
+	}
+	cfg := config.ContentFilter
+	switch {
+	case cfg.Command == "" && cfg.URL == "":
+		return []string{"contentFilter needs a command or a url to check images with"}
+	case cfg.Command != "" && cfg.URL != "":
+		return []string{"contentFilter checks images with either a command or a url, the url is used"}
+	case cfg.Command != "":
+		if _, err := exec.LookPath(strings.Fields(cfg.Command)[0]); err != nil {
+			return []string{fmt.Sprintf("contentFilter command %s can't be found", strings.Fields(cfg.Command)[0])}
+		}
+	}
+	return nil
+}
+
+// vetoedImage describes an image kept out by the content filter in the /api/filtered response
+type vetoedImage struct {
+	Image  string    `json:"image"` // relative to imageDirectory
+	Path   string    `json:"path"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// filteredHandler lists the images the configured content filter has kept out of the slideshow
+func filteredHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	found := map[string]contentVerdict{}
+	if config.ContentFilter != nil {
+		contentVerdicts.read(func(verdicts map[string]contentVerdict) {
+			for file, verdict := range verdicts {
+				if !verdict.Allowed && verdict.Filter == config.ContentFilter.filter() {
+					found[file] = verdict
+				}
+			}
+		})
+	}
+
+	files := []string{}
+	for file := range found {
+		files = append(files, file)
+	}
+	vetoed := []vetoedImage{}
+	for _, file := range withoutPrivateFiles(files, config.ImageDirectory) {
+		vetoed = append(vetoed, vetoedImage{
+			Image:  relativeImagePath(file, config.ImageDirectory),
+			Path:   file,
+			Reason: found[file].Reason,
+			Since:  found[file].Since,
+		})
+	}
+	sort.Slice(vetoed, func(i, j int) bool { return vetoed[i].Path < vetoed[j].Path })
+	writeJSON(w, vetoed)
+}
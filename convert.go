@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var convertMutex sync.Mutex // To ensure only one photo is converted at a time, and never twice at once
+
+// browserShowable reports whether browsers can show a photo as it is, rather than as a JPEG converted from it
+func browserShowable(file string) bool {
+	return !isHEIC(file) && !isRAW(file)
+}
+
+// decodableFile returns the file to read a photo's pixels from: the photo itself, the JPEG a HEIC photo is
+// converted to or the preview embedded in a RAW file
+func decodableFile(file string) (string, error) {
+	switch {
+	case isHEIC(file):
+		return convertedFile(file, heicCacheDir, convertHEIC)
+	case isRAW(file):
+		return convertedFile(file, rawCacheDir, extractRAWPreview)
+	}
+	return file, nil
+}
+
+// convertedFile returns the JPEG converted from a photo by convert, which writes it to the path it is given.
+// Converted photos are cached in cacheDir, mirroring their absolute path as the image directory can change, and
+// converted again when the photo changes.
+func convertedFile(file, cacheDir string, convert func(file, jpeg string) error) (string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	converted := imageCopyPath(cacheDir, strings.TrimPrefix(filepath.ToSlash(strings.TrimPrefix(file, filepath.VolumeName(file))), "/"))
+
+	convertMutex.Lock()
+	defer convertMutex.Unlock()
+	if convertedInfo, err := os.Stat(converted); err == nil && !convertedInfo.ModTime().Before(info.ModTime()) {
+		return converted, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(converted), 0o755); err != nil {
+		return "", err
+	}
+	// converters may pick the output format from its extension, so the partial file keeps it
+	partial := strings.TrimSuffix(converted, ".jpg") + ".partial.jpg"
+	err = convert(file, partial)
+	if err == nil {
+		err = os.Rename(partial, converted)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	return converted, nil
+}
+
+// serveConverted sends a photo browsers can't show as the JPEG converted from it, turned upright when its EXIF
+// orientation says so
+func serveConverted(w http.ResponseWriter, r *http.Request, file, imageDirectory string) {
+	if exifOrientation(file) > 1 {
+		serveResizedImage(w, r, file, imageDirectory)
+		return
+	}
+	converted, err := decodableFile(file)
+	if err != nil {
+		http.Error(w, "Error converting image: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error converting %s: %v", file, err)
+		quarantineFile(file, "convert", err)
+		return
+	}
+	if info, err := os.Stat(file); err == nil {
+		setImageCacheHeaders(w, info, "jpeg")
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, converted)
+}
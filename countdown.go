@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CountdownConfig describes a countdown slide that is mixed into the rotation ahead of a date
+type CountdownConfig struct {
+	Title      string `json:"title" desc:"Text shown above the countdown, e.g. Trip to Japan" required:"true"`
+	Target     string `json:"target" desc:"Date and time being counted down to, RFC3339 or YYYY-MM-DDTHH:MM" format:"datetime" required:"true"`
+	Background string `json:"background" desc:"Image or album (relative to imageDirectory) shown behind the countdown, a random image when empty"`
+	LeadDays   int    `json:"leadDays" desc:"Number of days before the target that the countdown starts appearing" default:"30"`
+}
+
+// countdownSlide is the countdown currently being displayed
+type countdownSlide struct {
+	Title  string
+	Target time.Time
+}
+
+const (
+	defaultCountdownLeadDays = 30
+	minCountdownFrequency    = 0.05 // share of slides used by a countdown when it first appears
+	maxCountdownFrequency    = 0.5  // share of slides used by a countdown on its final day
+)
+
+// countdownFrequency returns the share of slides a countdown should take, increasing linearly as the target approaches
+func countdownFrequency(remaining, lead time.Duration) float64 {
+	if remaining <= 0 || remaining > lead {
+		return 0
+	}
+	progress := 1 - float64(remaining)/float64(lead)
+	return minCountdownFrequency + (maxCountdownFrequency-minCountdownFrequency)*progress
+}
+
+// pickCountdown decides whether the next slide should be a countdown, returning nil for a normal photo
+func pickCountdown(countdowns []CountdownConfig, now time.Time) (*CountdownConfig, time.Time) {
+	for i := range countdowns {
+		cd := &countdowns[i]
+
+		target, err := parseEventTime(cd.Target)
+		if err != nil {
+			log.Printf("Skipping countdown %q: %v", cd.Title, err)
+			continue
+		}
+
+		leadDays := cd.LeadDays
+		if leadDays <= 0 {
+			leadDays = defaultCountdownLeadDays
+		}
+
+		if randomFloat64() < countdownFrequency(target.Sub(now), time.Duration(leadDays)*24*time.Hour) {
+			return cd, target
+		}
+	}
+	return nil, time.Time{}
+}
+
+// countdownBackground resolves the background of a countdown to an image, choosing at random when it is an album
+func countdownBackground(cd *CountdownConfig, imageDirectory string, library []string) string {
+	if cd.Background == "" {
+		return selectRandomImage(library)
+	}
+
+	if strings.HasPrefix(cd.Background, clusterAlbumPrefix) {
+		if files := albumFiles(library, imageDirectory, cd.Background); len(files) > 0 {
+			return selectRandomImage(files)
+		}
+	}
+
+	path := filepath.Join(imageDirectory, cd.Background)
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Printf("Countdown background %q not found, using a random image: %v", cd.Background, err)
+		return selectRandomImage(library)
+	}
+	if !info.IsDir() {
+		if isPrivate(path, imageDirectory) {
+			return selectRandomImage(library)
+		}
+		return path
+	}
+
+	files := albumFiles(library, imageDirectory, cd.Background)
+	if len(files) == 0 {
+		return selectRandomImage(library)
+	}
+	return selectRandomImage(files)
+}
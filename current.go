@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"randompic/client"
+)
+
+// currentHandler describes the slide on screen and when it will change, so other devices can mirror the frame
+func currentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	imageMutex.Lock()
+	current, shownAt := currentSlide, currentShownAt
+	imageMutex.Unlock()
+	// a private image is only on screen until the updater skips it after it was marked
+	if current.Image == "" || isPrivate(current.Image, config.ImageDirectory) {
+		http.Error(w, "No image is being shown", http.StatusConflict)
+		return
+	}
+
+	resp := client.Current{
+		Image:   relativeImagePath(current.Image, config.ImageDirectory),
+		URL:     imageURL(current.Image, config.ImageDirectory),
+		Kind:    "photo",
+		ShownAt: shownAt,
+		State:   "playing",
+	}
+	if current.Countdown != nil {
+		resp.Kind = "countdown"
+	} else if current.Intro != nil {
+		resp.Kind = "intro"
+	}
+	if meta, err := imageMetadataCache().get(current.Image); err == nil {
+		resp.Width, resp.Height = meta.Width, meta.Height
+		if meta.DateTaken != 0 {
+			taken := time.Unix(meta.DateTaken, 0)
+			resp.DateTaken = &taken
+		}
+	}
+
+	switch {
+	case rotationPaused.Load():
+		resp.State = "off"
+	case currentHold() != nil:
+		resp.State = "held"
+	case slideshowPaused.Load():
+		resp.State = "paused"
+	}
+	// the updater records when it next moves on, unknown while the display is off, paused or held until released
+	if next := getNextSlideAt(); resp.State != "off" && resp.State != "paused" && !next.IsZero() {
+		remaining := max(time.Until(next), 0).Seconds()
+		resp.NextAt, resp.SecondsUntilNext = &next, &remaining
+	}
+	writeJSON(w, resp)
+}
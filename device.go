@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deviceIdleTimeout is how long a browser can go without loading the page before it counts as connecting again
+const deviceIdleTimeout = 10 * time.Minute
+
+var (
+	devicesSeen      = map[string]time.Time{} // when each remote address last loaded the page
+	devicesSeenMutex sync.Mutex               // To ensure thread-safe access to `devicesSeen`
+)
+
+// DeviceClass tunes the slideshow page for a kind of browser, recognised from its User-Agent
+type DeviceClass struct {
+	Name          string   `json:"name" desc:"Name of the class, a page can also be put in the class with ?device=<name>" required:"true"`
+	UserAgents    []string `json:"userAgents" desc:"Browsers whose User-Agent contains any of these strings, ignoring case, are in this class"`
+	ReduceMotion  bool     `json:"reduceMotion" desc:"Turn off animations and transitions, for slow TV browsers" default:"false"`
+	TouchControls bool     `json:"touchControls" desc:"Always show large controls and allow swiping between photos, for phones and tablets" default:"false"`
+	Legacy        bool     `json:"legacy" desc:"Serve a plain page that reloads with a meta refresh and shows downsized JPEGs, for old TV browsers that can't run scripts" default:"false"`
+	ImageSize     string   `json:"imageSize" desc:"Largest size of the downsized JPEGs on the legacy page, WIDTHxHEIGHT" default:"1280x720"`
+}
+
+// defaultDeviceClasses are used when deviceClasses is not configured.  Old TV browsers are matched first
+// as they also mention the TV platform, and TVs before phones as some TV browsers also mention Android.
+var defaultDeviceClasses = []DeviceClass{
+	{
+		Name:       "legacy",
+		UserAgents: []string{"Opera TV", "Presto", "NetFront", "Maple", "Espial", "MSIE"},
+		Legacy:     true,
+	},
+	{
+		Name:         "tv",
+		UserAgents:   []string{"SmartTV", "SMART-TV", "Tizen", "Web0S", "WebOS", "NetCast", "BRAVIA", "HbbTV", "Android TV", "AFT", "CrKey"},
+		ReduceMotion: true,
+	},
+	{
+		Name:          "phone",
+		UserAgents:    []string{"iPhone", "iPad", "Android", "Mobile"},
+		TouchControls: true,
+	},
+}
+
+// classifyDevice returns the device class of the browser making the request, or nil when it is not in any class.
+// ?device=<name> picks a class by name, overriding the User-Agent.
+func classifyDevice(r *http.Request, config *Config) *DeviceClass {
+	classes := config.DeviceClasses
+	if classes == nil {
+		classes = defaultDeviceClasses
+	}
+
+	if name := r.URL.Query().Get("device"); name != "" {
+		for i := range classes {
+			if strings.EqualFold(classes[i].Name, name) {
+				return &classes[i]
+			}
+		}
+		return nil
+	}
+
+	userAgent := strings.ToLower(r.UserAgent())
+	for i := range classes {
+		for _, pattern := range classes[i].UserAgents {
+			if pattern != "" && strings.Contains(userAgent, strings.ToLower(pattern)) {
+				return &classes[i]
+			}
+		}
+	}
+	return nil
+}
+
+// noteDevice records a browser loading the page, publishing a deviceEvent when it hasn't been seen for a while
+func noteDevice(r *http.Request, device *DeviceClass) {
+	address := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+
+	now := time.Now()
+	devicesSeenMutex.Lock()
+	last, seen := devicesSeen[address]
+	devicesSeen[address] = now
+	for other, at := range devicesSeen {
+		if now.Sub(at) > deviceIdleTimeout {
+			delete(devicesSeen, other)
+		}
+	}
+	devicesSeenMutex.Unlock()
+
+	if seen && now.Sub(last) <= deviceIdleTimeout {
+		return
+	}
+	event := deviceEvent{Address: address, UserAgent: r.UserAgent()}
+	if device != nil {
+		event.Class = device.Name
+	}
+	deviceEvents.publish(event)
+}
+
+// connectedDevices returns the number of browsers that have loaded the page within deviceIdleTimeout
+func connectedDevices() int {
+	devicesSeenMutex.Lock()
+	defer devicesSeenMutex.Unlock()
+
+	count := 0
+	for _, at := range devicesSeen {
+		if time.Since(at) <= deviceIdleTimeout {
+			count++
+		}
+	}
+	return count
+}
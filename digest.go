@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digestPath is where the statistics collected for the next digest are persisted
+const digestPath = "./randompic-digest.json"
+
+// digestSaveInterval limits how often the statistics are written, to spare SD cards
+const digestSaveInterval = time.Minute
+
+const digestTopImages = 10
+
+// EmailDigestConfig configures the weekly email summarising the slideshow
+type EmailDigestConfig struct {
+	SMTPHost string   `json:"smtpHost" desc:"SMTP server the digest is sent through" required:"true"`
+	SMTPPort int      `json:"smtpPort" desc:"Port of the SMTP server, STARTTLS is used when the server offers it" default:"587"`
+	Username string   `json:"username" desc:"User name to log in to the SMTP server with, no login when empty"`
+	Password string   `json:"password" desc:"Password to log in to the SMTP server with" secret:"true"`
+	From     string   `json:"from" desc:"Address the digest is sent from" required:"true"`
+	To       []string `json:"to" desc:"Addresses the digest is sent to" required:"true"`
+	Weekday  string   `json:"weekday" desc:"Day of the week the digest is sent" default:"sunday" enum:"sunday,monday,tuesday,wednesday,thursday,friday,saturday"`
+	Time     string   `json:"time" desc:"Local time the digest is sent" format:"time" default:"18:00"`
+}
+
+// digestStats are the statistics collected since the last digest was sent
+type digestStats struct {
+	PeriodStart time.Time      `json:"periodStart"`
+	Shows       map[string]int `json:"shows"`    // times each image was shown
+	Problems    map[string]int `json:"problems"` // times each problem occurred
+}
+
+var (
+	digest      *digestStats
+	digestErr   error // set when the saved statistics couldn't be read, so they aren't written over
+	digestSaved time.Time
+	digestMutex sync.Mutex // To ensure thread-safe access to `digest`, `digestErr` and `digestSaved`
+)
+
+// loadDigest reads the statistics from disk on first use
+func loadDigest() *digestStats {
+	if digest != nil {
+		return digest
+	}
+
+	digest = &digestStats{PeriodStart: time.Now(), Shows: map[string]int{}, Problems: map[string]int{}}
+	data, err := os.ReadFile(digestPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			digestErr = fmt.Errorf("error reading digest statistics: %v", err)
+			log.Printf("%v, leaving %s unchanged until it is fixed and the app restarted", digestErr, digestPath)
+		}
+		return digest
+	}
+	if err := json.Unmarshal(data, digest); err != nil {
+		digestErr = fmt.Errorf("error parsing digest statistics: %v", err)
+		log.Printf("%v, leaving %s unchanged until it is fixed and the app restarted", digestErr, digestPath)
+	}
+	if digest.Shows == nil {
+		digest.Shows = map[string]int{}
+	}
+	if digest.Problems == nil {
+		digest.Problems = map[string]int{}
+	}
+	return digest
+}
+
+// recordDigestShow counts an image being shown, writing the statistics to disk at most once a minute
+func recordDigestShow(file string) {
+	digestMutex.Lock()
+	defer digestMutex.Unlock()
+
+	loadDigest().Shows[file]++
+	if time.Since(digestSaved) >= digestSaveInterval {
+		writeDigest()
+	}
+}
+
+// recordDigestProblem counts a problem to be reported in the next digest
+func recordDigestProblem(problem string) {
+	digestMutex.Lock()
+	defer digestMutex.Unlock()
+
+	loadDigest().Problems[problem]++
+	if time.Since(digestSaved) >= digestSaveInterval {
+		writeDigest()
+	}
+}
+
+// saveDigest writes the statistics to disk straight away, used when the app is stopped
+func saveDigest() {
+	digestMutex.Lock()
+	defer digestMutex.Unlock()
+	if digest != nil {
+		writeDigest()
+	}
+}
+
+// writeDigest writes the statistics to disk, the caller must hold digestMutex
+func writeDigest() {
+	if digestErr != nil {
+		return
+	}
+	data, err := json.Marshal(digest)
+	if err != nil {
+		log.Printf("Error encoding digest statistics: %v", err)
+		return
+	}
+	if err := writeFileAtomic(digestPath, data); err != nil {
+		log.Printf("Error saving digest statistics: %v", err)
+		return
+	}
+	digestSaved = time.Now()
+}
+
+// buildDigest writes the text of the digest for the statistics collected so far
+func buildDigest(config *Config, now time.Time) string {
+	digestMutex.Lock()
+	stats := loadDigest()
+	start := stats.PeriodStart
+	shows := make(map[string]int, len(stats.Shows))
+	for image, count := range stats.Shows {
+		shows[image] = count
+	}
+	problems := make(map[string]int, len(stats.Problems))
+	for problem, count := range stats.Problems {
+		problems[problem] = count
+	}
+	digestMutex.Unlock()
+	for image := range shows {
+		if isPrivate(image, config.ImageDirectory) {
+			delete(shows, image)
+		}
+	}
+
+	// Photos added are those whose files were modified during the period
+	library := currentLibrary()
+	added := 0
+	for _, file := range library {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(start) {
+			added++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Slideshow digest for %s to %s\n\n", start.Format("Mon 2 Jan"), now.Format("Mon 2 Jan"))
+	fmt.Fprintf(&b, "Library: %d photos, %d added\n", len(library), added)
+	total := 0
+	for _, count := range shows {
+		total += count
+	}
+	fmt.Fprintf(&b, "Shown: %d times, %d different photos\n", total, len(shows))
+
+	if len(shows) > 0 {
+		images := make([]string, 0, len(shows))
+		for image := range shows {
+			images = append(images, image)
+		}
+		sort.Slice(images, func(i, j int) bool {
+			if shows[images[i]] != shows[images[j]] {
+				return shows[images[i]] > shows[images[j]]
+			}
+			return images[i] < images[j]
+		})
+		b.WriteString("\nMost shown:\n")
+		for _, image := range images[:min(len(images), digestTopImages)] {
+			fmt.Fprintf(&b, "  %d× %s\n", shows[image], relativeImagePath(image, config.ImageDirectory))
+		}
+	}
+
+	if starred := withoutPrivate(favoritesSince(start)); len(starred) > 0 {
+		b.WriteString("\nNewly starred:\n")
+		for _, image := range starred {
+			fmt.Fprintf(&b, "  %s\n", image)
+		}
+	}
+
+	status := checkImageDirectory(config.ImageDirectory)
+	fmt.Fprintf(&b, "\nImage directory: %s\n", status.State)
+	if len(problems) == 0 {
+		b.WriteString("No problems were reported.\n")
+	} else {
+		b.WriteString("Problems:\n")
+		list := make([]string, 0, len(problems))
+		for problem := range problems {
+			list = append(list, problem)
+		}
+		sort.Strings(list)
+		for _, problem := range list {
+			fmt.Fprintf(&b, "  %d× %s\n", problems[problem], problem)
+		}
+	}
+	return b.String()
+}
+
+// sendDigest emails the digest and starts collecting statistics for the next one
+func sendDigest(cfg *EmailDigestConfig, config *Config) error {
+	now := time.Now()
+	body := buildDigest(config, now)
+
+	port := cfg.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.SMTPHost)
+	}
+	message := "From: " + cfg.From + "\r\n" +
+		"To: " + strings.Join(cfg.To, ", ") + "\r\n" +
+		"Subject: Slideshow digest for the week to " + now.Format("2 Jan") + "\r\n" +
+		"Date: " + now.Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+	if err := smtp.SendMail(fmt.Sprintf("%s:%d", cfg.SMTPHost, port), auth, cfg.From, cfg.To, []byte(message)); err != nil {
+		return err
+	}
+
+	digestMutex.Lock()
+	digest = &digestStats{PeriodStart: now, Shows: map[string]int{}, Problems: map[string]int{}}
+	writeDigest()
+	digestMutex.Unlock()
+	log.Printf("Digest emailed to %s", strings.Join(cfg.To, ", "))
+	return nil
+}
+
+// parseWeekday converts a day name from the config into a time.Weekday
+func parseWeekday(value string) (time.Weekday, error) {
+	if value == "" {
+		return time.Sunday, nil
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), value) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", value)
+}
+
+// nextDigestTime returns the first time after now on the given weekday at minute past midnight
+func nextDigestTime(now time.Time, weekday time.Weekday, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), minute/60, minute%60, 0, 0, now.Location())
+	next = next.AddDate(0, 0, (int(weekday)-int(now.Weekday())+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+// runEmailDigest emails the digest every week at the configured day and time
+func runEmailDigest(cfg *EmailDigestConfig) {
+	weekday, err := parseWeekday(cfg.Weekday)
+	if err != nil {
+		log.Printf("Email digest disabled: %v", err)
+		return
+	}
+	minute := 18 * 60
+	if cfg.Time != "" {
+		if minute, err = parseClockTime(cfg.Time); err != nil {
+			log.Printf("Email digest disabled: %v", err)
+			return
+		}
+	}
+
+	for {
+		next := nextDigestTime(time.Now(), weekday, minute)
+		log.Printf("Next digest will be emailed at %s", next.Format(time.RFC3339))
+		time.Sleep(time.Until(next))
+
+		config, err := loadConfig(configPath)
+		if err != nil {
+			log.Printf("Error loading config: %v", err)
+			continue
+		}
+		if err := sendDigest(cfg, config); err != nil {
+			// the statistics are kept, so next week's digest covers both weeks
+			log.Printf("Error emailing the digest: %v", err)
+		}
+	}
+}
+
+// digestHandler previews the digest collected so far (GET) or emails it straight away (POST)
+func digestHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, buildDigest(config, time.Now()))
+
+	case http.MethodPost:
+		if config.EmailDigest == nil {
+			http.Error(w, "No emailDigest is configured", http.StatusConflict)
+			return
+		}
+		if err := sendDigest(config.EmailDigest, config); err != nil {
+			http.Error(w, "Error emailing the digest: "+err.Error(), http.StatusBadGateway)
+			log.Printf("Error emailing the digest: %v", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+)
+
+const (
+	defaultMatteColor = "#111111"
+	maxMatteWidth     = 200 // pixels
+)
+
+// DisplayConfig sets the color behind the photo, a mat around it and how it fills the screen, so the frame can
+// match the room it hangs in
+type DisplayConfig struct {
+	BackgroundColor string `json:"backgroundColor" desc:"Color of the screen behind the photo, a CSS color name or #rrggbb, the theme's when empty" format:"color"`
+	MatteWidth      int    `json:"matteWidth" desc:"Width in pixels of the mat drawn around the photo, from 0 for none to 200" default:"0"`
+	MatteColor      string `json:"matteColor" desc:"Color of the mat, a CSS color name or #rrggbb" default:"#111111" format:"color"`
+	FitMode         string `json:"fitMode" desc:"contain shows the whole photo, cover fills the screen by cropping its middle, smart-crop by cropping around the faces in it and top-crop by keeping its top" default:"contain" enum:"contain,cover,smart-crop,top-crop"`
+}
+
+// displayStyle is the look the page gives the photo from the display options
+type displayStyle struct {
+	BackgroundColor string
+	MatteWidth      int
+	MatteColor      string
+}
+
+// cssColor matches the colors allowed in the display options, which are written into the page's stylesheet as they
+// are so nothing else may get through
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6}|[a-zA-Z]+)$`)
+
+// validColor reports whether a color option is a CSS color name or hex color
+func validColor(color string) bool {
+	return cssColor.MatchString(color)
+}
+
+// displayStyleFor returns the style for cfg, with out of range settings replaced by the defaults
+func displayStyleFor(cfg *DisplayConfig) *displayStyle {
+	style := &displayStyle{MatteWidth: min(max(cfg.MatteWidth, 0), maxMatteWidth), MatteColor: cfg.MatteColor}
+	if validColor(cfg.BackgroundColor) {
+		style.BackgroundColor = cfg.BackgroundColor
+	}
+	if !validColor(style.MatteColor) {
+		style.MatteColor = defaultMatteColor
+	}
+	return style
+}
+
+// pageFit returns how the page fits photos to the screen and the largest share of a photo it crops off to fill it.
+// The display fitMode is overridden by an album's own, and both by the fit applied to the screen the page is opened
+// for.
+func pageFit(config *Config, albumFitMode, screen string) (string, float64) {
+	if preset, ok := getScreenPreset(screen); ok && preset.FitMode != "" {
+		return preset.FitMode, preset.MaxCrop
+	}
+	fitMode := "contain"
+	if config.Display != nil && config.Display.FitMode != "" {
+		fitMode = config.Display.FitMode
+	}
+	if albumFitMode != "" {
+		fitMode = albumFitMode
+	}
+	if fitMode == "contain" || !slices.Contains(fitModes, fitMode) {
+		return "contain", 0
+	}
+	return fitMode, 1 // every photo fills the screen, however much is cropped
+}
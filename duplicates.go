@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// fileHashesPath is where the hashes of files that share their size with another file are persisted
+const fileHashesPath = "./randompic-hashes.json"
+
+// fileHash is the SHA-1 of a file's contents.  Size and ModTime are recorded so a changed file is hashed again.
+type fileHash struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	SHA1    string `json:"sha1"`
+}
+
+// fileHashes are the hashes of the files that share their size with another file, keyed by absolute path
+var fileHashes = &jsonStore[fileHash]{path: fileHashesPath, what: "file hashes"}
+
+var (
+	duplicateGroups [][]string         // each group of identical files found in the library, sorted with the one shown first
+	duplicateSizes  map[int64][]string // the files of the library by size, so an added file is only compared with those of its size
+	duplicatesMutex sync.Mutex         // To ensure thread-safe access to `duplicateGroups` and `duplicateSizes`
+)
+
+// hashFile returns the SHA-1 of a file's contents, reading it only when it is new or has changed
+func hashFile(file string, info os.FileInfo) (string, error) {
+	cached, ok := fileHashes.get(file)
+	if ok && cached.Size == info.Size() && cached.ModTime == info.ModTime().Unix() {
+		return cached.SHA1, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha1.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	fileHashes.set(file, fileHash{Size: info.Size(), ModTime: info.ModTime().Unix(), SHA1: sum})
+	return sum, nil
+}
+
+// findDuplicates returns the groups of files with identical contents, each sorted by path, and the files by size.
+// Only files that share their size with another file are read, so a library without duplicates is hardly read at all.
+func findDuplicates(files []string) ([][]string, map[int64][]string) {
+	bySize := map[int64][]string{}
+	infos := map[string]os.FileInfo{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], file)
+		infos[file] = info
+	}
+
+	var groups [][]string
+	for _, sameSize := range bySize {
+		if len(sameSize) < 2 {
+			continue
+		}
+		byHash := map[string][]string{}
+		for _, file := range sameSize {
+			sum, err := hashFile(file, infos[file])
+			if err != nil {
+				log.Printf("Error hashing %s: %v", file, err)
+				continue
+			}
+			byHash[sum] = append(byHash[sum], file)
+		}
+		for _, identical := range byHash {
+			if len(identical) > 1 {
+				sort.Strings(identical)
+				groups = append(groups, identical)
+			}
+		}
+	}
+	if err := fileHashes.save(); err != nil {
+		log.Printf("Error saving file hashes: %v", err)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups, bySize
+}
+
+// detectDuplicates records the identical files in the library, and with collapseDuplicates set keeps
+// only the first of each group so a photo synced into several folders isn't shown several times as often
+func detectDuplicates(files []string, config *Config) []string {
+	groups, sizes := findDuplicates(files)
+	duplicatesMutex.Lock()
+	duplicateGroups, duplicateSizes = groups, sizes
+	duplicatesMutex.Unlock()
+	if len(groups) == 0 {
+		return files
+	}
+
+	copies := 0
+	for _, group := range groups {
+		copies += len(group) - 1
+	}
+	if !config.CollapseDuplicates {
+		log.Printf("Found %d copies of %d photos in the library", copies, len(groups))
+		return files
+	}
+
+	drop := make(map[string]bool, copies)
+	for _, group := range groups {
+		for _, file := range group[1:] {
+			drop[file] = true
+		}
+	}
+	kept := make([]string, 0, len(files)-copies)
+	for _, file := range files {
+		if !drop[file] {
+			kept = append(kept, file)
+		}
+	}
+	log.Printf("Left out %d copies of %d photos in the library", copies, len(groups))
+	return kept
+}
+
+// addDuplicates records which of the files added to the library since the last scan are identical to a file already
+// in it, reading only the files of the same size and changing only the groups they join.  It returns the added files
+// to show and, with collapseDuplicates set, the files shown until now that an added file takes the place of as the
+// first of its group.
+func addDuplicates(added []string, config *Config) (kept, replaced []string) {
+	duplicatesMutex.Lock()
+	defer duplicatesMutex.Unlock()
+	if duplicateSizes == nil {
+		duplicateSizes = map[int64][]string{}
+	}
+
+	// copy the groups so readers holding the old ones are unaffected
+	groups := slices.Clone(duplicateGroups)
+	copies := 0
+	for _, file := range added {
+		info, err := os.Stat(file)
+		if err != nil {
+			kept = append(kept, file)
+			continue
+		}
+		sameSize := duplicateSizes[info.Size()]
+		if !slices.Contains(sameSize, file) {
+			duplicateSizes[info.Size()] = append(sameSize, file)
+		}
+
+		var identical []string
+		var sum string
+		for _, other := range sameSize {
+			otherInfo, err := os.Stat(other)
+			if other == file || err != nil || otherInfo.Size() != info.Size() || excludedFile(other, config) {
+				continue
+			}
+			if sum == "" {
+				if sum, err = hashFile(file, info); err != nil {
+					log.Printf("Error hashing %s: %v", file, err)
+					break
+				}
+			}
+			if otherSum, err := hashFile(other, otherInfo); err != nil {
+				log.Printf("Error hashing %s: %v", other, err)
+			} else if otherSum == sum {
+				identical = append(identical, other)
+			}
+		}
+		if len(identical) == 0 {
+			kept = append(kept, file)
+			continue
+		}
+
+		// join the group of the files it is identical to, or start one with them
+		group := append(identical, file)
+		shown := slices.Min(identical)
+		for i, existing := range groups {
+			if slices.Contains(existing, identical[0]) {
+				group = append(slices.Clone(existing), file)
+				shown = existing[0]
+				groups = slices.Delete(groups, i, i+1)
+				break
+			}
+		}
+		slices.Sort(group)
+		group = slices.Compact(group)
+		groups = append(groups, group)
+
+		switch {
+		case !config.CollapseDuplicates:
+			kept = append(kept, file)
+		case group[0] == file:
+			kept = append(kept, file)
+			if shown != file {
+				replaced = append(replaced, shown)
+			}
+		default:
+			copies++
+		}
+	}
+	if err := fileHashes.save(); err != nil {
+		log.Printf("Error saving file hashes: %v", err)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	duplicateGroups = groups
+	if copies > 0 {
+		log.Printf("Left out %d added copies of photos in the library", copies)
+	}
+	return kept, replaced
+}
+
+// duplicateGroup describes a group of identical files in the /api/duplicates response
+type duplicateGroup struct {
+	Size   int64    `json:"size"`
+	Images []string `json:"images"` // relative to imageDirectory, the one shown when collapsed first
+}
+
+// newDuplicateGroup describes a group of files, sized by the one shown
+func newDuplicateGroup(files []string, imageDirectory string) duplicateGroup {
+	group := duplicateGroup{}
+	if info, err := os.Stat(files[0]); err == nil {
+		group.Size = info.Size()
+	}
+	for _, file := range files {
+		group.Images = append(group.Images, relativeImagePath(file, imageDirectory))
+	}
+	return group
+}
+
+// duplicatesHandler lists the groups of identical files found in the library
+func duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	duplicatesMutex.Lock()
+	groups := duplicateGroups
+	duplicatesMutex.Unlock()
+	similarGroupsMutex.Lock()
+	similar := similarGroups
+	similarGroupsMutex.Unlock()
+
+	response := struct {
+		Collapsed bool             `json:"collapsed"`
+		Copies    int              `json:"copies"`
+		Bytes     int64            `json:"bytes"` // taken up by the copies
+		Groups    []duplicateGroup `json:"groups"`
+		Similar   []duplicateGroup `json:"similar,omitempty"` // groups of near identical photos, with nearDuplicates set
+	}{Collapsed: config.CollapseDuplicates, Groups: []duplicateGroup{}}
+	for _, files := range groups {
+		if files = withoutPrivateFiles(files, config.ImageDirectory); len(files) < 2 {
+			continue
+		}
+		group := newDuplicateGroup(files, config.ImageDirectory)
+		response.Groups = append(response.Groups, group)
+		response.Copies += len(files) - 1
+		response.Bytes += group.Size * int64(len(files)-1)
+	}
+	for _, files := range similar {
+		if config.NearDuplicates == nil {
+			break
+		}
+		if files = withoutPrivateFiles(files, config.ImageDirectory); len(files) < 2 {
+			continue
+		}
+		response.Similar = append(response.Similar, newDuplicateGroup(files, config.ImageDirectory))
+	}
+	writeJSON(w, response)
+}
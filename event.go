@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slideshowEvent temporarily takes over the rotation of every screen with a single album, interval and banner
+type slideshowEvent struct {
+	Album           string    `json:"album"`
+	IntervalSeconds int       `json:"intervalSeconds"`
+	Banner          string    `json:"banner"`
+	Until           time.Time `json:"until"`
+	ImageCount      int       `json:"imageCount"`
+	files           []string
+}
+
+var (
+	activeEvent *slideshowEvent
+	eventMutex  sync.Mutex // To ensure thread-safe access to `activeEvent`
+)
+
+// currentEvent returns the active event, clearing it once its end time has passed
+func currentEvent() *slideshowEvent {
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+
+	if activeEvent != nil && !time.Now().Before(activeEvent.Until) {
+		log.Printf("Event takeover for album %q ended, reverting to normal rotation", activeEvent.Album)
+		activeEvent = nil
+	}
+	return activeEvent
+}
+
+// albumFiles returns the files from the library that are inside the named album, either a sub directory
+// of the image directory or a photo cluster named clusters/<id>
+func albumFiles(files []string, imageDirectory, album string) []string {
+	if album == favoritesAlbum {
+		return favoriteFiles(files, imageDirectory)
+	}
+	if id, ok := strings.CutPrefix(album, clusterAlbumPrefix); ok {
+		if c, found := findCluster(id); found {
+			return c.files
+		}
+	}
+
+	prefix := filepath.Clean(filepath.Join(imageDirectory, album)) + string(filepath.Separator)
+
+	var matched []string
+	for _, file := range files {
+		if strings.HasPrefix(file, prefix) {
+			matched = append(matched, file)
+		}
+	}
+	return matched
+}
+
+// filterByDate keeps the files whose capture date falls inside the range
+func filterByDate(files []string, dateFrom, dateTo time.Time) []string {
+	cache := imageMetadataCache()
+	defer func() {
+		if err := cache.save(); err != nil {
+			log.Printf("Error saving metadata cache: %v", err)
+		}
+	}()
+
+	var matched []string
+	for _, file := range files {
+		meta, err := cache.get(file)
+		if err != nil {
+			log.Println("Error:", err)
+			continue
+		}
+		if inDateRange(meta.captureTime(), dateFrom, dateTo) {
+			matched = append(matched, file)
+		}
+	}
+	return matched
+}
+
+// parseEventTime accepts either an RFC3339 timestamp or the local "2006-01-02T15:04" format used by datetime-local inputs
+func parseEventTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339 or YYYY-MM-DDTHH:MM", value)
+	}
+	return t, nil
+}
+
+// eventHandler starts (POST), reports (GET) and cancels (DELETE) an event takeover
+func eventHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, currentEvent())
+
+	case http.MethodDelete:
+		eventMutex.Lock()
+		activeEvent = nil
+		eventMutex.Unlock()
+		log.Println("Event takeover cancelled")
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodPost:
+		var req struct {
+			Album           string `json:"album"`
+			IntervalSeconds int    `json:"intervalSeconds"`
+			Banner          string `json:"banner"`
+			Until           string `json:"until"`
+			DateFrom        string `json:"dateFrom"`
+			DateTo          string `json:"dateTo"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		until, err := parseEventTime(req.Until)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !until.After(time.Now()) {
+			http.Error(w, "Event end time must be in the future", http.StatusBadRequest)
+			return
+		}
+
+		config, err := loadConfig(configPath)
+		if err != nil {
+			http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error loading config: %v", err)
+			return
+		}
+
+		dateFrom, dateTo, err := parseDateRange(req.DateFrom, req.DateTo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		files := albumFiles(currentLibrary(), config.ImageDirectory, req.Album)
+		if !dateFrom.IsZero() || !dateTo.IsZero() {
+			files = filterByDate(files, dateFrom, dateTo)
+		}
+		if len(files) == 0 {
+			http.Error(w, fmt.Sprintf("Album %q contains no images", req.Album), http.StatusBadRequest)
+			return
+		}
+
+		event := &slideshowEvent{
+			Album:           req.Album,
+			IntervalSeconds: req.IntervalSeconds,
+			Banner:          req.Banner,
+			Until:           until,
+			ImageCount:      len(files),
+			files:           files,
+		}
+		if event.IntervalSeconds <= 0 {
+			event.IntervalSeconds = config.DisplaySeconds
+		}
+
+		eventMutex.Lock()
+		activeEvent = event
+		eventMutex.Unlock()
+		log.Printf("Event takeover started for album %q with %d images until %s", event.Album, event.ImageCount, event.Until.Format(time.RFC3339))
+
+		writeJSON(w, event)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// eventTopic is one kind of event on the internal event bus.  Integrations subscribe to the topics they care about
+// rather than being called from the rotation loop, library updates or scans directly.  Subscribers are called
+// synchronously in the order they subscribed, so one that does slow work such as a network call must hand it off
+// to its own goroutine.
+type eventTopic[T any] struct {
+	subscribers []func(T)
+	mutex       sync.RWMutex // To ensure thread-safe access to `subscribers`
+}
+
+// subscribe calls fn with every event published from now on
+func (t *eventTopic[T]) subscribe(fn func(T)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.subscribers = append(t.subscribers, fn)
+}
+
+// publish passes an event to each subscriber in turn
+func (t *eventTopic[T]) publish(event T) {
+	t.mutex.RLock()
+	subscribers := t.subscribers
+	t.mutex.RUnlock()
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+// rotationEvent is published each time a slide is put on screen
+type rotationEvent struct {
+	Slide     slide
+	Revisited bool    // set when the slide was stepped back or forward to rather than newly chosen
+	Config    *Config // the config the slide was chosen with
+}
+
+// libraryEvent is published when the library of images is replaced or updated, both lists are sorted
+type libraryEvent struct {
+	Old, New []string
+}
+
+// deviceEvent is published when a browser loads the slideshow page for the first time in a while
+type deviceEvent struct {
+	Address   string // remote address, without the port
+	UserAgent string
+	Class     string // name of the matching device class, empty when none matched
+}
+
+// errorEvent is published when something goes wrong that the owner should hear about
+type errorEvent struct {
+	Problem string // a short description, the same each time the same problem occurs
+	Err     error
+}
+
+var (
+	rotationEvents eventTopic[rotationEvent]
+	libraryEvents  eventTopic[libraryEvent]
+	deviceEvents   eventTopic[deviceEvent]
+	errorEvents    eventTopic[errorEvent]
+)
+
+// subscribeIntegrations connects the built in integrations to the event bus
+func subscribeIntegrations() {
+	rotationEvents.subscribe(func(e rotationEvent) {
+		recordHistory(e.Slide, e.Config)
+	})
+	rotationEvents.subscribe(func(e rotationEvent) {
+		slidesShown.Add(1)
+	})
+	rotationEvents.subscribe(func(e rotationEvent) {
+		broadcastSlide(e.Slide, e.Config)
+	})
+	rotationEvents.subscribe(func(e rotationEvent) {
+		if !e.Revisited && e.Slide.Image != "" && e.Config.EmailDigest != nil {
+			recordDigestShow(e.Slide.Image)
+		}
+	})
+	libraryEvents.subscribe(func(e libraryEvent) {
+		recordPoolChange(e.Old, e.New)
+	})
+	libraryEvents.subscribe(func(e libraryEvent) {
+		forgetOrientations()
+	})
+	libraryEvents.subscribe(func(e libraryEvent) {
+		forgetScheduleDates()
+	})
+	libraryEvents.subscribe(func(e libraryEvent) {
+		forgetAlbumDates()
+	})
+	deviceEvents.subscribe(func(e deviceEvent) {
+		if e.Class != "" {
+			log.Printf("Device connected from %s (%s): %s", e.Address, e.Class, e.UserAgent)
+		} else {
+			log.Printf("Device connected from %s: %s", e.Address, e.UserAgent)
+		}
+	})
+	errorEvents.subscribe(func(e errorEvent) {
+		recordDigestProblem(e.Problem)
+	})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EXIF tag ids used by the reader
+const (
+	exifTagMake             = 0x010F
+	exifTagModel            = 0x0110
+	exifTagOrientation      = 0x0112
+	exifTagDateTime         = 0x0132
+	exifTagExposureTime     = 0x829A
+	exifTagFNumber          = 0x829D
+	exifTagExifIFD          = 0x8769
+	exifTagGPSIFD           = 0x8825
+	exifTagISO              = 0x8827
+	exifTagDateTimeOriginal = 0x9003
+	exifTagFocalLength      = 0x920A
+	gpsTagLatitudeRef       = 0x0001
+	gpsTagLatitude          = 0x0002
+	gpsTagLongitudeRef      = 0x0003
+	gpsTagLongitude         = 0x0004
+)
+
+// exifData holds the subset of EXIF fields randompic makes use of
+type exifData struct {
+	DateTaken   time.Time
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+	Camera      string // make and model, e.g. "FUJIFILM X100V"
+	Exposure    string // e.g. "f/2.8 1/250s ISO 200 35mm", the settings that were recorded
+	Orientation int    // 1 to 8, how the stored pixels are turned or mirrored from the way the photo is seen, 0 when not recorded
+}
+
+// maxExifSegment bounds how much of a file is read while looking for EXIF data
+const maxExifSegment = 1 << 20
+
+// readExif extracts EXIF data from a JPEG or TIFF based file without decoding the image, or from the JPEG a HEIC
+// photo is converted to
+func readExif(file string) (*exifData, error) {
+	if isHEIC(file) {
+		converted, err := decodableFile(file)
+		if err != nil {
+			return nil, err
+		}
+		file = converted
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+
+	var tiff []byte
+	switch {
+	case magic[0] == 0xFF && magic[1] == 0xD8:
+		tiff, err = jpegExifSegment(r)
+	case string(magic) == "II*\x00" || string(magic) == "MM\x00*":
+		// TIFF based formats (including most RAW files) start with the EXIF structure itself
+		tiff, err = io.ReadAll(io.LimitReader(r, maxExifSegment))
+	default:
+		return nil, fmt.Errorf("no EXIF support for this file type")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTiff(tiff)
+}
+
+// jpegExifSegment walks the JPEG markers and returns the TIFF structure inside the APP1 Exif segment
+func jpegExifSegment(r *bufio.Reader) ([]byte, error) {
+	// skip the SOI marker
+	if _, err := r.Discard(2); err != nil {
+		return nil, err
+	}
+
+	for {
+		marker := make([]byte, 4)
+		if _, err := io.ReadFull(r, marker); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xFF {
+			return nil, fmt.Errorf("invalid JPEG marker")
+		}
+		// start of scan or end of image, there are no more metadata segments
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, fmt.Errorf("no EXIF data found")
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return nil, fmt.Errorf("invalid JPEG segment length")
+		}
+		if marker[1] != 0xE1 {
+			if _, err := r.Discard(length); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// tiffReader reads IFD entries from a TIFF structure
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// parseTiff reads the fields of interest from IFD0 and the Exif sub IFD
+func parseTiff(data []byte) (*exifData, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("EXIF data too short")
+	}
+
+	t := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid EXIF byte order")
+	}
+
+	ifd0, err := t.readIFD(t.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	exif := &exifData{Camera: cameraName(t.stringValue(ifd0[exifTagMake]), t.stringValue(ifd0[exifTagModel]))}
+	if entry, ok := ifd0[exifTagOrientation]; ok {
+		exif.Orientation = int(t.order.Uint16(entry[8:]))
+	}
+	dateValue := t.stringValue(ifd0[exifTagDateTime])
+	if offset, ok := ifd0[exifTagExifIFD]; ok {
+		if sub, err := t.readIFD(t.order.Uint32(offset[8:])); err == nil {
+			if original := t.stringValue(sub[exifTagDateTimeOriginal]); original != "" {
+				dateValue = original
+			}
+			exif.Exposure = t.exposure(sub)
+		}
+	}
+	if dateValue != "" {
+		if taken, err := time.ParseInLocation("2006:01:02 15:04:05", dateValue, time.Local); err == nil {
+			exif.DateTaken = taken
+		}
+	}
+
+	if offset, ok := ifd0[exifTagGPSIFD]; ok {
+		if gps, err := t.readIFD(t.order.Uint32(offset[8:])); err == nil {
+			lat, latOK := t.degreesValue(gps[gpsTagLatitude])
+			lon, lonOK := t.degreesValue(gps[gpsTagLongitude])
+			if latOK && lonOK {
+				if t.stringValue(gps[gpsTagLatitudeRef]) == "S" {
+					lat = -lat
+				}
+				if t.stringValue(gps[gpsTagLongitudeRef]) == "W" {
+					lon = -lon
+				}
+				exif.HasLocation = true
+				exif.Latitude = lat
+				exif.Longitude = lon
+			}
+		}
+	}
+
+	return exif, nil
+}
+
+// cameraName joins the make and model of a camera.  Many makers already start the model with their name, such as
+// NIKON CORPORATION and NIKON D750, in which case the model is used alone.
+func cameraName(maker, model string) string {
+	if brand, _, _ := strings.Cut(maker, " "); brand == "" || strings.HasPrefix(strings.ToLower(model), strings.ToLower(brand)) {
+		return model
+	}
+	if model == "" {
+		return maker
+	}
+	return maker + " " + model
+}
+
+// exposure describes the aperture, shutter speed, ISO and focal length recorded in the Exif sub IFD
+func (t *tiffReader) exposure(sub map[uint16][]byte) string {
+	var parts []string
+	if num, den, ok := t.rationalValue(sub[exifTagFNumber]); ok {
+		parts = append(parts, "f/"+strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64))
+	}
+	if num, den, ok := t.rationalValue(sub[exifTagExposureTime]); ok {
+		if seconds := float64(num) / float64(den); seconds >= 1 {
+			parts = append(parts, strconv.FormatFloat(seconds, 'f', -1, 64)+"s")
+		} else {
+			parts = append(parts, fmt.Sprintf("1/%.0fs", 1/seconds))
+		}
+	}
+	if entry := sub[exifTagISO]; entry != nil && t.order.Uint16(entry[2:]) == 3 {
+		parts = append(parts, fmt.Sprintf("ISO %d", t.order.Uint16(entry[8:])))
+	}
+	if num, den, ok := t.rationalValue(sub[exifTagFocalLength]); ok {
+		parts = append(parts, fmt.Sprintf("%.0fmm", float64(num)/float64(den)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// rationalValue returns the numerator and denominator of an unsigned rational entry, false when the entry is
+// missing, malformed or zero
+func (t *tiffReader) rationalValue(entry []byte) (uint32, uint32, bool) {
+	if entry == nil || t.order.Uint16(entry[2:]) != 5 {
+		return 0, 0, false
+	}
+	offset := int(t.order.Uint32(entry[8:]))
+	if offset+8 > len(t.data) {
+		return 0, 0, false
+	}
+	num, den := t.order.Uint32(t.data[offset:]), t.order.Uint32(t.data[offset+4:])
+	return num, den, num != 0 && den != 0
+}
+
+// degreesValue converts a GPS coordinate stored as three rationals (degrees, minutes, seconds) to decimal degrees
+func (t *tiffReader) degreesValue(entry []byte) (float64, bool) {
+	if entry == nil || t.order.Uint16(entry[2:]) != 5 || t.order.Uint32(entry[4:]) != 3 {
+		return 0, false
+	}
+
+	offset := int(t.order.Uint32(entry[8:]))
+	if offset+24 > len(t.data) {
+		return 0, false
+	}
+
+	var parts [3]float64
+	for i := range parts {
+		num := t.order.Uint32(t.data[offset+i*8:])
+		den := t.order.Uint32(t.data[offset+i*8+4:])
+		if den == 0 {
+			return 0, false
+		}
+		parts[i] = float64(num) / float64(den)
+	}
+	return parts[0] + parts[1]/60 + parts[2]/3600, true
+}
+
+// readIFD returns the raw 12 byte entries of the IFD at offset keyed by tag id
+func (t *tiffReader) readIFD(offset uint32) (map[uint16][]byte, error) {
+	if int(offset)+2 > len(t.data) {
+		return nil, fmt.Errorf("IFD offset out of range")
+	}
+	count := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(t.data) {
+		return nil, fmt.Errorf("IFD entries out of range")
+	}
+
+	entries := make(map[uint16][]byte, count)
+	for i := 0; i < count; i++ {
+		entry := t.data[start+i*12 : start+(i+1)*12]
+		entries[t.order.Uint16(entry)] = entry
+	}
+	return entries, nil
+}
+
+// stringValue returns the value of an ASCII entry, or "" if the entry is missing or malformed
+func (t *tiffReader) stringValue(entry []byte) string {
+	if entry == nil || t.order.Uint16(entry[2:]) != 2 {
+		return ""
+	}
+
+	count := int(t.order.Uint32(entry[4:]))
+	var value []byte
+	if count <= 4 {
+		value = entry[8 : 8+count]
+	} else {
+		offset := int(t.order.Uint32(entry[8:]))
+		if offset+count > len(t.data) {
+			return ""
+		}
+		value = t.data[offset : offset+count]
+	}
+	return strings.TrimRight(string(value), "\x00 ")
+}
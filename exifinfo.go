@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ExifOverlayConfig shows when and where each photo was taken, and with which camera, under the photo
+type ExifOverlayConfig struct {
+	ShowExposure bool   `json:"showExposure" desc:"Also show the aperture, shutter speed, ISO and focal length" default:"false"`
+	Location     string `json:"location" desc:"How the place a photo was taken is shown: its name looked up with OpenStreetMap, its GPS coordinates or not at all" default:"name" enum:"name,coordinates,none"`
+}
+
+// exifDetails returns the line of details shown under a photo: when and where it was taken and the camera, empty
+// when there are none
+func exifDetails(cfg *ExifOverlayConfig, file string, messages *messageCatalog) string {
+	if file == "" {
+		return ""
+	}
+	meta, err := imageMetadataCache().get(file)
+	if err != nil {
+		return ""
+	}
+
+	var parts []string
+	if meta.DateTaken != 0 {
+		parts = append(parts, messages.date(time.Unix(meta.DateTaken, 0), "date.long"))
+	}
+	if meta.HasLocation {
+		switch cfg.Location {
+		case "coordinates":
+			parts = append(parts, formatCoordinates(meta.Latitude, meta.Longitude))
+		case "none":
+		default:
+			// coordinates stand in for the name until it has been looked up
+			if name, ok := placeName(meta.Latitude, meta.Longitude); ok && name != "" {
+				parts = append(parts, name)
+			} else {
+				parts = append(parts, formatCoordinates(meta.Latitude, meta.Longitude))
+			}
+		}
+	}
+	if meta.Camera != "" {
+		parts = append(parts, meta.Camera)
+	}
+	if cfg.ShowExposure && meta.Exposure != "" {
+		parts = append(parts, meta.Exposure)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// prefetchPlaceName looks up the name of the place a photo was taken ahead of it being shown
+func prefetchPlaceName(cfg *ExifOverlayConfig, file string) {
+	if cfg.Location != "" && cfg.Location != "name" {
+		return
+	}
+	if meta, err := imageMetadataCache().get(file); err == nil && meta.HasLocation {
+		placeName(meta.Latitude, meta.Longitude)
+	}
+}
+
+// formatCoordinates writes a position the way it is usually read, e.g. 51.5072°N 0.1276°W
+func formatCoordinates(latitude, longitude float64) string {
+	ns, ew := "N", "E"
+	if latitude < 0 {
+		ns, latitude = "S", -latitude
+	}
+	if longitude < 0 {
+		ew, longitude = "W", -longitude
+	}
+	return fmt.Sprintf("%.4f°%s %.4f°%s", latitude, ns, longitude, ew)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	detectedFacesPath           = "./randompic-faces.json"
+	faceDetectionImageSize      = 1024 // longest side in pixels of the copy of a photo faces are looked for in
+	defaultFaceDetectionTimeout = 30
+)
+
+// FaceDetectionConfig runs a face detector over photos that are cropped to fill the screen, so the crop keeps the
+// faces in frame rather than cutting off heads
+type FaceDetectionConfig struct {
+	Command        string `json:"command" desc:"Command run with the path of an upright JPEG of the photo added as its last argument, printing the faces it finds as a JSON array of {x, y, width, height} boxes in pixels" required:"true"`
+	TimeoutSeconds int    `json:"timeoutSeconds" desc:"How long to wait for the command to look at one photo" default:"30"`
+}
+
+// faceBox is a face found in a photo, as fractions of the width and height of the photo shown upright
+type faceBox struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// detectedFaces are the faces found in a photo, none when there are none.  Size and ModTime are recorded so a
+// changed photo is looked at again.
+type detectedFaces struct {
+	Faces   []faceBox `json:"faces"`
+	Size    int64     `json:"size"`
+	ModTime int64     `json:"modTime"`
+}
+
+// faceDetections are the faces found in the photos looked at, keyed by absolute path
+var faceDetections = &jsonStore[detectedFaces]{path: detectedFacesPath, what: "detected faces"}
+
+var (
+	facesDetecting      = map[string]bool{} // photos being looked at in the background for the page
+	facesDetectingMutex sync.Mutex          // To ensure thread-safe access to `facesDetecting`
+)
+
+// photoFaces returns the faces in a photo, running the configured face detector only when the photo is new or has
+// changed.  There are none when no face detector is configured.
+func photoFaces(file string) ([]faceBox, error) {
+	config, err := loadConfig(configPath)
+	if err != nil || config.FaceDetection == nil {
+		return nil, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if faces, ok := cachedFaces(file, info); ok {
+		return faces, nil
+	}
+
+	faces, err := detectFaces(file, config.FaceDetection)
+	if err != nil {
+		return nil, err
+	}
+	faceDetections.set(file, detectedFaces{Faces: faces, Size: info.Size(), ModTime: info.ModTime().Unix()})
+	if err := faceDetections.save(); err != nil {
+		log.Printf("Error saving detected faces: %v", err)
+	}
+	return faces, nil
+}
+
+// cachedFaces returns the faces found in a photo when it has been looked at since it last changed
+func cachedFaces(file string, info os.FileInfo) ([]faceBox, bool) {
+	cached, ok := faceDetections.get(file)
+	if !ok || cached.Size != info.Size() || cached.ModTime != info.ModTime().Unix() {
+		return nil, false
+	}
+	return cached.Faces, true
+}
+
+// pageFaces returns the faces in a photo for the page to crop around without waiting for the face detector.  A photo
+// not looked at yet is looked at in the background, so its faces are known the next time it is shown.
+func pageFaces(file string, config *Config) []faceBox {
+	if config.FaceDetection == nil || file == "" || isVideo(file) {
+		return nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil
+	}
+	if faces, ok := cachedFaces(file, info); ok {
+		return faces
+	}
+
+	facesDetectingMutex.Lock()
+	defer facesDetectingMutex.Unlock()
+	if facesDetecting[file] {
+		return nil
+	}
+	facesDetecting[file] = true
+	go func() {
+		if _, err := photoFaces(file); err != nil {
+			log.Printf("Error looking for faces in %s: %v", file, err)
+		}
+		facesDetectingMutex.Lock()
+		delete(facesDetecting, file)
+		facesDetectingMutex.Unlock()
+	}()
+	return nil
+}
+
+// detectFaces runs the face detector over a small upright JPEG of the photo, so it never has to read HEIC or RAW
+// files or EXIF orientations, and turns the boxes it prints into fractions of the photo
+func detectFaces(file string, cfg *FaceDetectionConfig) ([]faceBox, error) {
+	args := strings.Fields(cfg.Command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no face detection command is set")
+	}
+	width, height, err := probeDimensions(file)
+	if err != nil {
+		return nil, err
+	}
+	scale := min(1, float64(faceDetectionImageSize)/float64(max(width, height, 1)))
+	width, height = max(int(float64(width)*scale), 1), max(int(float64(height)*scale), 1)
+	frame, err := renderFrame(file, width, height, "contain")
+	if err != nil {
+		return nil, err
+	}
+
+	upright, err := os.CreateTemp("", "randompic-faces-*.jpg")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(upright.Name())
+	err = jpeg.Encode(upright, frame, &jpeg.Options{Quality: resizeJPEGQuality})
+	if closeErr := upright.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultFaceDetectionTimeout * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], upright.Name())...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("face detection failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var boxes []struct {
+		X, Y, Width, Height float64
+	}
+	if err := json.Unmarshal(output, &boxes); err != nil {
+		return nil, fmt.Errorf("error parsing the faces found: %v", err)
+	}
+	faces := []faceBox{}
+	for _, box := range boxes {
+		if box.Width <= 0 || box.Height <= 0 {
+			continue
+		}
+		faces = append(faces, faceBox{
+			X:      min(max(box.X/float64(width), 0), 1),
+			Y:      min(max(box.Y/float64(height), 0), 1),
+			Width:  min(box.Width/float64(width), 1),
+			Height: min(box.Height/float64(height), 1),
+		})
+	}
+	return faces, nil
+}
+
+// smartCropFocus returns the point of a photo, as fractions of its width and height, that a crop showing windowWidth
+// by windowHeight of it is centred on: the middle of all the faces when they fit in the window together, otherwise
+// the middle of the largest face, and the middle of the photo when there are none
+func smartCropFocus(file string, windowWidth, windowHeight float64) (float64, float64) {
+	faces, err := photoFaces(file)
+	if err != nil {
+		log.Printf("Error looking for faces in %s, cropping its middle: %v", file, err)
+	}
+	if len(faces) == 0 {
+		return 0.5, 0.5
+	}
+
+	left, top, right, bottom := 1.0, 1.0, 0.0, 0.0
+	largest := faces[0]
+	for _, face := range faces {
+		left, top = min(left, face.X), min(top, face.Y)
+		right, bottom = max(right, face.X+face.Width), max(bottom, face.Y+face.Height)
+		if face.Width*face.Height > largest.Width*largest.Height {
+			largest = face
+		}
+	}
+	if right-left <= windowWidth && bottom-top <= windowHeight {
+		return (left + right) / 2, (top + bottom) / 2
+	}
+	return largest.X + largest.Width/2, largest.Y + largest.Height/2
+}
+
+// faceDetectionProblems reports face detection settings that can't work
+func faceDetectionProblems(config *Config) []string {
+	if config.FaceDetection == nil {
+		return nil
+	}
+	args := strings.Fields(config.FaceDetection.Command)
+	if len(args) == 0 {
+		return []string{"faceDetection needs a command to find faces with"}
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return []string{fmt.Sprintf("faceDetection command %s can't be found", args[0])}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"randompic/client"
+)
+
+// favoritesPath is where the starred images are persisted
+const favoritesPath = "./randompic-favorites.json"
+
+// favoritesAlbum is the virtual album of starred images, usable anywhere an album is
+const favoritesAlbum = "starred"
+
+// favorites is when each image, relative to imageDirectory, was starred
+var favorites = &jsonStore[time.Time]{path: favoritesPath, what: "favorites", userData: true}
+
+// isFavorite reports whether an image has been starred
+func isFavorite(file, imageDirectory string) bool {
+	_, ok := favorites.get(relativeImagePath(file, imageDirectory))
+	return ok
+}
+
+// favoriteFiles returns the files that have been starred
+func favoriteFiles(files []string, imageDirectory string) []string {
+	var matched []string
+	favorites.read(func(starred map[string]time.Time) {
+		for _, file := range files {
+			if _, ok := starred[relativeImagePath(file, imageDirectory)]; ok {
+				matched = append(matched, file)
+			}
+		}
+	})
+	return matched
+}
+
+// favoritesSince returns the images starred after a time, in order
+func favoritesSince(since time.Time) []string {
+	var images []string
+	favorites.read(func(starred map[string]time.Time) {
+		for image, at := range starred {
+			if at.After(since) {
+				images = append(images, image)
+			}
+		}
+	})
+	sort.Strings(images)
+	return images
+}
+
+// setFavorite stars or unstars an image, given relative to imageDirectory, and writes the favorites to disk
+func setFavorite(image string, starred bool) error {
+	if starred {
+		favorites.set(image, time.Now())
+	} else {
+		favorites.delete(image)
+	}
+	return favorites.save()
+}
+
+// favoritesHandler lists the starred images (GET), stars the current image or the one given (POST)
+// or unstars an image, the current image when ?image= is not given (DELETE)
+func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		images := []string{}
+		favorites.read(func(starred map[string]time.Time) {
+			for image := range starred {
+				images = append(images, image)
+			}
+		})
+		sort.Strings(images)
+		writeJSON(w, withoutPrivate(images))
+
+	case http.MethodPost:
+		var req client.ImageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		image := req.Image
+		if image == "" {
+			current := getCurrentSlide().Image
+			if current == "" {
+				http.Error(w, "No image is being shown", http.StatusConflict)
+				return
+			}
+			image = relativeImagePath(current, config.ImageDirectory)
+		}
+		image = relativeImagePath(filepath.Join(config.ImageDirectory, filepath.FromSlash(image)), config.ImageDirectory)
+
+		if err := setFavorite(image, true); err != nil {
+			http.Error(w, "Error saving favorites: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error saving favorites: %v", err)
+			return
+		}
+		log.Printf("Starred %s", image)
+		writeJSON(w, client.ImageResponse{Image: image})
+
+	case http.MethodDelete:
+		image := r.URL.Query().Get("image")
+		if image == "" {
+			current := getCurrentSlide().Image
+			if current == "" {
+				http.Error(w, "No image is being shown", http.StatusConflict)
+				return
+			}
+			image = relativeImagePath(current, config.ImageDirectory)
+		}
+		if !isFavorite(filepath.Join(config.ImageDirectory, filepath.FromSlash(image)), config.ImageDirectory) {
+			http.Error(w, "The image is not starred", http.StatusNotFound)
+			return
+		}
+		if err := setFavorite(image, false); err != nil {
+			http.Error(w, "Error saving favorites: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error saving favorites: %v", err)
+			return
+		}
+		log.Printf("Unstarred %s", image)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
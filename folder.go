@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// folderIntro is the generated slide shown when sequential mode enters a new folder
+type folderIntro struct {
+	Name     string
+	DateFrom time.Time
+	DateTo   time.Time
+	Images   int
+}
+
+// coverNames are the file names (without extension) recognised as a folder's cover image
+var coverNames = []string{"cover", "folder"}
+
+// folderFiles returns the files from the list that are directly inside dir
+func folderFiles(files []string, dir string) []string {
+	var matched []string
+	for _, file := range files {
+		if filepath.Dir(file) == dir {
+			matched = append(matched, file)
+		}
+	}
+	return matched
+}
+
+// folderCover returns the folder's cover image, a file named cover or folder if there is one, otherwise its first image
+func folderCover(files []string) string {
+	for _, file := range files {
+		name := strings.ToLower(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+		if contains(coverNames, name) {
+			return file
+		}
+	}
+	return files[0]
+}
+
+// buildFolderIntro creates the intro slide for the folder containing image, returning its cover image
+func buildFolderIntro(image, imageDirectory string, library []string) (*folderIntro, string) {
+	dir := filepath.Dir(image)
+	files := folderFiles(library, dir)
+
+	name, err := filepath.Rel(imageDirectory, dir)
+	if err != nil || name == "." {
+		name = filepath.Base(dir)
+	}
+	intro := &folderIntro{Name: name, Images: len(files)}
+
+	// the date range covers the capture dates of every photo in the folder
+	cache := imageMetadataCache()
+	for _, file := range files {
+		meta, err := cache.get(file)
+		if err != nil {
+			log.Println("Error:", err)
+			continue
+		}
+		taken := meta.captureTime()
+		if intro.DateFrom.IsZero() || taken.Before(intro.DateFrom) {
+			intro.DateFrom = taken
+		}
+		if taken.After(intro.DateTo) {
+			intro.DateTo = taken
+		}
+	}
+	if err := cache.save(); err != nil {
+		log.Printf("Error saving metadata cache: %v", err)
+	}
+
+	return intro, folderCover(files)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"randompic/client"
+)
+
+const (
+	followRequestTimeout = 10 * time.Second
+	followIdleTimeout    = 2 * eventStreamKeepIdle // the leader sends a keepalive well within this, so the connection is dead
+	followRetryMin       = time.Second
+	followRetryMax       = time.Minute
+)
+
+// FollowConfig shows the slides of another randompic instance, the leader, so every frame in the house changes
+// photo at the same moment
+type FollowConfig struct {
+	Leader string `json:"leader" desc:"Base URL of the randompic instance to follow, e.g. http://living-room.local" format:"url" required:"true"`
+}
+
+var leader atomic.Pointer[client.Client] // the instance being followed, nil when this instance chooses its own slides
+
+// startRotation starts the image updater, or follows the leader when the config names one, and the slideshow of
+// each named album
+func startRotation(config *Config) {
+	if config.Follow != nil {
+		go followLeader(config.Follow)
+	} else {
+		go updateImagePeriodically(config)
+	}
+	startAlbumRotations(config)
+}
+
+// followLeader shows each slide the leader pushes for as long as the app runs, reconnecting whenever the
+// connection drops.  The slide on screen is kept while the leader can't be reached.
+func followLeader(cfg *FollowConfig) {
+	frame := client.New(cfg.Leader)
+	leader.Store(frame)
+
+	delay := followRetryMin
+	for {
+		connected, err := followEvents(frame)
+		if connected {
+			delay = followRetryMin
+		}
+		log.Printf("Lost the connection to the leader %s, retrying in %s: %v", frame.BaseURL, delay, err)
+		time.Sleep(delay)
+		delay = min(delay*2, followRetryMax)
+	}
+}
+
+// followEvents reads the leader's event stream until it ends, reporting whether it connected at all
+func followEvents(frame *client.Client) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, frame.BaseURL+apiVersionPrefix+"events", nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	log.Printf("Following the slideshow of %s", frame.BaseURL)
+
+	// Catch up with the slide already on screen, the stream only carries the slides that follow
+	syncWithLeader(frame, true)
+
+	// A leader that vanished from the network leaves the connection open without sending anything
+	idle := time.AfterFunc(followIdleTimeout, func() { resp.Body.Close() })
+	defer idle.Stop()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		idle.Reset(followIdleTimeout)
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var msg client.Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			log.Printf("Error decoding message from the leader: %v", err)
+			continue
+		}
+		switch msg.Type {
+		case client.MessageSlide:
+			if msg.Slide != nil {
+				showLeaderSlide(msg.Slide.Image)
+				syncWithLeader(frame, false)
+			}
+		case client.MessageRefresh:
+			syncWithLeader(frame, false)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, errors.New("the event stream ended")
+}
+
+// syncWithLeader copies the leader's pause state and next slide, and also the slide on screen when withCurrent
+// is set
+func syncWithLeader(frame *client.Client, withCurrent bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), followRequestTimeout)
+	defer cancel()
+
+	if current, err := frame.Current(ctx); err == nil {
+		if withCurrent {
+			showLeaderSlide(current.Image)
+		}
+		if paused := current.State == "paused"; slideshowPaused.Swap(paused) != paused {
+			broadcastRefresh() // the pause button on every screen changes
+		}
+	} else if !client.IsStatus(err, http.StatusConflict) {
+		log.Printf("Error reading the current slide of the leader: %v", err)
+	}
+
+	// Browsers load the next photo early, as they do from the leader
+	var next slide
+	if upcoming, err := frame.Upcoming(ctx); err == nil {
+		if config, err := loadConfig(configPath); err == nil {
+			if file, ok := leaderImage(upcoming.Image, config); ok {
+				next.Image = file
+			}
+		}
+	}
+	setUpcomingSlide(next)
+}
+
+// leaderImage returns where an image shown by the leader is in this instance's library, and whether it can be
+// shown here
+func leaderImage(image string, config *Config) (string, bool) {
+	if image == "" || !filepath.IsLocal(filepath.FromSlash(image)) {
+		return "", false
+	}
+	file := filepath.Join(config.ImageDirectory, filepath.FromSlash(image))
+	return file, stillShowable(file, config)
+}
+
+// showLeaderSlide puts the image the leader is showing on screen, when this instance has the same image
+func showLeaderSlide(image string) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+	file, ok := leaderImage(image, config)
+	if !ok {
+		log.Printf("The leader is showing an image that can't be shown here, keeping the current slide: %s", image)
+		return
+	}
+	if file == getCurrentSlide().Image {
+		return
+	}
+	log.Printf("Displaying image from the leader: %s", file)
+
+	next := slide{Image: file}
+	recordShown(file)
+	imageMutex.Lock()
+	currentSlide = next
+	currentShownAt = time.Now()
+	imageMutex.Unlock()
+	rotationEvents.publish(rotationEvent{Slide: next, Config: config})
+	libraryLoaded.Store(true)
+}
+
+// passedToLeader reports whether a navigation or pause requested on a follower was passed on to the leader,
+// writing the error response when it wasn't
+func passedToLeader(w http.ResponseWriter, name string, err error) bool {
+	if err == nil {
+		log.Printf("Passed %s requested through the API to the leader", name)
+		return true
+	}
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		http.Error(w, apiErr.Message, apiErr.StatusCode)
+		return false
+	}
+	http.Error(w, "Error reaching the leader: "+err.Error(), http.StatusBadGateway)
+	log.Printf("Error passing %s to the leader: %v", name, err)
+	return false
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	defaultFramebufferDevice = "/dev/fb0"
+	framebufferFrameTime     = 40 * time.Millisecond // time between frames of a transition
+)
+
+// FramebufferConfig describes a display driven directly through the Linux framebuffer, without a browser
+type FramebufferConfig struct {
+	Device       string `json:"device" desc:"Framebuffer device to draw to" default:"/dev/fb0"`
+	Rotation     int    `json:"rotation" desc:"Degrees to rotate the picture clockwise, for screens mounted on their side: 0, 90, 180 or 270" default:"0"`
+	FitMode      string `json:"fitMode" desc:"contain shows the whole image, cover fills the screen by cropping, smart-crop crops around the faces found by faceDetection, top-crop keeps the top of the image" default:"contain" enum:"contain,cover,smart-crop,top-crop"`
+	TransitionMs int    `json:"transitionMs" desc:"Length of the crossfade between images in milliseconds, 0 to switch straight away" default:"0"`
+}
+
+// pixelFormat describes how a pixel is packed in the framebuffer
+type pixelFormat struct {
+	bytesPerPixel              int
+	redOffset, greenOffset     uint
+	blueOffset                 uint
+	redLength, greenLength     uint
+	blueLength                 uint
+	transpOffset, transpLength uint
+}
+
+// framebuffer is an open framebuffer device
+type framebuffer struct {
+	file          *os.File
+	width, height int // physical resolution
+	stride        int // bytes per row
+	format        pixelFormat
+}
+
+// runFramebuffer draws the current slide to the framebuffer whenever it changes, protecting OLED panels from
+// burn-in when oled is set
+func runFramebuffer(cfg *FramebufferConfig, oled *OLEDConfig) {
+	device := cfg.Device
+	if device == "" {
+		device = defaultFramebufferDevice
+	}
+	rotation := ((cfg.Rotation % 360) + 360) % 360
+	if rotation%90 != 0 {
+		log.Printf("Framebuffer rotation must be 0, 90, 180 or 270, not rotating")
+		rotation = 0
+	}
+
+	fb, err := openFramebuffer(device)
+	if err != nil {
+		log.Printf("Framebuffer display disabled: %v", err)
+		return
+	}
+	defer fb.file.Close()
+	log.Printf("Drawing to %s at %dx%d, %d bits per pixel", device, fb.width, fb.height, fb.format.bytesPerPixel*8)
+
+	width, height := fb.width, fb.height
+	if rotation == 90 || rotation == 270 {
+		width, height = height, width
+	}
+
+	var protection *oledProtection
+	if oled != nil {
+		p := oledProtectionFor(oled)
+		protection = &p
+	}
+	nextBlack := time.Now()
+	if protection != nil {
+		nextBlack = nextBlack.Add(protection.BlackEvery)
+	}
+
+	var shown string
+	var previous *image.RGBA
+	for {
+		current := getCurrentSlide()
+		if displayBlanked() {
+			// blank the screen while a presence sensor sees nobody or during quiet hours
+			if shown != "" {
+				if err := fb.show(previous, image.NewRGBA(image.Rect(0, 0, width, height)), rotation, 0); err != nil {
+					log.Printf("Error writing to the framebuffer: %v", err)
+				}
+				previous, shown = nil, ""
+			}
+			time.Sleep(250 * time.Millisecond)
+			continue
+		}
+		if current.Image == "" {
+			// nothing is chosen until the library has loaded
+			current.Image = startupImageFile
+		}
+		// clips can't be played on the framebuffer, the photo before one stays on screen while it is the slide
+		if current.Image != "" && current.Image != shown && !isVideo(current.Image) {
+			frame, err := renderFrame(current.Image, width, height, cfg.FitMode)
+			if err != nil {
+				log.Printf("Error drawing %s: %v", current.Image, err)
+				if current.Image != startupImageFile {
+					quarantineFile(current.Image, "framebuffer", err)
+				}
+			} else {
+				if protection != nil {
+					// move the picture a little for each slide so no pixel shows the same thing all day
+					frame = shiftFrame(frame, protection.shift())
+				}
+				if err := fb.show(previous, frame, rotation, time.Duration(cfg.TransitionMs)*time.Millisecond); err != nil {
+					log.Printf("Error writing to the framebuffer: %v", err)
+				}
+				previous = frame
+			}
+			shown = current.Image
+		}
+		if protection != nil && previous != nil && time.Now().After(nextBlack) {
+			// black out the screen briefly, then put the slide back
+			black := image.NewRGBA(previous.Rect)
+			draw.Draw(black, black.Bounds(), image.Black, image.Point{}, draw.Src)
+			if err := fb.write(black, rotation); err != nil {
+				log.Printf("Error writing to the framebuffer: %v", err)
+			}
+			time.Sleep(protection.BlackFor)
+			if err := fb.write(previous, rotation); err != nil {
+				log.Printf("Error writing to the framebuffer: %v", err)
+			}
+			nextBlack = time.Now().Add(protection.BlackEvery)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// renderFrame decodes an image and scales it onto a black frame of the given size.  With fitMode contain the whole
+// image is shown, with cover it fills the frame cropped around its middle, with smart-crop it fills the frame cropped
+// around the faces in it, and with top-crop it fills the frame keeping the top of the image.
+func renderFrame(file string, width, height int, fitMode string) (*image.RGBA, error) {
+	decodable, err := decodableFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(decodable)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoded, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	bounds := decoded.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), decoded, bounds.Min, draw.Src)
+	src = orientImage(src, exifOrientation(file))
+
+	frame := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(frame, frame.Bounds(), image.Black, image.Point{}, draw.Src)
+	if src.Rect.Dx() == 0 || src.Rect.Dy() == 0 {
+		return frame, nil
+	}
+
+	// scale to fit inside the frame, or to fill it when cropping
+	scale := min(float64(width)/float64(src.Rect.Dx()), float64(height)/float64(src.Rect.Dy()))
+	if fitMode != "contain" && fitMode != "" {
+		scale = max(float64(width)/float64(src.Rect.Dx()), float64(height)/float64(src.Rect.Dy()))
+	}
+	scaledWidth := int(float64(src.Rect.Dx()) * scale)
+	scaledHeight := int(float64(src.Rect.Dy()) * scale)
+	focusX, focusY := 0.5, 0.5
+	switch fitMode {
+	case "smart-crop":
+		focusX, focusY = smartCropFocus(file, float64(width)/float64(scaledWidth), float64(height)/float64(scaledHeight))
+	case "top-crop":
+		focusY = 0
+	}
+	offsetX := cropOffset(width, scaledWidth, focusX)
+	offsetY := cropOffset(height, scaledHeight, focusY)
+
+	for y := max(offsetY, 0); y < min(offsetY+scaledHeight, height); y++ {
+		sy := (float64(y-offsetY)+0.5)/scale - 0.5
+		for x := max(offsetX, 0); x < min(offsetX+scaledWidth, width); x++ {
+			sx := (float64(x-offsetX)+0.5)/scale - 0.5
+			r, g, b := bilinear(src, sx, sy)
+			i := frame.PixOffset(x, y)
+			frame.Pix[i], frame.Pix[i+1], frame.Pix[i+2] = r, g, b
+		}
+	}
+	return frame, nil
+}
+
+// cropOffset returns where an image scaled to scaled pixels along one side starts in a frame of size pixels: centred
+// when it fits, otherwise moved so focus, a fraction of the image, is as near the middle as the image allows
+func cropOffset(size, scaled int, focus float64) int {
+	if scaled <= size {
+		return (size - scaled) / 2
+	}
+	offset := int(float64(size)/2 - focus*float64(scaled))
+	return min(max(offset, size-scaled), 0)
+}
+
+// bilinear samples src at a fractional position, blending the four surrounding pixels
+func bilinear(src *image.RGBA, x, y float64) (uint8, uint8, uint8) {
+	maxX, maxY := src.Rect.Dx()-1, src.Rect.Dy()-1
+	x = min(max(x, 0), float64(maxX))
+	y = min(max(y, 0), float64(maxY))
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, maxX), min(y0+1, maxY)
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	var out [3]uint8
+	for c := 0; c < 3; c++ {
+		top := float64(src.Pix[src.PixOffset(x0, y0)+c])*(1-fx) + float64(src.Pix[src.PixOffset(x1, y0)+c])*fx
+		bottom := float64(src.Pix[src.PixOffset(x0, y1)+c])*(1-fx) + float64(src.Pix[src.PixOffset(x1, y1)+c])*fx
+		out[c] = uint8(top*(1-fy) + bottom*fy + 0.5)
+	}
+	return out[0], out[1], out[2]
+}
+
+// show writes a frame to the screen, crossfading from the previous frame when a transition is set
+func (fb *framebuffer) show(previous, frame *image.RGBA, rotation int, transition time.Duration) error {
+	steps := int(transition / framebufferFrameTime)
+	if previous == nil || steps < 2 || previous.Rect != frame.Rect {
+		return fb.write(frame, rotation)
+	}
+
+	blended := image.NewRGBA(frame.Rect)
+	for step := 1; step <= steps; step++ {
+		start := time.Now()
+		alpha := float64(step) / float64(steps)
+		for i := range blended.Pix {
+			blended.Pix[i] = uint8(float64(previous.Pix[i])*(1-alpha) + float64(frame.Pix[i])*alpha + 0.5)
+		}
+		if err := fb.write(blended, rotation); err != nil {
+			return err
+		}
+		time.Sleep(framebufferFrameTime - time.Since(start))
+	}
+	return nil
+}
+
+// write converts a frame to the framebuffer's pixel format, rotating it to the screen, and writes it out
+func (fb *framebuffer) write(frame *image.RGBA, rotation int) error {
+	logicalWidth, logicalHeight := frame.Rect.Dx(), frame.Rect.Dy()
+	bpp := fb.format.bytesPerPixel
+	buf := make([]byte, fb.stride*fb.height)
+
+	for py := 0; py < fb.height; py++ {
+		row := buf[py*fb.stride:]
+		for px := 0; px < fb.width; px++ {
+			// find the pixel of the upright frame that lands on this physical pixel
+			lx, ly := px, py
+			switch rotation {
+			case 90:
+				lx, ly = py, logicalHeight-1-px
+			case 180:
+				lx, ly = logicalWidth-1-px, logicalHeight-1-py
+			case 270:
+				lx, ly = logicalWidth-1-py, px
+			}
+			i := frame.PixOffset(lx, ly)
+			value := fb.format.pack(frame.Pix[i], frame.Pix[i+1], frame.Pix[i+2])
+			for b := 0; b < bpp; b++ {
+				row[px*bpp+b] = byte(value >> (8 * b)) // framebuffers are little endian on the supported hardware
+			}
+		}
+	}
+
+	_, err := fb.file.WriteAt(buf, 0)
+	return err
+}
+
+// pack combines 8 bit colour components into a pixel value
+func (f pixelFormat) pack(r, g, b uint8) uint32 {
+	component := func(value uint8, offset, length uint) uint32 {
+		if length == 0 {
+			return 0
+		}
+		return uint32(value) >> (8 - min(length, 8)) << offset
+	}
+	value := component(r, f.redOffset, f.redLength) | component(g, f.greenOffset, f.greenLength) | component(b, f.blueOffset, f.blueLength)
+	if f.transpLength > 0 {
+		value |= (1<<f.transpLength - 1) << f.transpOffset // fully opaque
+	}
+	return value
+}
+
+// validFramebufferFormat checks a framebuffer uses a pixel format that can be drawn
+func validFramebufferFormat(f pixelFormat) error {
+	if f.bytesPerPixel != 2 && f.bytesPerPixel != 3 && f.bytesPerPixel != 4 {
+		return fmt.Errorf("unsupported framebuffer depth of %d bits per pixel", f.bytesPerPixel*8)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// fbiogetVscreeninfo is the FBIOGET_VSCREENINFO ioctl returning the variable screen information
+const fbiogetVscreeninfo = 0x4600
+
+// fbBitfield mirrors struct fb_bitfield from linux/fb.h
+type fbBitfield struct {
+	Offset   uint32
+	Length   uint32
+	MsbRight uint32
+}
+
+// fbVarScreeninfo mirrors struct fb_var_screeninfo from linux/fb.h, which only has 32 bit fields so
+// has the same layout on every architecture
+type fbVarScreeninfo struct {
+	Xres, Yres               uint32
+	XresVirtual, YresVirtual uint32
+	Xoffset, Yoffset         uint32
+	BitsPerPixel             uint32
+	Grayscale                uint32
+	Red, Green, Blue, Transp fbBitfield
+	Nonstd                   uint32
+	Activate                 uint32
+	Height, Width            uint32
+	AccelFlags               uint32
+	Pixclock                 uint32
+	LeftMargin, RightMargin  uint32
+	UpperMargin, LowerMargin uint32
+	HsyncLen, VsyncLen       uint32
+	Sync, Vmode, Rotate      uint32
+	Colorspace               uint32
+	Reserved                 [4]uint32
+}
+
+// openFramebuffer opens a framebuffer device and reads its resolution and pixel format
+func openFramebuffer(device string) (*framebuffer, error) {
+	file, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var info fbVarScreeninfo
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), fbiogetVscreeninfo, uintptr(unsafe.Pointer(&info))); errno != 0 {
+		file.Close()
+		return nil, errno
+	}
+
+	fb := &framebuffer{
+		file:   file,
+		width:  int(info.Xres),
+		height: int(info.Yres),
+		format: pixelFormat{
+			bytesPerPixel: int(info.BitsPerPixel) / 8,
+			redOffset:     uint(info.Red.Offset),
+			redLength:     uint(info.Red.Length),
+			greenOffset:   uint(info.Green.Offset),
+			greenLength:   uint(info.Green.Length),
+			blueOffset:    uint(info.Blue.Offset),
+			blueLength:    uint(info.Blue.Length),
+			transpOffset:  uint(info.Transp.Offset),
+			transpLength:  uint(info.Transp.Length),
+		},
+	}
+	if err := validFramebufferFormat(fb.format); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// rows may be padded, the stride is only reported by the fixed screen info whose layout differs
+	// between 32 and 64 bit systems, so it is read from sysfs instead
+	fb.stride = int(info.XresVirtual) * fb.format.bytesPerPixel
+	if data, err := os.ReadFile(filepath.Join("/sys/class/graphics", filepath.Base(device), "stride")); err == nil {
+		if stride, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && stride > 0 {
+			fb.stride = stride
+		}
+	}
+	return fb, nil
+}
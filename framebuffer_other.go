@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// openFramebuffer is only supported on linux
+func openFramebuffer(device string) (*framebuffer, error) {
+	return nil, fmt.Errorf("drawing to the framebuffer is only supported on linux")
+}
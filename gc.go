@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheGCInterval is how often the caches are cleaned up in the background
+const cacheGCInterval = 24 * time.Hour
+
+// gcStats reports what the cache garbage collector has reclaimed
+type gcStats struct {
+	Runs                int       `json:"runs"`
+	LastRun             time.Time `json:"lastRun"`
+	LastSkipped         string    `json:"lastSkipped,omitempty"` // why the last run did nothing, e.g. the image directory is unavailable
+	LastError           string    `json:"lastError,omitempty"`
+	LastRemoved         int       `json:"lastRemoved"`
+	LastReclaimedBytes  int64     `json:"lastReclaimedBytes"`
+	TotalRemoved        int       `json:"totalRemoved"`
+	TotalReclaimedBytes int64     `json:"totalReclaimedBytes"`
+}
+
+var (
+	cacheGC      gcStats
+	cacheGCMutex sync.Mutex // To ensure thread-safe access to `cacheGC`, and that only one collection runs at a time
+)
+
+// collectCaches removes the cached entries of images that no longer exist, or that were probed by an older
+// version of the app, from the metadata cache (or image index), the last shown times, the problem files, the file
+// hashes and the blurred, upright, resized and converted copies
+func collectCaches() gcStats {
+	cacheGCMutex.Lock()
+	defer cacheGCMutex.Unlock()
+
+	start := time.Now()
+	cacheGC.Runs++
+	cacheGC.LastRun = start
+	cacheGC.LastSkipped, cacheGC.LastError = "", ""
+	cacheGC.LastRemoved, cacheGC.LastReclaimedBytes = 0, 0
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		cacheGC.LastError = err.Error()
+		log.Printf("Error loading config: %v", err)
+		return cacheGC
+	}
+
+	// A missing file usually means an unmounted share rather than a deleted photo, don't drop everything
+	if status := checkImageDirectory(config.ImageDirectory); status.State != directoryOK && status.State != directoryReadOnly {
+		cacheGC.LastSkipped = "image directory is " + status.State
+		log.Printf("Skipping cache cleanup as the image directory is %s", status.State)
+		return cacheGC
+	}
+
+	exists := func(file string) bool {
+		_, err := os.Stat(file)
+		return !errors.Is(err, os.ErrNotExist)
+	}
+
+	cacheFiles := []string{metadataCachePath, lastShownPath, problemFilesPath, fileHashesPath, similarHashesPath, contentVerdictsPath, detectedFacesPath}
+	if config.IndexDatabase != "" {
+		cacheFiles = append(cacheFiles, config.IndexDatabase)
+	}
+	before := totalFileSize(cacheFiles)
+
+	removed, err := imageMetadataCache().collectGarbage(exists)
+	if err != nil {
+		cacheGC.LastError = err.Error()
+		log.Printf("Error cleaning up the metadata cache: %v", err)
+	}
+	removed += lastShown.collect(exists)
+	removed += collectProblemFiles(exists)
+	removed += fileHashes.collect(exists)
+	removed += similarHashes.collect(exists)
+	removed += contentVerdicts.collect(exists)
+	removed += faceDetections.collect(exists)
+	copiesReclaimed := int64(0)
+	for _, cacheDir := range []string{blurCacheDir, uprightCacheDir} {
+		copiesRemoved, reclaimed := collectImageCopies(cacheDir, config.ImageDirectory, exists)
+		removed += copiesRemoved
+		copiesReclaimed += reclaimed
+	}
+	// converted HEIC photos and RAW previews mirror their absolute path
+	for _, cacheDir := range []string{heicCacheDir, rawCacheDir} {
+		convertedRemoved, reclaimed := collectImageCopies(cacheDir, string(filepath.Separator), exists)
+		removed += convertedRemoved
+		copiesReclaimed += reclaimed
+	}
+	resizedRemoved, resizedReclaimed := collectResizeCache(config.ImageDirectory, exists)
+	removed += resizedRemoved
+	copiesReclaimed += resizedReclaimed
+
+	cacheGC.LastRemoved = removed
+	cacheGC.LastReclaimedBytes = max(before-totalFileSize(cacheFiles), 0) + copiesReclaimed
+	cacheGC.TotalRemoved += cacheGC.LastRemoved
+	cacheGC.TotalReclaimedBytes += cacheGC.LastReclaimedBytes
+	log.Printf("Cache cleanup removed %d entries and reclaimed %d bytes in %s", removed, cacheGC.LastReclaimedBytes, time.Since(start))
+	return cacheGC
+}
+
+// totalFileSize adds up the sizes of files, ignoring any that don't exist
+func totalFileSize(files []string) int64 {
+	var total int64
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// collectCachesPeriodically cleans up the caches on an interval
+func collectCachesPeriodically() {
+	for {
+		time.Sleep(cacheGCInterval)
+		collectCaches()
+	}
+}
+
+// gcHandler reports what the cache cleanup has reclaimed (GET) or runs it straight away (POST)
+func gcHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cacheGCMutex.Lock()
+		stats := cacheGC
+		cacheGCMutex.Unlock()
+		writeJSON(w, stats)
+
+	case http.MethodPost:
+		log.Println("Cache cleanup requested through the API")
+		writeJSON(w, collectCaches())
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// imageCopyPath returns where a copy made from an image is cached in cacheDir, mirroring its path in the image
+// directory
+func imageCopyPath(cacheDir, relative string) string {
+	return filepath.Join(cacheDir, filepath.FromSlash(relative)+".jpg")
+}
+
+// collectImageCopies removes the copies cached in cacheDir of images that no longer exist, returning how many were
+// removed and the bytes reclaimed
+func collectImageCopies(cacheDir, imageDirectory string, exists func(string) bool) (int, int64) {
+	removed, reclaimed := 0, int64(0)
+	filepath.WalkDir(cacheDir, func(cached string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		relative, err := filepath.Rel(cacheDir, cached)
+		if err != nil {
+			return nil
+		}
+		// copies are named after the image with the extension of their own format added
+		if exists(filepath.Join(imageDirectory, strings.TrimSuffix(relative, filepath.Ext(relative)))) {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && os.Remove(cached) == nil {
+			removed++
+			reclaimed += info.Size()
+		}
+		return nil
+	})
+	return removed, reclaimed
+}
@@ -2,4 +2,7 @@ module randompic
 
 go 1.22.2
 
-require gopkg.in/natefinch/lumberjack.v2 v2.2.1
+require (
+	github.com/mattn/go-sqlite3 v1.14.52
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
+)
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"randompic/client"
+)
+
+// Image directory states reported by /healthz
+const (
+	directoryOK          = "ok"
+	directoryReadOnly    = "read-only"   // readable, but mounted read-only
+	directoryStale       = "stale"       // ESTALE, the network share needs remounting
+	directoryMissing     = "missing"     // the directory does not exist, usually the share is not mounted
+	directoryDenied      = "denied"      // permission denied
+	directoryUnavailable = "unavailable" // other errors, e.g. the server behind the mount is not responding
+)
+
+// directoryStatus describes the health of the image directory
+type directoryStatus = client.DirectoryStatus
+
+var (
+	lastScanStatus directoryStatus
+	scanStatusLock sync.Mutex // To ensure thread-safe access to `lastScanStatus`
+)
+
+// classifyDirectoryError maps a file system error onto a directory state
+func classifyDirectoryError(err error) string {
+	switch {
+	case err == nil:
+		return directoryOK
+	case errors.Is(err, syscall.ESTALE):
+		return directoryStale
+	case errors.Is(err, syscall.EROFS):
+		return directoryReadOnly
+	case errors.Is(err, os.ErrNotExist):
+		return directoryMissing
+	case errors.Is(err, os.ErrPermission):
+		return directoryDenied
+	default:
+		return directoryUnavailable
+	}
+}
+
+// checkImageDirectory inspects the image directory as it is right now
+func checkImageDirectory(dir string) directoryStatus {
+	status := directoryStatus{State: directoryOK, CheckedAt: time.Now()}
+
+	if _, err := os.ReadDir(dir); err != nil {
+		status.State = classifyDirectoryError(err)
+		status.Error = err.Error()
+		return status
+	}
+
+	readOnly, err := mountReadOnly(dir)
+	if err != nil {
+		status.State = classifyDirectoryError(err)
+		status.Error = err.Error()
+	} else if readOnly {
+		status.State = directoryReadOnly
+	}
+	return status
+}
+
+// recordScanResult remembers the outcome of the last scan of the image directory, logging a clear message when it fails
+func recordScanResult(dir string, err error) {
+	status := directoryStatus{State: directoryOK, CheckedAt: time.Now()}
+	if err != nil {
+		status.State = classifyDirectoryError(err)
+		status.Error = err.Error()
+		log.Printf("Image directory %s is %s: %v", dir, status.State, err)
+		errorEvents.publish(errorEvent{Problem: fmt.Sprintf("Image directory %s was %s", dir, status.State), Err: err})
+	}
+
+	scanStatusLock.Lock()
+	lastScanStatus = status
+	scanStatusLock.Unlock()
+}
+
+// healthzHandler reports the health of the image directory and library, returning 503 when images can't be read
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	scanStatusLock.Lock()
+	lastScan := lastScanStatus
+	scanStatusLock.Unlock()
+
+	health := client.Health{
+		Status:    "ok",
+		Loaded:    libraryLoaded.Load(),
+		Images:    len(currentLibrary()),
+		Directory: checkImageDirectory(config.ImageDirectory),
+		LastScan:  lastScan,
+	}
+
+	// a read-only mount can still be displayed, anything else means images can't be read
+	if health.Directory.State != directoryOK && health.Directory.State != directoryReadOnly {
+		health.Status = "error"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, health)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	heicCacheDir    = "./randompic-heic"
+	heicJPEGQuality = 90
+	heicTimeout     = time.Minute // bounds how long converting a single photo may take
+)
+
+// heifConverter is the command converting HEIC photos to JPEGs, empty when libheif's tools aren't installed.
+// heif-dec is the name of heif-convert since libheif 1.17.
+var heifConverter = sync.OnceValue(func() string {
+	for _, command := range []string{"heif-dec", "heif-convert"} {
+		if _, err := exec.LookPath(command); err == nil {
+			return command
+		}
+	}
+	log.Printf("HEIC photos are left out as neither heif-dec nor heif-convert is installed")
+	return ""
+})
+
+// isHEIC reports whether a file is a HEIC or HEIF photo, as taken by iPhones, which browsers and Go can't decode
+func isHEIC(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".heic" || ext == ".heif"
+}
+
+// convertHEIC converts a HEIC photo to a JPEG with libheif's tools
+func convertHEIC(file, jpeg string) error {
+	command := heifConverter()
+	if command == "" {
+		return fmt.Errorf("neither heif-dec nor heif-convert is installed to convert HEIC photos")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), heicTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command, "-q", strconv.Itoa(heicJPEGQuality), file, jpeg)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", command, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"randompic/client"
+)
+
+// historyPath is where the display history is persisted when persistHistory is set
+const historyPath = "./randompic-history.json"
+
+const defaultHistorySize = 50
+
+// historyEntry is a slide that was displayed
+type historyEntry = client.HistoryEntry
+
+// historyRing keeps the most recent entries in a fixed size ring buffer
+type historyRing struct {
+	entries []historyEntry
+	next    int // index the next entry is written to once the buffer is full
+}
+
+var (
+	history        *historyRing
+	historyPersist bool
+	historyErr     error      // set when the saved history couldn't be read, so it isn't written over
+	historyMutex   sync.Mutex // To ensure thread-safe access to `history`, `historyPersist` and `historyErr`
+)
+
+// add stores an entry, overwriting the oldest once size entries are held
+func (h *historyRing) add(entry historyEntry, size int) {
+	if len(h.entries) < size {
+		h.entries = append(h.entries, entry)
+		return
+	}
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+}
+
+// newestFirst returns the entries from the most recently displayed
+func (h *historyRing) newestFirst() []historyEntry {
+	ordered := make([]historyEntry, 0, len(h.entries))
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		ordered = append(ordered, h.entries[(h.next+i)%len(h.entries)])
+	}
+	return ordered
+}
+
+// initHistory sets up the display history, restoring the persisted history when persistHistory is set
+func initHistory(config *Config) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	history = &historyRing{}
+	historyPersist, historyErr = config.PersistHistory, nil
+	if !historyPersist {
+		return
+	}
+
+	data, err := os.ReadFile(historyPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			historyErr = fmt.Errorf("error reading display history: %v", err)
+			log.Printf("%v, leaving %s unchanged until it is fixed and the app restarted", historyErr, historyPath)
+		}
+		return
+	}
+	var saved []historyEntry
+	if err := json.Unmarshal(data, &saved); err != nil {
+		historyErr = fmt.Errorf("error parsing display history: %v", err)
+		log.Printf("%v, leaving %s unchanged until it is fixed and the app restarted", historyErr, historyPath)
+		return
+	}
+	// saved newest first, added oldest first, dropping any beyond the current size
+	for i := min(len(saved), historySize(config)) - 1; i >= 0; i-- {
+		history.entries = append(history.entries, saved[i])
+	}
+}
+
+// historySize returns the number of entries kept in the history
+func historySize(config *Config) int {
+	if config.HistorySize <= 0 {
+		return defaultHistorySize
+	}
+	return config.HistorySize
+}
+
+// newHistoryEntry describes a slide as it is put on screen
+func newHistoryEntry(current slide, config *Config) historyEntry {
+	entry := historyEntry{
+		Image:   relativeImagePath(current.Image, config.ImageDirectory),
+		URL:     imageURL(current.Image, config.ImageDirectory),
+		ShownAt: time.Now(),
+		Kind:    "photo",
+	}
+	if current.Countdown != nil {
+		entry.Kind = "countdown"
+	} else if current.Intro != nil {
+		entry.Kind = "intro"
+	}
+	return entry
+}
+
+// recordHistory adds the slide that has just been displayed to the history
+func recordHistory(current slide, config *Config) {
+	if current.Image == "" {
+		return
+	}
+
+	entry := newHistoryEntry(current, config)
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if history == nil {
+		history = &historyRing{}
+	}
+	history.add(entry, historySize(config))
+	writeHistory()
+}
+
+// forgetHistory removes every showing of an image, given relative to imageDirectory, from the display history
+func forgetHistory(image string) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if history == nil {
+		return
+	}
+
+	entries := history.newestFirst()
+	kept := &historyRing{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Image != image {
+			kept.entries = append(kept.entries, entries[i])
+		}
+	}
+	if len(kept.entries) == len(entries) {
+		return
+	}
+	history = kept
+	writeHistory()
+}
+
+// writeHistory saves the display history when persistHistory is set, the caller must hold historyMutex
+func writeHistory() {
+	if !historyPersist || historyErr != nil {
+		return
+	}
+	data, err := json.MarshalIndent(history.newestFirst(), "", "    ")
+	if err != nil {
+		log.Printf("Error encoding display history: %v", err)
+		return
+	}
+	if err := writeFileAtomic(historyPath, data); err != nil {
+		log.Printf("Error saving display history: %v", err)
+	}
+}
+
+// historyHandler returns the most recently displayed slides, newest first, optionally limited with ?limit=
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	historyMutex.Lock()
+	entries := []historyEntry{}
+	if history != nil {
+		entries = history.newestFirst()
+	}
+	historyMutex.Unlock()
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		entries = entries[:min(limit, len(entries))]
+	}
+	writeJSON(w, entries)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"randompic/client"
+)
+
+const defaultHoldMinutes = 30
+
+// imageHold pins the slide on screen, the updater carries on by itself once it expires
+type imageHold = client.Hold
+
+var (
+	activeHold *imageHold
+	holdMutex  sync.Mutex // To ensure thread-safe access to `activeHold`
+)
+
+// currentHold returns the active hold, clearing it once it has expired
+func currentHold() *imageHold {
+	holdMutex.Lock()
+	defer holdMutex.Unlock()
+
+	if activeHold != nil && activeHold.Until != nil && time.Now().After(*activeHold.Until) {
+		log.Printf("Hold on %s expired", activeHold.Image)
+		activeHold = nil
+	}
+	return activeHold
+}
+
+// releaseHold lets the updater carry on from the image on screen, if it is held
+func releaseHold() {
+	holdMutex.Lock()
+	if activeHold != nil {
+		log.Printf("Hold on %s released", activeHold.Image)
+		activeHold = nil
+	}
+	holdMutex.Unlock()
+	notifyPauseChanged()
+}
+
+// holdHandler reads (GET), starts (POST) or releases (DELETE) a hold on the image on screen
+func holdHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, currentHold())
+
+	case http.MethodDelete:
+		releaseHold()
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodPost:
+		var req client.HoldRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		config, err := loadConfig(configPath)
+		if err != nil {
+			http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error loading config: %v", err)
+			return
+		}
+		minutes := float64(config.HoldMinutes)
+		if minutes <= 0 {
+			minutes = defaultHoldMinutes
+		}
+		if req.Minutes != nil {
+			if *req.Minutes < 0 {
+				http.Error(w, "minutes must not be negative", http.StatusBadRequest)
+				return
+			}
+			minutes = *req.Minutes
+		}
+
+		hold := &imageHold{Image: getCurrentSlide().Image, Since: time.Now()}
+		if minutes > 0 {
+			until := hold.Since.Add(time.Duration(minutes * float64(time.Minute)))
+			hold.Until = &until
+			log.Printf("Holding %s until %s", hold.Image, hold.Until.Format(time.RFC3339))
+		} else {
+			log.Printf("Holding %s until released", hold.Image)
+		}
+
+		holdMutex.Lock()
+		activeHold = hold
+		holdMutex.Unlock()
+		notifyPauseChanged()
+
+		writeJSON(w, hold)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
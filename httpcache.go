@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// imageMaxAge is how long in seconds a browser may show a photo it already has without asking whether it changed
+const imageMaxAge = 3600
+
+// setImageCacheHeaders lets the browser keep a photo, or a copy made from it, and gives it an ETag made from the
+// photo's size and modification time, which change whenever it is edited.  Set before http.ServeFile or
+// http.ServeContent, a browser asking again with If-None-Match is answered 304 Not Modified rather than sent the same
+// bytes, such as when the page shows the photo it loaded ahead of time.  variant tells apart the copies made from
+// one photo, as each is sent from the same path.
+func setImageCacheHeaders(w http.ResponseWriter, info os.FileInfo, variant string) {
+	etag := strconv.FormatInt(info.Size(), 36) + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 36)
+	if variant != "" {
+		etag += "-" + variant
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", imageMaxAge))
+	w.Header().Set("ETag", `"`+etag+`"`)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLanguage is the language of the built in catalog every other catalog falls back to
+const defaultLanguage = "en"
+
+var (
+	builtinCatalogs     map[string]map[string]string
+	builtinCatalogsOnce sync.Once
+)
+
+// messageCatalog is the text of the pages in one language.  Messages may hold numbered placeholders, {0}, {1} and
+// so on, filled in with the arguments they are given.
+type messageCatalog struct {
+	Language string
+	messages map[string]string
+}
+
+// loadBuiltinCatalogs parses the catalogs built into the app, one JSON file of message keys and text per language
+// in static/messages, keyed by language
+func loadBuiltinCatalogs() map[string]map[string]string {
+	builtinCatalogsOnce.Do(func() {
+		builtinCatalogs = map[string]map[string]string{}
+		files, _ := staticFiles.ReadDir("static/messages")
+		for _, file := range files {
+			data, err := staticFiles.ReadFile(path.Join("static/messages", file.Name()))
+			if err != nil {
+				continue
+			}
+			var messages map[string]string
+			if err := json.Unmarshal(data, &messages); err != nil {
+				log.Printf("Error parsing the %s message catalog: %v", file.Name(), err)
+				continue
+			}
+			builtinCatalogs[strings.TrimSuffix(file.Name(), ".json")] = messages
+		}
+	})
+	return builtinCatalogs
+}
+
+// localMessagesPath is where a household's own catalog for a language is looked for, next to the config file
+func localMessagesPath(language string) string {
+	return filepath.Join(filepath.Dir(configPath), "messages", language+".json")
+}
+
+// messagesFor returns the catalog for a language.  Messages missing from it fall back to the built in catalog of
+// the language, such as de for de-AT, and then to English, and a local catalog can add or replace any of them.
+func messagesFor(language string) *messageCatalog {
+	if language == "" {
+		language = defaultLanguage
+	}
+	builtins := loadBuiltinCatalogs()
+
+	catalog := &messageCatalog{Language: language, messages: map[string]string{}}
+	layers := []map[string]string{builtins[defaultLanguage]}
+	if base, _, found := strings.Cut(language, "-"); found {
+		layers = append(layers, builtins[strings.ToLower(base)])
+	}
+	layers = append(layers, builtins[strings.ToLower(language)])
+	for _, layer := range layers {
+		for key, text := range layer {
+			catalog.messages[key] = text
+		}
+	}
+
+	// a local catalog is read each time, so edits show on the next page load like config changes
+	if data, err := os.ReadFile(localMessagesPath(language)); err == nil {
+		var local map[string]string
+		if err := json.Unmarshal(data, &local); err != nil {
+			log.Printf("Error parsing the %s message catalog: %v", localMessagesPath(language), err)
+		}
+		for key, text := range local {
+			catalog.messages[key] = text
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Error reading the %s message catalog: %v", localMessagesPath(language), err)
+	}
+	return catalog
+}
+
+// text returns a message with its placeholders filled in, or the key itself when no catalog has the message
+func (c *messageCatalog) text(key string, args ...any) string {
+	message, ok := c.messages[key]
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return message
+	}
+	pairs := make([]string, 0, len(args)*2)
+	for i, arg := range args {
+		pairs = append(pairs, "{"+strconv.Itoa(i)+"}", fmt.Sprint(arg))
+	}
+	return strings.NewReplacer(pairs...).Replace(message)
+}
+
+// date formats a date in the catalog's language, with the layout and the month and day names from the catalog
+func (c *messageCatalog) date(t time.Time, layoutKey string) string {
+	formatted := t.Format(c.text(layoutKey))
+	// Go only writes English names, which are swapped for the catalog's
+	names := strings.Split(c.text("date.months"), ",")
+	if len(names) == 12 {
+		formatted = strings.Replace(formatted, t.Month().String(), names[t.Month()-1], 1)
+	}
+	names = strings.Split(c.text("date.weekdays"), ",")
+	if len(names) == 7 {
+		formatted = strings.Replace(formatted, t.Weekday().String(), names[t.Weekday()], 1)
+	}
+	return formatted
+}
+
+// dateRange formats the dates from and to, collapsing them to a single date when they fall on the same day
+func (c *messageCatalog) dateRange(from, to time.Time) string {
+	if from.IsZero() {
+		return ""
+	}
+	start, end := c.date(from, "date.long"), c.date(to, "date.long")
+	if start == end {
+		return start
+	}
+	return c.text("date.range", start, end)
+}
+
+// JSON returns the whole catalog for a page's scripts, escaped to be written straight into a script element
+func (c *messageCatalog) JSON() template.JS {
+	data, _ := json.Marshal(c.messages)
+	return template.JS(data)
+}
+
+// funcs returns the template functions writing text from the catalog: t for a message, dateRange for a range of
+// dates, lang for the page's language and messages for the whole catalog as JSON
+func (c *messageCatalog) funcs() template.FuncMap {
+	return template.FuncMap{
+		"t":         c.text,
+		"dateRange": c.dateRange,
+		"lang":      func() string { return c.Language },
+		"messages":  c.JSON,
+	}
+}
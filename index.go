@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imageIndexDriver is the database/sql driver used for the image index, registered by builds with the sqlite tag
+const imageIndexDriver = "sqlite3"
+
+// imageIndexSchema creates the index table, one row per image in the library
+const imageIndexSchema = `
+CREATE TABLE IF NOT EXISTS images (
+	path              TEXT PRIMARY KEY,
+	version           INTEGER NOT NULL,
+	size              INTEGER NOT NULL,
+	mod_time          INTEGER NOT NULL,
+	width             INTEGER NOT NULL,
+	height            INTEGER NOT NULL,
+	date_taken        INTEGER NOT NULL DEFAULT 0,
+	has_location      INTEGER NOT NULL DEFAULT 0,
+	latitude          REAL NOT NULL DEFAULT 0,
+	longitude         REAL NOT NULL DEFAULT 0,
+	rating            INTEGER NOT NULL DEFAULT 0,
+	description       TEXT NOT NULL DEFAULT '',
+	has_sidecar       INTEGER NOT NULL DEFAULT 0,
+	camera            TEXT NOT NULL DEFAULT '',
+	exposure          TEXT NOT NULL DEFAULT '',
+	orientation       INTEGER NOT NULL DEFAULT 0,
+	animation_ms      INTEGER NOT NULL DEFAULT 0,
+	video_ms          INTEGER NOT NULL DEFAULT 0,
+	generated_caption TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS images_date_taken ON images (date_taken);
+`
+
+// imageIndex stores image metadata in a SQLite database so it can be queried without loading it all into memory
+type imageIndex struct {
+	db *sql.DB
+}
+
+// openImageIndex opens the SQLite index at path, creating it if needed
+func openImageIndex(path string) (*imageIndex, error) {
+	db, err := sql.Open(imageIndexDriver, path)
+	if err != nil {
+		return nil, fmt.Errorf("%v (the app must be built with -tags sqlite to use indexDatabase)", err)
+	}
+	// SQLite only allows one writer, serialising access avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(imageIndexSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// indexes created before ratings, captions, camera details, orientations, animations, clip lengths and generated
+	// captions were read lack the columns, adding them fails harmlessly when already present
+	for _, column := range []string{
+		`rating INTEGER NOT NULL DEFAULT 0`,
+		`description TEXT NOT NULL DEFAULT ''`,
+		`has_sidecar INTEGER NOT NULL DEFAULT 0`,
+		`camera TEXT NOT NULL DEFAULT ''`,
+		`exposure TEXT NOT NULL DEFAULT ''`,
+		`orientation INTEGER NOT NULL DEFAULT 0`,
+		`animation_ms INTEGER NOT NULL DEFAULT 0`,
+		`video_ms INTEGER NOT NULL DEFAULT 0`,
+		`generated_caption TEXT NOT NULL DEFAULT ''`,
+	} {
+		if _, err := db.Exec(`ALTER TABLE images ADD COLUMN ` + column); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &imageIndex{db: db}, nil
+}
+
+func (x *imageIndex) get(file string) (imageMetadata, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return imageMetadata{}, err
+	}
+
+	var meta imageMetadata
+	err = x.db.QueryRow(
+		`SELECT version, size, mod_time, width, height, date_taken, has_location, latitude, longitude, rating, description, has_sidecar, camera, exposure, orientation, animation_ms, video_ms, generated_caption FROM images WHERE path = ?`, file,
+	).Scan(&meta.Version, &meta.Size, &meta.ModTime, &meta.Width, &meta.Height, &meta.DateTaken, &meta.HasLocation, &meta.Latitude, &meta.Longitude, &meta.Rating, &meta.Description, &meta.HasSidecar, &meta.Camera, &meta.Exposure, &meta.Orientation, &meta.AnimationMs, &meta.VideoMs, &meta.GeneratedCaption)
+	if err == nil && meta.Version == metadataVersion && meta.Size == info.Size() && meta.ModTime == info.ModTime().Unix() {
+		return meta, nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error reading %s from the image index: %v", file, err)
+	}
+
+	// the generated caption is kept when only the version has changed, and cleared when the file has
+	keepCaption := err == nil && meta.Size == info.Size() && meta.ModTime == info.ModTime().Unix()
+	generatedCaption := meta.GeneratedCaption
+	meta = probeMetadata(file, info)
+	_, err = x.db.Exec(
+		`INSERT INTO images (path, version, size, mod_time, width, height, date_taken, has_location, latitude, longitude, rating, description, has_sidecar, camera, exposure, orientation, animation_ms, video_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET version = excluded.version, size = excluded.size, mod_time = excluded.mod_time,
+			width = excluded.width, height = excluded.height, date_taken = excluded.date_taken,
+			has_location = excluded.has_location, latitude = excluded.latitude, longitude = excluded.longitude,
+			rating = excluded.rating, description = excluded.description, has_sidecar = excluded.has_sidecar,
+			camera = excluded.camera, exposure = excluded.exposure, orientation = excluded.orientation,
+			animation_ms = excluded.animation_ms, video_ms = excluded.video_ms,
+			generated_caption = CASE WHEN images.size = excluded.size AND images.mod_time = excluded.mod_time
+				THEN images.generated_caption ELSE '' END`,
+		file, meta.Version, meta.Size, meta.ModTime, meta.Width, meta.Height, meta.DateTaken, meta.HasLocation, meta.Latitude, meta.Longitude, meta.Rating, meta.Description, meta.HasSidecar, meta.Camera, meta.Exposure, meta.Orientation, meta.AnimationMs, meta.VideoMs,
+	)
+	if err != nil {
+		log.Printf("Error writing %s to the image index: %v", file, err)
+	}
+	if keepCaption {
+		meta.GeneratedCaption = generatedCaption
+	}
+	return meta, nil
+}
+
+// save is a no-op as each change is written to the database as it is made
+func (x *imageIndex) save() error {
+	return nil
+}
+
+func (x *imageIndex) setGeneratedCaption(file, caption string) error {
+	result, err := x.db.Exec(`UPDATE images SET generated_caption = ? WHERE path = ?`, caption, file)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("%s is not in the image index", file)
+	}
+	return nil
+}
+
+func (x *imageIndex) prune(files []string) error {
+	keep := make(map[string]bool, len(files))
+	for _, file := range files {
+		keep[file] = true
+	}
+
+	rows, err := x.db.Query(`SELECT path FROM images`)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return err
+		}
+		if !keep[path] {
+			stale = append(stale, path)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := x.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if _, err := tx.Exec(`DELETE FROM images WHERE path = ?`, path); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (x *imageIndex) collectGarbage(exists func(file string) bool) (int, error) {
+	rows, err := x.db.Query(`SELECT path, version FROM images`)
+	if err != nil {
+		return 0, err
+	}
+	var stale []string
+	for rows.Next() {
+		var path string
+		var version int
+		if err := rows.Scan(&path, &version); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if version != metadataVersion || !exists(path) {
+			stale = append(stale, path)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	tx, err := x.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for _, path := range stale {
+		if _, err := tx.Exec(`DELETE FROM images WHERE path = ?`, path); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// SQLite keeps the freed pages in the file until it is vacuumed
+	_, err = x.db.Exec(`VACUUM`)
+	return len(stale), err
+}
+
+// indexMutex prevents the library from being indexed by two scans at the same time
+var indexMutex sync.Mutex
+
+// indexLibrary brings the image index up to date with the library, probing the files in probe that are new
+// or have changed and removing the entries of files no longer in the library
+func indexLibrary(library, probe []string) {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	start := time.Now()
+	store := imageMetadataCache()
+	for _, file := range probe {
+		if _, err := store.get(file); err != nil {
+			log.Println("Error:", err)
+		}
+	}
+	if err := store.prune(library); err != nil {
+		log.Printf("Error pruning the image index: %v", err)
+	}
+	if err := store.save(); err != nil {
+		log.Printf("Error saving metadata cache: %v", err)
+	}
+	log.Printf("Indexed %d images in %s", len(probe), time.Since(start))
+}
@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package main
+
+// The SQLite driver needs cgo so it is only included in builds with the sqlite tag
+import _ "github.com/mattn/go-sqlite3"
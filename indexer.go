@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runIndexCommand implements `randompic index`, scanning the image directory once and writing the library
+// index for servers configured with libraryIndex, so the scan can run on the NAS rather than the frames
+func runIndexCommand(args []string) {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	output := flags.String("output", "randompic-index.json", "file to write the library index to")
+	flags.Parse(args)
+
+	// log to the terminal as well, as this usually runs from cron or by hand
+	log.SetOutput(os.Stderr)
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if config.LibraryIndex != "" {
+		log.Fatalf("libraryIndex is set so this config reads an index rather than writing one")
+	}
+
+	start := time.Now()
+	files := loadAllImages()
+	if len(files) == 0 {
+		// keep the previous index rather than emptying every frame when the share is unavailable
+		log.Fatalf("No images found in %s, the index was not written", config.ImageDirectory)
+	}
+	refreshClusters(config, files)
+	if config.IndexDatabase != "" {
+		indexLibrary(files, files)
+	}
+
+	if err := saveLibraryCache(*output, config.ImageDirectory, files); err != nil {
+		log.Fatalf("Error writing the library index: %v", err)
+	}
+	fmt.Printf("Indexed %d images in %s to %s\n", len(files), time.Since(start).Round(time.Millisecond), *output)
+}
+
+// libraryIndexPath returns the location of the library index, relative paths are resolved from the config file
+func libraryIndexPath(config *Config) string {
+	if filepath.IsAbs(config.LibraryIndex) {
+		return config.LibraryIndex
+	}
+	return filepath.Join(filepath.Dir(configPath), config.LibraryIndex)
+}
+
+// readLibraryIndex loads the library from an index written by `randompic index`.  The filters are applied
+// by the indexer so the paths are used as they are, resolved against this machine's image directory, apart
+// from this frame's private images and blacklist.
+func readLibraryIndex(config *Config) ([]string, error) {
+	files, cache, err := readLibraryCache(libraryIndexPath(config), config.ImageDirectory)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Read %d images from the library index written at %s", len(files), cache.SavedAt.Format(time.RFC3339))
+
+	shown := files[:0]
+	for _, file := range files {
+		if !isPrivate(file, config.ImageDirectory) && !isBlacklisted(file, config.ImageDirectory) {
+			shown = append(shown, file)
+		}
+	}
+	return shown, nil
+}
+
+// watchLibraryIndex reloads the library whenever the index file is replaced by the indexer
+func watchLibraryIndex(config *Config) {
+	path := libraryIndexPath(config)
+
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+	for {
+		time.Sleep(time.Minute)
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().After(lastModified) {
+			continue
+		}
+		lastModified = info.ModTime()
+		rescanLibrary()
+	}
+}
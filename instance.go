@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// instanceLockPath is locked while the app runs, so two copies started in the same directory (e.g. by systemd
+// and by hand) don't overwrite each other's caches, index and log files
+const instanceLockPath = "./randompic.lock"
+
+// takeoverTimeout is how long to wait for the running instance to save its state and exit when taking over
+const takeoverTimeout = 15 * time.Second
+
+// instanceLock stays open for the life of the process, closing it would release the lock
+var instanceLock *os.File
+
+// acquireInstanceLock makes sure this is the only instance running in the directory.  With takeover set,
+// a running instance is asked to stop (it saves its state as it does when stopped by systemd) and the lock
+// is taken once it has exited.
+func acquireInstanceLock(takeover bool) error {
+	f, err := os.OpenFile(instanceLockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := lockFile(f); err != nil {
+		pid := lockHolder(f)
+		if !takeover {
+			f.Close()
+			return fmt.Errorf("another instance (pid %d) is already running in this directory, stop it first or start with -takeover", pid)
+		}
+		if err := stopInstance(f, pid); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	// record our pid so the next instance can say who holds the lock
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	instanceLock = f
+	return nil
+}
+
+// lockHolder returns the pid written to the lock file by the instance holding it, 0 when unknown
+func lockHolder(f *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	return pid
+}
+
+// stopInstance asks the instance holding the lock to stop and waits until the lock is free
+func stopInstance(f *os.File, pid int) error {
+	if pid <= 0 {
+		return fmt.Errorf("another instance is running in this directory but its pid is unknown, stop it by hand")
+	}
+	log.Printf("Taking over from the running instance (pid %d)", pid)
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		return fmt.Errorf("unable to stop the running instance (pid %d): %v", pid, err)
+	}
+
+	deadline := time.Now().Add(takeoverTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(250 * time.Millisecond)
+		if lockFile(f) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("the running instance (pid %d) did not stop within %s", pid, takeoverTimeout)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// jsonStore is a map keyed by file path or name, such as the files that couldn't be read, the hashes of the photos
+// or the starred images, persisted as a JSON object.  It is read from disk on first use and written back whole,
+// through a temporary file so a crash never leaves it half written, when save is called after it has changed.
+type jsonStore[V any] struct {
+	path     string // where the map is persisted
+	what     string // what the entries are, for the log
+	compact  bool   // written without indentation, for stores written often or holding every photo
+	userData bool   // set by the user rather than worked out again, so never written over when it couldn't be read
+
+	mutex   sync.Mutex   // To ensure thread-safe access to `entries`, `err`, `dirty` and `saved`
+	entries map[string]V // nil until loaded
+	err     error        // set when user data couldn't be read, leaving it unchanged until the app is restarted
+	dirty   bool         // changed since it was last written
+	saved   time.Time
+}
+
+// load reads the entries from disk on first use, starting empty when they can't be read, the caller must hold
+// s.mutex.  User data that can't be read is left alone rather than written over with the changes made since, until
+// it is fixed and the app restarted, the way loadPrivate treats the private images.
+func (s *jsonStore[V]) load() map[string]V {
+	if s.entries != nil {
+		return s.entries
+	}
+
+	s.entries = map[string]V{}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		err = json.Unmarshal(data, &s.entries)
+		if err != nil {
+			s.entries = map[string]V{}
+			err = fmt.Errorf("error parsing %s: %v", s.what, err)
+		}
+	} else if os.IsNotExist(err) {
+		return s.entries
+	} else {
+		err = fmt.Errorf("error reading %s: %v", s.what, err)
+	}
+	if s.userData {
+		s.err = err
+		log.Printf("%v, leaving %s unchanged until it is fixed and the app restarted", err, s.path)
+	} else {
+		log.Printf("%v, starting again without them", err)
+	}
+	return s.entries
+}
+
+// get returns the entry for a file
+func (s *jsonStore[V]) get(file string) (V, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.load()[file]
+	return entry, ok
+}
+
+// set records the entry for a file, written by the next save
+func (s *jsonStore[V]) set(file string, entry V) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.load(); s.err != nil {
+		return
+	}
+	s.entries[file] = entry
+	s.dirty = true
+}
+
+// delete forgets the entries for files, written by the next save
+func (s *jsonStore[V]) delete(files ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.load(); s.err != nil {
+		return
+	}
+	for _, file := range files {
+		if _, ok := s.load()[file]; ok {
+			delete(s.entries, file)
+			s.dirty = true
+		}
+	}
+}
+
+// read calls f with the entries, which it must not change or keep
+func (s *jsonStore[V]) read(f func(entries map[string]V)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f(s.load())
+}
+
+// save writes the entries to disk when they have changed since they were last written
+func (s *jsonStore[V]) save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write()
+}
+
+// saveAfter saves the entries unless they were written less than interval ago, to spare SD cards from stores
+// changed on every slide
+func (s *jsonStore[V]) saveAfter(interval time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if time.Since(s.saved) < interval {
+		return nil
+	}
+	return s.write()
+}
+
+// write saves the entries when they have changed, the caller must hold s.mutex
+func (s *jsonStore[V]) write() error {
+	if s.err != nil {
+		return s.err
+	}
+	if !s.dirty {
+		return nil
+	}
+	var data []byte
+	var err error
+	if s.compact {
+		data, err = json.Marshal(s.entries)
+	} else {
+		data, err = json.MarshalIndent(s.entries, "", "    ")
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	s.dirty = false
+	s.saved = time.Now()
+	return nil
+}
+
+// collect forgets the entries of files that no longer exist, returning the number forgotten
+func (s *jsonStore[V]) collect(exists func(file string) bool) int {
+	s.mutex.Lock()
+	var files []string
+	for file := range s.load() {
+		files = append(files, file)
+	}
+	s.mutex.Unlock()
+
+	// check the files without holding the lock, as it can be slow on a network share
+	var gone []string
+	for _, file := range files {
+		if !exists(file) {
+			gone = append(gone, file)
+		}
+	}
+	if len(gone) == 0 {
+		return 0
+	}
+
+	s.delete(gone...)
+	if err := s.save(); err != nil {
+		log.Printf("Error saving %s: %v", s.what, err)
+	}
+	return len(gone)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONStore(t *testing.T) {
+	tests := []struct {
+		name     string
+		saved    string // the file on disk, none when empty
+		userData bool
+		wantErr  bool
+		want     string // the file after setting b to 2 and saving
+	}{
+		{name: "no file yet", want: "{\n    \"b\": 2\n}"},
+		{name: "adds to the saved entries", saved: `{"a": 1}`, want: "{\n    \"a\": 1,\n    \"b\": 2\n}"},
+		{name: "a broken cache starts again", saved: `{"a": `, want: "{\n    \"b\": 2\n}"},
+		{name: "broken user data is left alone", saved: `{"a": `, userData: true, wantErr: true, want: `{"a": `},
+		{name: "user data", saved: `{"a": 1}`, userData: true, want: "{\n    \"a\": 1,\n    \"b\": 2\n}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store.json")
+			if tt.saved != "" {
+				if err := os.WriteFile(path, []byte(tt.saved), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store := &jsonStore[int]{path: path, what: "test entries", userData: tt.userData}
+
+			store.set("b", 2)
+			if err := store.save(); (err != nil) != tt.wantErr {
+				t.Fatalf("save() error = %v, want error %v", err, tt.wantErr)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("saved %s, want %s", data, tt.want)
+			}
+			if _, ok := store.get("b"); ok == tt.wantErr {
+				t.Errorf("get(b) found %v, want %v", ok, !tt.wantErr)
+			}
+			if info, err := os.Stat(path); err == nil && tt.saved != "" && info.Mode().Perm() != 0600 {
+				t.Errorf("mode = %v, want -rw-------", info.Mode())
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+const (
+	defaultKenBurnsZoom   = 1.15
+	maxKenBurnsZoom       = 2
+	defaultKenBurnsEasing = "ease-in-out"
+)
+
+// KenBurnsConfig slowly pans and zooms across each photo on the slideshow page while it is on screen
+type KenBurnsConfig struct {
+	MaxZoom float64 `json:"maxZoom" desc:"How far the photo is zoomed in at most, 1.2 making it 20% larger" default:"1.15"`
+	Easing  string  `json:"easing" desc:"How the movement speeds up and slows down" default:"ease-in-out" enum:"linear,ease,ease-in,ease-out,ease-in-out"`
+}
+
+// kenBurnsEffect is the pan and zoom the page script applies to the photo on screen
+type kenBurnsEffect struct {
+	MaxZoom    float64
+	Easing     string
+	DurationMs int64 // how long the slide is on screen, the movement lasts the whole time
+}
+
+// kenBurnsFor returns the effect for a slide shown for displayMs, with out of range settings replaced by the defaults
+func kenBurnsFor(cfg *KenBurnsConfig, displayMs int64) *kenBurnsEffect {
+	effect := &kenBurnsEffect{MaxZoom: cfg.MaxZoom, Easing: cfg.Easing, DurationMs: displayMs}
+	if effect.MaxZoom <= 1 {
+		effect.MaxZoom = defaultKenBurnsZoom
+	}
+	effect.MaxZoom = min(effect.MaxZoom, maxKenBurnsZoom)
+	switch effect.Easing {
+	case "linear", "ease", "ease-in", "ease-out", "ease-in-out":
+	default:
+		effect.Easing = defaultKenBurnsEasing
+	}
+	return effect
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// lastShownPath is where the time each image was last displayed is persisted
+const lastShownPath = "./randompic-lastshown.json"
+
+// lastShownSaveInterval limits how often the last shown times are written, to spare SD cards
+const lastShownSaveInterval = time.Minute
+
+const defaultRecencyDecayHours = 168
+
+// lastShown is the unix time each image was last displayed, keyed by absolute path
+var lastShown = &jsonStore[int64]{path: lastShownPath, what: "last shown times", compact: true}
+
+// recordShown notes that an image has just been displayed, writing the times to disk at most once a minute
+func recordShown(file string) {
+	lastShown.set(file, time.Now().Unix())
+	if err := lastShown.saveAfter(lastShownSaveInterval); err != nil {
+		log.Printf("Error saving last shown times: %v", err)
+	}
+}
+
+// mostRecentlyShown returns the image displayed last, empty when none has been recorded
+func mostRecentlyShown() string {
+	var latest string
+	var latestTime int64
+	lastShown.read(func(times map[string]int64) {
+		for file, shown := range times {
+			if shown > latestTime || shown == latestTime && file < latest {
+				latest, latestTime = file, shown
+			}
+		}
+	})
+	return latest
+}
+
+// saveLastShown writes the last shown times to disk straight away, used when the app is stopped
+func saveLastShown() {
+	if err := lastShown.save(); err != nil {
+		log.Printf("Error saving last shown times: %v", err)
+	}
+}
+
+// recencyWeight returns how likely an image is to be chosen given how long ago it was shown, from 0 just after
+// it was shown rising towards 1 as the time since grows past the decay period.  Images never shown have weight 1.
+func recencyWeight(shownAt int64, now time.Time, decay time.Duration) float64 {
+	if shownAt == 0 {
+		return 1
+	}
+	age := now.Sub(time.Unix(shownAt, 0))
+	if age <= 0 {
+		return 0
+	}
+	return 1 - math.Exp(-float64(age)/float64(decay))
+}
+
+// weightedSelector picks images at random, favouring those that have not been shown recently
+type weightedSelector struct {
+	decay  time.Duration
+	random *selectionRandom // nil for selectionRand, a clone draws from a copy
+}
+
+func (s weightedSelector) next(files []string) string {
+	if len(files) == 0 {
+		return selectRandomImage(files)
+	}
+
+	now := time.Now()
+	weights := make([]float64, len(files))
+	total := 0.0
+	lastShown.read(func(shown map[string]int64) {
+		for i, file := range files {
+			weights[i] = recencyWeight(shown[file], now, s.decay)
+			total += weights[i]
+		}
+	})
+
+	if total <= 0 {
+		// everything was shown moments ago
+		return selectRandomImage(files)
+	}
+
+	target := s.random.float64() * total
+	for i, weight := range weights {
+		target -= weight
+		if target < 0 {
+			return files[i]
+		}
+	}
+	return files[len(files)-1]
+}
+
+func (s weightedSelector) clone() imageSelector {
+	s.random = s.random.copy()
+	return s
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWeightedSelector(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		shown map[string]time.Duration // how long ago each image was shown, never when missing
+		want  map[string]bool          // the images that may be chosen
+	}{
+		{
+			name:  "never shown images only",
+			shown: map[string]time.Duration{"a": time.Second, "b": 2 * time.Second},
+			want:  map[string]bool{"c": true, "d": true},
+		},
+		{
+			name:  "long ago is as good as never",
+			shown: map[string]time.Duration{"a": time.Second, "b": 1000 * time.Hour},
+			want:  map[string]bool{"b": true, "c": true, "d": true},
+		},
+		{
+			name:  "everything shown just now",
+			shown: map[string]time.Duration{"a": 0, "b": 0, "c": 0, "d": 0},
+			want:  map[string]bool{"a": true, "b": true, "c": true, "d": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []string{"a", "b", "c", "d"}
+			for file, ago := range tt.shown {
+				lastShown.set(file, now.Add(-ago).Unix())
+			}
+			t.Cleanup(func() { lastShown.delete(files...) })
+
+			selector := weightedSelector{decay: 24 * time.Hour, random: newSelectionRandom(1)}
+			chosen := map[string]bool{}
+			for _, image := range nextImages(selector, files, 200) {
+				chosen[image] = true
+			}
+			for image := range chosen {
+				if !tt.want[image] {
+					t.Errorf("chose %s, want only %v", image, tt.want)
+				}
+			}
+			if len(chosen) != len(tt.want) {
+				t.Errorf("chose %v, want each of %v", chosen, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLegacyImageSize = "1280x720"
+	maxLegacyImageWidth    = 3840
+	maxLegacyImageHeight   = 2160
+	legacyJPEGQuality      = 80
+)
+
+// renderLegacyPage serves the slide on a plain page for old TV browsers that can't run the page's scripts.
+// It reloads itself with a meta refresh and shows a JPEG downsized on the server, and anything the main page
+// works out in the browser, such as the time left on a countdown, is worked out here instead.
+func renderLegacyPage(w http.ResponseWriter, r *http.Request, config *Config, current slide, device *DeviceClass, messages *messageCatalog) {
+	tmplParsed, err := template.New("legacy.html").Funcs(messages.funcs()).ParseFS(staticFiles, "static/legacy.html")
+	if err != nil {
+		http.Error(w, "Error parsing template: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error parsing template: %v", err)
+		return
+	}
+
+	size := defaultLegacyImageSize
+	if device != nil && device.ImageSize != "" {
+		size = device.ImageSize
+	}
+
+	data := struct {
+		ImageURL       string
+		DisplaySeconds int
+		RefreshURL     string
+		Banner         string
+		Intro          *folderIntro
+		Note           *albumNote
+		Countdown      *countdownSlide
+		CountdownLeft  string
+		AltText        string
+	}{
+		DisplaySeconds: pageDisplaySeconds(config),
+		RefreshURL:     r.URL.RequestURI(),
+		Intro:          current.Intro,
+		Note:           current.Note,
+		Countdown:      current.Countdown,
+		AltText:        slideDescription(current, config.ImageDirectory, messages),
+	}
+	if current.Image != "" {
+		relative := relativeImagePath(current.Image, config.ImageDirectory)
+		data.ImageURL = "/legacy/image?path=" + url.QueryEscape(relative) + "&size=" + url.QueryEscape(size)
+	}
+	if current.Countdown != nil {
+		data.CountdownLeft = countdownText(time.Until(current.Countdown.Target), messages)
+	}
+	if event := currentEvent(); event != nil {
+		data.Banner = event.Banner
+	}
+
+	if err := tmplParsed.Execute(w, data); err != nil {
+		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+// countdownText describes the time left on a countdown the way the main page's script does
+func countdownText(remaining time.Duration, messages *messageCatalog) string {
+	remaining = max(remaining, 0)
+	days := int(remaining / (24 * time.Hour))
+	hours := int(remaining % (24 * time.Hour) / time.Hour)
+	minutes := int(remaining % time.Hour / time.Minute)
+	return messages.text("page.countdown", days, hours, minutes)
+}
+
+// imageDirectoryFile returns the file at a path relative to the image directory given in a request, which can't
+// lead outside the image directory
+func imageDirectoryFile(imageDirectory, relative string) string {
+	// cleaning the path as if it were absolute keeps it inside the image directory
+	return filepath.Join(imageDirectory, filepath.FromSlash(strings.TrimPrefix(path.Clean("/"+relative), "/")))
+}
+
+// legacyImageHandler serves an image from the image directory as a JPEG scaled down to fit inside ?size=WIDTHxHEIGHT,
+// so old TV browsers don't have to decode full size photos they often can't handle
+func legacyImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	var width, height int
+	if _, err := fmt.Sscanf(r.URL.Query().Get("size"), "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+		http.Error(w, "The size parameter must be in WIDTHxHEIGHT format", http.StatusBadRequest)
+		return
+	}
+	width, height = min(width, maxLegacyImageWidth), min(height, maxLegacyImageHeight)
+
+	relative := r.URL.Query().Get("path")
+	if relative == "" {
+		http.Error(w, "The path parameter is required", http.StatusBadRequest)
+		return
+	}
+	file := imageDirectoryFile(config.ImageDirectory, relative)
+
+	if isPrivate(file, config.ImageDirectory) {
+		http.NotFound(w, r)
+		return
+	}
+	if !headerChecked(file) {
+		// a format the app can't decode, such as WebP, is sent as it is for the browser to try
+		http.ServeFile(w, r, file)
+		return
+	}
+
+	imageWidth, imageHeight, err := probeDimensions(file)
+	if err != nil {
+		http.Error(w, "Error reading image: "+err.Error(), http.StatusNotFound)
+		log.Printf("Error reading %s for the legacy page: %v", file, err)
+		return
+	}
+
+	// never scale up, the browser can do that itself
+	scale := min(1, float64(width)/float64(imageWidth), float64(height)/float64(imageHeight))
+	frame, err := renderFrame(file, max(int(float64(imageWidth)*scale), 1), max(int(float64(imageHeight)*scale), 1), "contain")
+	if err != nil {
+		http.Error(w, "Error reading image: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error decoding %s for the legacy page: %v", file, err)
+		quarantineFile(file, "legacy", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	if err := jpeg.Encode(w, frame, &jpeg.Options{Quality: legacyJPEGQuality}); err != nil {
+		log.Printf("Error encoding %s for the legacy page: %v", file, err)
+	}
+}
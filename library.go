@@ -0,0 +1,240 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"randompic/client"
+)
+
+// loadLibrary performs the initial scan of the image directory and then starts the rotation and the
+// background rescans.  It runs in its own goroutine so the server can start before the scan completes.
+func loadLibrary(config *Config) {
+	if cached, ok := loadLibraryCache(config.ImageDirectory); ok && config.LibraryIndex == "" {
+		// Serve from the list saved by the last run straight away, then verify it against the directory
+		if config.ContentFilter != nil {
+			cached = filterByContent(cached, config)
+		}
+		setLibrary(cached)
+		refreshClusters(config, cached)
+		startRotation(config)
+		rescanLibrary()
+	} else {
+		start := time.Now() // time the loading of images
+		// get the list of files
+		fileList := loadAllImages()
+		elapsed := time.Since(start)
+		log.Printf("Loading fileList from disk took: %s", elapsed)
+		setLibrary(fileList)
+		persistLibrary(fileList)
+
+		// Group the photos into clusters when they are used as virtual albums
+		refreshClusters(config, fileList)
+
+		// Index the metadata of every image, a rescan does this when the library was loaded from the cache
+		if config.IndexDatabase != "" {
+			go indexLibrary(fileList, fileList)
+		}
+
+		// Start the image updater, or follow the leader
+		startRotation(config)
+	}
+
+	// A separate indexer does the scanning, reload the library whenever it writes a new index
+	if config.LibraryIndex != "" {
+		go watchLibraryIndex(config)
+		return
+	}
+
+	// Pick up new and removed images by rescanning the directory on an interval
+	if config.LowMemory {
+		// Draw a fresh sample of the library once the current one has been shown
+		go rescanPeriodically(lowMemoryResampleInterval(config))
+	} else if config.RescanMinutes > 0 {
+		go rescanPeriodically(time.Duration(config.RescanMinutes) * time.Minute)
+	}
+
+	// Add and remove images as files change on disk
+	if config.WatchDirectory && config.LowMemory {
+		log.Println("Not watching the image directory as it is not held in memory in low memory mode")
+	} else if config.WatchDirectory {
+		if err := watchImageDirectory(config.ImageDirectory); err != nil {
+			log.Printf("Unable to watch the image directory: %v", err)
+		}
+	}
+}
+
+// rescanMutex prevents rescans triggered by the timer, the API and the watcher from running at the same time
+var rescanMutex sync.Mutex
+
+// rescanLibrary walks the image directory again and swaps in the new list of images, returning the number of images found.
+// An empty result is ignored while images are already loaded, as it usually means the directory is temporarily unavailable.
+func rescanLibrary() int {
+	rescanMutex.Lock()
+	defer rescanMutex.Unlock()
+
+	start := time.Now()
+	files := loadAllImages()
+
+	if len(files) == 0 && len(currentLibrary()) > 0 {
+		log.Printf("Rescan found no images, keeping the existing list of %d images", len(currentLibrary()))
+		return len(currentLibrary())
+	}
+
+	setLibrary(files)
+	persistLibrary(files)
+	log.Printf("Rescan found %d images in %s", len(files), time.Since(start))
+
+	if config, err := loadConfig(configPath); err == nil {
+		refreshClusters(config, files)
+		if config.IndexDatabase != "" {
+			go indexLibrary(files, files)
+		}
+	}
+	return len(files)
+}
+
+// rescanHandler triggers a rescan of the image directory and returns the number of images found
+func rescanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Println("Rescan requested through the API")
+	writeJSON(w, client.RescanResult{
+		Images: rescanLibrary(),
+	})
+}
+
+// rescanPeriodically rescans the image directory on an interval so newly synced photos are picked up
+func rescanPeriodically(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		rescanLibrary()
+	}
+}
+
+// updateLibrary adds and removes individual files from the library without a full rescan.
+// Files under any of the removed directories are dropped as well.  The list is kept sorted.
+func updateLibrary(added, removed, removedDirs []string) {
+	libraryMutex.Lock()
+
+	drop := make(map[string]bool, len(removed))
+	for _, file := range removed {
+		drop[file] = true
+	}
+
+	// copy the list so readers holding the old slice are unaffected
+	files := make([]string, 0, len(libraryFiles)+len(added))
+	for _, file := range libraryFiles {
+		if drop[file] || underAnyDir(file, removedDirs) {
+			continue
+		}
+		files = append(files, file)
+	}
+	removedCount := len(libraryFiles) - len(files)
+
+	addedCount := 0
+	for _, file := range added {
+		i := sort.SearchStrings(files, file)
+		if i < len(files) && files[i] == file {
+			continue
+		}
+		files = append(files, "")
+		copy(files[i+1:], files[i:])
+		files[i] = file
+		addedCount++
+	}
+
+	log.Printf("Library updated: %d images (%d added, %d removed)", len(files), addedCount, removedCount)
+	old := libraryFiles
+	libraryFiles = files
+	libraryMutex.Unlock()
+	libraryEvents.publish(libraryEvent{Old: old, New: files})
+	persistLibrary(files)
+
+	if config, err := loadConfig(configPath); err == nil {
+		refreshClusters(config, files)
+		if config.IndexDatabase != "" {
+			go indexLibrary(files, added)
+		}
+	}
+}
+
+// collapseCopies records the groups of identical and near identical photos among files and returns the files to
+// keep in the library, leaving out all but one of each group as configured
+func collapseCopies(files []string, config *Config) []string {
+	files = detectDuplicates(files, config)
+	if config.NearDuplicates != nil {
+		files = collapseSimilar(files, config.NearDuplicates)
+	}
+	return files
+}
+
+// addToLibrary adds files that passed filterImages to the library and removes others, like updateLibrary, but first
+// compares the added files with the copies found by the last scan, so a copy of a photo added while the app runs is
+// left out just as a scan would leave it out.  Only the added files are read, and the files they may be copies of.
+// When the file shown for a group of copies is removed the next one of the group is shown instead.
+func addToLibrary(added, removed, removedDirs []string, config *Config) {
+	if len(removed) > 0 || len(removedDirs) > 0 {
+		added = append(added, forgetCopies(removed, removedDirs, config)...)
+	}
+	added, replaced := addDuplicates(added, config)
+	removed = append(removed, replaced...)
+	if config.NearDuplicates != nil {
+		added, replaced = addSimilar(added, config)
+		removed = append(removed, replaced...)
+	}
+	updateLibrary(added, removed, removedDirs)
+}
+
+// forgetCopies drops removed files from the groups of identical and near identical photos, returning the files that
+// take the place of a removed file that was shown for its group
+func forgetCopies(removed, removedDirs []string, config *Config) []string {
+	gone := make(map[string]bool, len(removed))
+	for _, file := range removed {
+		gone[file] = true
+	}
+	without := func(groups [][]string, collapsed bool) ([][]string, []string) {
+		var kept [][]string
+		var shown []string
+		for _, group := range groups {
+			rest := slices.DeleteFunc(slices.Clone(group), func(file string) bool {
+				return gone[file] || underAnyDir(file, removedDirs)
+			})
+			if collapsed && len(rest) > 0 && rest[0] != group[0] && !excludedFile(rest[0], config) {
+				shown = append(shown, rest[0])
+			}
+			if len(rest) > 1 {
+				kept = append(kept, rest)
+			}
+		}
+		return kept, shown
+	}
+
+	duplicatesMutex.Lock()
+	var shown, similar []string
+	duplicateGroups, shown = without(duplicateGroups, config.CollapseDuplicates)
+	duplicatesMutex.Unlock()
+	similarGroupsMutex.Lock()
+	similarGroups, similar = without(similarGroups, config.NearDuplicates != nil)
+	similarGroupsMutex.Unlock()
+	return append(shown, similar...)
+}
+
+// underAnyDir reports whether file is inside one of dirs
+func underAnyDir(file string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(file, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// libraryCachePath is where the scanned list of images is persisted so restarts can serve straight away
+const libraryCachePath = "./randompic-library.json"
+
+// libraryCacheEntry is a single image in the persisted library
+type libraryCacheEntry struct {
+	Path    string `json:"path"` // relative to the image directory, older caches hold absolute paths
+	ModTime int64  `json:"modTime"`
+}
+
+// libraryCacheFile is the persisted library.  Paths are stored relative to the image directory so an index
+// written by `randompic index` on one machine can be served by another that mounts the photos elsewhere.
+type libraryCacheFile struct {
+	ImageDirectory string              `json:"imageDirectory"`
+	SavedAt        time.Time           `json:"savedAt"`
+	Files          []libraryCacheEntry `json:"files"`
+}
+
+// libraryCacheMutex serialises writes of the library cache
+var libraryCacheMutex sync.Mutex
+
+// saveLibraryCache writes the list of images and their modification times to path
+func saveLibraryCache(path, imageDirectory string, files []string) error {
+	libraryCacheMutex.Lock()
+	defer libraryCacheMutex.Unlock()
+
+	cache := libraryCacheFile{
+		ImageDirectory: imageDirectory,
+		SavedAt:        time.Now(),
+		Files:          make([]libraryCacheEntry, 0, len(files)),
+	}
+	for _, file := range files {
+		entry := libraryCacheEntry{Path: relativeImagePath(file, imageDirectory)}
+		if info, err := os.Stat(file); err == nil {
+			entry.ModTime = info.ModTime().Unix()
+		}
+		cache.Files = append(cache.Files, entry)
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes data to a temporary file first and renames it over path, so a crash or a power cut, or a
+// server reading the file, never sees it half written.  The file keeps the permissions of the one it replaces.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if info, statErr := os.Stat(path); statErr == nil {
+		err = file.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readLibraryCache reads a persisted library, resolving its paths against imageDirectory
+func readLibraryCache(path, imageDirectory string) ([]string, libraryCacheFile, error) {
+	var cache libraryCacheFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, cache, err
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, cache, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	files := make([]string, 0, len(cache.Files))
+	for _, entry := range cache.Files {
+		file := entry.Path
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(imageDirectory, filepath.FromSlash(file))
+		}
+		files = append(files, file)
+	}
+	return files, cache, nil
+}
+
+// loadLibraryCache returns the persisted list of images if it was saved for imageDirectory
+func loadLibraryCache(imageDirectory string) ([]string, bool) {
+	files, cache, err := readLibraryCache(libraryCachePath, imageDirectory)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading library cache: %v", err)
+		}
+		return nil, false
+	}
+	if cache.ImageDirectory != imageDirectory || len(files) == 0 {
+		return nil, false
+	}
+
+	log.Printf("Loaded %d images from the library cache saved at %s", len(files), cache.SavedAt.Format(time.RFC3339))
+	return files, true
+}
+
+// persistLibrary saves the library in the background after it changes.  Nothing is written when the
+// library is read from an index produced by `randompic index`, as it is only read by this process.
+func persistLibrary(files []string) {
+	config, err := loadConfig(configPath)
+	if err != nil || config.LibraryIndex != "" {
+		return
+	}
+	go func() {
+		if err := saveLibraryCache(libraryCachePath, config.ImageDirectory, files); err != nil {
+			log.Printf("Error saving library cache: %v", err)
+		}
+	}()
+}
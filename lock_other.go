@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// lockFile is only supported on linux, other systems always get the lock
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// lowMemorySampleSize is the number of images held in memory at once in low memory mode
+const lowMemorySampleSize = 1000
+
+// sampleFiles walks the image directory keeping a uniformly random sample of up to size files using reservoir
+// sampling, so memory use stays the same however large the library is.  The sample is returned sorted.
+func sampleFiles(config *Config, workers, size int) ([]string, error) {
+	sample := make([]string, 0, size)
+	seen := 0
+	err := WalkFiles(config.ImageDirectory, workers, func(path string) {
+		// skip files that would be filtered out anyway so they don't take up places in the sample
+		if excludedFile(path, config) {
+			return
+		}
+
+		seen++
+		if len(sample) < size {
+			sample = append(sample, path)
+		} else if i := randomIntn(seen); i < size {
+			sample[i] = path
+		}
+	})
+
+	sort.Strings(sample)
+	return sample, err
+}
+
+// lowMemoryResampleInterval returns how often a new sample is taken, roughly once every image in the sample has been shown
+func lowMemoryResampleInterval(config *Config) time.Duration {
+	return time.Duration(lowMemorySampleSize*max(config.DisplaySeconds, 1)) * time.Second
+}
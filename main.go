@@ -1,40 +1,135 @@
 package main
 
 import (
-	_ "embed"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"html/template"
 	"log"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
-	"text/template"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-//go:embed static/index.html
-var staticIndexFile string
+// defaultScanWorkers is the number of directories read concurrently when scanWorkers is not set
+const defaultScanWorkers = 8
+
+// configPath is the location of the configuration file, relative to the directory the app is run from
+var configPath = filepath.Join(".", "config.json")
 
 var (
-	randomImage   string
-	imageMutex    sync.Mutex         // To ensure thread-safe access to `randomImage`
-	IndexTemplate *template.Template // capitalised to allow "export" and usage in init funcion
+	currentSlide   slide
+	currentShownAt time.Time          // when currentSlide was put on screen
+	imageMutex     sync.Mutex         // To ensure thread-safe access to `currentSlide` and `currentShownAt`
+	IndexTemplate  *template.Template // capitalised to allow "export" and usage in init funcion
+	rotationPaused atomic.Bool        // set while the display is powered off, the updater keeps the current image instead of selecting a new one
+	libraryFiles   []string
+	libraryLoaded  atomic.Bool  // set once the initial scan of the image directory has completed and the first slide is ready
+	libraryMutex   sync.RWMutex // To ensure thread-safe access to `libraryFiles`
 	/*
 		embed package includes the index file contents as a string but the template engine expects a file path.  Instead parse the string content instead of trying to use a filepath
 	*/
 )
 
+// slide is what is currently on screen, an image plus the countdown, intro or note shown over it
+type slide struct {
+	Image     string
+	Countdown *countdownSlide // set when the slide is a countdown rather than a plain photo
+	Intro     *folderIntro    // set when the slide introduces a folder or cluster in story mode
+	Note      *albumNote      // set on the first slide of an album that has a note attached
+}
+
+// getCurrentSlide safely returns the slide currently on screen
+func getCurrentSlide() slide {
+	imageMutex.Lock()
+	defer imageMutex.Unlock()
+	return currentSlide
+}
+
 // Config represents the configuration structure for exclusions
 type Config struct {
-	ExcludedExtensions  []string `json:"excludedExtensions"`
-	ExcludedDirectories []string `json:"excludedDirectories"`
-	ImageDirectory      string   `json:"imageDirectory"`
-	DisplaySeconds      int      `json:"displaySeconds"`
+	ConfigVersion       int                  `json:"configVersion" desc:"Version of the configuration format, upgraded automatically when the app starts"`
+	ExcludedExtensions  []string             `json:"excludedExtensions" desc:"File extensions (including the dot) that are never displayed"`
+	ExcludedDirectories []string             `json:"excludedDirectories" desc:"Directories whose path contains any of these strings are not loaded"`
+	ImageDirectory      string               `json:"imageDirectory" desc:"Absolute path of the directory to load images from" required:"true"`
+	DisplaySeconds      int                  `json:"displaySeconds" desc:"Number of seconds each image is displayed" required:"true"`
+	MinWidth            int                  `json:"minWidth" desc:"Images narrower than this many pixels are not displayed" default:"0"`
+	MinHeight           int                  `json:"minHeight" desc:"Images shorter than this many pixels are not displayed" default:"0"`
+	MinFileSizeKB       int64                `json:"minFileSizeKB" desc:"Files smaller than this many kilobytes are not displayed" default:"0"`
+	MaxFileSizeMB       int64                `json:"maxFileSizeMB" desc:"Files larger than this many megabytes are not displayed, 0 for no limit" default:"0"`
+	CollapseDuplicates  bool                 `json:"collapseDuplicates" desc:"Show only one of several identical files, such as a photo synced into several folders, so it isn't shown several times as often" default:"false"`
+	AICaptions          *AICaptionConfig     `json:"aiCaptions" desc:"Write a one line caption for each photo without one using a vision model, served locally or through a cloud API"`
+	ContentFilter       *ContentFilterConfig `json:"contentFilter" desc:"Run each image past a command or HTTP endpoint, such as an NSFW classifier, that can keep it out of the slideshow"`
+	NearDuplicates      *NearDuplicateConfig `json:"nearDuplicates" desc:"Show only one photo of each group of visually near identical shots, such as a burst series or a re-saved copy"`
+	SniffContent        bool                 `json:"sniffContent" desc:"Tell images from other files by their first bytes rather than their extension, for exports without extensions or with the wrong ones" default:"false"`
+	PowerSchedule       *PowerScheduleConfig `json:"powerSchedule" desc:"Smart plug used to power the display on and off each day"`
+	Framebuffer         *FramebufferConfig   `json:"framebuffer" desc:"Draw the slideshow directly to the Linux framebuffer, without a browser"`
+	Countdowns          []CountdownConfig    `json:"countdowns" desc:"Countdown slides mixed into the rotation ahead of a date"`
+	DateFrom            string               `json:"dateFrom" desc:"Only photos taken on or after this date (YYYY-MM-DD) are displayed" format:"date"`
+	DateTo              string               `json:"dateTo" desc:"Only photos taken on or before this date (YYYY-MM-DD) are displayed" format:"date"`
+	SelectionMode       string               `json:"selectionMode" desc:"How the next image is chosen" default:"random" enum:"random,shuffle,weighted,rated,sequential,story,events"`
+	RecencyDecayHours   float64              `json:"recencyDecayHours" desc:"In weighted mode, how many hours it takes for a shown image to become about two thirds as likely to be chosen again" default:"168"`
+	FolderIntros        bool                 `json:"folderIntros" desc:"Show an intro slide when sequential or events mode enters a folder or cluster" default:"false"`
+	ScanWorkers         int                  `json:"scanWorkers" desc:"Number of directories read concurrently while scanning the image directory" default:"8"`
+	RescanMinutes       int                  `json:"rescanMinutes" desc:"Rescan the image directory every this many minutes, 0 to only scan at startup" default:"0"`
+	WatchDirectory      bool                 `json:"watchDirectory" desc:"Watch the image directory with inotify and update the library as files change" default:"false"`
+	RandomSeed          int64                `json:"randomSeed" desc:"Seed for choosing images so the selection is reproducible, 0 seeds from the clock" default:"0"`
+	ClusterPhotos       bool                 `json:"clusterPhotos" desc:"Group photos into clusters usable as virtual albums" default:"false"`
+	ClusterGapHours     float64              `json:"clusterGapHours" desc:"Start a new cluster after a gap of this many hours between photos" default:"12"`
+	ClusterDistanceKm   float64              `json:"clusterDistanceKm" desc:"Start a new cluster when consecutive geotagged photos are this many kilometres apart" default:"50"`
+	IndexDatabase       string               `json:"indexDatabase" desc:"Path of a SQLite database indexing the metadata of every image, requires a build with the sqlite tag"`
+	HistorySize         int                  `json:"historySize" desc:"Number of recently displayed images listed at /api/history" default:"50"`
+	PersistHistory      bool                 `json:"persistHistory" desc:"Keep the display history across restarts" default:"false"`
+	LibraryIndex        string               `json:"libraryIndex" desc:"Read the library from an index file written by 'randompic index' instead of scanning imageDirectory"`
+	LowMemory           bool                 `json:"lowMemory" desc:"Only keep a random sample of the library in memory, for libraries with millions of images" default:"false"`
+	Album               string               `json:"album" desc:"Only show images from this album (a directory relative to imageDirectory, clusters/<id> or starred), all images when empty"`
+	HoldMinutes         int                  `json:"holdMinutes" desc:"Number of minutes POST /api/hold keeps the current image on screen when the request doesn't say" default:"30"`
+	EmailDigest         *EmailDigestConfig   `json:"emailDigest" desc:"Email a weekly summary of the slideshow"`
+	StartupImage        string               `json:"startupImage" desc:"Image shown while the library loads, a path relative to imageDirectory, an absolute path, or last for the image shown last before the app was stopped"`
+	Location            *LocationConfig      `json:"location" desc:"Where the frame is, for times relative to sunrise and sunset such as sunset+30m"`
+	QuietHours          *QuietHoursConfig    `json:"quietHours" desc:"Blank the display overnight without a smart plug, waking it again in the morning"`
+	Presence            *PresenceConfig      `json:"presence" desc:"Blank the display when a motion or presence sensor posting to /api/v1/presence sees nobody"`
+	KenBurns            *KenBurnsConfig      `json:"kenBurns" desc:"Slowly pan and zoom across each photo on the slideshow page while it is on screen"`
+	Schedules           []ScheduleConfig     `json:"schedules" desc:"Albums or date ranges shown at set times of day in place of album, the first running schedule is used"`
+	Albums              []AlbumConfig        `json:"albums" desc:"Named albums, each with a slideshow of its own served at /album/<name>"`
+	Follow              *FollowConfig        `json:"follow" desc:"Show the slides of another randompic instance instead of choosing them, so several frames change photo together"`
+	MaxImageWidth       int                  `json:"maxImageWidth" desc:"Photos wider than this many pixels are scaled down on the server before the page shows them, 0 for no limit" default:"0"`
+	MaxImageHeight      int                  `json:"maxImageHeight" desc:"Photos taller than this many pixels are scaled down on the server before the page shows them, 0 for no limit" default:"0"`
+	ResizeCacheMB       int64                `json:"resizeCacheMB" desc:"Most megabytes the scaled down copies of photos may take up on disk, the least recently used are removed beyond it" default:"512"`
+	FaceDetection       *FaceDetectionConfig `json:"faceDetection" desc:"Find the faces in photos that are smart cropped to fill the screen, so the crop keeps them in frame"`
+	Pregenerate         *PregenerateConfig   `json:"pregenerate" desc:"Scale photos down in the background before they are shown, rather than when the page first asks for them"`
+	Transcode           *TranscodeConfig     `json:"transcode" desc:"Send scaled down photos as AVIF or WebP to browsers that accept them"`
+	Compression         *CompressionConfig   `json:"compression" desc:"Gzip the page, static files and API responses for browsers that accept it"`
+	VideoMaxSeconds     int                  `json:"videoMaxSeconds" desc:"Longest an MP4 or QuickTime clip plays before the slideshow moves on, clips are otherwise shown until they end" default:"60"`
+	AnimationTiming     string               `json:"animationTiming" desc:"How long animated GIFs and PNGs are shown: for the display interval, looping as often as fits, or extended to play at least one whole loop" default:"loop" enum:"loop,extend"`
+	CorrectOrientation  bool                 `json:"correctOrientation" desc:"Turn photos upright on the server from their EXIF orientation, for browsers that ignore it" default:"false"`
+	BlurredFill         bool                 `json:"blurredFill" desc:"Fill the bars beside or above a photo that doesn't match the shape of the screen with a blurred copy of it" default:"false"`
+	Display             *DisplayConfig       `json:"display" desc:"Color behind the photo, a mat around it and whether it fills the screen"`
+	Orientation         string               `json:"orientation" desc:"Orientation of the screen, photos of the other orientation are shown less often or not at all, also set for one screen with ?orientation=portrait" enum:"portrait,landscape"`
+	OrientationMatch    string               `json:"orientationMatch" desc:"prefer still shows a few photos of the other orientation, require never shows them" default:"prefer" enum:"prefer,require"`
+	Theme               string               `json:"theme" desc:"Look of the slideshow page: the photo on a light background, just the photo on black, the photo in a mat and frame or the photo above a bar of its details" default:"classic" enum:"classic,minimal,matte,info"`
+	TemplatePath        string               `json:"templatePath" desc:"HTML template shown in place of the built in slideshow page, which is used while the template can't be parsed"`
+	Language            string               `json:"language" desc:"Language of the slideshow and admin pages, e.g. de or pt-BR, with text from messages/<language>.json next to the config file replacing the built in text" default:"en"`
+	ShowPath            bool                 `json:"showPath" desc:"Show the path of each photo on screen, so it can be found on disk later, also toggled with the P key or ?path=1" default:"false"`
+	ExifOverlay         *ExifOverlayConfig   `json:"exifOverlay" desc:"Show when and where each photo was taken, and the camera, under the photo"`
+	MiniMap             *MiniMapConfig       `json:"miniMap" desc:"Show a small map of where each geotagged photo was taken"`
+	Weather             *WeatherConfig       `json:"weather" desc:"Show the current temperature and conditions over the photo"`
+	Clock               *ClockConfig         `json:"clock" desc:"Show the time over the photo so the frame doubles as a wall clock"`
+	Music               *MusicConfig         `json:"music" desc:"Play a directory of music or an audio stream alongside the slideshow"`
+	OLEDProtection      *OLEDConfig          `json:"oledProtection" desc:"Protect OLED panels running all day from burn-in by moving the picture a little and blacking out the screen now and then"`
+	IndependentScreens  bool                 `json:"independentScreens" desc:"Give each browser its own slideshow rather than showing every screen the same slide" default:"false"`
+	CrossfadeSeconds    float64              `json:"crossfadeSeconds" desc:"Seconds the slideshow page takes to fade from one slide to the next, 0 to cut straight to it" default:"0"`
+	DeviceClasses       []DeviceClass        `json:"deviceClasses" desc:"Kinds of browser the slideshow page is tuned for, recognised by User-Agent, replacing the built in tv and phone classes"`
 }
 
 func init() {
@@ -47,16 +142,33 @@ func init() {
 		Compress:   false,             // Do not compress log files
 	})
 
-	// parse the embedded index.html string to create a new template "file"
+	// parse the embedded page with each of its themes, so a broken theme stops the app rather than a page load
 	var tmplErr error
-	IndexTemplate, tmplErr = template.New("index").Parse(staticIndexFile)
+	for _, theme := range themeNames() {
+		if _, tmplErr = pageTemplate(theme, messagesFor(defaultLanguage)); tmplErr != nil {
+			log.Fatalf("Error parsing the %s theme: %v", theme, tmplErr)
+		}
+	}
+	IndexTemplate, tmplErr = pageTemplate(defaultTheme, messagesFor(defaultLanguage))
 	if tmplErr != nil {
 		log.Fatalf("Error parsing template: %v", tmplErr)
 	}
 }
 
-// loadConfig reads the exclusion configuration from a JSON file
+// loadConfig reads the exclusion configuration from a JSON file, with the active profile applied
 func loadConfig(configPath string) (*Config, error) {
+	profile := currentProfile()
+	config, err := readConfig(configPath, profile)
+	if err != nil && profile != "" {
+		// fall back to the main config rather than stopping the slideshow
+		log.Printf("Error loading profile, using the main config: %v", err)
+		return readConfig(configPath, "")
+	}
+	return config, err
+}
+
+// readConfig loads the configuration file with the options of the named profile, if any, applied on top
+func readConfig(configPath, profile string) (*Config, error) {
 	file, err := os.Open(configPath)
 	if err != nil {
 		return nil, err
@@ -69,31 +181,87 @@ func loadConfig(configPath string) (*Config, error) {
 		return nil, err
 	}
 
+	if profile != "" {
+		if err := applyProfile(&config, profile); err != nil {
+			return nil, err
+		}
+	}
 	return &config, nil
 }
 
+// readConfigFile returns the raw contents of the configuration file
+func readConfigFile() ([]byte, error) {
+	return os.ReadFile(configPath)
+}
+
 // ListFiles recursively traverses a directory and its subdirectories,
-// returning a slice of absolute file paths for all files.
-func ListFiles(root string) ([]string, error) {
+// returning a sorted slice of absolute file paths for all files.
+// Up to workers directories are read concurrently, which greatly speeds up
+// scans of spinning disks and network shares.
+func ListFiles(root string, workers int) ([]string, error) {
 	var files []string
+	err := WalkFiles(root, workers, func(path string) {
+		files = append(files, path)
+	})
 
-	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+	sort.Strings(files)
+	return files, err
+}
+
+// WalkFiles recursively traverses a directory and its subdirectories, calling visit with the
+// absolute path of every file without holding the whole list in memory.  visit is never called
+// concurrently.  Up to workers directories are read at once and the first error is returned.
+func WalkFiles(root string, workers int, visit func(path string)) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	var (
+		firstErr error
+		mu       sync.Mutex // To ensure thread-safe access to `visit` and `firstErr`
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, workers) // bounds the number of directories being read at once
+	)
+
+	var walk func(dir string)
+	walk = func(dir string) {
+		defer wg.Done()
+
+		sem <- struct{}{}
+		entries, err := os.ReadDir(dir)
+		<-sem
+
+		mu.Lock()
+		defer mu.Unlock()
 		if err != nil {
-			return err
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
 		}
 
-		// If it's not a directory, add the file path to the slice
-		if !d.IsDir() {
-			absPath, err := filepath.Abs(path)
-			if err != nil {
-				return err
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			// Walk subdirectories in their own goroutine, otherwise pass the file path on
+			if entry.IsDir() {
+				wg.Add(1)
+				go walk(path)
+			} else {
+				visit(path)
 			}
-			files = append(files, absPath)
 		}
-		return nil
-	})
+	}
 
-	return files, err
+	wg.Add(1)
+	walk(absRoot)
+	wg.Wait()
+
+	return firstErr
 }
 
 // SelectRandomElement selects a random element from a slice of strings.
@@ -102,11 +270,8 @@ func SelectRandomElement(elements []string) (string, error) {
 		return "", fmt.Errorf("the list is empty")
 	}
 
-	// Create a new random source and generator
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	// Generate a random index
-	randomIndex := r.Intn(len(elements))
+	// Generate a random index from the shared selection source
+	randomIndex := randomIntn(len(elements))
 
 	// Return the random element
 	return elements[randomIndex], nil
@@ -118,7 +283,6 @@ func pageHandler(w http.ResponseWriter, r *http.Request) {
 	*/
 
 	// load config file to get the timeout value
-	configPath := filepath.Join(".", "config.json")
 	config, err := loadConfig(configPath)
 	if err != nil {
 		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
@@ -127,29 +291,247 @@ func pageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse the embedded template content once during initialization
-	tmplParsed, err := template.New("index").Parse(staticIndexFile)
+	messages := messagesFor(config.Language)
+	tmplParsed, err := pageTemplateFor(config, messages)
 	if err != nil {
 		http.Error(w, "Error parsing template: "+err.Error(), http.StatusInternalServerError)
 		log.Printf("Error parsing template: %v", err)
 		return
 	}
 
-	// Safely access the randomImage variable
-	image := func() string {
-		imageMutex.Lock()
-		defer imageMutex.Unlock()
-		// Strip the base directory and return a relative path
-		// Assuming randomImage is the absolute path, so remove the provided path loaded from the configuratoin file
-		return "/images" + randomImage[len(config.ImageDirectory):]
-	}()
+	// Blank the page while a presence sensor sees nobody or during quiet hours, it wakes on the next reload
+	if displayBlanked() {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		blank, _ := staticFiles.ReadFile("static/blank.html")
+		w.Write(blank)
+		return
+	}
+
+	// Show the loading page until the initial scan of the image directory has completed
+	if !libraryLoaded.Load() {
+		renderLoadingPage(w, messages)
+		return
+	}
+
+	// A named album has a slideshow of its own, served at /album/<name>
+	var rotation *albumRotation
+	albumName, isAlbum := strings.CutPrefix(r.URL.Path, "/album/")
+	if isAlbum {
+		if rotation = findAlbumRotation(albumName); rotation == nil {
+			http.Error(w, messages.text("error.noAlbum", albumName), http.StatusNotFound)
+			return
+		}
+		config = rotation.config(config)
+	} else {
+		albumName = ""
+	}
+
+	// A display asking for its own interval or album in the query string gets a slideshow of its own
+	var overrides pageOverrides
+	if rotation == nil {
+		if overrides, err = parsePageOverrides(r, config, messages); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	independent := rotation == nil && (config.IndependentScreens || overrides.set())
+	config = overrides.apply(config)
+
+	// Safely access the current slide, or this browser's own slide when each screen has its own slideshow
+	current := getCurrentSlide()
+	if rotation != nil {
+		if current = rotation.currentSlide(); current.Image == "" {
+			http.Error(w, messages.text("error.emptyAlbum", albumName), http.StatusNotFound)
+			return
+		}
+	} else if independent {
+		current = sessionSlide(w, r, config, overrides.key())
+	}
+
+	// Old TV browsers that can't run the page's scripts get a plain page showing a downsized JPEG
+	device := classifyDevice(r, config)
+	noteDevice(r, device)
+	if r.URL.Query().Get("legacy") == "1" || device != nil && device.Legacy {
+		renderLegacyPage(w, r, config, current, device, messages)
+		return
+	}
+
+	// Fit photos to the screen as the display options, the album or the fit applied to this screen ask
+	albumFitMode := ""
+	if rotation != nil {
+		albumFitMode = rotation.cfg.FitMode
+	}
+	fitMode, maxCrop := pageFit(config, albumFitMode, r.URL.Query().Get("screen"))
+
+	// Strip the base directory and return a relative path
+	// Assuming the image is the absolute path, so remove the provided path loaded from the configuratoin file
+	image := pageImageURL(current.Image, config, fitMode, maxCrop)
 
 	// Render the template with image data and timeout value
 	data := struct {
 		ImageURL       string
+		ImagePath      string // relative to imageDirectory, so the page stars or hides the photo it shows
+		Independent    bool   // each screen has its own slideshow, rather than following the server's rotation
+		Album          string // the named album the page shows, empty for the main slideshow
+		UpcomingURL    string // photo of the next slide, loaded in the background so it shows straight away
+		BlurURL        string // blurred copy of the photo filling the screen behind it
+		UpcomingBlur   string
+		CrossfadeMs    int64
+		KenBurns       *kenBurnsEffect
+		Display        *displayStyle
+		OLED           *oledPageEffect
+		Clock          *clockOverlay
+		Weather        *weatherOverlay
+		Music          *musicPlayer
 		DisplaySeconds int
+		Banner         string
+		Countdown      *countdownSlide
+		CountdownMs    int64 // countdown target as unix milliseconds for the page script
+		Intro          *folderIntro
+		Note           *albumNote
+		NoteAudioURL   string
+		FitMode        string
+		MaxCrop        float64
+		Faces          []faceBox // faces in the photo, which smart-crop keeps on screen
+		Accessible     bool
+		AltText        string
+		Caption        string // from a sidecar or the photo's XMP description
+		ExifInfo       string // when and where the photo was taken and the camera
+		ShowPath       bool   // show ImagePath on screen when the page loads, the P key toggles it
+		MiniMap        *miniMap
+		Animated       bool // an animated GIF or PNG, sent as it is and shown without the Ken Burns effect
+		Video          bool // an MP4 or QuickTime clip, played muted in a video element until it ends
+		Paused         bool
+		Favorite       bool
+		Device         string // name of the device class the browser is in, empty when it isn't in any
+		ReduceMotion   bool
+		TouchControls  bool
 	}{
 		ImageURL:       image,
-		DisplaySeconds: config.DisplaySeconds, // number of seconds to display an image pulled from the config file
+		ImagePath:      relativeImagePath(current.Image, config.ImageDirectory),
+		Independent:    independent,
+		Album:          albumName,
+		DisplaySeconds: pageDisplaySeconds(config), // number of seconds to display an image pulled from the config file
+		Countdown:      current.Countdown,
+		Intro:          current.Intro,
+		Note:           current.Note,
+		FitMode:        fitMode,
+		MaxCrop:        maxCrop,
+		AltText:        slideDescription(current, config.ImageDirectory, messages),
+		Accessible:     r.URL.Query().Get("accessibility") == "1",
+		ShowPath:       config.ShowPath,
+		Paused:         slideshowPaused.Load(),
+		Favorite:       current.Image != "" && isFavorite(current.Image, config.ImageDirectory),
+		CrossfadeMs:    int64(config.CrossfadeSeconds * 1000),
+	}
+	data.Video = current.Image != "" && isVideo(current.Image)
+	if config.BlurredFill && current.Image != "" && !data.Video {
+		data.BlurURL = blurredImageURL(current.Image, config.ImageDirectory)
+	}
+	if rotation != nil {
+		// the album's own interval, an event takeover and speed change only affect the main slideshow
+		data.DisplaySeconds = config.DisplaySeconds
+	}
+	data.Animated = current.Image != "" && isAnimated(current.Image)
+	if data.Animated || data.Video {
+		shown := slideInterval(time.Duration(data.DisplaySeconds)*time.Second, current.Image, config)
+		data.DisplaySeconds = max(int((shown+time.Second-1)/time.Second), 1)
+	}
+	// clips aren't loaded ahead, the browser starts playing them as they download
+	if upcoming := getUpcomingSlide(); !data.Independent && rotation == nil && upcoming.Image != "" && upcoming.Image != current.Image && !isVideo(upcoming.Image) && stillShowable(upcoming.Image, config) {
+		data.UpcomingURL = pageImageURL(upcoming.Image, config, fitMode, maxCrop)
+		if fitMode == "smart-crop" {
+			pageFaces(upcoming.Image, config)
+		}
+		if config.BlurredFill {
+			data.UpcomingBlur = blurredImageURL(upcoming.Image, config.ImageDirectory)
+		}
+		if exifOverlay := exifOverlayFor(config); exifOverlay != nil {
+			prefetchPlaceName(exifOverlay, upcoming.Image)
+		}
+		if config.AICaptions != nil {
+			prefetchCaption(upcoming.Image)
+		}
+	}
+	if current.Countdown != nil {
+		data.CountdownMs = current.Countdown.Target.UnixMilli()
+	}
+	if current.Countdown == nil && current.Intro == nil && (current.Note == nil || current.Note.Text == "") {
+		// an album note takes the place of the caption and details at the bottom of the screen
+		data.Caption = photoCaption(current.Image)
+		if exifOverlay := exifOverlayFor(config); exifOverlay != nil {
+			data.ExifInfo = exifDetails(exifOverlay, current.Image, messages)
+		}
+	}
+	if config.MiniMap != nil && current.Countdown == nil && current.Intro == nil {
+		data.MiniMap = miniMapFor(config.MiniMap, current.Image)
+	}
+	// notes saved before their audio was checked are checked again, as the path is written into the page
+	if current.Note != nil && current.Note.Audio != "" && checkNoteAudio(current.Note.Audio, config.ImageDirectory) == nil {
+		data.NoteAudioURL = imageURL(filepath.Join(config.ImageDirectory, current.Note.Audio), config.ImageDirectory)
+	}
+
+	// ?path=1 or ?path=0 shows or hides the photo's path on this screen whatever the config says
+	if path := r.URL.Query().Get("path"); path != "" {
+		data.ShowPath = path == "1"
+	}
+
+	if config.Display != nil {
+		data.Display = displayStyleFor(config.Display)
+	}
+	if fitMode == "smart-crop" {
+		data.Faces = pageFaces(current.Image, config)
+	}
+
+	// Screens identify themselves with ?screen=<name> to use the fit preset applied from the admin page, see pageFit
+	if preset, ok := getScreenPreset(r.URL.Query().Get("screen")); ok {
+		data.Accessible = data.Accessible || preset.Accessibility
+	}
+
+	// Tune the page for the kind of browser showing it, e.g. no animations on slow TV browsers
+	if device != nil {
+		data.Device = device.Name
+		data.ReduceMotion = device.ReduceMotion
+		data.TouchControls = device.TouchControls
+	}
+
+	// Pan and zoom across the photo for as long as it is shown, including the fade to the next slide, unless the
+	// screen or browser wants no motion or the photo moves by itself
+	if config.KenBurns != nil && !data.ReduceMotion && !data.Accessible && !data.Animated && !data.Video {
+		data.KenBurns = kenBurnsFor(config.KenBurns, int64(data.DisplaySeconds)*1000+data.CrossfadeMs)
+	}
+
+	// Show the time over the photo
+	if config.Clock != nil {
+		data.Clock = clockOverlayFor(config.Clock)
+	}
+
+	// Show the current conditions, as last fetched by the server
+	if config.Weather != nil {
+		data.Weather = weatherOverlayFor(config.Weather, messages)
+	}
+
+	// Play music alongside the slideshow
+	if config.Music != nil {
+		data.Music = musicPlayerFor(config.Music)
+	}
+
+	// Move the page a little for each slide and black it out now and then, protecting OLED panels from burn-in
+	if config.OLEDProtection != nil {
+		data.OLED = oledProtectionFor(config.OLEDProtection).pageEffect()
+	}
+
+	// An active event takeover adds its banner
+	if event := currentEvent(); event != nil && rotation == nil {
+		data.Banner = event.Banner
+	}
+
+	// Have the browser start on the next slide's photo as soon as the page arrives rather than once its script runs, at
+	// a lower priority than the photo the page shows.  Custom templates get it too, whether or not they load it.
+	for _, upcomingURL := range []string{data.UpcomingURL, data.UpcomingBlur} {
+		if upcomingURL != "" {
+			w.Header().Add("Link", preloadLink(upcomingURL))
+		}
 	}
 	if err := tmplParsed.Execute(w, data); err != nil {
 		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
@@ -157,6 +539,96 @@ func pageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// pageDisplaySeconds returns how long the page should show the current slide for, taking a speed change from
+// the admin page and an event takeover's interval into account
+func pageDisplaySeconds(config *Config) int {
+	if event := currentEvent(); event != nil {
+		return event.IntervalSeconds
+	}
+	if speed := currentSpeed(); speed != nil {
+		// A speed change ramps the interval up or down
+		interval := effectiveInterval(time.Duration(config.DisplaySeconds)*time.Second, time.Now())
+		return max(int(interval.Round(time.Second)/time.Second), 1)
+	}
+	return config.DisplaySeconds
+}
+
+// imageURL converts the absolute path of an image into the URL it is served from
+func imageURL(file, imageDirectory string) string {
+	return "/images" + filepath.ToSlash(strings.TrimPrefix(file, imageDirectory))
+}
+
+// pageImageURL returns the URL the slideshow page shows a photo from: scaled down to the config's maximum size,
+// which also turns it upright and crops it to the screen for fitMode, or turned upright when correctOrientation is set,
+// or the photo itself.  Animations and clips are always sent as they are, as a scaled copy would be a still.
+func pageImageURL(file string, config *Config, fitMode string, maxCrop float64) string {
+	switch {
+	case file == "" || isVideo(file) || isAnimated(file):
+		return imageURL(file, config.ImageDirectory)
+	case config.MaxImageWidth > 0 || config.MaxImageHeight > 0:
+		return resizedImageURL(file, config.ImageDirectory, config.MaxImageWidth, config.MaxImageHeight, pageCopyFit(file, config, fitMode, maxCrop))
+	case config.CorrectOrientation:
+		return uprightImageURL(file, config.ImageDirectory)
+	}
+	return imageURL(file, config.ImageDirectory)
+}
+
+// preloadLink returns the value of a Link header asking the browser to load the photo at rawURL at a low priority,
+// with the path escaped so a file name can't break out of it
+func preloadLink(rawURL string) string {
+	photoPath, query, _ := strings.Cut(rawURL, "?")
+	escaped := &url.URL{Path: photoPath, RawQuery: query}
+	return "<" + escaped.String() + ">; rel=preload; as=image; fetchpriority=low"
+}
+
+// pageCopyFit returns the fit mode the scaled down copy of a photo sent to the page is made with.  With both
+// maxImageWidth and maxImageHeight set, the size of the screen, a photo the page crops is cropped on the server
+// instead, unless more than maxCrop of it would be cut off and the page shows it whole.
+func pageCopyFit(file string, config *Config, fitMode string, maxCrop float64) string {
+	if fitMode == "contain" || config.MaxImageWidth <= 0 || config.MaxImageHeight <= 0 {
+		return "contain"
+	}
+	if maxCrop < 1 {
+		width, height, err := probeDimensions(file)
+		screenRatio := float64(config.MaxImageWidth) / float64(config.MaxImageHeight)
+		if err != nil || width <= 0 || height <= 0 || cropFraction(float64(width)/float64(height), screenRatio) > maxCrop {
+			return "contain"
+		}
+	}
+	return fitMode
+}
+
+// relativeImagePath returns the path of an image relative to the image directory
+func relativeImagePath(file, imageDirectory string) string {
+	if rel, err := filepath.Rel(imageDirectory, file); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return file
+}
+
+// adminHandler serves the admin page used to control the slideshow
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	messages := messagesFor(config.Language)
+	tmplParsed, err := template.New("admin.html").Funcs(messages.funcs()).ParseFS(staticFiles, "static/admin.html")
+	if err != nil {
+		http.Error(w, "Error parsing template: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error parsing template: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmplParsed.Execute(w, nil); err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
 // loadAllImages loads all images from a directory while applying exclusions
 func loadAllImages() []string {
 	/*
@@ -165,55 +637,221 @@ func loadAllImages() []string {
 	*/
 
 	// Load configuration
-	configPath := filepath.Join(".", "config.json")
 	config, err := loadConfig(configPath)
 	if err != nil {
 		log.Printf("Failed to load configuration: %v", err)
 		return []string{} // Return an empty slice if config loading fails
 	}
 
+	// Read the list of files from the index when another machine does the scanning
+	if config.LibraryIndex != "" {
+		files, err := readLibraryIndex(config)
+		if err != nil {
+			log.Printf("Error reading the library index: %v", err)
+			return []string{}
+		}
+		return files
+	}
+
 	// Get the list of files
-	files, err := ListFiles(config.ImageDirectory)
+	workers := config.ScanWorkers
+	if workers <= 0 {
+		workers = defaultScanWorkers
+	}
+	var files []string
+	if config.LowMemory {
+		// Only keep a random sample of the library in memory
+		files, err = sampleFiles(config, workers, lowMemorySampleSize)
+	} else {
+		files, err = ListFiles(config.ImageDirectory, workers)
+	}
+	recordScanResult(config.ImageDirectory, err)
 	if err != nil {
 		log.Println("Error:", err)
 		return []string{} // Return an empty slice instead of nil
 	}
 
+	return collapseCopies(filterImages(files, config), config)
+}
+
+// filterImages applies the configured exclusions to a list of files
+func filterImages(files []string, config *Config) []string {
 	// Filtered list of files
 	var filteredFiles []string
+	notImages := 0
 
 	// Loop through all the files and exclude those that match the conditions
 	for _, file := range files {
-		// Check if the file has an excluded extension
-		ext := strings.ToLower(filepath.Ext(file))
-		if contains(config.ExcludedExtensions, ext) {
+		// Check the extension, hidden files and excluded directories
+		if excludedFile(file, config) {
 			continue
 		}
 
-		// Check if the file starts with a dot (hidden files)
-		if strings.HasPrefix(filepath.Base(file), ".") {
+		// Tell images from other files by their content rather than their extension
+		if config.SniffContent && !sniffedShowable(file) {
+			notImages++
 			continue
 		}
 
-		// Check if the file is in an excluded directory
-		excluded := false
-		for _, dirSubstring := range config.ExcludedDirectories {
-			if strings.Contains(filepath.Dir(file), dirSubstring) {
-				excluded = true
-				break
+		// Check if the file size is outside the configured limits
+		if config.MinFileSizeKB > 0 || config.MaxFileSizeMB > 0 {
+			info, err := os.Stat(file)
+			if err != nil {
+				log.Println("Error:", err)
+				continue
+			}
+			if config.MinFileSizeKB > 0 && info.Size() < config.MinFileSizeKB*1024 {
+				continue
+			}
+			if config.MaxFileSizeMB > 0 && info.Size() > config.MaxFileSizeMB*1024*1024 {
+				continue
 			}
-		}
-		if excluded {
-			continue
 		}
 
 		// Add the file to the filtered list if it passes all conditions
 		filteredFiles = append(filteredFiles, file)
 	}
+	if notImages > 0 {
+		log.Printf("Skipped %d files whose content isn't an image that can be shown", notImages)
+	}
 
+	filteredFiles = filterByMetadata(filteredFiles, config)
+	if config.ContentFilter != nil {
+		filteredFiles = filterByContent(filteredFiles, config)
+	}
 	return filteredFiles
 }
 
+// excludedFile reports whether a file is excluded by its extension, being hidden, being in an excluded directory,
+// being marked private or blacklisted or having failed to be read
+func excludedFile(file string, config *Config) bool {
+	// Check if the file has an excluded extension
+	ext := strings.ToLower(filepath.Ext(file))
+	if contains(config.ExcludedExtensions, ext) {
+		return true
+	}
+
+	// XMP and caption sidecars only hold metadata such as ratings and captions for the image beside them
+	if ext == ".xmp" || ext == ".txt" {
+		return true
+	}
+
+	// HEIC photos can only be shown once they are converted
+	if isHEIC(file) && heifConverter() == "" {
+		return true
+	}
+
+	// Check if the file starts with a dot (hidden files)
+	if strings.HasPrefix(filepath.Base(file), ".") {
+		return true
+	}
+
+	// Check if the file is in an excluded directory
+	for _, dirSubstring := range config.ExcludedDirectories {
+		if strings.Contains(filepath.Dir(file), dirSubstring) {
+			return true
+		}
+	}
+
+	// Check if the file has been marked private or to never be shown again, or couldn't be read
+	return isPrivate(file, config.ImageDirectory) || isBlacklisted(file, config.ImageDirectory) || isProblemFile(file)
+}
+
+// filterByMetadata drops files that aren't images the page can show, and images outside the configured minimum
+// resolution and capture date range
+func filterByMetadata(files []string, config *Config) []string {
+	/*
+		Dimensions and EXIF dates are read from the file headers only and cached on disk, so the probe only runs
+		for new or modified files.  Files whose header cannot be read, such as truncated downloads or files with the
+		wrong extension, are dropped so the page never shows a broken image.
+	*/
+	dateFrom, dateTo, err := parseDateRange(config.DateFrom, config.DateTo)
+	if err != nil {
+		log.Printf("Ignoring date range: %v", err)
+	}
+
+	cache := imageMetadataCache()
+
+	var kept, broken []string
+	skippedResolution, skippedDate := 0, 0
+	for _, file := range files {
+		meta, err := cache.get(file)
+		if err != nil {
+			log.Println("Error:", err)
+			continue
+		}
+		if meta.Width == 0 && headerChecked(file) {
+			broken = append(broken, file)
+			continue
+		}
+		if meta.Width > 0 && (meta.Width < config.MinWidth || meta.Height < config.MinHeight) {
+			skippedResolution++
+			continue
+		}
+		if !inDateRange(meta.captureTime(), dateFrom, dateTo) {
+			skippedDate++
+			continue
+		}
+		kept = append(kept, file)
+	}
+
+	if err := cache.save(); err != nil {
+		log.Printf("Error saving metadata cache: %v", err)
+	}
+	if skippedResolution > 0 || skippedDate > 0 {
+		log.Printf("Skipped %d images below the minimum resolution of %dx%d and %d images outside the date range", skippedResolution, config.MinWidth, config.MinHeight, skippedDate)
+	}
+	if len(broken) > 0 {
+		examples := broken[:min(len(broken), maxBrokenExamples)]
+		log.Printf("Skipped %d files that couldn't be read as images, e.g. %s", len(broken), strings.Join(examples, ", "))
+	}
+
+	return kept
+}
+
+// maxBrokenExamples is how many of the files that couldn't be read are named in the log
+const maxBrokenExamples = 5
+
+// headerChecked reports whether the app reads the header of a file, so a file whose dimensions are unknown is
+// broken.  Formats it can't read, such as WebP, are left for the browser to show.
+func headerChecked(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return isHEIC(file) || isRAW(file) || isVideo(file)
+}
+
+// parseDateRange parses optional YYYY-MM-DD bounds, the end date is inclusive so it is moved to the start of the following day
+func parseDateRange(from, to string) (time.Time, time.Time, error) {
+	var dateFrom, dateTo time.Time
+	var err error
+
+	if from != "" {
+		if dateFrom, err = time.ParseInLocation("2006-01-02", from, time.Local); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid dateFrom %q, expected YYYY-MM-DD", from)
+		}
+	}
+	if to != "" {
+		if dateTo, err = time.ParseInLocation("2006-01-02", to, time.Local); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid dateTo %q, expected YYYY-MM-DD", to)
+		}
+		dateTo = dateTo.AddDate(0, 0, 1)
+	}
+	return dateFrom, dateTo, nil
+}
+
+// inDateRange reports whether t is inside the range, a zero bound is treated as open
+func inDateRange(t, from, to time.Time) bool {
+	if !from.IsZero() && t.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !t.Before(to) {
+		return false
+	}
+	return true
+}
+
 // Helper function to check if a slice contains a string (used to filter file extensions and prefixes from the filteredFiles list)
 func contains(slice []string, str string) bool {
 	for _, item := range slice {
@@ -224,6 +862,22 @@ func contains(slice []string, str string) bool {
 	return false
 }
 
+// currentLibrary returns the list of images available for display
+func currentLibrary() []string {
+	libraryMutex.RLock()
+	defer libraryMutex.RUnlock()
+	return libraryFiles
+}
+
+// setLibrary replaces the list of images available for display
+func setLibrary(files []string) {
+	libraryMutex.Lock()
+	old := libraryFiles
+	libraryFiles = files
+	libraryMutex.Unlock()
+	libraryEvents.publish(libraryEvent{Old: old, New: files})
+}
+
 func selectRandomImage(fileList []string) string {
 
 	// Select a random element
@@ -236,43 +890,300 @@ func selectRandomImage(fileList []string) string {
 
 }
 
-func updateImagePeriodically(fileList []string, interval time.Duration) {
+func updateImagePeriodically(config *Config) {
+	interval := time.Duration(config.DisplaySeconds) * time.Second
+	selector := newImageSelector(config)
+	restoreSelectionState(selector)
+	setActiveSelector(selector)
+	story, isStory := selector.(storySelector)
+	showIntros := config.FolderIntros && isStory
+
+	var pendingImage string // image held back while its folder intro is displayed
+	var lastGroup string
+	var reload bool // set when the config profile was switched
+	var step int    // the step requested through the API, stepPrev, stepNext or stepSkip
+	var trail slideTrail
+	var upcoming *slide                  // the next new slide, chosen a slide ahead so browsers can load its photo early
+	var upcomingEvent *slideshowEvent    // the event takeover upcoming was chosen during
+	var upcomingSchedule *ScheduleConfig // the schedule upcoming was chosen during
+	var schedule *ScheduleConfig         // the schedule running when the last slide was chosen
+
+	// chooseSlide decides the next new slide: the photo an intro was shown for, a countdown, or a newly selected
+	// image, introducing its folder when the story moves into a new one
+	chooseSlide := func(config *Config, fileList []string) slide {
+		var next slide
+		if pendingImage != "" {
+			// Show the image whose folder was introduced by the previous slide
+			next.Image, pendingImage = pendingImage, ""
+		} else if cd, target := pickCountdown(config.Countdowns, time.Now()); cd != nil {
+			// Show a countdown slide over its background instead of a plain photo
+			next.Countdown = &countdownSlide{Title: cd.Title, Target: target}
+			next.Image = countdownBackground(cd, config.ImageDirectory, fileList)
+		} else {
+			next.Image = nextImage(selector, fileList)
+			if isStory && next.Image != "" {
+				if group := story.groupOf(next.Image, config.ImageDirectory); group != lastGroup {
+					// Entering a new folder or cluster, its note is attached to its first slide
+					lastGroup = group
+					next.Note = getAlbumNote(group)
+					if showIntros {
+						// Introduce it over its cover before showing its photos
+						pendingImage = next.Image
+						next.Intro, next.Image = story.introFor(pendingImage, config.ImageDirectory, fileList)
+					}
+				}
+			}
+		}
+		return next
+	}
+
+	// discardUpcoming drops the slide chosen ahead, e.g. when the profile is switched
+	discardUpcoming := func() {
+		if upcoming != nil && upcoming.Intro != nil {
+			// the introduced photo is held back for the slide after, introduce its folder again instead
+			pendingImage, lastGroup = "", ""
+		}
+		upcoming = nil
+	}
+
 	for {
-		// Select a new random image
-		newImage := selectRandomImage(fileList)
-		log.Printf("Displaying image: %s", newImage)
+		// Swap the album, interval and overlays over to the newly active profile in one go
+		if reload {
+			reload = false
+			if updated, err := loadConfig(configPath); err != nil {
+				log.Printf("Error loading config: %v", err)
+			} else {
+				discardUpcoming()
+				if updated.SelectionMode != config.SelectionMode || updated.RecencyDecayHours != config.RecencyDecayHours {
+					selector = newImageSelector(updated)
+					setActiveSelector(selector)
+					story, isStory = selector.(storySelector)
+					pendingImage, lastGroup = "", ""
+				}
+				config = updated
+				interval = time.Duration(config.DisplaySeconds) * time.Second
+				showIntros = config.FolderIntros && isStory
+			}
+		}
+
+		// Keep the current image while rotation is paused (e.g. the display is powered off)
+		if rotationPaused.Load() {
+			time.Sleep(interval)
+			continue
+		}
 
-		// Update the shared randomImage variable safely
+		// Select a new random image, drawing from the event album while an event takeover is active
+		fileList, event := rotationFiles(config)
+		if running := activeSchedule(config.Schedules, config.Location, time.Now()); !sameSchedule(running, schedule) {
+			log.Printf("Schedule changed from %s to %s", scheduleName(schedule), scheduleName(running))
+			schedule = running
+		}
+		var next slide
+		var revisited bool
+		if step == stepPrev {
+			next, revisited = trail.previous()
+		} else if step == stepSkip {
+			// Choose a new image in place of the unwanted one on screen
+			log.Printf("Skipping image: %s", getCurrentSlide().Image)
+			trail.discard()
+		} else {
+			// Move forward through any slides that were stepped back over before choosing new ones
+			next, revisited = trail.replay()
+		}
+		if revisited && isPrivate(next.Image, config.ImageDirectory) {
+			// Marked private since it was shown, choose a new image in its place
+			trail.discard()
+			next, revisited = slide{}, false
+		}
+		if revisited {
+			log.Printf("Displaying image again: %s", next.Image)
+		} else {
+			// Show the slide chosen ahead, unless the event or schedule changed or its image went since it was chosen
+			if upcoming != nil && (upcomingEvent != event || !sameSchedule(upcomingSchedule, schedule) || !stillShowable(upcoming.Image, config)) {
+				discardUpcoming()
+			}
+			if upcoming != nil {
+				next = *upcoming
+				upcoming = nil
+			} else {
+				next = chooseSlide(config, fileList)
+			}
+			if next.Countdown != nil {
+				log.Printf("Displaying countdown %q over image: %s", next.Countdown.Title, next.Image)
+			} else if next.Intro != nil {
+				log.Printf("Displaying intro for %q over image: %s", next.Intro.Name, next.Image)
+			} else {
+				log.Printf("Displaying image: %s", next.Image)
+			}
+		}
+
+		if !revisited {
+			trail.push(next)
+			if next.Image != "" {
+				recordShown(next.Image)
+				saveSelectionState(false)
+			}
+		}
+
+		// Decide the next slide now, so browsers and followers told about this one can load its photo early
+		if replay, ok := trail.peekForward(); ok {
+			setUpcomingSlide(replay)
+		} else {
+			if upcoming == nil {
+				chosen := chooseSlide(config, fileList)
+				upcoming, upcomingEvent, upcomingSchedule = &chosen, event, schedule
+			}
+			setUpcomingSlide(*upcoming)
+		}
+		pregenerateUpcoming(getUpcomingSlide().Image, config)
+
+		// Update the shared current slide safely
 		imageMutex.Lock()
-		randomImage = newImage
+		currentSlide = next
+		currentShownAt = time.Now()
 		imageMutex.Unlock()
+		rotationEvents.publish(rotationEvent{Slide: next, Revisited: revisited, Config: config})
+		libraryLoaded.Store(true)
 
-		// Sleep for the specified interval
-		time.Sleep(interval)
+		// Sleep for the display interval, or until the profile is switched or the slideshow is navigated
+		reload, step = waitForNextSlide(time.Now(), func() time.Duration {
+			if event != nil {
+				return time.Duration(event.IntervalSeconds) * time.Second
+			}
+			return slideInterval(effectiveInterval(interval, time.Now()), next.Image, config)
+		})
 	}
 }
 
 func main() {
+	profile := flag.String("profile", "", "name of the config profile to start with, overriding the last active profile")
+	seed := flag.Int64("seed", 0, "seed for choosing images so the selection is reproducible, overriding randomSeed")
+	takeover := flag.Bool("takeover", false, "stop another instance running in this directory and take over from it")
+	flag.Parse()
+
+	// randompic index scans the library and writes it out for other servers, then exits
+	if flag.Arg(0) == "index" {
+		runIndexCommand(flag.Args()[1:])
+		return
+	}
+
+	// make sure no other instance is using the same caches and log files, telling whoever started this one why not
+	if err := acquireInstanceLock(*takeover); err != nil {
+		fmt.Fprintf(os.Stderr, "randompic: %v\n", err)
+		log.Fatalf("Not starting: %v", err)
+	}
+
+	// connect the history, digest and other integrations to the event bus before anything is published
+	subscribeIntegrations()
 
-	start := time.Now() // time the loading of images
-	// get the list of files (only runs once)
-	fileList := loadAllImages()
-	elapsed := time.Since(start)
-	log.Printf("Loading fileList from disk took: %s", elapsed)
+	// upgrade the config file from older versions of the app before loading it
+	checkConfigFile(configPath)
+
+	// restore the config profile that was active when the app last ran
+	loadActiveProfile()
+	if *profile != "" {
+		if err := switchProfile(*profile); err != nil {
+			log.Printf("Error switching profile: %v", err)
+		}
+	}
 
 	// load config file
-	configPath := filepath.Join(".", "config.json")
 	config, _ := loadConfig(configPath)
 
-	// Start the image updater in a goroutine
-	go updateImagePeriodically(fileList, time.Duration(config.DisplaySeconds)*time.Second)
+	// Make the selection reproducible when a seed is given, for testing and debugging
+	if *seed != 0 {
+		config.RandomSeed = *seed
+	}
+	if config.RandomSeed != 0 {
+		seedSelection(config.RandomSeed)
+		log.Printf("Choosing images with random seed %d", config.RandomSeed)
+	}
+
+	// Keep a list of the recently displayed images
+	initHistory(config)
+
+	// Show the startup image until the first slide is ready, chosen before loading starts recording new slides
+	startupImageFile = resolveStartupImage(config)
+
+	// Load the images in the background so the page can be served while a slow directory is scanned
+	go loadLibrary(config)
+
+	// Power the display on and off at the configured times
+	if config.PowerSchedule != nil {
+		go runPowerSchedule(config.PowerSchedule, config.Location)
+	}
+
+	// Blank the display when the room is empty
+	if config.Presence != nil {
+		go runPresence(config.Presence, config.PowerSchedule != nil)
+	}
+
+	// Blank the display overnight
+	if config.QuietHours != nil {
+		go runQuietHours(config.QuietHours, config.Location, config.PowerSchedule != nil)
+	}
+
+	// Fetch the weather shown over the photo
+	if config.Weather != nil {
+		go runWeather(config.Weather, config.Location)
+	}
+
+	// Draw the slideshow straight to the screen when there is no browser
+	if config.Framebuffer != nil {
+		go runFramebuffer(config.Framebuffer, config.OLEDProtection)
+	}
+
+	// Email a summary of the slideshow every week
+	if config.EmailDigest != nil {
+		go runEmailDigest(config.EmailDigest)
+	}
+
+	// Clean up cached details of deleted photos in the background
+	go collectCachesPeriodically()
+
+	// Scale photos down before the page asks for them
+	if config.Pregenerate != nil {
+		runPregeneration(config.Pregenerate)
+	}
 
 	// Serve images from the directory
-	http.Handle("/images/", http.StripPrefix("/images/", http.FileServer(http.Dir(config.ImageDirectory))))
+	http.Handle("/images/", imageFileHandler(config.ImageDirectory))
+	http.HandleFunc("/startup-image", startupImageHandler)
+	http.HandleFunc("/minimap/tile", miniMapTileHandler)
+	http.HandleFunc("/blur", blurredImageHandler)
+	http.HandleFunc("/upright", uprightImageHandler)
+	http.HandleFunc("/music/", musicFileHandler)
+	http.Handle("/static/", staticHandler())
+
+	// Control and status API, versioned and at the legacy paths
+	http.Handle(legacyAPIPrefix, newAPIHandler())
 
-	// Serve the page
+	// Admin page
+	http.HandleFunc("/admin", adminHandler)
+	http.HandleFunc("/legacy/image", legacyImageHandler)
+	http.HandleFunc("/healthz", healthzHandler)
+
+	// Save the slideshow's position when the app is stopped so a restart carries on where it left off
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		log.Printf("Received %s, saving the slideshow position", sig)
+		saveSelectionState(true)
+		saveLastShown()
+		saveDigest()
+		os.Exit(0)
+	}()
+
+	// Serve the page, and the slideshows of the named albums
 	http.HandleFunc("/", pageHandler)
+	http.HandleFunc("/album/", pageHandler)
+	// Compress what isn't compressed already for slow links
+	handler := http.Handler(http.DefaultServeMux)
+	if config.Compression != nil {
+		handler = compressHandler(handler, config.Compression)
+	}
 	log.Println("Starting server on :80")
-	log.Fatal(http.ListenAndServe(":80", nil))
+	log.Fatal(http.ListenAndServe(":80", handler))
 
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestMain runs the tests in a temporary directory, so the stores the app keeps beside its config are written there
+// rather than into the source tree, with the log discarded
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	dir, err := os.MkdirTemp("", "randompic-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// writeFiles creates empty files, given relative to dir, and returns their absolute paths
+func writeFiles(t *testing.T, dir string, names ...string) []string {
+	t.Helper()
+	var files []string
+	for _, name := range names {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+func TestWalkFiles(t *testing.T) {
+	dir := t.TempDir()
+	want := writeFiles(t, dir, "a.jpg", "2019/b.jpg", "2019/japan/c.jpg", "2019/japan/fuji/d.jpg", "2020/e.png", ".hidden/f.jpg")
+	if err := os.Mkdir(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(want)
+
+	tests := []struct {
+		name    string
+		root    string
+		workers int
+		want    []string
+		wantErr bool
+	}{
+		{name: "one worker", root: dir, workers: 1, want: want},
+		{name: "several workers", root: dir, workers: 4, want: want},
+		{name: "no workers counts as one", root: dir, workers: 0, want: want},
+		{name: "empty directory", root: filepath.Join(dir, "empty"), workers: 2},
+		{name: "missing directory", root: filepath.Join(dir, "missing"), workers: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := WalkFiles(tt.root, tt.workers, func(path string) {
+				got = append(got, path)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WalkFiles() error = %v, want error %v", err, tt.wantErr)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("WalkFiles() visited %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
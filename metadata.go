@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"  // register gif so image.DecodeConfig can read gif headers
+	_ "image/jpeg" // register jpeg so image.DecodeConfig can read jpeg headers
+	_ "image/png"  // register png so image.DecodeConfig can read png headers
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// metadataCachePath is where probed image metadata is persisted between runs
+const metadataCachePath = "./randompic-cache.json"
+
+// metadataVersion is bumped whenever new fields are probed so older cache entries are refreshed
+const metadataVersion = 9
+
+// imageMetadata holds the probed details of a single image file.
+// Size and ModTime are recorded so a changed file is probed again.
+// Width and Height are those of the photo shown upright, zero when the image header could not be read, and
+// Orientation is its EXIF orientation, zero when there is none.  DateTaken is zero when there is no EXIF date.
+// AnimationMs is how long one loop of an animated GIF or PNG takes, zero for a still image, and VideoMs is how long
+// a video clip plays for, zero for a photo or when the clip's header could not be read.
+// Rating is the star rating in the embedded XMP packet, zero when unrated and -1 when rejected.
+// Description is the caption in the embedded XMP packet, and HasSidecar records whether a caption or XMP sidecar was
+// alongside the photo when it was probed.  Camera and Exposure describe the camera and its settings, empty when
+// there is no EXIF data.  GeneratedCaption is written by the caption generator rather than probed, and is kept when
+// the photo is probed again by a newer version of the app.
+type imageMetadata struct {
+	Version     int     `json:"version"`
+	Size        int64   `json:"size"`
+	ModTime     int64   `json:"modTime"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	DateTaken   int64   `json:"dateTaken,omitempty"`
+	HasLocation bool    `json:"hasLocation,omitempty"`
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+	Rating      int     `json:"rating,omitempty"`
+	Description string  `json:"description,omitempty"`
+	HasSidecar  bool    `json:"hasSidecar,omitempty"`
+	Camera      string  `json:"camera,omitempty"`
+	Exposure    string  `json:"exposure,omitempty"`
+	Orientation int     `json:"orientation,omitempty"`
+	AnimationMs int64   `json:"animationMs,omitempty"`
+	VideoMs     int64   `json:"videoMs,omitempty"`
+
+	GeneratedCaption string `json:"generatedCaption,omitempty"`
+}
+
+// captureTime returns when the photo was taken, falling back to the file modification time when there is no EXIF date
+func (m imageMetadata) captureTime() time.Time {
+	if m.DateTaken != 0 {
+		return time.Unix(m.DateTaken, 0)
+	}
+	return time.Unix(m.ModTime, 0)
+}
+
+// metadataStore persists probed image metadata, either in the JSON cache or the SQLite index
+type metadataStore interface {
+	// get returns the metadata for a file, probing the file only when the stored entry is missing or stale
+	get(file string) (imageMetadata, error)
+	// save writes any pending changes
+	save() error
+	// setGeneratedCaption records the caption generated for a file already in the store
+	setGeneratedCaption(file, caption string) error
+	// prune removes the entries of files that are no longer in the library
+	prune(files []string) error
+	// collectGarbage removes the entries of files that no longer exist and entries probed by an older
+	// version of the app, returning the number of entries removed
+	collectGarbage(exists func(file string) bool) (int, error)
+}
+
+var (
+	sharedMetadata     metadataStore
+	sharedMetadataOnce sync.Once
+)
+
+// imageMetadataCache returns the process wide metadata store, opening it on first use.
+// The SQLite index is used when indexDatabase is configured, otherwise the JSON cache.
+func imageMetadataCache() metadataStore {
+	sharedMetadataOnce.Do(func() {
+		if config, err := loadConfig(configPath); err == nil && config.IndexDatabase != "" {
+			index, err := openImageIndex(config.IndexDatabase)
+			if err == nil {
+				sharedMetadata = index
+				return
+			}
+			log.Printf("Error opening the image index, using the metadata cache instead: %v", err)
+		}
+		sharedMetadata = loadMetadataCache(metadataCachePath)
+	})
+	return sharedMetadata
+}
+
+// metadataCache stores probed image metadata keyed by absolute file path
+type metadataCache struct {
+	entries *jsonStore[imageMetadata]
+}
+
+// loadMetadataCache returns the metadata cache persisted at path, read on first use and empty if the file is missing
+// or unreadable
+func loadMetadataCache(path string) *metadataCache {
+	return &metadataCache{entries: &jsonStore[imageMetadata]{path: path, what: "metadata cache", compact: true}}
+}
+
+// get returns the metadata for a file, probing the file headers only when the cached entry is missing or stale.
+// An error is only returned when the file itself cannot be read.
+func (c *metadataCache) get(file string) (imageMetadata, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return imageMetadata{}, err
+	}
+
+	cached, ok := c.entries.get(file)
+	if ok && cached.Version == metadataVersion && cached.Size == info.Size() && cached.ModTime == info.ModTime().Unix() {
+		return cached, nil
+	}
+
+	meta := probeMetadata(file, info)
+	if ok && cached.Size == info.Size() && cached.ModTime == info.ModTime().Unix() {
+		meta.GeneratedCaption = cached.GeneratedCaption
+	}
+
+	c.entries.set(file, meta)
+
+	return meta, nil
+}
+
+// save writes the cache back to disk if anything has changed since it was loaded
+func (c *metadataCache) save() error {
+	return c.entries.save()
+}
+
+func (c *metadataCache) setGeneratedCaption(file, caption string) error {
+	meta, ok := c.entries.get(file)
+	if !ok {
+		return fmt.Errorf("%s is not in the metadata cache", file)
+	}
+	meta.GeneratedCaption = caption
+	c.entries.set(file, meta)
+	return nil
+}
+
+// prune removes the entries of files that are no longer in the library
+func (c *metadataCache) prune(files []string) error {
+	keep := make(map[string]bool, len(files))
+	for _, file := range files {
+		keep[file] = true
+	}
+
+	var gone []string
+	c.entries.read(func(entries map[string]imageMetadata) {
+		for file := range entries {
+			if !keep[file] {
+				gone = append(gone, file)
+			}
+		}
+	})
+	c.entries.delete(gone...)
+	return nil
+}
+
+func (c *metadataCache) collectGarbage(exists func(file string) bool) (int, error) {
+	// entries probed by an older version of the app are read again
+	var outdated []string
+	c.entries.read(func(entries map[string]imageMetadata) {
+		for file, meta := range entries {
+			if meta.Version != metadataVersion {
+				outdated = append(outdated, file)
+			}
+		}
+	})
+	c.entries.delete(outdated...)
+
+	removed := len(outdated) + c.entries.collect(exists)
+	return removed, c.save()
+}
+
+// probeMetadata reads the dimensions and EXIF details of a file
+func probeMetadata(file string, info os.FileInfo) imageMetadata {
+	meta := imageMetadata{Version: metadataVersion, Size: info.Size(), ModTime: info.ModTime().Unix()}
+
+	var err error
+	if isVideo(file) {
+		var length time.Duration
+		if meta.Width, meta.Height, length, err = probeVideo(file); err != nil {
+			log.Printf("Unable to read the header of %s: %v", file, err)
+			recordProblemFile(file, "probe", err)
+		}
+		meta.VideoMs = length.Milliseconds()
+		return meta
+	}
+	if meta.Width, meta.Height, err = probeDimensions(file); err != nil {
+		log.Printf("Unable to read dimensions of %s: %v", file, err)
+		// formats the app can't read are left to the browser, and HEIC photos wait for a converter to be installed
+		if headerChecked(file) && (!isHEIC(file) || heifConverter() != "") {
+			recordProblemFile(file, "probe", err)
+		}
+	}
+	if exif, err := readExif(file); err == nil {
+		if !exif.DateTaken.IsZero() {
+			meta.DateTaken = exif.DateTaken.Unix()
+		}
+		meta.HasLocation = exif.HasLocation
+		meta.Latitude = exif.Latitude
+		meta.Longitude = exif.Longitude
+		meta.Camera = exif.Camera
+		meta.Exposure = exif.Exposure
+		meta.Orientation = exif.Orientation
+	}
+	meta.AnimationMs = animationLoop(file).Milliseconds()
+	xmp := readEmbeddedXMP(file)
+	meta.Rating = parseXMPRating(xmp)
+	meta.Description = parseXMPDescription(xmp)
+	meta.HasSidecar = hasSidecar(file)
+	return meta
+}
+
+// probeDimensions reads only the image header to determine the width and height of the photo shown upright, swapped
+// when its EXIF orientation says it is stored on its side
+func probeDimensions(file string) (int, int, error) {
+	decodable, err := decodableFile(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	f, err := os.Open(decodable)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	if exifOrientation(file) >= 5 {
+		return cfg.Height, cfg.Width, nil
+	}
+	return cfg.Width, cfg.Height, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// configMigration upgrades the raw configuration from one version to the next
+type configMigration struct {
+	description string
+	apply       func(config map[string]any)
+}
+
+// configMigrations holds the migration from version i to version i+1 at index i.
+// New migrations are appended whenever an option is renamed or changes shape.
+var configMigrations = []configMigration{}
+
+// currentConfigVersion is the configVersion written by this version of the app
+func currentConfigVersion() int {
+	return len(configMigrations)
+}
+
+// renameValue replaces an option's value when it matches from
+func renameValue(config map[string]any, key, from, to string) {
+	if value, ok := config[key].(string); ok && value == from {
+		config[key] = to
+	}
+}
+
+// migrateConfigFile upgrades the configuration file to the current version, keeping a backup of the original.  The
+// file is left as it is when none of the migrations change anything in it, and numbers are written back as they were.
+func migrateConfigFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var config map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return err
+	}
+
+	version := 0
+	if v, ok := config["configVersion"].(json.Number); ok {
+		if n, err := v.Int64(); err == nil {
+			version = int(n)
+		}
+	}
+	current := currentConfigVersion()
+	if version > current {
+		log.Printf("Config version %d is newer than this app supports (%d), some options may be ignored", version, current)
+		return nil
+	}
+	if version == current {
+		return nil
+	}
+
+	before, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	for v := version; v < current; v++ {
+		configMigrations[v].apply(config)
+	}
+	if after, err := json.Marshal(config); err != nil || bytes.Equal(before, after) {
+		return err
+	}
+	for v := version; v < current; v++ {
+		log.Printf("Migrated config from version %d to %d: %s", v, v+1, configMigrations[v].description)
+	}
+	config["configVersion"] = current
+
+	migrated, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.v%d.%s.bak", path, version, time.Now().Format("20060102150405"))
+	if err := os.WriteFile(backup, data, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("unable to back up config before migrating: %v", err)
+	}
+	if err := writeFileAtomic(path, migrated); err != nil {
+		return err
+	}
+	log.Printf("Config migrated to version %d, the original was saved to %s", current, backup)
+	return nil
+}
+
+// checkConfigFile migrates the configuration file and logs any options that would otherwise be silently ignored
+func checkConfigFile(path string) {
+	if err := migrateConfigFile(path); err != nil {
+		log.Printf("Error migrating config: %v", err)
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, problem := range validateConfig(data) {
+		log.Printf("Config problem: %s", problem)
+	}
+}
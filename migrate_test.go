@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withTestMigration registers a migration to version 1 renaming selectionMode story to sequential for the test
+func withTestMigration(t *testing.T) {
+	saved := configMigrations
+	t.Cleanup(func() { configMigrations = saved })
+	configMigrations = []configMigration{{
+		description: "rename selectionMode story to sequential",
+		apply: func(config map[string]any) {
+			renameValue(config, "selectionMode", "story", "sequential")
+		},
+	}}
+}
+
+func TestMigrateConfigFile(t *testing.T) {
+	withTestMigration(t)
+
+	tests := []struct {
+		name       string
+		config     string
+		migrated   bool     // the file is rewritten and the original backed up
+		wantValues []string // found in the file afterwards
+	}{
+		{
+			name:       "renames old values",
+			config:     `{"selectionMode": "story", "powerSchedule": {"provider": "kasa"}}`,
+			migrated:   true,
+			wantValues: []string{`"selectionMode": "sequential"`, `"provider": "kasa"`, `"configVersion": 1`},
+		},
+		{
+			name:       "keeps numbers as written",
+			config:     `{"selectionMode": "story", "randomSeed": 9007199254740993, "crossfadeSeconds": 1.50}`,
+			migrated:   true,
+			wantValues: []string{`"randomSeed": 9007199254740993`, `"crossfadeSeconds": 1.50`},
+		},
+		{
+			name:       "leaves a config with nothing to migrate alone",
+			config:     `{"selectionMode": "shuffle", "randomSeed": 1e3}`,
+			wantValues: []string{`"randomSeed": 1e3`},
+		},
+		{
+			name:   "leaves a current config alone",
+			config: `{"configVersion": 1, "selectionMode": "story"}`,
+		},
+		{
+			name:   "leaves a newer config alone",
+			config: `{"configVersion": 2, "selectionMode": "story"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := migrateConfigFile(path); err != nil {
+				t.Fatalf("migrateConfigFile() error = %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.migrated && string(data) != tt.config {
+				t.Errorf("config was rewritten to %s", data)
+			}
+			for _, value := range tt.wantValues {
+				if !strings.Contains(string(data), value) {
+					t.Errorf("config %s doesn't hold %s", data, value)
+				}
+			}
+			if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("config mode = %v, %v, want -rw-------", info.Mode(), err)
+			}
+
+			backups, _ := filepath.Glob(path + ".v0.*.bak")
+			if !tt.migrated {
+				if len(backups) > 0 {
+					t.Errorf("config was backed up to %v", backups)
+				}
+				return
+			}
+			if len(backups) != 1 {
+				t.Fatalf("config backups = %v, want one", backups)
+			}
+			backup, err := os.ReadFile(backups[0])
+			if err != nil || string(backup) != tt.config {
+				t.Errorf("backup = %s, %v, want the original %s", backup, err, tt.config)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if err := migrateConfigFile(filepath.Join(t.TempDir(), "config.json")); err == nil {
+			t.Error("migrateConfigFile() of a missing file succeeded")
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMiniMapTileURL     = "https://tile.openstreetmap.org/{z}/{x}/{y}.png"
+	defaultMiniMapAttribution = "© OpenStreetMap contributors"
+	defaultMiniMapZoom        = 11
+	defaultMiniMapSize        = 200
+	miniMapTileSize           = 256 // pixels, the size of a tile from every common tile server
+	maxMiniMapTileBytes       = 1 << 20
+)
+
+// miniMapTilesDir is where map tiles are cached, so each tile is only fetched from the tile server once
+const miniMapTilesDir = "./randompic-tiles"
+
+// MiniMapConfig shows a small map of where each geotagged photo was taken, built from tiles the server fetches and
+// caches
+type MiniMapConfig struct {
+	TileURL     string `json:"tileURL" desc:"Map tile server, with {z}, {x} and {y} replaced by the zoom and tile numbers" default:"https://tile.openstreetmap.org/{z}/{x}/{y}.png"`
+	Attribution string `json:"attribution" desc:"Credit for the map shown under it, as most tile servers require" default:"© OpenStreetMap contributors"`
+	Zoom        int    `json:"zoom" desc:"Map zoom level from 1 (the world) to 18 (streets)" default:"11"`
+	Size        int    `json:"size" desc:"Width and height of the map in pixels, from 100 to 512" default:"200"`
+	Position    string `json:"position" desc:"Corner of the screen the map is shown in" default:"bottom-right" enum:"top-left,top-right,bottom-left,bottom-right"`
+}
+
+var miniMapHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// miniMap is the map the page shows, the tiles around where a photo was taken placed so the spot is in the middle
+type miniMap struct {
+	Position    string // class placing the map on screen
+	Size        int
+	Attribution string
+	Tiles       []miniMapTile
+}
+
+// miniMapTile is one map tile and where it goes in the map, in pixels from the top left corner
+type miniMapTile struct {
+	URL       string
+	Left, Top int
+}
+
+// miniMapFor returns the map of where a photo was taken, nil when the photo has no location
+func miniMapFor(cfg *MiniMapConfig, file string) *miniMap {
+	if file == "" {
+		return nil
+	}
+	meta, err := imageMetadataCache().get(file)
+	if err != nil || !meta.HasLocation {
+		return nil
+	}
+
+	m := &miniMap{Position: cfg.Position, Size: cfg.Size, Attribution: cfg.Attribution}
+	switch m.Position {
+	case "top-left", "top-right", "bottom-left", "bottom-right":
+	default:
+		m.Position = "bottom-right"
+	}
+	if m.Size == 0 {
+		m.Size = defaultMiniMapSize
+	}
+	m.Size = min(max(m.Size, 100), 512)
+	if m.Attribution == "" {
+		m.Attribution = defaultMiniMapAttribution
+	}
+	zoom := cfg.Zoom
+	if zoom == 0 {
+		zoom = defaultMiniMapZoom
+	}
+	zoom = min(max(zoom, 1), 18)
+
+	// the position in tiles on the Web Mercator projection every common tile server uses
+	tiles := float64(int(1) << zoom)
+	latitude := min(max(meta.Latitude, -85.0511), 85.0511) * math.Pi / 180
+	x := (meta.Longitude + 180) / 360 * tiles
+	y := (1 - math.Log(math.Tan(latitude)+1/math.Cos(latitude))/math.Pi) / 2 * tiles
+
+	// the tiles around the one the photo is in, as many as the map needs to be covered
+	tileX, tileY := int(math.Floor(x)), int(math.Floor(y))
+	left := m.Size/2 - int((x-float64(tileX))*miniMapTileSize)
+	top := m.Size/2 - int((y-float64(tileY))*miniMapTileSize)
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			tileLeft, tileTop := left+dx*miniMapTileSize, top+dy*miniMapTileSize
+			if tileLeft >= m.Size || tileTop >= m.Size || tileLeft+miniMapTileSize <= 0 || tileTop+miniMapTileSize <= 0 {
+				continue
+			}
+			row := tileY + dy
+			if row < 0 || row >= int(tiles) {
+				continue // beyond the poles
+			}
+			column := ((tileX+dx)%int(tiles) + int(tiles)) % int(tiles) // the map wraps around at 180°
+			m.Tiles = append(m.Tiles, miniMapTile{
+				URL:  fmt.Sprintf("/minimap/tile?z=%d&x=%d&y=%d", zoom, column, row),
+				Left: tileLeft,
+				Top:  tileTop,
+			})
+		}
+	}
+	return m
+}
+
+// miniMapTileHandler serves a map tile from the cache, fetching it from the tile server the first time it is asked
+// for, so screens never call the tile server themselves
+func miniMapTileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+	if config.MiniMap == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var zxy [3]int
+	for i, name := range []string{"z", "x", "y"} {
+		if zxy[i], err = strconv.Atoi(r.URL.Query().Get(name)); err != nil {
+			http.Error(w, "The z, x and y parameters must be numbers", http.StatusBadRequest)
+			return
+		}
+	}
+	z, x, y := zxy[0], zxy[1], zxy[2]
+	if z < 1 || z > 18 || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		http.Error(w, "No such tile", http.StatusBadRequest)
+		return
+	}
+
+	file := filepath.Join(miniMapTilesDir, strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y))
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if data, err = fetchMiniMapTile(config.MiniMap, z, x, y); err != nil {
+			http.Error(w, "Error fetching map tile: "+err.Error(), http.StatusBadGateway)
+			log.Printf("Error fetching map tile %d/%d/%d: %v", z, x, y, err)
+			return
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err == nil {
+			err = os.WriteFile(file, data, 0644)
+		}
+		if err != nil {
+			log.Printf("Error caching map tile %d/%d/%d: %v", z, x, y, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.Write(data)
+}
+
+// fetchMiniMapTile downloads a tile from the configured tile server
+func fetchMiniMapTile(cfg *MiniMapConfig, z, x, y int) ([]byte, error) {
+	tileURL := cfg.TileURL
+	if tileURL == "" {
+		tileURL = defaultMiniMapTileURL
+	}
+	tileURL = strings.NewReplacer("{z}", strconv.Itoa(z), "{x}", strconv.Itoa(x), "{y}", strconv.Itoa(y)).Replace(tileURL)
+
+	req, err := http.NewRequest(http.MethodGet, tileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// OpenStreetMap's tile usage policy asks each app to identify itself
+	req.Header.Set("User-Agent", "randompic photo frame")
+	resp, err := miniMapHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tile server returned status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxMiniMapTileBytes))
+}
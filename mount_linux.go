@@ -0,0 +1,17 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// stRdonly is the ST_RDONLY mount flag reported by statfs
+const stRdonly = 0x1
+
+// mountReadOnly reports whether the file system holding path is mounted read-only
+func mountReadOnly(path string) (bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false, err
+	}
+	return st.Flags&stRdonly != 0, nil
+}
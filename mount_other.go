@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// mountReadOnly is only supported on linux, other systems always report a writable mount
+func mountReadOnly(path string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const defaultMusicVolume = 0.3
+
+// musicExtensions are the audio files played from the music directory, those current browsers can play
+var musicExtensions = []string{".aac", ".flac", ".m4a", ".mp3", ".oga", ".ogg", ".opus", ".wav", ".weba"}
+
+// MusicConfig plays ambient music alongside the slideshow
+type MusicConfig struct {
+	Directory string  `json:"directory" desc:"Absolute path of a directory of music, its files are played one after another and the list starts again once they have all played"`
+	StreamURL string  `json:"streamURL" desc:"An internet radio station or other audio stream played in place of a directory" format:"url"`
+	Volume    float64 `json:"volume" desc:"Volume of the music, from 0 to 1" default:"0.3"`
+	Shuffle   bool    `json:"shuffle" desc:"Play the directory's files in a different random order each time through rather than by name" default:"false"`
+}
+
+// musicPlayer is the music the page plays
+type musicPlayer struct {
+	Volume    float64
+	StreamURL string // played in place of the playlist when set
+}
+
+// musicPlayerFor returns the music the page plays for cfg, with an out of range volume replaced by the default
+func musicPlayerFor(cfg *MusicConfig) *musicPlayer {
+	return &musicPlayer{Volume: musicVolume(cfg), StreamURL: cfg.StreamURL}
+}
+
+// musicVolume returns the configured volume, the default when it is unset or out of range
+func musicVolume(cfg *MusicConfig) float64 {
+	if cfg.Volume <= 0 || cfg.Volume > 1 {
+		return defaultMusicVolume
+	}
+	return cfg.Volume
+}
+
+// isMusicFile reports whether a file in the music directory is one of the tracks played
+func isMusicFile(name string) bool {
+	return !strings.HasPrefix(path.Base(name), ".") && slices.Contains(musicExtensions, strings.ToLower(path.Ext(name)))
+}
+
+// musicTracks returns the paths of the tracks in the music directory relative to it, sorted by name
+func musicTracks(dir string) ([]string, error) {
+	var tracks []string
+	err := fs.WalkDir(os.DirFS(dir), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && name != "." && strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
+		if !d.IsDir() && isMusicFile(name) {
+			tracks = append(tracks, name)
+		}
+		return nil
+	})
+	return tracks, err
+}
+
+// musicPlaylist is what the page plays: the stream, or the URLs of the tracks in the music directory
+type musicPlaylist struct {
+	Volume float64  `json:"volume"`
+	Stream string   `json:"stream,omitempty"`
+	Tracks []string `json:"tracks"`
+}
+
+// musicHandler returns the music the page plays, the tracks in a new random order each time when shuffled
+func musicHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+	if config.Music == nil {
+		http.Error(w, "No music is configured", http.StatusNotFound)
+		return
+	}
+
+	playlist := musicPlaylist{Volume: musicVolume(config.Music), Stream: config.Music.StreamURL, Tracks: []string{}}
+	if playlist.Stream == "" && config.Music.Directory != "" {
+		tracks, err := musicTracks(config.Music.Directory)
+		if err != nil {
+			http.Error(w, "Error reading the music directory: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error reading the music directory: %v", err)
+			return
+		}
+		if config.Music.Shuffle {
+			rand.Shuffle(len(tracks), func(i, j int) { tracks[i], tracks[j] = tracks[j], tracks[i] })
+		}
+		for _, track := range tracks {
+			playlist.Tracks = append(playlist.Tracks, (&url.URL{Path: "/music/" + track}).EscapedPath())
+		}
+	}
+	writeJSON(w, playlist)
+}
+
+// musicFileHandler serves the tracks in the music directory at /music/, and nothing else in it
+func musicFileHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+	if config.Music == nil || config.Music.Directory == "" || !isMusicFile(r.URL.Path) {
+		http.NotFound(w, r)
+		return
+	}
+	http.StripPrefix("/music/", http.FileServer(http.Dir(config.Music.Directory))).ServeHTTP(w, r)
+}
+
+// musicProblems reports music settings that can't work
+func musicProblems(config *Config) []string {
+	if config.Music == nil {
+		return nil
+	}
+	var problems []string
+	switch {
+	case config.Music.Directory == "" && config.Music.StreamURL == "":
+		problems = append(problems, "music needs a directory or a streamURL to play")
+	case config.Music.Directory != "" && config.Music.StreamURL != "":
+		problems = append(problems, "music plays either a directory or a streamURL, the stream is played")
+	case config.Music.Directory != "":
+		if !filepath.IsAbs(config.Music.Directory) {
+			problems = append(problems, fmt.Sprintf("music directory %s must be an absolute path", config.Music.Directory))
+		} else if info, err := os.Stat(config.Music.Directory); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("music directory %s is not a directory", config.Music.Directory))
+		}
+	}
+	if config.Music.Volume < 0 || config.Music.Volume > 1 {
+		problems = append(problems, "music volume must be between 0 and 1")
+	}
+	return problems
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"randompic/client"
+)
+
+const (
+	maxBackSlides       = 50 // how many slides /api/prev can step back through
+	maxPendingNavigates = 10
+)
+
+// Steps the image updater can be asked to take through the API
+const (
+	stepPrev = -1
+	stepNext = 1
+	stepSkip = 2 // replace the slide on screen with a newly chosen one, without keeping it to go back to
+)
+
+var (
+	navigateRequests = make(chan int, maxPendingNavigates) // steps requested through the API, one of stepPrev, stepNext or stepSkip
+	slideshowPaused  atomic.Bool                           // when set through the API the current slide stays on screen until resumed
+	pauseChanged     = make(chan struct{}, 1)              // wakes the image updater when the slideshow is paused, resumed, held or released
+)
+
+// notifyPauseChanged wakes the image updater without blocking when it is already due to wake
+func notifyPauseChanged() {
+	select {
+	case pauseChanged <- struct{}{}:
+	default:
+	}
+}
+
+// slideTrail remembers the slides the updater has shown so it can step back through them.
+// Slides stepped back over are kept so moving forward again replays them before new images are chosen.
+type slideTrail struct {
+	back    []slide // the last slide is the one on screen
+	forward []slide // the last slide is the next one to replay
+}
+
+// push records a newly chosen slide as the one on screen
+func (t *slideTrail) push(s slide) {
+	t.back = append(t.back, s)
+	if len(t.back) > maxBackSlides {
+		t.back = t.back[len(t.back)-maxBackSlides:]
+	}
+	t.forward = nil
+}
+
+// previous steps back to the slide before the one on screen, staying on the first slide of the trail
+// when there is nothing further back.  It returns false when no slide has been shown yet.
+func (t *slideTrail) previous() (slide, bool) {
+	if len(t.back) == 0 {
+		return slide{}, false
+	}
+	if len(t.back) == 1 {
+		return t.back[0], true
+	}
+	t.forward = append(t.forward, t.back[len(t.back)-1])
+	t.back = t.back[:len(t.back)-1]
+	return t.back[len(t.back)-1], true
+}
+
+// discard forgets the slide on screen, so stepping back doesn't return to it
+func (t *slideTrail) discard() {
+	if len(t.back) > 0 {
+		t.back = t.back[:len(t.back)-1]
+	}
+}
+
+// replay steps forward to a slide that was stepped back over, returning false when there are none left
+func (t *slideTrail) replay() (slide, bool) {
+	if len(t.forward) == 0 {
+		return slide{}, false
+	}
+	s := t.forward[len(t.forward)-1]
+	t.forward = t.forward[:len(t.forward)-1]
+	t.back = append(t.back, s)
+	return s, true
+}
+
+// peekForward returns the slide replay would step forward to, without moving
+func (t *slideTrail) peekForward() (slide, bool) {
+	if len(t.forward) == 0 {
+		return slide{}, false
+	}
+	return t.forward[len(t.forward)-1], true
+}
+
+// nextHandler moves on to the next slide straight away
+func nextHandler(w http.ResponseWriter, r *http.Request) {
+	navigate(w, r, stepNext, "next")
+}
+
+// prevHandler goes back to the previous slide straight away
+func prevHandler(w http.ResponseWriter, r *http.Request) {
+	navigate(w, r, stepPrev, "previous")
+}
+
+// skipHandler replaces an unwanted slide, e.g. a blurry photo, with a newly chosen one straight away
+func skipHandler(w http.ResponseWriter, r *http.Request) {
+	navigate(w, r, stepSkip, "skip")
+}
+
+// navigate passes a step to the image updater, which restarts the display interval for the slide it moves to
+func navigate(w http.ResponseWriter, r *http.Request, step int, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if frame := leader.Load(); frame != nil {
+		// A follower shows whatever the leader shows, so move the leader and every other frame with it
+		var err error
+		switch step {
+		case stepPrev:
+			err = frame.Prev(r.Context())
+		case stepSkip:
+			err = frame.Skip(r.Context())
+		default:
+			err = frame.Next(r.Context())
+		}
+		if passedToLeader(w, name, err) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	if rotationPaused.Load() {
+		http.Error(w, "The display is powered off", http.StatusConflict)
+		return
+	}
+	if currentHold() != nil {
+		http.Error(w, "The current image is held, release it first", http.StatusConflict)
+		return
+	}
+
+	select {
+	case navigateRequests <- step:
+		log.Printf("Navigation requested through the API: %s", name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Too many navigation requests waiting", http.StatusTooManyRequests)
+	}
+}
+
+// pauseHandler keeps the current slide on screen until the slideshow is resumed
+func pauseHandler(w http.ResponseWriter, r *http.Request) {
+	setPaused(w, r, true)
+}
+
+// resumeHandler carries on the slideshow, showing the current slide for a full interval before moving on
+func resumeHandler(w http.ResponseWriter, r *http.Request) {
+	setPaused(w, r, false)
+}
+
+// setPaused pauses or resumes the slideshow and wakes the image updater so it applies straight away
+func setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if frame := leader.Load(); frame != nil {
+		// The pause state is copied back from the leader once it has changed
+		var err error
+		name := "resume"
+		if paused {
+			err, name = frame.Pause(r.Context()), "pause"
+		} else {
+			err = frame.Resume(r.Context())
+		}
+		if passedToLeader(w, name, err) {
+			writeJSON(w, client.PauseState{Paused: paused})
+		}
+		return
+	}
+
+	if slideshowPaused.Swap(paused) != paused {
+		notifyPauseChanged()
+		broadcastRefresh() // the pause button on every screen changes
+		if paused {
+			log.Println("Slideshow paused through the API")
+		} else {
+			log.Println("Slideshow resumed through the API")
+		}
+	}
+	writeJSON(w, client.PauseState{Paused: paused})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSlideTrail(t *testing.T) {
+	// each step is push, previous, replay or discard, and the image previous or replay should step to, empty when
+	// they should report there is nothing to step to
+	type step struct {
+		op    string
+		image string
+	}
+	tests := []struct {
+		name        string
+		steps       []step
+		wantForward string // the image peekForward should return, empty when there is none
+	}{
+		{
+			name:  "nothing shown",
+			steps: []step{{"previous", ""}, {"replay", ""}},
+		},
+		{
+			name:  "stays on the first slide",
+			steps: []step{{"push", "a"}, {"previous", "a"}, {"previous", "a"}, {"replay", ""}},
+		},
+		{
+			name:        "steps back",
+			steps:       []step{{"push", "a"}, {"push", "b"}, {"push", "c"}, {"previous", "b"}, {"previous", "a"}},
+			wantForward: "b",
+		},
+		{
+			name:        "replays the slides stepped back over",
+			steps:       []step{{"push", "a"}, {"push", "b"}, {"push", "c"}, {"previous", "b"}, {"previous", "a"}, {"replay", "b"}},
+			wantForward: "c",
+		},
+		{
+			name:        "replays every slide and then runs out",
+			steps:       []step{{"push", "a"}, {"push", "b"}, {"previous", "a"}, {"replay", "b"}, {"replay", ""}, {"previous", "a"}},
+			wantForward: "b",
+		},
+		{
+			name:        "a new slide forgets the ones stepped back over",
+			steps:       []step{{"push", "a"}, {"push", "b"}, {"previous", "a"}, {"push", "c"}, {"replay", ""}, {"previous", "a"}},
+			wantForward: "c",
+		},
+		{
+			name:        "a discarded slide isn't stepped back to",
+			steps:       []step{{"push", "a"}, {"push", "b"}, {"discard", ""}, {"push", "c"}, {"previous", "a"}},
+			wantForward: "c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var trail slideTrail
+			for i, step := range tt.steps {
+				var got slide
+				var ok bool
+				switch step.op {
+				case "push":
+					trail.push(slide{Image: step.image})
+					continue
+				case "discard":
+					trail.discard()
+					continue
+				case "previous":
+					got, ok = trail.previous()
+				case "replay":
+					got, ok = trail.replay()
+				}
+				if ok != (step.image != "") || got.Image != step.image {
+					t.Fatalf("step %d: %s() = %q, %v, want %q", i, step.op, got.Image, ok, step.image)
+				}
+			}
+			got, ok := trail.peekForward()
+			if ok != (tt.wantForward != "") || got.Image != tt.wantForward {
+				t.Errorf("peekForward() = %q, %v, want %q", got.Image, ok, tt.wantForward)
+			}
+		})
+	}
+
+	t.Run("keeps the last slides", func(t *testing.T) {
+		var trail slideTrail
+		for i := range maxBackSlides + 10 {
+			trail.push(slide{Image: fmt.Sprint(i)})
+		}
+		var s slide
+		for range maxBackSlides - 1 {
+			s, _ = trail.previous()
+		}
+		if s.Image != "10" {
+			t.Errorf("stepped back %d slides to slide %s, want 10", maxBackSlides-1, s.Image)
+		}
+		if s, _ = trail.previous(); s.Image != "10" {
+			t.Errorf("stepped back past the oldest slide kept to slide %s", s.Image)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// albumNotesPath is where the notes attached to albums are persisted
+const albumNotesPath = "./randompic-notes.json"
+
+// albumNote is a text note and/or audio clip presented when story mode enters an album
+type albumNote struct {
+	Text  string `json:"text"`
+	Audio string `json:"audio"` // audio file relative to imageDirectory
+}
+
+// albumNotes are the notes attached to albums, keyed by album name
+var albumNotes = &jsonStore[*albumNote]{path: albumNotesPath, what: "album notes", userData: true}
+
+// getAlbumNote returns the note attached to an album, or nil if there is none
+func getAlbumNote(album string) *albumNote {
+	note, _ := albumNotes.get(album)
+	return note
+}
+
+// saveAlbumNote attaches a note to an album, removing it when note is nil, and writes all notes to disk
+func saveAlbumNote(album string, note *albumNote) error {
+	if note == nil {
+		albumNotes.delete(album)
+	} else {
+		albumNotes.set(album, note)
+	}
+	return albumNotes.save()
+}
+
+// checkNoteAudio returns an error unless the audio of a note is the path of an audio file inside imageDirectory,
+// relative to it, as the note's audio is played by every screen showing the album
+func checkNoteAudio(audio, imageDirectory string) error {
+	if path.IsAbs(audio) || path.Clean(audio) != audio || audio == ".." || strings.HasPrefix(audio, "../") {
+		return fmt.Errorf("the audio must be a path relative to imageDirectory")
+	}
+	if !slices.Contains(musicExtensions, strings.ToLower(path.Ext(audio))) {
+		return fmt.Errorf("the audio must be one of the file types %s", strings.Join(musicExtensions, ", "))
+	}
+	if info, err := os.Stat(filepath.Join(imageDirectory, filepath.FromSlash(audio))); err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("the audio file %s doesn't exist", audio)
+	}
+	return nil
+}
+
+// notesHandler lists all notes (GET without an album), and reads (GET), attaches (POST) or removes (DELETE) the note of an album
+func notesHandler(w http.ResponseWriter, r *http.Request) {
+	album := r.URL.Query().Get("album")
+	if album == "" && r.Method == http.MethodGet {
+		albumNotes.read(func(notes map[string]*albumNote) {
+			writeJSON(w, notes)
+		})
+		return
+	}
+	if album == "" {
+		http.Error(w, "The album parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, getAlbumNote(album))
+
+	case http.MethodPost:
+		var note albumNote
+		if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if note.Text == "" && note.Audio == "" {
+			http.Error(w, "A note needs text or audio", http.StatusBadRequest)
+			return
+		}
+		if note.Audio != "" {
+			config, err := loadConfig(configPath)
+			if err != nil {
+				http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+				log.Printf("Error loading config: %v", err)
+				return
+			}
+			if err := checkNoteAudio(note.Audio, config.ImageDirectory); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := saveAlbumNote(album, &note); err != nil {
+			http.Error(w, "Error saving note: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error saving note: %v", err)
+			return
+		}
+		log.Printf("Note attached to album %q", album)
+		writeJSON(w, note)
+
+	case http.MethodDelete:
+		if err := saveAlbumNote(album, nil); err != nil {
+			http.Error(w, "Error saving note: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error saving note: %v", err)
+			return
+		}
+		log.Printf("Note removed from album %q", album)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
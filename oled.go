@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultOLEDShiftPixels       = 4
+	maxOLEDShiftPixels           = 50
+	defaultOLEDBlackFrameMinutes = 60
+	defaultOLEDBlackFrameSeconds = 2
+)
+
+// OLEDConfig protects OLED panels running all day from burn-in, moving the picture a few pixels for each
+// slide and blacking out the screen briefly now and then
+type OLEDConfig struct {
+	ShiftPixels       int     `json:"shiftPixels" desc:"Most pixels the picture is moved from the centre, in a new direction for each slide" default:"4"`
+	BlackFrameMinutes float64 `json:"blackFrameMinutes" desc:"Minutes between brief all black frames" default:"60"`
+	BlackFrameSeconds float64 `json:"blackFrameSeconds" desc:"Seconds each black frame lasts" default:"2"`
+}
+
+// oledProtection is the burn-in protection applied to the screen, with the defaults in place of missing settings
+type oledProtection struct {
+	ShiftPixels int
+	BlackEvery  time.Duration
+	BlackFor    time.Duration
+}
+
+// oledProtectionFor returns the protection for cfg, with out of range settings replaced by the defaults
+func oledProtectionFor(cfg *OLEDConfig) oledProtection {
+	protection := oledProtection{
+		ShiftPixels: cfg.ShiftPixels,
+		BlackEvery:  time.Duration(cfg.BlackFrameMinutes * float64(time.Minute)),
+		BlackFor:    time.Duration(cfg.BlackFrameSeconds * float64(time.Second)),
+	}
+	if protection.ShiftPixels <= 0 {
+		protection.ShiftPixels = defaultOLEDShiftPixels
+	}
+	protection.ShiftPixels = min(protection.ShiftPixels, maxOLEDShiftPixels)
+	if protection.BlackEvery <= 0 {
+		protection.BlackEvery = defaultOLEDBlackFrameMinutes * time.Minute
+	}
+	if protection.BlackFor <= 0 {
+		protection.BlackFor = defaultOLEDBlackFrameSeconds * time.Second
+	}
+	return protection
+}
+
+// shift returns a random offset of up to ShiftPixels in each direction.  It doesn't use the selection source, so
+// a seeded selection stays reproducible.
+func (p oledProtection) shift() image.Point {
+	return image.Pt(rand.Intn(2*p.ShiftPixels+1)-p.ShiftPixels, rand.Intn(2*p.ShiftPixels+1)-p.ShiftPixels)
+}
+
+// oledPageEffect is the burn-in protection the slideshow page applies
+type oledPageEffect struct {
+	Shift        image.Point // how far the page is moved for this slide
+	BlackEveryMs int64
+	BlackForMs   int64
+}
+
+// pageEffect returns the protection for one slide of the slideshow page
+func (p oledProtection) pageEffect() *oledPageEffect {
+	return &oledPageEffect{Shift: p.shift(), BlackEveryMs: p.BlackEvery.Milliseconds(), BlackForMs: p.BlackFor.Milliseconds()}
+}
+
+// shiftFrame moves a frame by offset, filling the uncovered edge with black
+func shiftFrame(frame *image.RGBA, offset image.Point) *image.RGBA {
+	shifted := image.NewRGBA(frame.Rect)
+	draw.Draw(shifted, shifted.Bounds(), image.Black, image.Point{}, draw.Src)
+	draw.Draw(shifted, frame.Rect.Add(offset), frame, frame.Rect.Min, draw.Src)
+	return shifted
+}
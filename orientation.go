@@ -0,0 +1,96 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// otherOrientationShare is how many photos of the other orientation, one in this many, stay in the rotation when
+// the screen's orientation is only preferred.  Which ones changes each day.
+const otherOrientationShare = 5
+
+var (
+	imageOrientations      map[string]string // orientation of each image, empty when its dimensions are unknown
+	imageOrientationsMutex sync.Mutex        // To ensure thread-safe access to `imageOrientations`
+)
+
+// orientationOf returns whether dimensions are portrait, landscape or square, empty when they are unknown
+func orientationOf(width, height int) string {
+	switch {
+	case width <= 0 || height <= 0:
+		return ""
+	case width > height:
+		return "landscape"
+	case width < height:
+		return "portrait"
+	default:
+		return "square"
+	}
+}
+
+// forgetOrientations drops the orientations worked out so far, so changed photos are looked at again
+func forgetOrientations() {
+	imageOrientationsMutex.Lock()
+	defer imageOrientationsMutex.Unlock()
+	imageOrientations = nil
+}
+
+// orientationFiles returns the images suiting a screen of the given orientation: those of the same orientation,
+// square ones and those whose dimensions are unknown, with a daily sample of the others unless the orientation is
+// required.  The files are returned unchanged when no orientation is set or none of them have it.
+func orientationFiles(files []string, orientation, match string) []string {
+	if orientation != "portrait" && orientation != "landscape" {
+		return files
+	}
+
+	imageOrientationsMutex.Lock()
+	defer imageOrientationsMutex.Unlock()
+	if imageOrientations == nil {
+		imageOrientations = map[string]string{}
+	}
+
+	store := imageMetadataCache()
+	probed := false
+	day := strconv.Itoa(time.Now().YearDay())
+	var kept []string
+	matching := 0
+	for _, file := range files {
+		fileOrientation, ok := imageOrientations[file]
+		if !ok {
+			if meta, err := store.get(file); err == nil {
+				fileOrientation = orientationOf(meta.Width, meta.Height)
+			}
+			imageOrientations[file] = fileOrientation
+			probed = true
+		}
+		switch fileOrientation {
+		case "", "square", orientation:
+			kept = append(kept, file)
+			matching++
+		default:
+			if match != "require" && sampledToday(file, day) {
+				kept = append(kept, file)
+			}
+		}
+	}
+	if probed {
+		if err := store.save(); err != nil {
+			log.Printf("Error saving metadata cache: %v", err)
+		}
+	}
+
+	if matching == 0 {
+		return files
+	}
+	return kept
+}
+
+// sampledToday reports whether an image of the other orientation is one of those kept in the rotation today
+func sampledToday(file, day string) bool {
+	hash := fnv.New32a()
+	hash.Write([]byte(day + file))
+	return hash.Sum32()%otherOrientationShare == 0
+}
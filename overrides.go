@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	minIntervalOverride = 3            // seconds, shorter and the photo hasn't loaded before the next is asked for
+	maxIntervalOverride = 24 * 60 * 60 // seconds
+)
+
+// pageOverrides are settings one display asks for in the query string of the page, e.g. /?interval=10&album=holidays,
+// so it can run at its own speed, from its own album or with photos suiting its orientation without a config file
+// of its own
+type pageOverrides struct {
+	IntervalSeconds int    // zero when not overridden
+	Album           string // empty when not overridden
+	Orientation     string // portrait or landscape, empty when not overridden
+}
+
+// parsePageOverrides reads the overrides of a page request, clamping the interval to a sensible range
+func parsePageOverrides(r *http.Request, config *Config, messages *messageCatalog) (pageOverrides, error) {
+	var overrides pageOverrides
+	query := r.URL.Query()
+
+	if value := query.Get("interval"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return overrides, errors.New(messages.text("error.interval", value))
+		}
+		overrides.IntervalSeconds = min(max(seconds, minIntervalOverride), maxIntervalOverride)
+	}
+
+	if album := query.Get("album"); album != "" {
+		if len(albumFiles(currentLibrary(), config.ImageDirectory, album)) == 0 {
+			return overrides, errors.New(messages.text("error.emptyAlbum", album))
+		}
+		overrides.Album = album
+	}
+
+	if orientation := query.Get("orientation"); orientation != "" {
+		if orientation != "portrait" && orientation != "landscape" {
+			return overrides, errors.New(messages.text("error.orientation", orientation))
+		}
+		overrides.Orientation = orientation
+	}
+	return overrides, nil
+}
+
+// set reports whether the page asks for any override, in which case the display gets its own slideshow
+func (o pageOverrides) set() bool {
+	return o.IntervalSeconds != 0 || o.Album != "" || o.Orientation != ""
+}
+
+// apply returns config with the overrides applied, leaving config itself unchanged
+func (o pageOverrides) apply(config *Config) *Config {
+	if !o.set() {
+		return config
+	}
+	updated := *config
+	if o.IntervalSeconds != 0 {
+		updated.DisplaySeconds = o.IntervalSeconds
+	}
+	if o.Album != "" {
+		updated.Album = o.Album
+		updated.Schedules = nil // the display asked for this album whatever the time
+	}
+	if o.Orientation != "" {
+		updated.Orientation = o.Orientation
+	}
+	return &updated
+}
+
+// key tells apart the slideshows of one browser showing pages with different overrides, e.g. in two tabs
+func (o pageOverrides) key() string {
+	if !o.set() {
+		return ""
+	}
+	return fmt.Sprintf("?interval=%d&album=%s&orientation=%s", o.IntervalSeconds, o.Album, o.Orientation)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// placeNamesPath is where the names of the places photos were taken are kept, so each place is only looked up once
+const placeNamesPath = "./randompic-places.json"
+
+const (
+	placeLookupInterval = time.Second // OpenStreetMap's Nominatim allows at most one request a second
+	placeLookupRetry    = 10 * time.Minute
+	placeLookupQueue    = 64
+)
+
+// placeNames are the place names keyed by placeKey, empty when a position has no name
+var placeNames = &jsonStore[string]{path: placeNamesPath, what: "place names"}
+
+var (
+	placeLookups    = make(chan string, placeLookupQueue)
+	placeLookupOnce sync.Once
+)
+
+var placeHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// placeKey rounds a position to about a kilometre, so photos taken around the same spot share a lookup
+func placeKey(latitude, longitude float64) string {
+	return fmt.Sprintf("%.2f,%.2f", latitude, longitude)
+}
+
+// placeName returns the name of the place at a position and whether it is known yet.  Unknown places are looked up
+// in the background, so the name is there the next time a photo from the place is shown.
+func placeName(latitude, longitude float64) (string, bool) {
+	key := placeKey(latitude, longitude)
+	if name, ok := placeNames.get(key); ok {
+		return name, true
+	}
+
+	placeLookupOnce.Do(func() { go runPlaceLookups() })
+	select {
+	case placeLookups <- key:
+	default:
+		// the queue is full of places waiting their turn, this one is asked for again when next shown
+	}
+	return "", false
+}
+
+// runPlaceLookups names each queued place in turn, keeping to the rate Nominatim allows
+func runPlaceLookups() {
+	for key := range placeLookups {
+		if _, known := placeNames.get(key); known {
+			continue // queued again while it was waiting
+		}
+
+		var latitude, longitude float64
+		fmt.Sscanf(key, "%f,%f", &latitude, &longitude)
+		name, err := lookupPlaceName(latitude, longitude)
+		if err != nil {
+			// wait rather than failing again for each photo while the frame is offline
+			log.Printf("Error looking up the place name of %s, retrying in %s: %v", key, placeLookupRetry, err)
+			time.Sleep(placeLookupRetry)
+			continue
+		}
+		placeNames.set(key, name)
+		if err := placeNames.save(); err != nil {
+			log.Printf("Error saving place names: %v", err)
+		}
+		time.Sleep(placeLookupInterval)
+	}
+}
+
+// lookupPlaceName asks OpenStreetMap's Nominatim for the town and country at a position, empty for a position
+// away from any town such as out at sea
+func lookupPlaceName(latitude, longitude float64) (string, error) {
+	query := url.Values{
+		"format": {"jsonv2"},
+		"lat":    {fmt.Sprint(latitude)},
+		"lon":    {fmt.Sprint(longitude)},
+		"zoom":   {"10"}, // towns and cities rather than streets
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), placeHTTPClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://nominatim.openstreetmap.org/reverse?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	// Nominatim's usage policy asks each app to identify itself
+	req.Header.Set("User-Agent", "randompic photo frame")
+	resp, err := placeHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("place lookup returned status %s", resp.Status)
+	}
+
+	var reply struct {
+		Address map[string]string `json:"address"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, field := range []string{"city", "town", "village", "hamlet", "county", "state"} {
+		if place := reply.Address[field]; place != "" {
+			parts = append(parts, place)
+			break
+		}
+	}
+	if country := reply.Address["country"]; country != "" {
+		parts = append(parts, country)
+	}
+	return strings.Join(parts, ", "), nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxPoolChanges bounds the number of library changes remembered for /api/pool/diff
+const maxPoolChanges = 1000
+
+// poolChange records the images added to and removed from the library at a point in time
+type poolChange struct {
+	at      time.Time
+	added   []string
+	removed []string
+}
+
+var (
+	poolChanges  []poolChange
+	poolComplete time.Time  // changes are known from this time on, earlier requests need a full listing
+	poolMutex    sync.Mutex // To ensure thread-safe access to `poolChanges` and `poolComplete`
+)
+
+// recordPoolChange remembers the difference between the old and new library, both sorted
+func recordPoolChange(old, new []string) {
+	var added, removed []string
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case j == len(new) || (i < len(old) && old[i] < new[j]):
+			removed = append(removed, old[i])
+			i++
+		case i == len(old) || new[j] < old[i]:
+			added = append(added, new[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+
+	poolMutex.Lock()
+	defer poolMutex.Unlock()
+
+	now := time.Now()
+	if poolComplete.IsZero() {
+		// the first load is the starting point, not a change
+		poolComplete = now
+		return
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	poolChanges = append(poolChanges, poolChange{at: now, added: added, removed: removed})
+	if len(poolChanges) > maxPoolChanges {
+		// forget the oldest change, anyone asking from before it needs a full listing
+		poolComplete = poolChanges[0].at
+		poolChanges = poolChanges[1:]
+	}
+}
+
+// poolHandler lists every image in the pool, relative to the image directory, with the time of the listing
+// to pass to /api/pool/diff later
+func poolHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	asOf := time.Now()
+	library := currentLibrary()
+	files := make([]string, 0, len(library))
+	for _, file := range library {
+		files = append(files, relativeImagePath(file, config.ImageDirectory))
+	}
+	writeJSON(w, struct {
+		AsOf  time.Time `json:"asOf"`
+		Files []string  `json:"files"`
+	}{asOf, files})
+}
+
+// poolDiffHandler returns the images added to and removed from the pool since a time, given as RFC3339 or unix
+// seconds.  When the changes since then are no longer known resync is set and the pool must be listed again.
+func poolDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	since, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	response := struct {
+		Since   time.Time `json:"since"`
+		AsOf    time.Time `json:"asOf"`
+		Resync  bool      `json:"resync"`
+		Added   []string  `json:"added"`
+		Removed []string  `json:"removed"`
+	}{Since: since, AsOf: time.Now(), Added: []string{}, Removed: []string{}}
+
+	poolMutex.Lock()
+	defer poolMutex.Unlock()
+
+	if poolComplete.IsZero() || since.Before(poolComplete) {
+		response.Resync = true
+		writeJSON(w, response)
+		return
+	}
+
+	// replay the changes in order so an image removed and added again ends up in the right list
+	state := map[string]bool{}
+	for _, change := range poolChanges {
+		if !change.at.After(since) {
+			continue
+		}
+		for _, file := range change.removed {
+			state[file] = false
+		}
+		for _, file := range change.added {
+			state[file] = true
+		}
+	}
+	for file, present := range state {
+		if present {
+			response.Added = append(response.Added, relativeImagePath(file, config.ImageDirectory))
+		} else {
+			response.Removed = append(response.Removed, relativeImagePath(file, config.ImageDirectory))
+		}
+	}
+	writeJSON(w, response)
+}
+
+// parseSince parses the since parameter, an RFC3339 time or unix seconds
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("the since parameter is required")
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %q, expected RFC3339 or unix seconds", value)
+	}
+	return t, nil
+}
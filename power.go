@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// PowerScheduleConfig describes a smart plug used to physically power the display on and off at set times
+type PowerScheduleConfig struct {
+	Provider    string `json:"provider" desc:"Smart plug API, or cec to control a TV over HDMI-CEC" enum:"tasmota,shelly,tplink,kasa,cec" required:"true"`
+	Host        string `json:"host" desc:"IP address or hostname of the plug, not used with cec"`
+	OnTime      string `json:"onTime" desc:"Local time to power the display on, or sunrise or sunset with an optional offset such as sunrise-30m" format:"suntime" required:"true"`
+	OffTime     string `json:"offTime" desc:"Local time to power the display off, or sunrise or sunset with an optional offset" format:"suntime" required:"true"`
+	CecAdapter  string `json:"cecAdapter" desc:"With cec, the adapter port passed to cec-client, the first adapter found when empty"`
+	SwitchInput bool   `json:"switchInput" desc:"With cec, also switch the TV to this device's input when powering on" default:"false"`
+}
+
+// maxKasaReply is the longest reply read from a TP-Link plug, whose replies to a power change are a few dozen bytes
+const maxKasaReply = 64 << 10
+
+// smartPlug is implemented by each supported smart plug API
+type smartPlug interface {
+	setPower(on bool) error
+}
+
+// newSmartPlug returns the plug implementation for the configured provider
+func newSmartPlug(cfg *PowerScheduleConfig) (smartPlug, error) {
+	if strings.EqualFold(cfg.Provider, "cec") {
+		return cecDisplay{adapter: cfg.CecAdapter, switchInput: cfg.SwitchInput}, nil
+	}
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("power schedule host is not set")
+	}
+
+	switch strings.ToLower(cfg.Provider) {
+	case "tasmota":
+		return tasmotaPlug{host: cfg.Host}, nil
+	case "shelly":
+		return shellyPlug{host: cfg.Host}, nil
+	case "tplink", "kasa":
+		return tplinkPlug{host: cfg.Host}, nil
+	default:
+		return nil, fmt.Errorf("unsupported smart plug provider %q", cfg.Provider)
+	}
+}
+
+var plugHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// plugGet issues a GET request to a plug's local HTTP API and checks the response status
+func plugGet(rawURL string) error {
+	resp, err := plugHTTPClient.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("plug returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// tasmotaPlug drives plugs running the Tasmota firmware via its command API
+type tasmotaPlug struct {
+	host string
+}
+
+func (p tasmotaPlug) setPower(on bool) error {
+	state := "Off"
+	if on {
+		state = "On"
+	}
+	return plugGet(fmt.Sprintf("http://%s/cm?cmnd=%s", p.host, url.QueryEscape("Power "+state)))
+}
+
+// shellyPlug drives Shelly plugs via the relay endpoint of the local API
+type shellyPlug struct {
+	host string
+}
+
+func (p shellyPlug) setPower(on bool) error {
+	state := "off"
+	if on {
+		state = "on"
+	}
+	return plugGet(fmt.Sprintf("http://%s/relay/0?turn=%s", p.host, state))
+}
+
+// tplinkPlug drives TP-Link Kasa plugs using their local TCP protocol on port 9999
+type tplinkPlug struct {
+	host string
+}
+
+func (p tplinkPlug) setPower(on bool) error {
+	state := 0
+	if on {
+		state = 1
+	}
+	payload, err := json.Marshal(map[string]any{
+		"system": map[string]any{"set_relay_state": map[string]int{"state": state}},
+	})
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(p.host, "9999"), 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// messages are length prefixed and obfuscated with an autokey XOR cipher
+	msg := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(msg, uint32(len(payload)))
+	msg = append(msg, kasaEncrypt(payload)...)
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > maxKasaReply {
+		return fmt.Errorf("plug sent a reply of %d bytes, more than the %d allowed", length, maxKasaReply)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return err
+	}
+
+	var reply struct {
+		System struct {
+			SetRelayState struct {
+				ErrCode int    `json:"err_code"`
+				ErrMsg  string `json:"err_msg"`
+			} `json:"set_relay_state"`
+		} `json:"system"`
+	}
+	if err := json.Unmarshal(kasaDecrypt(body), &reply); err != nil {
+		return err
+	}
+	if reply.System.SetRelayState.ErrCode != 0 {
+		return fmt.Errorf("plug returned error %d: %s", reply.System.SetRelayState.ErrCode, reply.System.SetRelayState.ErrMsg)
+	}
+	return nil
+}
+
+// cecDisplay switches the attached TV on and off over HDMI-CEC using cec-client from libcec,
+// which is available on Raspberry Pi OS in the cec-utils package
+type cecDisplay struct {
+	adapter     string
+	switchInput bool
+}
+
+func (d cecDisplay) setPower(on bool) error {
+	// address 0 is always the TV
+	commands := "standby 0\n"
+	if on {
+		commands = "on 0\n"
+		if d.switchInput {
+			// announce this device as the active source so the TV switches to its input
+			commands += "as\n"
+		}
+	}
+
+	// -s sends the commands from stdin and exits, -d 1 only logs errors
+	args := []string{"-s", "-d", "1"}
+	if d.adapter != "" {
+		args = append(args, d.adapter)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "cec-client", args...)
+	cmd.Stdin = strings.NewReader(commands)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cec-client failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// kasaEncrypt applies the Kasa autokey XOR cipher
+func kasaEncrypt(data []byte) []byte {
+	key := byte(171)
+	out := make([]byte, len(data))
+	for i, b := range data {
+		key ^= b
+		out[i] = key
+	}
+	return out
+}
+
+// kasaDecrypt reverses kasaEncrypt
+func kasaDecrypt(data []byte) []byte {
+	key := byte(171)
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = key ^ b
+		key = b
+	}
+	return out
+}
+
+// parseClockTime converts a HH:MM string into minutes since midnight
+func parseClockTime(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM: %v", value, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// withinWindow reports whether minute (since midnight) falls inside the start-end window, which may wrap past midnight
+func withinWindow(minute, start, end int) bool {
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// runPowerSchedule switches the smart plug on and off at the configured times and pauses image rotation while the display is off.
+// Within the on hours the display is also switched off while a presence sensor sees nobody and during quiet hours.
+func runPowerSchedule(cfg *PowerScheduleConfig, location *LocationConfig) {
+	plug, err := newSmartPlug(cfg)
+	if err != nil {
+		log.Printf("Power schedule disabled: %v", err)
+		return
+	}
+
+	var current *bool // nil until the first successful command so the plug is always set at startup
+	for {
+		now := time.Now()
+		// worked out each time as times relative to sunrise and sunset change from day to day
+		onMinute, offMinute, err := dayWindow(cfg.OnTime, cfg.OffTime, location, now)
+		if err != nil {
+			log.Printf("Power schedule disabled: %v", err)
+			return
+		}
+		want := withinWindow(now.Hour()*60+now.Minute(), onMinute, offMinute) && !displayBlanked()
+
+		if current == nil || *current != want {
+			if err := plug.setPower(want); err != nil {
+				log.Printf("Error switching display power: %v", err)
+			} else {
+				log.Printf("Display power switched on: %t", want)
+				current = &want
+				rotationPaused.Store(!want)
+			}
+		}
+
+		select {
+		case <-time.After(time.Minute):
+		case <-presenceChanged:
+		}
+	}
+}
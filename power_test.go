@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestWithinWindow(t *testing.T) {
+	tests := []struct {
+		name               string
+		minute, start, end int
+		want               bool
+	}{
+		{name: "inside a daytime window", minute: 12 * 60, start: 7 * 60, end: 23 * 60, want: true},
+		{name: "at the start", minute: 7 * 60, start: 7 * 60, end: 23 * 60, want: true},
+		{name: "at the end", minute: 23 * 60, start: 7 * 60, end: 23 * 60, want: false},
+		{name: "before a daytime window", minute: 6*60 + 59, start: 7 * 60, end: 23 * 60, want: false},
+		{name: "late in a window past midnight", minute: 23*60 + 30, start: 22 * 60, end: 6 * 60, want: true},
+		{name: "early in a window past midnight", minute: 5 * 60, start: 22 * 60, end: 6 * 60, want: true},
+		{name: "outside a window past midnight", minute: 12 * 60, start: 22 * 60, end: 6 * 60, want: false},
+		{name: "empty window", minute: 7 * 60, start: 7 * 60, end: 7 * 60, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withinWindow(tt.minute, tt.start, tt.end); got != tt.want {
+				t.Errorf("withinWindow(%d, %d, %d) = %v, want %v", tt.minute, tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
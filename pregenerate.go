@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// PregenerateConfig sets up the background workers making the scaled down copies of photos before they are shown
+type PregenerateConfig struct {
+	Workers     int    `json:"workers" desc:"Number of photos scaled down at the same time" default:"1"`
+	LibraryTime string `json:"libraryTime" desc:"Local time each day copies of the whole library are made, only the upcoming photos are scaled down early when empty" format:"time"`
+}
+
+// pregenerateJob is a photo to make the scaled down copy of
+type pregenerateJob struct {
+	file   string
+	config *Config
+	pass   *sync.WaitGroup // the library pass the job is part of, nil for the upcoming photo
+}
+
+var (
+	upcomingPregenerateJobs = make(chan pregenerateJob, 4) // the upcoming photos, made ahead of the library
+	libraryPregenerateJobs  = make(chan pregenerateJob)
+)
+
+// runPregeneration starts the workers making the scaled down copies of photos, so the page doesn't wait for a
+// photo to be resized when it is first shown, and copies the whole library once a day when a time is set
+func runPregeneration(cfg *PregenerateConfig) {
+	for range max(cfg.Workers, 1) {
+		go pregenerateWorker()
+	}
+	if cfg.LibraryTime == "" {
+		return
+	}
+	minute, err := parseClockTime(cfg.LibraryTime)
+	if err != nil {
+		log.Printf("Copying the library disabled: %v", err)
+		return
+	}
+	go pregenerateLibraryDaily(minute)
+}
+
+// pregenerateUpcoming queues the upcoming photo to be scaled down before it is shown.  It is dropped when the
+// workers are already busy with others, the page then resizes it when it is asked for.
+func pregenerateUpcoming(file string, config *Config) {
+	if file == "" || config.Pregenerate == nil || config.MaxImageWidth <= 0 && config.MaxImageHeight <= 0 {
+		return
+	}
+	select {
+	case upcomingPregenerateJobs <- pregenerateJob{file: file, config: config}:
+	default:
+	}
+}
+
+// pregenerateWorker makes the copies queued for it, always taking the upcoming photos before the library
+func pregenerateWorker() {
+	for {
+		var job pregenerateJob
+		select {
+		case job = <-upcomingPregenerateJobs:
+		default:
+			select {
+			case job = <-upcomingPregenerateJobs:
+			case job = <-libraryPregenerateJobs:
+			}
+		}
+		pregenerate(job.file, job.config)
+		if job.pass != nil {
+			job.pass.Done()
+		}
+	}
+}
+
+// pregenerate makes the copy of a photo the page will ask for, unless it is sent as it is or already cached
+func pregenerate(file string, config *Config) {
+	// the copies are made for the main slideshow's fit, screens and albums fitting photos differently make their own
+	fitMode, maxCrop := pageFit(config, "", "")
+	resized, err := planResize(file, config.ImageDirectory, config.MaxImageWidth, config.MaxImageHeight, pageCopyFit(file, config, fitMode, maxCrop))
+	if err != nil || resized == nil || resized.fresh() {
+		return
+	}
+	if _, err := resized.make(config.ResizeCacheMB); err != nil {
+		log.Printf("Error resizing %s: %v", file, err)
+	}
+}
+
+// pregenerateLibraryDaily queues every photo in the library to be scaled down at minute past midnight each day
+func pregenerateLibraryDaily(minute int) {
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), minute/60, minute%60, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		time.Sleep(time.Until(next))
+
+		config, err := loadConfig(configPath)
+		if err != nil {
+			log.Printf("Error loading config: %v", err)
+			continue
+		}
+		if config.MaxImageWidth <= 0 && config.MaxImageHeight <= 0 {
+			continue
+		}
+
+		start := time.Now()
+		files := currentLibrary()
+		log.Printf("Making the scaled down copies of %d photos", len(files))
+		var pass sync.WaitGroup
+		for _, file := range files {
+			pass.Add(1)
+			libraryPregenerateJobs <- pregenerateJob{file: file, config: config, pass: &pass}
+		}
+		pass.Wait()
+		log.Printf("Made the scaled down copies of %d photos in %s", len(files), time.Since(start).Round(time.Second))
+	}
+}
+
+// pregenerateProblems reports a pregenerate section that does nothing as photos aren't scaled down
+func pregenerateProblems(config *Config) []string {
+	if config.Pregenerate != nil && config.MaxImageWidth <= 0 && config.MaxImageHeight <= 0 {
+		return []string{"pregenerate has no effect unless maxImageWidth or maxImageHeight is set"}
+	}
+	return nil
+}
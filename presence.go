@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"randompic/client"
+)
+
+const defaultAbsenceMinutes = 15
+
+// PresenceConfig blanks the display when a motion or presence sensor has seen nobody for a while
+type PresenceConfig struct {
+	AbsenceMinutes float64 `json:"absenceMinutes" desc:"Minutes without presence before the display is blanked" default:"15"`
+}
+
+// presenceState is what the sensor last reported
+type presenceState struct {
+	Occupied bool      // set while a presence sensor reports someone in the room
+	LastSeen time.Time // the last motion, or when the room was last reported empty
+}
+
+var (
+	presence         presenceState
+	presenceMutex    sync.Mutex // To ensure thread-safe access to `presence`
+	presenceAway     atomic.Bool
+	presenceReported = make(chan struct{}, 1) // wakes runPresence so the display wakes straight away
+	presenceChanged  = make(chan struct{}, 1) // wakes the power schedule so the display follows presence and quiet hours straight away
+)
+
+// absenceTimeout returns the configured absence timeout
+func absenceTimeout(cfg *PresenceConfig) time.Duration {
+	minutes := cfg.AbsenceMinutes
+	if minutes <= 0 {
+		minutes = defaultAbsenceMinutes
+	}
+	return time.Duration(minutes * float64(time.Minute))
+}
+
+// away reports whether the room has been empty for the absence timeout
+func (s presenceState) away(timeout time.Duration) bool {
+	return !s.Occupied && time.Since(s.LastSeen) >= timeout
+}
+
+// runPresence blanks the display once nobody has been seen for the absence timeout.  With a power schedule the
+// schedule switches the display, otherwise the rotation is paused and the page is blanked.
+func runPresence(cfg *PresenceConfig, powerSchedule bool) {
+	presenceMutex.Lock()
+	presence.LastSeen = time.Now() // give whoever started the frame the full timeout
+	presenceMutex.Unlock()
+
+	timeout := absenceTimeout(cfg)
+	for {
+		presenceMutex.Lock()
+		state := presence
+		presenceMutex.Unlock()
+		away := state.away(timeout)
+
+		if presenceAway.Swap(away) != away {
+			if away {
+				log.Println("Nobody present, blanking the display")
+			} else {
+				log.Println("Presence detected, waking the display")
+			}
+			if powerSchedule {
+				select {
+				case presenceChanged <- struct{}{}:
+				default:
+				}
+			} else {
+				rotationPaused.Store(displayBlanked())
+				broadcastRefresh()
+			}
+		}
+
+		var expired <-chan time.Time // never fires while occupied or away, only a report changes those
+		if !away && !state.Occupied {
+			expired = time.After(time.Until(state.LastSeen.Add(timeout)))
+		}
+		select {
+		case <-expired:
+		case <-presenceReported:
+		}
+	}
+}
+
+// presenceHandler takes reports from a motion or presence sensor (POST) or returns what was last reported (GET).
+// A motion sensor POSTs with no body each time it triggers.  A presence sensor POSTs {"present": true} when someone
+// enters, which keeps the display on until it POSTs {"present": false}, starting the absence timeout.
+func presenceHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+	if config.Presence == nil {
+		http.Error(w, "No presence sensor is configured", http.StatusConflict)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req client.PresenceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		presenceMutex.Lock()
+		presence.LastSeen = time.Now()
+		if req.Present != nil {
+			presence.Occupied = *req.Present
+		}
+		presenceMutex.Unlock()
+		select {
+		case presenceReported <- struct{}{}:
+		default:
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	presenceMutex.Lock()
+	state := presence
+	presenceMutex.Unlock()
+	timeout := absenceTimeout(config.Presence)
+	resp := client.Presence{Occupied: state.Occupied, LastSeen: state.LastSeen, Away: state.away(timeout)}
+	if !state.Occupied {
+		blankAt := state.LastSeen.Add(timeout)
+		resp.BlankAt = &blankAt
+	}
+	writeJSON(w, resp)
+}
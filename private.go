@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"randompic/client"
+)
+
+// privatePath is where the images marked private are persisted
+const privatePath = "./randompic-private.json"
+
+var (
+	privateSet   map[string]bool // paths relative to imageDirectory
+	privateErr   error           // set when the private images couldn't be read
+	privateMutex sync.Mutex      // To ensure thread-safe access to `privateSet` and `privateErr`
+)
+
+// loadPrivate reads the private images from disk on first use, the caller must hold privateMutex.  When the file
+// can't be read or parsed every image is treated as private until it is fixed and the app restarted, rather than
+// showing the photos it hides, and it is never written over.
+func loadPrivate() (map[string]bool, error) {
+	if privateSet != nil || privateErr != nil {
+		return privateSet, privateErr
+	}
+
+	var images []string
+	data, err := os.ReadFile(privatePath)
+	if err == nil {
+		if err := json.Unmarshal(data, &images); err != nil {
+			privateErr = fmt.Errorf("error parsing private images: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		privateErr = fmt.Errorf("error reading private images: %v", err)
+	}
+	if privateErr != nil {
+		log.Printf("%v, hiding every image until %s is fixed and the app restarted", privateErr, privatePath)
+		return nil, privateErr
+	}
+
+	privateSet = map[string]bool{}
+	for _, image := range images {
+		privateSet[image] = true
+	}
+	return privateSet, nil
+}
+
+// privateImages returns the private paths in order, the caller must hold privateMutex
+func privateImages() ([]string, error) {
+	private, err := loadPrivate()
+	if err != nil {
+		return nil, err
+	}
+	images := []string{}
+	for image := range private {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// isPrivate reports whether an image has been marked private.  Private images are never shown on any screen or
+// returned by any API, whatever the other filters say, so everything that hands out images checks this.
+func isPrivate(file, imageDirectory string) bool {
+	privateMutex.Lock()
+	defer privateMutex.Unlock()
+	private, err := loadPrivate()
+	return err != nil || private[relativeImagePath(file, imageDirectory)]
+}
+
+// setPrivate marks or unmarks an image, given relative to imageDirectory, and writes the private images to disk
+func setPrivate(image string, private bool) error {
+	privateMutex.Lock()
+	defer privateMutex.Unlock()
+
+	set, err := loadPrivate()
+	if err != nil {
+		return err
+	}
+	if private {
+		set[image] = true
+	} else {
+		delete(set, image)
+	}
+
+	images, _ := privateImages()
+	data, err := json.MarshalIndent(images, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(privatePath, data)
+}
+
+// withoutPrivate returns the images, relative to imageDirectory, that are not private, none when the private images
+// can't be read
+func withoutPrivate(images []string) []string {
+	privateMutex.Lock()
+	defer privateMutex.Unlock()
+
+	private, err := loadPrivate()
+	shown := []string{}
+	if err != nil {
+		return shown
+	}
+	for _, image := range images {
+		if !private[image] {
+			shown = append(shown, image)
+		}
+	}
+	return shown
+}
+
+// withoutPrivateFiles returns the files that are not private, none when the private images can't be read
+func withoutPrivateFiles(files []string, imageDirectory string) []string {
+	privateMutex.Lock()
+	defer privateMutex.Unlock()
+
+	private, err := loadPrivate()
+	shown := []string{}
+	if err != nil {
+		return shown
+	}
+	for _, file := range files {
+		if !private[relativeImagePath(file, imageDirectory)] {
+			shown = append(shown, file)
+		}
+	}
+	return shown
+}
+
+// imageFileHandler serves the image directory to the slideshow page, answering as if private images don't exist.
+// Photos asked for with ?w= or ?h= are scaled down to fit, see serveResizedImage, and HEIC and RAW photos are sent as JPEGs.
+// Browsers may keep each photo and copy, see setImageCacheHeaders.
+func imageFileHandler(imageDirectory string) http.Handler {
+	files := http.StripPrefix("/images/", http.FileServer(http.Dir(imageDirectory)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file := imageDirectoryFile(imageDirectory, r.URL.Path[len("/images/"):])
+		if isPrivate(file, imageDirectory) {
+			http.NotFound(w, r)
+			return
+		}
+		if query := r.URL.Query(); query.Has("w") || query.Has("h") {
+			serveResizedImage(w, r, file, imageDirectory)
+			return
+		}
+		if !browserShowable(file) {
+			serveConverted(w, r, file, imageDirectory)
+			return
+		}
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			setImageCacheHeaders(w, info, "")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// privateHandler lists the private images (GET), marks the current image or the one given private (POST)
+// or makes an image public again (DELETE ?image=)
+func privateHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		privateMutex.Lock()
+		images, err := privateImages()
+		privateMutex.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, images)
+
+	case http.MethodPost:
+		var req client.ImageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		current := getCurrentSlide().Image
+		file := current
+		if req.Image != "" {
+			file = filepath.Join(config.ImageDirectory, filepath.FromSlash(req.Image))
+		}
+		if file == "" {
+			http.Error(w, "No image is being shown", http.StatusConflict)
+			return
+		}
+
+		image := relativeImagePath(file, config.ImageDirectory)
+		if err := setPrivate(image, true); err != nil {
+			http.Error(w, "Error saving private images: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error saving private images: %v", err)
+			return
+		}
+		log.Printf("Marked %s private", image)
+
+		// Drop it from the library, the display history and the screen straight away
+		updateLibrary(nil, []string{file}, nil)
+		forgetHistory(image)
+		if file == current {
+			releaseHold()
+			select {
+			case navigateRequests <- stepSkip:
+			default:
+			}
+		}
+		writeJSON(w, client.ImageResponse{Image: image})
+
+	case http.MethodDelete:
+		image := r.URL.Query().Get("image")
+		if image == "" {
+			http.Error(w, "The image parameter is required", http.StatusBadRequest)
+			return
+		}
+		file := filepath.Join(config.ImageDirectory, filepath.FromSlash(image))
+		image = relativeImagePath(file, config.ImageDirectory)
+		if !isPrivate(file, config.ImageDirectory) {
+			http.Error(w, "The image is not private", http.StatusNotFound)
+			return
+		}
+		if err := setPrivate(image, false); err != nil {
+			http.Error(w, "Error saving private images: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error saving private images: %v", err)
+			return
+		}
+		log.Printf("Marked %s public", image)
+
+		// Put it back in the library if it still exists and passes the filters
+		if _, err := os.Stat(file); err == nil {
+			if added := filterImages([]string{file}, config); len(added) > 0 {
+				addToLibrary(added, nil, nil, config)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
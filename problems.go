@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// problemFilesPath is where the files that couldn't be read are persisted
+const problemFilesPath = "./randompic-problems.json"
+
+// problemFile is a file the app failed to read, left out of the slideshow until it changes.  Size and ModTime are
+// recorded so a file that is fixed or replaced is tried again.
+type problemFile struct {
+	Error   string    `json:"error"`
+	During  string    `json:"during"` // what was reading it: probe, convert, resize, upright, blur, legacy or framebuffer
+	Size    int64     `json:"size"`
+	ModTime int64     `json:"modTime"`
+	Since   time.Time `json:"since"`
+}
+
+// problemFiles are the files the app failed to read, keyed by absolute path
+var problemFiles = &jsonStore[problemFile]{path: problemFilesPath, what: "problem files"}
+
+// recordProblemFile notes that reading a file failed, so it is left out of the slideshow until it changes.  A file
+// that no longer exists isn't recorded, as it is simply gone from the library.
+func recordProblemFile(file, during string, err error) {
+	info, statErr := os.Stat(file)
+	if statErr != nil {
+		return
+	}
+
+	if previous, ok := problemFiles.get(file); ok && previous.Size == info.Size() && previous.ModTime == info.ModTime().Unix() {
+		return
+	}
+	problemFiles.set(file, problemFile{Error: err.Error(), During: during, Size: info.Size(), ModTime: info.ModTime().Unix(), Since: time.Now()})
+	if saveErr := problemFiles.save(); saveErr != nil {
+		log.Printf("Error saving problem files: %v", saveErr)
+	}
+	log.Printf("Left %s out of the slideshow as it couldn't be read: %v", file, err)
+}
+
+// quarantineFile records a file that couldn't be read while it was being served or drawn, dropping it from the
+// library straight away and moving the slideshow on when it is on screen.  Only formats the app reads itself are
+// quarantined, see headerChecked, as failing to decode a WebP or BMP says nothing about whether the browser can show it.
+func quarantineFile(file, during string, err error) {
+	if !headerChecked(file) {
+		return
+	}
+	recordProblemFile(file, during, err)
+	if !isProblemFile(file) {
+		return
+	}
+	updateLibrary(nil, []string{file}, nil)
+	if file == getCurrentSlide().Image {
+		select {
+		case navigateRequests <- stepSkip:
+		default:
+		}
+	}
+}
+
+// isProblemFile reports whether reading a file failed and it hasn't changed since
+func isProblemFile(file string) bool {
+	problem, ok := problemFiles.get(file)
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(file)
+	return err == nil && problem.Size == info.Size() && problem.ModTime == info.ModTime().Unix()
+}
+
+// collectProblemFiles forgets the problem files that have since been deleted or changed, returning the number
+// forgotten
+func collectProblemFiles(exists func(file string) bool) int {
+	return problemFiles.collect(func(file string) bool {
+		return exists(file) && isProblemFile(file)
+	})
+}
+
+// problemFileEntry describes a problem file in the /api/problems response
+type problemFileEntry struct {
+	Image  string    `json:"image"` // relative to imageDirectory
+	Path   string    `json:"path"`
+	Error  string    `json:"error"`
+	During string    `json:"during"`
+	Since  time.Time `json:"since"`
+}
+
+// problemsHandler lists the files that couldn't be read and are left out of the slideshow, leaving out those that
+// have since been changed and will be tried again
+func problemsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	var problems map[string]problemFile
+	problemFiles.read(func(entries map[string]problemFile) {
+		problems = maps.Clone(entries)
+	})
+
+	files := []string{}
+	for file := range problems {
+		if isProblemFile(file) {
+			files = append(files, file)
+		}
+	}
+	entries := []problemFileEntry{}
+	for _, file := range withoutPrivateFiles(files, config.ImageDirectory) {
+		problem := problems[file]
+		entries = append(entries, problemFileEntry{
+			Image:  relativeImagePath(file, config.ImageDirectory),
+			Path:   file,
+			Error:  problem.Error,
+			During: problem.During,
+			Since:  problem.Since,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	writeJSON(w, entries)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"randompic/client"
+)
+
+// activeProfilePath is where the name of the active config profile is persisted across restarts
+const activeProfilePath = "./randompic-profile.json"
+
+var (
+	activeProfile   string
+	profileMutex    sync.Mutex               // To ensure thread-safe access to `activeProfile`
+	profileSwitched = make(chan struct{}, 1) // wakes the image updater so a new profile applies straight away
+)
+
+// profilesDirectory returns the directory holding the config profiles, alongside the main config file
+func profilesDirectory() string {
+	return filepath.Join(filepath.Dir(configPath), "profiles")
+}
+
+// profilePath returns the file of a named profile, rejecting names that would escape the profiles directory
+func profilePath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid profile name %q", name)
+	}
+	return filepath.Join(profilesDirectory(), name+".json"), nil
+}
+
+// currentProfile returns the name of the active profile, or an empty string when the main config is used on its own
+func currentProfile() string {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	return activeProfile
+}
+
+// loadActiveProfile restores the profile that was active when the app last ran
+func loadActiveProfile() {
+	data, err := os.ReadFile(activeProfilePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading active profile: %v", err)
+		}
+		return
+	}
+
+	var saved struct {
+		Profile string `json:"profile"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Error parsing active profile: %v", err)
+		return
+	}
+
+	profileMutex.Lock()
+	activeProfile = saved.Profile
+	profileMutex.Unlock()
+}
+
+// applyProfile overlays the options set in a profile onto the config, leaving every other option unchanged
+func applyProfile(config *Config, name string) error {
+	path, err := profilePath(name)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("profile %q: %v", name, err)
+	}
+	return nil
+}
+
+// listProfiles returns the names of the profiles in the profiles directory
+func listProfiles() ([]string, error) {
+	entries, err := os.ReadDir(profilesDirectory())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	profiles := []string{}
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			profiles = append(profiles, name)
+		}
+	}
+	sort.Strings(profiles)
+	return profiles, nil
+}
+
+// switchProfile makes name the active profile, or returns to the main config when name is empty.
+// The profile is checked before it is activated so a broken profile never replaces a working one.
+func switchProfile(name string) error {
+	if name != "" {
+		if _, err := readConfig(configPath, name); err != nil {
+			return err
+		}
+	}
+
+	profileMutex.Lock()
+	activeProfile = name
+	profileMutex.Unlock()
+
+	data, err := json.MarshalIndent(struct {
+		Profile string `json:"profile"`
+	}{name}, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(activeProfilePath, data); err != nil {
+		return err
+	}
+
+	select {
+	case profileSwitched <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// profilesHandler lists the profiles and the active profile (GET) or switches to another profile (POST)
+func profilesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		profiles, err := listProfiles()
+		if err != nil {
+			http.Error(w, "Error listing profiles: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error listing profiles: %v", err)
+			return
+		}
+		writeJSON(w, client.Profiles{
+			Active:   currentProfile(),
+			Profiles: profiles,
+		})
+
+	case http.MethodPost:
+		var request client.ProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := switchProfile(request.Profile); err != nil {
+			http.Error(w, "Error switching profile: "+err.Error(), http.StatusBadRequest)
+			log.Printf("Error switching profile: %v", err)
+			return
+		}
+		log.Printf("Switched to profile %q", request.Profile)
+		writeJSON(w, request)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"randompic/client"
+)
+
+const (
+	slideListenerQueue  = 8                // messages waiting for a slow browser before it is disconnected
+	eventStreamKeepIdle = 30 * time.Second // comments sent on an idle event stream so proxies don't time it out
+)
+
+// slideListener is a connected browser
+type slideListener struct {
+	album string // the named album the browser shows, empty for the main slideshow
+	drop  func() // disconnects the browser
+}
+
+var (
+	slideListeners      = map[chan []byte]slideListener{} // keyed by the queue of each connected browser
+	slideListenersMutex sync.Mutex                        // To ensure thread-safe access to `slideListeners`
+)
+
+// addSlideListener starts passing the slide messages of album, the main slideshow when empty, to a browser's
+// queue, drop is called when it can't keep up
+func addSlideListener(send chan []byte, album string, drop func()) {
+	slideListenersMutex.Lock()
+	defer slideListenersMutex.Unlock()
+	slideListeners[send] = slideListener{album: album, drop: drop}
+}
+
+// removeSlideListener stops passing slide messages to a queue once its browser has gone
+func removeSlideListener(send chan []byte) {
+	slideListenersMutex.Lock()
+	defer slideListenersMutex.Unlock()
+	delete(slideListeners, send)
+}
+
+// broadcastSlide tells every browser showing the main slideshow that a new slide is on screen
+func broadcastSlide(current slide, config *Config) {
+	if current.Image == "" {
+		return
+	}
+	broadcastAlbumSlide("", current, config)
+}
+
+// broadcastAlbumSlide tells every browser showing a named album that its slideshow has moved on
+func broadcastAlbumSlide(album string, current slide, config *Config) {
+	entry := newHistoryEntry(current, config)
+	broadcast(client.Message{Type: client.MessageSlide, Slide: &entry}, func(l slideListener) bool {
+		return l.album == album
+	})
+}
+
+// broadcastRefresh tells every connected browser to show the page again, when something on it has changed
+// without a new slide
+func broadcastRefresh() {
+	broadcast(client.Message{Type: client.MessageRefresh}, func(slideListener) bool { return true })
+}
+
+// broadcast passes a message to every connected browser to is true for.  A browser too slow to keep up is
+// disconnected rather than holding up the rotation, and catches up when it reconnects.
+func broadcast(msg client.Message, to func(l slideListener) bool) {
+	message, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error encoding JSON message: %v", err)
+		return
+	}
+
+	slideListenersMutex.Lock()
+	defer slideListenersMutex.Unlock()
+	for send, listener := range slideListeners {
+		if !to(listener) {
+			continue
+		}
+		select {
+		case send <- message:
+		default:
+			delete(slideListeners, send)
+			listener.drop()
+		}
+	}
+}
+
+// eventStreamHandler sends the same messages as the WebSocket as Server-Sent Events, for kiosk browsers whose
+// WebSocket support is missing or broken
+func eventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	album, ok := listenerAlbum(w, r)
+	if !ok {
+		return
+	}
+
+	send := make(chan []byte, slideListenerQueue)
+	dropped := make(chan struct{})
+	var once sync.Once
+	addSlideListener(send, album, func() { once.Do(func() { close(dropped) }) })
+	defer removeSlideListener(send)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // stops nginx holding the events back
+	w.WriteHeader(http.StatusOK)
+	flusher := http.NewResponseController(w)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := flusher.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(eventStreamKeepIdle)
+	defer keepalive.Stop()
+	for {
+		select {
+		case message := <-send:
+			fmt.Fprintf(w, "data: %s\n\n", message)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-dropped:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err := flusher.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// listenerAlbum returns the named album a browser connecting for pushes asks for with ?album=, empty for the main
+// slideshow, writing the error response when there is no such album
+func listenerAlbum(w http.ResponseWriter, r *http.Request) (string, bool) {
+	album := r.URL.Query().Get("album")
+	if album != "" && findAlbumRotation(album) == nil {
+		http.Error(w, fmt.Sprintf("No album named %q", album), http.StatusNotFound)
+		return "", false
+	}
+	return album, true
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// QuietHoursConfig blanks the display overnight, for frames without a smart plug to power them off
+type QuietHoursConfig struct {
+	Start string `json:"start" desc:"Local time the display is blanked, or sunrise or sunset with an optional offset such as sunset+2h" format:"suntime" required:"true"`
+	End   string `json:"end" desc:"Local time the display wakes again, or sunrise or sunset with an optional offset" format:"suntime" required:"true"`
+}
+
+var quietHoursActive atomic.Bool
+
+// displayBlanked reports whether the page and the framebuffer display are black, while a presence sensor sees
+// nobody or during quiet hours
+func displayBlanked() bool {
+	return presenceAway.Load() || quietHoursActive.Load()
+}
+
+// runQuietHours blanks the display at the start of quiet hours and wakes it at the end.  With a power schedule the
+// schedule switches the display, otherwise the rotation is paused and the page is blanked.
+func runQuietHours(cfg *QuietHoursConfig, location *LocationConfig, powerSchedule bool) {
+	for {
+		now := time.Now()
+		// worked out each time as times relative to sunrise and sunset change from day to day
+		startMinute, endMinute, err := dayWindow(cfg.Start, cfg.End, location, now)
+		if err != nil {
+			log.Printf("Quiet hours disabled: %v", err)
+			return
+		}
+		quiet := withinWindow(now.Hour()*60+now.Minute(), startMinute, endMinute)
+		if quietHoursActive.Swap(quiet) != quiet {
+			if quiet {
+				log.Println("Quiet hours started, blanking the display")
+			} else {
+				log.Println("Quiet hours ended, waking the display")
+			}
+			if powerSchedule {
+				select {
+				case presenceChanged <- struct{}{}:
+				default:
+				}
+			} else {
+				rotationPaused.Store(displayBlanked())
+				broadcastRefresh()
+			}
+		}
+
+		// check again at the start of the next minute
+		time.Sleep(time.Until(now.Truncate(time.Minute).Add(time.Minute)))
+	}
+}
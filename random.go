@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// selectionRand is the single source of randomness for choosing images, so seeding it makes the selection
+// reproducible
+var selectionRand = newSelectionRandom(time.Now().UnixNano())
+
+// selectionRandom is a source of random numbers for choosing images.  A selector draws from its own copy when it has
+// one, such as a clone previewing the rotation, and from selectionRand otherwise, so a nil *selectionRandom is
+// selectionRand.
+type selectionRandom struct {
+	source *rand.PCG
+	rand   *rand.Rand
+	mutex  sync.Mutex // To ensure thread-safe access to `rand`, which is not safe for concurrent use
+}
+
+func newSelectionRandom(seed int64) *selectionRandom {
+	source := rand.NewPCG(uint64(seed), 0)
+	return &selectionRandom{source: source, rand: rand.New(source)}
+}
+
+// or returns r, or selectionRand when r is nil
+func (r *selectionRandom) or() *selectionRandom {
+	if r == nil {
+		return selectionRand
+	}
+	return r
+}
+
+// copy returns a source in the same state as r, which then draws the same numbers without affecting r
+func (r *selectionRandom) copy() *selectionRandom {
+	r = r.or()
+	r.mutex.Lock()
+	state, _ := r.source.MarshalBinary() // never fails
+	r.mutex.Unlock()
+
+	source := &rand.PCG{}
+	source.UnmarshalBinary(state) // can't fail, the state comes from MarshalBinary
+	return &selectionRandom{source: source, rand: rand.New(source)}
+}
+
+func (r *selectionRandom) intn(n int) int {
+	r = r.or()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rand.IntN(n)
+}
+
+func (r *selectionRandom) float64() float64 {
+	r = r.or()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rand.Float64()
+}
+
+func (r *selectionRandom) shuffle(n int, swap func(i, j int)) {
+	r = r.or()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rand.Shuffle(n, swap)
+}
+
+// pick returns a random one of files, empty when there are none
+func (r *selectionRandom) pick(files []string) string {
+	if len(files) == 0 {
+		return ""
+	}
+	return files[r.intn(len(files))]
+}
+
+// seedSelection reseeds the source used to choose images, making the selection reproducible
+func seedSelection(seed int64) {
+	selectionRand.mutex.Lock()
+	defer selectionRand.mutex.Unlock()
+	selectionRand.source.Seed(uint64(seed), 0)
+}
+
+// randomIntn returns a random number in [0,n) from the selection source
+func randomIntn(n int) int {
+	return selectionRand.intn(n)
+}
+
+// randomFloat64 returns a random number in [0.0,1.0) from the selection source
+func randomFloat64() float64 {
+	return selectionRand.float64()
+}
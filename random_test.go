@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSeededSelectors(t *testing.T) {
+	files := testFiles(20)
+	rated := writeFiles(t, t.TempDir(), "a.jpg", "b.jpg", "c.jpg", "d.jpg")
+	for _, file := range rated {
+		if err := os.WriteFile(file+".xmp", []byte(`xmp:Rating="2"`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		files    []string
+		selector func(seed int64) imageSelector
+	}{
+		{
+			name:     "random",
+			files:    files,
+			selector: func(seed int64) imageSelector { return randomSelector{random: newSelectionRandom(seed)} },
+		},
+		{
+			name:     "shuffle",
+			files:    files,
+			selector: func(seed int64) imageSelector { return &shuffleSelector{random: newSelectionRandom(seed)} },
+		},
+		{
+			name:  "weighted",
+			files: files,
+			selector: func(seed int64) imageSelector {
+				return weightedSelector{decay: time.Hour, random: newSelectionRandom(seed)}
+			},
+		},
+		{
+			name:     "rated",
+			files:    rated,
+			selector: func(seed int64) imageSelector { return &ratedSelector{random: newSelectionRandom(seed)} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := nextImages(tt.selector(42), tt.files, 60)
+			if b := nextImages(tt.selector(42), tt.files, 60); !slices.Equal(a, b) {
+				t.Errorf("seed 42 chose %v and then %v", a, b)
+			}
+			if c := nextImages(tt.selector(43), tt.files, 60); slices.Equal(a, c) {
+				t.Errorf("seeds 42 and 43 both chose %v", a)
+			}
+
+			// a clone previews the images the selector goes on to choose, without moving it on
+			selector := tt.selector(7)
+			nextImages(selector, tt.files, 5)
+			preview := nextImages(selector.clone(), tt.files, 30)
+			if got := nextImages(selector, tt.files, 30); !slices.Equal(got, preview) {
+				t.Errorf("chose %v after previewing %v", got, preview)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"randompic/client"
+)
+
+// ratingsPath is where ratings set through the API are persisted
+const ratingsPath = "./randompic-ratings.json"
+
+// maxXMPScan bounds how much of a file is searched for an embedded XMP packet
+const maxXMPScan = 1 << 20
+
+// xmpRatingPattern matches the rating in an XMP packet, written as an attribute or an element
+var xmpRatingPattern = regexp.MustCompile(`xmp:Rating(?:="|>)\s*(-?\d)`)
+
+// ratings are the ratings set through the API, keyed by path relative to imageDirectory
+var ratings = &jsonStore[int]{path: ratingsPath, what: "ratings", userData: true}
+
+// readSidecarRating returns the star rating from an XMP sidecar written by a photo manager (photo.jpg.xmp or
+// photo.xmp).  Sidecars are read every time as editing one doesn't change the photo, so a cached rating would go stale.
+func readSidecarRating(file string) (int, bool) {
+	if data, ok := readXMPSidecar(file); ok {
+		return parseXMPRating(data), true
+	}
+	return 0, false
+}
+
+// readXMPSidecar returns the contents of the photo's XMP sidecar, photo.jpg.xmp or photo.xmp, and whether it has one
+func readXMPSidecar(file string) ([]byte, bool) {
+	sidecars := []string{file + ".xmp", strings.TrimSuffix(file, filepath.Ext(file)) + ".xmp"}
+	for _, sidecar := range sidecars {
+		if data, err := os.ReadFile(sidecar); err == nil {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// readEmbeddedXMP returns the start of the file, where an embedded XMP packet is found, nil when it can't be read
+func readEmbeddedXMP(file string) []byte {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxXMPScan))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// xmpRating returns the XMP rating of an image, from its sidecar or otherwise the metadata store.
+// It is 0 when unrated and -1 when the photo was rejected.
+func xmpRating(store metadataStore, file string) int {
+	if rating, ok := readSidecarRating(file); ok {
+		return rating
+	}
+	if meta, err := store.get(file); err == nil {
+		return meta.Rating
+	}
+	return 0
+}
+
+// parseXMPRating finds the rating in XMP data, clamped to -1 to 5
+func parseXMPRating(data []byte) int {
+	match := xmpRatingPattern.FindSubmatch(data)
+	if match == nil {
+		return 0
+	}
+	rating, err := strconv.Atoi(string(match[1]))
+	if err != nil {
+		return 0
+	}
+	return min(max(rating, -1), 5)
+}
+
+// setRating sets the rating of an image, given relative to imageDirectory, removing it when rating is 0 so the
+// XMP rating applies again, and writes the ratings to disk
+func setRating(image string, rating int) error {
+	if rating == 0 {
+		ratings.delete(image)
+	} else {
+		ratings.set(image, rating)
+	}
+	return ratings.save()
+}
+
+// imageRating returns the rating of an image, set through the API or otherwise read from its XMP metadata
+func imageRating(file, imageDirectory string) int {
+	if rating, ok := ratings.get(relativeImagePath(file, imageDirectory)); ok {
+		return rating
+	}
+	return xmpRating(imageMetadataCache(), file)
+}
+
+// ratingWeight returns how likely an image is to be chosen for its rating, doubling with each star.
+// Unrated images count as three stars, and even rejected images are occasionally shown.
+func ratingWeight(rating int) float64 {
+	switch {
+	case rating < 0:
+		return 0.5
+	case rating == 0:
+		return 4
+	default:
+		return float64(int(1) << (rating - 1))
+	}
+}
+
+// ratedSelector picks images at random, showing higher rated images more often
+type ratedSelector struct {
+	imageDirectory string
+	source         []string         // the list of files the XMP ratings were read for
+	xmp            []int            // XMP rating of each file in source, edited sidecars are picked up when the library next changes
+	random         *selectionRandom // nil for selectionRand, a clone draws from a copy
+}
+
+func (s *ratedSelector) next(files []string) string {
+	if len(files) == 0 {
+		return selectRandomImage(files)
+	}
+
+	// the library is replaced rather than modified in place, so a different slice means the files have changed
+	if len(files) != len(s.source) || &files[0] != &s.source[0] {
+		store := imageMetadataCache()
+		s.xmp = make([]int, len(files))
+		for i, file := range files {
+			s.xmp[i] = xmpRating(store, file)
+		}
+		if err := store.save(); err != nil {
+			log.Printf("Error saving metadata cache: %v", err)
+		}
+		s.source = files
+	}
+
+	weights := make([]float64, len(files))
+	total := 0.0
+	ratings.read(func(overrides map[string]int) {
+		for i, file := range files {
+			rating := s.xmp[i]
+			if override, ok := overrides[relativeImagePath(file, s.imageDirectory)]; ok {
+				rating = override
+			}
+			weights[i] = ratingWeight(rating)
+			total += weights[i]
+		}
+	})
+
+	target := s.random.float64() * total
+	for i, weight := range weights {
+		target -= weight
+		if target < 0 {
+			return files[i]
+		}
+	}
+	return files[len(files)-1]
+}
+
+func (s *ratedSelector) clone() imageSelector {
+	c := *s
+	c.random = s.random.copy()
+	return &c
+}
+
+// ratingsHandler lists the ratings set through the API (GET), returns the rating of an image (GET ?image=),
+// or rates the image on screen or the one given (POST), 0 removing the rating so the XMP rating applies
+func ratingsHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if image := r.URL.Query().Get("image"); image != "" {
+			file := filepath.Join(config.ImageDirectory, filepath.FromSlash(image))
+			if isPrivate(file, config.ImageDirectory) {
+				http.Error(w, "The image is not in the library", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, client.ImageRating{Image: image, Rating: imageRating(file, config.ImageDirectory)})
+			return
+		}
+		var rated map[string]int
+		ratings.read(func(overrides map[string]int) {
+			rated = maps.Clone(overrides)
+		})
+		for image := range rated {
+			if isPrivate(filepath.Join(config.ImageDirectory, filepath.FromSlash(image)), config.ImageDirectory) {
+				delete(rated, image)
+			}
+		}
+		writeJSON(w, rated)
+
+	case http.MethodPost:
+		var req client.RatingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Rating < -1 || req.Rating > 5 {
+			http.Error(w, "rating must be from 1 to 5, -1 to reject or 0 to remove", http.StatusBadRequest)
+			return
+		}
+		image := req.Image
+		if image == "" {
+			current := getCurrentSlide().Image
+			if current == "" {
+				http.Error(w, "No image is being shown", http.StatusConflict)
+				return
+			}
+			image = relativeImagePath(current, config.ImageDirectory)
+		}
+		file := filepath.Join(config.ImageDirectory, filepath.FromSlash(image))
+		image = relativeImagePath(file, config.ImageDirectory)
+
+		if err := setRating(image, req.Rating); err != nil {
+			http.Error(w, "Error saving ratings: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error saving ratings: %v", err)
+			return
+		}
+		log.Printf("Rated %s: %d", image, req.Rating)
+		writeJSON(w, client.ImageRating{Image: image, Rating: imageRating(file, config.ImageDirectory)})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRatedSelector(t *testing.T) {
+	tests := []struct {
+		name      string
+		xmp       []string // the sidecar of each photo
+		overrides []int    // ratings set through the API, 0 for none
+	}{
+		{
+			name:      "sidecar ratings",
+			xmp:       []string{`xmp:Rating="5"`, `xmp:Rating="3"`, `<x:xmpmeta/>`, `xmp:Rating="-1"`},
+			overrides: []int{0, 0, 0, 0},
+		},
+		{
+			name:      "ratings set through the API win",
+			xmp:       []string{`xmp:Rating="1"`, `xmp:Rating="5"`, `<x:xmpmeta/>`, `xmp:Rating="3"`},
+			overrides: []int{5, 3, 0, -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := writeFiles(t, dir, "a.jpg", "b.jpg", "c.jpg", "d.jpg")
+			want := make([]float64, len(files)) // the share of the picks each photo should get
+			total := 0.0
+			for i, file := range files {
+				if err := os.WriteFile(file+".xmp", []byte(tt.xmp[i]), 0644); err != nil {
+					t.Fatal(err)
+				}
+				rating := parseXMPRating([]byte(tt.xmp[i]))
+				if tt.overrides[i] != 0 {
+					rating = tt.overrides[i]
+					ratings.set(filepath.Base(file), rating)
+				}
+				want[i] = ratingWeight(rating)
+				total += want[i]
+			}
+			t.Cleanup(func() {
+				for _, file := range files {
+					ratings.delete(filepath.Base(file))
+				}
+			})
+
+			const picks = 5000
+			selector := &ratedSelector{imageDirectory: dir, random: newSelectionRandom(1)}
+			counts := map[string]int{}
+			for _, image := range nextImages(selector, files, picks) {
+				counts[image]++
+			}
+			for i, file := range files {
+				share := float64(counts[file]) / picks
+				if math.Abs(share-want[i]/total) > 0.03 {
+					t.Errorf("%s was chosen %.3f of the time, want %.3f", filepath.Base(file), share, want[i]/total)
+				}
+			}
+		})
+	}
+}
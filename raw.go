@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	rawCacheDir = "./randompic-raw"
+	maxRAWIFDs  = 16 // IFDs read while looking for previews, more than any camera writes
+)
+
+// TIFF tags and values locating the JPEG previews embedded in RAW files
+const (
+	tiffTagCompression     = 0x0103
+	tiffTagStripOffsets    = 0x0111
+	tiffTagStripByteCounts = 0x0117
+	tiffTagSubIFDs         = 0x014A
+	tiffTagJPEGOffset      = 0x0201
+	tiffTagJPEGLength      = 0x0202
+	tiffCompressionOldJPEG = 6
+	tiffCompressionJPEG    = 7
+	tiffTypeShort          = 3
+	tiffTypeLong           = 4
+	tiffTypeIFD            = 13
+	tiffEntrySize          = 12
+	maxRAWIFDEntries       = 1000
+)
+
+// rawExtensions are the TIFF based RAW formats whose embedded previews are shown
+var rawExtensions = []string{".arw", ".cr2", ".dng", ".nef", ".nrw", ".pef", ".sr2", ".srf"}
+
+// isRAW reports whether a file is a camera RAW file, shown from the JPEG preview the camera embedded in it
+func isRAW(file string) bool {
+	return slices.Contains(rawExtensions, strings.ToLower(filepath.Ext(file)))
+}
+
+// rawPreview is a JPEG embedded in a RAW file
+type rawPreview struct {
+	offset, length uint32
+}
+
+// extractRAWPreview writes the largest JPEG preview embedded in a RAW file to jpeg.  Cameras store a full size or
+// nearly full size preview alongside the sensor data, referenced from the TIFF structure the file is built on.
+func extractRAWPreview(file, jpeg string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 8)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return err
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errors.New("not a TIFF based RAW file")
+	}
+
+	// walk IFD0, the IFDs chained after it and their sub IFDs, noting every JPEG they point to
+	var previews []rawPreview
+	pending := []uint32{order.Uint32(header[4:])}
+	seen := map[uint32]bool{}
+	for len(pending) > 0 && len(seen) < maxRAWIFDs {
+		offset := pending[0]
+		pending = pending[1:]
+		if offset == 0 || seen[offset] {
+			continue
+		}
+		seen[offset] = true
+
+		entries, next, err := readRAWIFD(f, order, offset)
+		if err != nil {
+			continue
+		}
+		pending = append(pending, next)
+		pending = append(pending, rawLongs(f, order, entries[tiffTagSubIFDs])...)
+
+		if jpegOffset, ok := rawValue(order, entries[tiffTagJPEGOffset]); ok {
+			if jpegLength, ok := rawValue(order, entries[tiffTagJPEGLength]); ok {
+				previews = append(previews, rawPreview{jpegOffset, jpegLength})
+			}
+		}
+		// some cameras, Canon's among them, store the preview as the image data of an IFD
+		if compression, _ := rawValue(order, entries[tiffTagCompression]); compression == tiffCompressionOldJPEG || compression == tiffCompressionJPEG {
+			stripOffset, offsetOK := rawValue(order, entries[tiffTagStripOffsets])
+			stripLength, lengthOK := rawValue(order, entries[tiffTagStripByteCounts])
+			if offsetOK && lengthOK {
+				previews = append(previews, rawPreview{stripOffset, stripLength})
+			}
+		}
+	}
+
+	// the largest preview Go can decode, the sensor data itself is sometimes stored as a lossless JPEG it can't
+	slices.SortFunc(previews, func(a, b rawPreview) int { return cmp.Compare(b.length, a.length) })
+	for _, preview := range previews {
+		if preview.length < 2 || int64(preview.offset)+int64(preview.length) > info.Size() {
+			continue
+		}
+		data := make([]byte, preview.length)
+		if _, err := f.ReadAt(data, int64(preview.offset)); err != nil {
+			continue
+		}
+		if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || format != "jpeg" {
+			continue
+		}
+		return os.WriteFile(jpeg, data, 0o644)
+	}
+	return errors.New("no JPEG preview found in the RAW file")
+}
+
+// readRAWIFD reads the entries of the IFD at offset by tag, and the offset of the next IFD
+func readRAWIFD(f *os.File, order binary.ByteOrder, offset uint32) (map[uint16][]byte, uint32, error) {
+	countBytes := make([]byte, 2)
+	if _, err := f.ReadAt(countBytes, int64(offset)); err != nil {
+		return nil, 0, err
+	}
+	count := int(order.Uint16(countBytes))
+	if count > maxRAWIFDEntries {
+		return nil, 0, errors.New("too many IFD entries")
+	}
+	data := make([]byte, count*tiffEntrySize+4)
+	if _, err := f.ReadAt(data, int64(offset)+2); err != nil {
+		return nil, 0, err
+	}
+	entries := make(map[uint16][]byte, count)
+	for i := range count {
+		entry := data[i*tiffEntrySize : (i+1)*tiffEntrySize]
+		entries[order.Uint16(entry)] = entry
+	}
+	return entries, order.Uint32(data[count*tiffEntrySize:]), nil
+}
+
+// rawValue returns the single SHORT or LONG value of an IFD entry
+func rawValue(order binary.ByteOrder, entry []byte) (uint32, bool) {
+	if entry == nil || order.Uint32(entry[4:]) != 1 {
+		return 0, false
+	}
+	switch order.Uint16(entry[2:]) {
+	case tiffTypeShort:
+		return uint32(order.Uint16(entry[8:])), true
+	case tiffTypeLong:
+		return order.Uint32(entry[8:]), true
+	}
+	return 0, false
+}
+
+// rawLongs returns the LONG values of an IFD entry, such as the offsets of the sub IFDs
+func rawLongs(f *os.File, order binary.ByteOrder, entry []byte) []uint32 {
+	if entry == nil || order.Uint16(entry[2:]) != tiffTypeLong && order.Uint16(entry[2:]) != tiffTypeIFD {
+		return nil
+	}
+	count := order.Uint32(entry[4:])
+	if count == 1 {
+		return []uint32{order.Uint32(entry[8:])}
+	}
+	if count > maxRAWIFDs {
+		return nil
+	}
+	data := make([]byte, count*4)
+	if _, err := f.ReadAt(data, int64(order.Uint32(entry[8:]))); err != nil {
+		return nil
+	}
+	values := make([]uint32, count)
+	for i := range values {
+		values[i] = order.Uint32(data[i*4:])
+	}
+	return values
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image/jpeg"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxResizeDimension   = 7680 // pixels, the largest width or height a photo is scaled to, 8K
+	resizeJPEGQuality    = 85
+	resizeCacheDir       = "./randompic-resized"
+	defaultResizeCacheMB = 512
+)
+
+var (
+	resizeCacheBytes      int64      = -1 // bytes taken up by the cached copies, -1 until the cache is first added to
+	resizeCacheBytesMutex sync.Mutex      // To ensure thread-safe access to `resizeCacheBytes`, and that only one trim runs at a time
+)
+
+// fitModes are how a photo is fitted to a screen or the size asked for: scaled down to fit inside it, or cropped to
+// fill it around its middle, around the faces in it or from its top
+var fitModes = []string{"contain", "cover", "smart-crop", "top-crop"}
+
+// resizedImageURL returns the URL of a photo scaled down to fit inside width by height, or cropped to fill it with
+// a fit mode other than contain, or of the photo itself when neither is set
+func resizedImageURL(file, imageDirectory string, width, height int, fitMode string) string {
+	photoURL := imageURL(file, imageDirectory)
+	if width <= 0 && height <= 0 {
+		return photoURL
+	}
+	width, height = min(max(width, 0), maxResizeDimension), min(max(height, 0), maxResizeDimension)
+	photoURL += "?w=" + strconv.Itoa(width) + "&h=" + strconv.Itoa(height)
+	if fitMode != "contain" && fitMode != "" && width > 0 && height > 0 {
+		photoURL += "&fit=" + fitMode
+	}
+	return photoURL
+}
+
+// resizeDimension reads the w or h parameter of an image request, zero when it is missing so that side isn't limited
+func resizeDimension(r *http.Request, name string) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, true
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 || size > maxResizeDimension {
+		return 0, false
+	}
+	return size, true
+}
+
+// serveResizedImage sends a photo scaled down to fit inside ?w= by ?h=, as a JPEG turned upright from its EXIF
+// orientation, so small boards aren't sent huge originals to decode.  A photo that already fits is sent as it is, as
+// is one whose size the app can't read, such as a WebP, for the browser to scale.  Photos are never scaled up.  With
+// a ?fit= other than contain it is cropped to the shape of w by h instead.  Each size of a photo is scaled once and
+// cached on disk, made again when the photo changes.  Browsers accepting one of the transcode formats are sent the
+// copy in that format.
+func serveResizedImage(w http.ResponseWriter, r *http.Request, file string, imageDirectory string) {
+	width, widthOK := resizeDimension(r, "w")
+	height, heightOK := resizeDimension(r, "h")
+	if !widthOK || !heightOK {
+		http.Error(w, "The w and h parameters must be whole numbers of pixels up to "+strconv.Itoa(maxResizeDimension), http.StatusBadRequest)
+		return
+	}
+	fitMode := r.URL.Query().Get("fit")
+	if fitMode == "" {
+		fitMode = "contain"
+	}
+	if !slices.Contains(fitModes, fitMode) {
+		http.Error(w, "The fit parameter must be one of "+strings.Join(fitModes, ", "), http.StatusBadRequest)
+		return
+	}
+	if fitMode != "contain" && (width == 0 || height == 0) {
+		http.Error(w, "Cropping to fit needs both the w and h parameters", http.StatusBadRequest)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	resized, err := planResize(file, imageDirectory, width, height, fitMode)
+	if err != nil || resized == nil {
+		setImageCacheHeaders(w, info, "")
+		http.ServeFile(w, r, file)
+		return
+	}
+	variant := strconv.Itoa(resized.width) + "x" + strconv.Itoa(resized.height) + "-" + fitMode
+
+	var encoded []byte
+	if !resized.fresh() {
+		if encoded, err = resized.make(config.ResizeCacheMB); err != nil {
+			http.Error(w, "Error resizing image: "+err.Error(), http.StatusInternalServerError)
+			log.Printf("Error resizing %s: %v", file, err)
+			quarantineFile(file, "resize", err)
+			return
+		}
+	}
+
+	if config.Transcode != nil {
+		w.Header().Add("Vary", "Accept")
+		if format := acceptedFormat(r, config.Transcode); format != "" {
+			transcoded, err := resized.transcode(format, config.Transcode.Quality, config.ResizeCacheMB)
+			if err == nil {
+				setImageCacheHeaders(w, info, variant+"-"+format)
+				w.Header().Set("Content-Type", imageEncoders[format].mimeType)
+				http.ServeFile(w, r, transcoded)
+				return
+			}
+			log.Printf("Error transcoding %s to %s, sending a JPEG: %v", file, format, err)
+		}
+	}
+
+	setImageCacheHeaders(w, info, variant+"-jpeg")
+	w.Header().Set("Content-Type", "image/jpeg")
+	if encoded != nil {
+		http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(encoded))
+		return
+	}
+	// the modification time records when a copy was last used, so the least recently used are removed first
+	now := time.Now()
+	os.Chtimes(resized.cached, now, now)
+	http.ServeFile(w, r, resized.cached)
+}
+
+// resizedCopy is the copy of a photo scaled down to fit inside a size, or cropped to fill it, and where it is cached
+type resizedCopy struct {
+	file          string
+	modTime       time.Time // of the photo, a copy older than this is out of date
+	width, height int       // of the copy
+	fitMode       string
+	cached        string
+}
+
+// planResize works out the copy of a photo fitting inside width by height, either of which may be 0 to leave that
+// side unlimited.  It returns nil when the photo is sent as it is, as it already fits, is upright and browsers can
+// show it.  With a fit mode other than contain the copy is cropped to the shape of width by height, at the photo's
+// own resolution when it is smaller.
+func planResize(file, imageDirectory string, width, height int, fitMode string) (*resizedCopy, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errors.New("not an image")
+	}
+	imageWidth, imageHeight, err := probeDimensions(file)
+	if err != nil {
+		return nil, err
+	}
+	if imageWidth <= 0 || imageHeight <= 0 {
+		return nil, errors.New("image has no size")
+	}
+
+	if fitMode != "contain" && width > 0 && height > 0 {
+		// a photo smaller than the size is cropped to its shape rather than scaled up
+		shrink := min(1, float64(imageWidth)/float64(width), float64(imageHeight)/float64(height))
+		return &resizedCopy{
+			file:    file,
+			modTime: info.ModTime(),
+			width:   max(int(float64(width)*shrink), 1),
+			height:  max(int(float64(height)*shrink), 1),
+			fitMode: fitMode,
+			cached:  imageCopyPath(resizeCachePath(width, height, fitMode), relativeImagePath(file, imageDirectory)),
+		}, nil
+	}
+
+	scale := 1.0
+	if width > 0 {
+		scale = min(scale, float64(width)/float64(imageWidth))
+	}
+	if height > 0 {
+		scale = min(scale, float64(height)/float64(imageHeight))
+	}
+	if scale == 1 && exifOrientation(file) <= 1 && browserShowable(file) {
+		return nil, nil
+	}
+	return &resizedCopy{
+		file:    file,
+		modTime: info.ModTime(),
+		width:   max(int(float64(imageWidth)*scale), 1),
+		height:  max(int(float64(imageHeight)*scale), 1),
+		fitMode: "contain",
+		cached:  imageCopyPath(resizeCachePath(width, height, "contain"), relativeImagePath(file, imageDirectory)),
+	}, nil
+}
+
+// fresh reports whether the copy is cached and up to date with the photo
+func (c *resizedCopy) fresh() bool {
+	info, err := os.Stat(c.cached)
+	return err == nil && !info.ModTime().Before(c.modTime)
+}
+
+// make scales the photo down and caches the copy, keeping the cache under cacheMB megabytes, and returns the JPEG
+func (c *resizedCopy) make(cacheMB int64) ([]byte, error) {
+	frame, err := renderFrame(c.file, c.width, c.height, c.fitMode)
+	if err != nil {
+		return nil, err
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, frame, &jpeg.Options{Quality: resizeJPEGQuality}); err != nil {
+		return nil, err
+	}
+
+	// the copy is written alongside and renamed into place, so a copy being made at the same time is never sent half written
+	if err := os.MkdirAll(filepath.Dir(c.cached), 0o755); err == nil {
+		partial := c.cached + ".partial"
+		err := os.WriteFile(partial, encoded.Bytes(), 0o644)
+		if err == nil {
+			err = os.Rename(partial, c.cached)
+		}
+		if err != nil {
+			os.Remove(partial)
+			log.Printf("Error caching the resized copy of %s: %v", c.file, err)
+		} else {
+			addToResizeCache(int64(encoded.Len()), cacheMB)
+		}
+	}
+	return encoded.Bytes(), nil
+}
+
+// resizeCachePath returns the directory the copies of photos scaled to fit inside width by height, or cropped to fill
+// it, are cached in, mirroring the image directory
+func resizeCachePath(width, height int, fitMode string) string {
+	size := strconv.Itoa(width) + "x" + strconv.Itoa(height)
+	if fitMode != "contain" {
+		size += "-" + fitMode
+	}
+	return filepath.Join(resizeCacheDir, size)
+}
+
+// addToResizeCache counts a newly cached copy, removing the least recently used copies once the cache takes up more
+// than cacheMB megabytes, 0 for the default
+func addToResizeCache(size, cacheMB int64) {
+	if cacheMB <= 0 {
+		cacheMB = defaultResizeCacheMB
+	}
+	limit := cacheMB << 20
+
+	resizeCacheBytesMutex.Lock()
+	defer resizeCacheBytesMutex.Unlock()
+	if resizeCacheBytes >= 0 {
+		resizeCacheBytes += size
+		if resizeCacheBytes <= limit {
+			return
+		}
+	}
+
+	// walking the cache also corrects the count for copies the cache cleanup removed since
+	type cachedCopy struct {
+		path    string
+		size    int64
+		lastUse time.Time
+	}
+	var copies []cachedCopy
+	total := int64(0)
+	filepath.WalkDir(resizeCacheDir, func(cached string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			copies = append(copies, cachedCopy{path: cached, size: info.Size(), lastUse: info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	slices.SortFunc(copies, func(a, b cachedCopy) int { return a.lastUse.Compare(b.lastUse) })
+
+	// trimming a tenth below the limit leaves room for a while, rather than walking the cache for every new copy
+	target := limit / 10 * 9
+
+	removed, reclaimed := 0, int64(0)
+	for _, old := range copies {
+		if total <= target {
+			break
+		}
+		if os.Remove(old.path) == nil {
+			total -= old.size
+			removed++
+			reclaimed += old.size
+		}
+	}
+	resizeCacheBytes = total
+	if removed > 0 {
+		log.Printf("Removed %d resized copies taking up %d bytes to keep the resize cache under %d MB", removed, reclaimed, cacheMB)
+	}
+}
+
+// collectResizeCache removes the resized copies of images that no longer exist, from the cache of each size,
+// returning how many were removed and the bytes reclaimed
+func collectResizeCache(imageDirectory string, exists func(string) bool) (int, int64) {
+	sizes, _ := os.ReadDir(resizeCacheDir)
+	removed, reclaimed := 0, int64(0)
+	for _, size := range sizes {
+		if size.IsDir() {
+			sizeRemoved, sizeReclaimed := collectImageCopies(filepath.Join(resizeCacheDir, size.Name()), imageDirectory, exists)
+			removed += sizeRemoved
+			reclaimed += sizeReclaimed
+		}
+	}
+	return removed, reclaimed
+}
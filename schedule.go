@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ScheduleConfig shows an album or a range of dates during part of each day, e.g. the kids' photos in the daytime
+// and landscapes at night
+type ScheduleConfig struct {
+	Name     string `json:"name" desc:"Name of the schedule in the log"`
+	Start    string `json:"start" desc:"Local time the schedule starts, or sunrise or sunset with an optional offset such as sunset+30m" format:"suntime" required:"true"`
+	End      string `json:"end" desc:"Local time the schedule ends, before start for a schedule running past midnight" format:"suntime" required:"true"`
+	Album    string `json:"album" desc:"Only show images from this album (a directory relative to imageDirectory, clusters/<id> or starred), all images when empty"`
+	DateFrom string `json:"dateFrom" desc:"Only photos taken on or after this date (YYYY-MM-DD) are displayed" format:"date"`
+	DateTo   string `json:"dateTo" desc:"Only photos taken on or before this date (YYYY-MM-DD) are displayed" format:"date"`
+}
+
+// activeSchedule returns the first schedule running at now, nil when none is.  Schedules whose times can't be
+// worked out are skipped, they are reported when the config is checked.
+func activeSchedule(schedules []ScheduleConfig, location *LocationConfig, now time.Time) *ScheduleConfig {
+	minute := now.Hour()*60 + now.Minute()
+	for i := range schedules {
+		start, end, err := dayWindow(schedules[i].Start, schedules[i].End, location, now)
+		if err != nil {
+			continue
+		}
+		if withinWindow(minute, start, end) {
+			return &schedules[i]
+		}
+	}
+	return nil
+}
+
+// sameSchedule reports whether a and b are the same schedule, or both no schedule, also when the config was
+// loaded again in between
+func sameSchedule(a, b *ScheduleConfig) bool {
+	return a == b || a != nil && b != nil && *a == *b
+}
+
+// scheduleDates is the library's images inside the dates of the schedule last asked for, kept as working them out
+// reads every photo's metadata, and dropped when the library changes
+type scheduleDates struct {
+	dateFrom, dateTo string
+	files            map[string]bool
+	err              error
+}
+
+var (
+	scheduleDatesCache      *scheduleDates
+	scheduleDatesCacheMutex sync.Mutex // To ensure thread-safe access to `scheduleDatesCache`
+)
+
+// forgetScheduleDates drops the images worked out for a schedule's dates, so a changed library is looked at again
+func forgetScheduleDates() {
+	scheduleDatesCacheMutex.Lock()
+	defer scheduleDatesCacheMutex.Unlock()
+	scheduleDatesCache = nil
+}
+
+// scheduledFiles returns the images a schedule shows out of files
+func scheduledFiles(schedule *ScheduleConfig, files []string, imageDirectory string) []string {
+	if schedule.Album != "" {
+		files = albumFiles(files, imageDirectory, schedule.Album)
+	}
+	if schedule.DateFrom == "" && schedule.DateTo == "" {
+		return files
+	}
+	dated, err := datedFiles(schedule)
+	if err != nil {
+		return files
+	}
+	var matched []string
+	for _, file := range files {
+		if dated[file] {
+			matched = append(matched, file)
+		}
+	}
+	return matched
+}
+
+// datedFiles returns the library's images taken inside a schedule's dates, worked out once for each range and
+// library.  An invalid range is logged once and the schedule shows every image.
+func datedFiles(schedule *ScheduleConfig) (map[string]bool, error) {
+	scheduleDatesCacheMutex.Lock()
+	defer scheduleDatesCacheMutex.Unlock()
+	if cache := scheduleDatesCache; cache != nil && cache.dateFrom == schedule.DateFrom && cache.dateTo == schedule.DateTo {
+		return cache.files, cache.err
+	}
+
+	cache := &scheduleDates{dateFrom: schedule.DateFrom, dateTo: schedule.DateTo}
+	from, to, err := parseDateRange(schedule.DateFrom, schedule.DateTo)
+	if err != nil {
+		log.Printf("Error in the dates of schedule %q: %v", schedule.Name, err)
+		cache.err = err
+	} else {
+		cache.files = map[string]bool{}
+		for _, file := range filterByDate(currentLibrary(), from, to) {
+			cache.files[file] = true
+		}
+	}
+	scheduleDatesCache = cache
+	return cache.files, cache.err
+}
+
+// scheduleName describes a schedule in the log
+func scheduleName(schedule *ScheduleConfig) string {
+	if schedule == nil {
+		return "none"
+	}
+	if schedule.Name != "" {
+		return schedule.Name
+	}
+	return schedule.Start + "-" + schedule.End
+}
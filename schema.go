@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schemaField describes one configuration option, generated from the struct tags of Config:
+//
+//	json     - the option name
+//	desc     - a description of the option
+//	default  - the value used when the option is not set
+//	enum     - a comma separated list of allowed values
+//	format   - the expected string format: date (YYYY-MM-DD), time (HH:MM), suntime (HH:MM, or sunrise or sunset
+//	           with an optional offset such as sunset+30m), datetime, url (http or https) or color (a CSS color
+//	           name or #rrggbb)
+//	required - "true" when the option must be set
+//	secret   - "true" for passwords and API keys, which GET /api/config leaves out
+type schemaField struct {
+	Name        string        `json:"name,omitempty"`
+	Type        string        `json:"type"` // string, integer, number, boolean, array or object
+	Description string        `json:"description,omitempty"`
+	Default     any           `json:"default,omitempty"`
+	Enum        []string      `json:"enum,omitempty"`
+	Format      string        `json:"format,omitempty"`
+	Required    bool          `json:"required,omitempty"`
+	Secret      bool          `json:"secret,omitempty"`
+	Items       *schemaField  `json:"items,omitempty"`  // element type of arrays
+	Fields      []schemaField `json:"fields,omitempty"` // options of objects
+}
+
+// configSchema builds the schema of the configuration file from the Config struct
+func configSchema() schemaField {
+	return schemaForType(reflect.TypeOf(Config{}))
+}
+
+// schemaForType builds the schema of a Go type
+func schemaForType(t reflect.Type) schemaField {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return schemaField{Type: "string"}
+	case reflect.Bool:
+		return schemaField{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schemaField{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schemaField{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		items := schemaForType(t.Elem())
+		return schemaField{Type: "array", Items: &items}
+	case reflect.Map:
+		items := schemaForType(t.Elem())
+		return schemaField{Type: "object", Items: &items}
+	case reflect.Struct:
+		schema := schemaField{Type: "object"}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "" || name == "-" {
+				continue
+			}
+
+			field := schemaForType(f.Type)
+			field.Name = name
+			field.Description = f.Tag.Get("desc")
+			field.Format = f.Tag.Get("format")
+			field.Required = f.Tag.Get("required") == "true"
+			field.Secret = f.Tag.Get("secret") == "true"
+			if enum := f.Tag.Get("enum"); enum != "" && field.Items != nil {
+				// each item of a list must be one of the values
+				field.Items.Enum = strings.Split(enum, ",")
+			} else if enum != "" {
+				field.Enum = strings.Split(enum, ",")
+			}
+			if def, ok := f.Tag.Lookup("default"); ok {
+				field.Default = typedDefault(field.Type, def)
+			}
+			schema.Fields = append(schema.Fields, field)
+		}
+		return schema
+	default:
+		return schemaField{Type: "string"}
+	}
+}
+
+// redactSecrets blanks the options tagged secret in a decoded config, so it can be shown without giving away
+// passwords and API keys
+func redactSecrets(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !value.IsNil() {
+			redactSecrets(value.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range value.Len() {
+			redactSecrets(value.Index(i))
+		}
+	case reflect.Map:
+		// map values can't be changed in place, so each is redacted in a copy and put back
+		for _, key := range value.MapKeys() {
+			elem := reflect.New(value.Type().Elem()).Elem()
+			elem.Set(value.MapIndex(key))
+			redactSecrets(elem)
+			value.SetMapIndex(key, elem)
+		}
+	case reflect.Struct:
+		t := value.Type()
+		for i := range t.NumField() {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			if t.Field(i).Tag.Get("secret") == "true" {
+				value.Field(i).SetZero()
+				continue
+			}
+			redactSecrets(value.Field(i))
+		}
+	}
+}
+
+// typedDefault converts a default tag into a value of the field's JSON type
+func typedDefault(fieldType, value string) any {
+	switch fieldType {
+	case "integer":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "number":
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// validateAgainstSchema checks a decoded value against its schema, returning a message for each problem found
+func validateAgainstSchema(schema schemaField, value reflect.Value, path string) []string {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	var problems []string
+	switch schema.Type {
+	case "object":
+		if value.Kind() != reflect.Struct {
+			break
+		}
+		for _, field := range schema.Fields {
+			fieldValue := fieldByJSONName(value, field.Name)
+			fieldPath := strings.TrimPrefix(path+"."+field.Name, ".")
+			if field.Required && fieldValue.IsZero() {
+				problems = append(problems, fmt.Sprintf("%s is required", fieldPath))
+				continue
+			}
+			problems = append(problems, validateAgainstSchema(field, fieldValue, fieldPath)...)
+		}
+	case "array":
+		for i := 0; i < value.Len(); i++ {
+			problems = append(problems, validateAgainstSchema(*schema.Items, value.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		s := value.String()
+		if s == "" {
+			break
+		}
+		if len(schema.Enum) > 0 && !containsFold(schema.Enum, s) {
+			problems = append(problems, fmt.Sprintf("%s must be one of %s", path, strings.Join(schema.Enum, ", ")))
+		}
+		if err := validateFormat(schema.Format, s); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %v", path, err))
+		}
+	}
+	return problems
+}
+
+// fieldByJSONName returns the struct field with the given json name
+func fieldByJSONName(value reflect.Value, name string) reflect.Value {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if tagName, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); tagName == name {
+			return value.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// validateFormat checks a string option is in its documented format
+func validateFormat(format, value string) error {
+	var err error
+	switch format {
+	case "date":
+		_, err = time.Parse("2006-01-02", value)
+	case "time":
+		_, err = parseClockTime(value)
+	case "suntime":
+		_, err = parseDayTime(value)
+	case "datetime":
+		_, err = parseEventTime(value)
+	case "color":
+		if !validColor(value) {
+			err = errors.New("not a color name or #rrggbb")
+		}
+	case "url":
+		var u *url.URL
+		if u, err = url.Parse(value); err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+			err = errors.New("not an http or https URL")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("is not a valid %s: %q", format, value)
+	}
+	return nil
+}
+
+// containsFold reports whether slice contains str, ignoring case
+func containsFold(slice []string, str string) bool {
+	for _, item := range slice {
+		if strings.EqualFold(item, str) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateConfig checks a configuration file's contents, returning a message for each problem found
+func validateConfig(data []byte) []string {
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return []string{err.Error()}
+	}
+	problems := validateAgainstSchema(configSchema(), reflect.ValueOf(config), "")
+	problems = append(problems, sunTimeProblems(&config)...)
+	problems = append(problems, weatherProblems(&config)...)
+	problems = append(problems, pregenerateProblems(&config)...)
+	problems = append(problems, transcodeProblems(&config)...)
+	problems = append(problems, compressionProblems(&config)...)
+	problems = append(problems, musicProblems(&config)...)
+	problems = append(problems, nearDuplicatesProblems(&config)...)
+	problems = append(problems, contentFilterProblems(&config)...)
+	problems = append(problems, aiCaptionProblems(&config)...)
+	problems = append(problems, faceDetectionProblems(&config)...)
+	return append(problems, templateProblems(&config)...)
+}
+
+// configSchemaHandler returns the schema of the configuration file
+func configSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, configSchema())
+}
+
+// configValidateHandler validates the configuration in the request body, or the current configuration file for GET requests
+func configValidateHandler(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		data, err = readConfigFile()
+	case http.MethodPost:
+		data, err = readBody(r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading config: "+err.Error(), http.StatusBadRequest)
+		log.Printf("Error reading config: %v", err)
+		return
+	}
+
+	problems := validateConfig(data)
+	if problems == nil {
+		problems = []string{}
+	}
+	writeJSON(w, struct {
+		Valid    bool     `json:"valid"`
+		Problems []string `json:"problems"`
+	}{
+		Valid:    len(problems) == 0,
+		Problems: problems,
+	})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPreviewCount = 20
+	maxPreviewCount     = 500
+)
+
+// imageSelector chooses the next image to display from the list of available files.
+// clone returns an independent copy so the upcoming choices can be previewed without affecting the rotation,
+// drawing from a copy of the selector's random numbers so the rotation goes on to choose what was previewed.
+type imageSelector interface {
+	next(files []string) string
+	clone() imageSelector
+}
+
+// storySelector is implemented by selectors that play the library in groups, such as folders,
+// so each group can be introduced with an intro slide.  groupOf returns the album name of the group.
+type storySelector interface {
+	imageSelector
+	groupOf(image, imageDirectory string) string
+	introFor(image, imageDirectory string, library []string) (*folderIntro, string)
+}
+
+// newImageSelector returns the selector for the configured selection mode, defaulting to random
+func newImageSelector(config *Config) imageSelector {
+	switch strings.ToLower(config.SelectionMode) {
+	case "sequential", "story":
+		return &sequentialSelector{}
+	case "events":
+		return &clusterSelector{}
+	case "shuffle":
+		return &shuffleSelector{}
+	case "weighted":
+		decay := config.RecencyDecayHours
+		if decay <= 0 {
+			decay = defaultRecencyDecayHours
+		}
+		return weightedSelector{decay: time.Duration(decay * float64(time.Hour))}
+	case "rated":
+		return &ratedSelector{imageDirectory: config.ImageDirectory}
+	default:
+		return randomSelector{}
+	}
+}
+
+var (
+	activeSelector imageSelector
+	selectorMutex  sync.Mutex // To ensure thread-safe access to `activeSelector` and its state
+)
+
+// setActiveSelector records the selector used by the rotation so it can be previewed
+func setActiveSelector(selector imageSelector) {
+	selectorMutex.Lock()
+	defer selectorMutex.Unlock()
+	activeSelector = selector
+}
+
+// nextImage advances the rotation's selector, guarding its state against a concurrent preview
+func nextImage(selector imageSelector, files []string) string {
+	selectorMutex.Lock()
+	defer selectorMutex.Unlock()
+	return selector.next(files)
+}
+
+// rotationFiles returns the images the rotation draws from, the event album while an event takeover
+// is active, otherwise the images of the schedule running now, the configured album or the whole library
+// suiting the orientation of the screen
+func rotationFiles(config *Config) ([]string, *slideshowEvent) {
+	if event := currentEvent(); event != nil {
+		return event.files, event
+	}
+
+	files := currentLibrary()
+	if schedule := activeSchedule(config.Schedules, config.Location, time.Now()); schedule != nil {
+		if matched := scheduledFiles(schedule, files, config.ImageDirectory); len(matched) > 0 {
+			return orientationFiles(matched, config.Orientation, config.OrientationMatch), nil
+		}
+	}
+	if config.Album != "" {
+		if matched := albumFiles(files, config.ImageDirectory, config.Album); len(matched) > 0 {
+			files = matched
+		}
+	}
+	return orientationFiles(files, config.Orientation, config.OrientationMatch), nil
+}
+
+// previewHandler returns the next images the rotation would choose, without advancing it.
+// Countdowns and intro slides are not included as they are decided as the rotation runs.
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	count := defaultPreviewCount
+	if value := r.URL.Query().Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "count must be a positive number", http.StatusBadRequest)
+			return
+		}
+		count = min(n, maxPreviewCount)
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	files, _ := rotationFiles(config)
+	if album := r.URL.Query().Get("album"); album != "" {
+		files = albumFiles(files, config.ImageDirectory, album)
+	}
+
+	selectorMutex.Lock()
+	selector := activeSelector
+	if selector != nil {
+		selector = selector.clone()
+	}
+	selectorMutex.Unlock()
+	if selector == nil {
+		// the rotation has not started yet, preview what it will start with
+		selector = newImageSelector(config)
+	}
+
+	type previewImage struct {
+		Path string `json:"path"`
+		URL  string `json:"url"`
+	}
+	images := []previewImage{}
+	if len(files) > 0 {
+		for range count {
+			file := selector.next(files)
+			images = append(images, previewImage{Path: file, URL: imageURL(file, config.ImageDirectory)})
+		}
+	}
+	writeJSON(w, struct {
+		Images []previewImage `json:"images"`
+	}{images})
+}
+
+// randomSelector picks a uniformly random image each time
+type randomSelector struct {
+	random *selectionRandom // nil for selectionRand, a clone draws from a copy
+}
+
+func (s randomSelector) next(files []string) string {
+	if len(files) == 0 {
+		return selectRandomImage(files)
+	}
+	return s.random.pick(files)
+}
+
+func (s randomSelector) clone() imageSelector {
+	return randomSelector{random: s.random.copy()}
+}
+
+// shuffleSelector walks a shuffled permutation of the files so no image is repeated until every image has been shown
+type shuffleSelector struct {
+	source []string // the list of files the order was built from
+	order  []string // images before pos have been shown in the current cycle
+	pos    int
+	random *selectionRandom // nil for selectionRand, a clone draws from a copy
+}
+
+func (s *shuffleSelector) next(files []string) string {
+	if len(files) == 0 {
+		return selectRandomImage(files)
+	}
+
+	// the library is replaced rather than modified in place, so a different slice means the files have changed
+	if len(files) != len(s.source) || &files[0] != &s.source[0] {
+		s.reconcile(files)
+	}
+
+	if s.pos >= len(s.order) {
+		// every image has been shown, start a new cycle without repeating the last image straight away
+		var last string
+		if s.pos > 0 {
+			last = s.order[s.pos-1]
+		}
+		s.order = make([]string, len(files))
+		copy(s.order, files)
+		s.random.shuffle(len(s.order), func(i, j int) { s.order[i], s.order[j] = s.order[j], s.order[i] })
+		if len(s.order) > 1 && s.order[0] == last {
+			swap := 1 + s.random.intn(len(s.order)-1)
+			s.order[0], s.order[swap] = s.order[swap], s.order[0]
+		}
+		s.pos = 0
+	}
+
+	image := s.order[s.pos]
+	s.pos++
+	return image
+}
+
+// reconcile carries the current cycle over to a new list of files, dropping images that have gone
+// and mixing new images into the part of the cycle still to be shown
+func (s *shuffleSelector) reconcile(files []string) {
+	current := make(map[string]bool, len(files))
+	for _, file := range files {
+		current[file] = true
+	}
+	known := make(map[string]bool, len(s.order))
+	for _, file := range s.order {
+		known[file] = true
+	}
+
+	var shown, remaining []string
+	for i, file := range s.order {
+		if !current[file] {
+			continue
+		}
+		if i < s.pos {
+			shown = append(shown, file)
+		} else {
+			remaining = append(remaining, file)
+		}
+	}
+	for _, file := range files {
+		if !known[file] {
+			remaining = append(remaining, file)
+		}
+	}
+	s.random.shuffle(len(remaining), func(i, j int) { remaining[i], remaining[j] = remaining[j], remaining[i] })
+
+	s.order = append(shown, remaining...)
+	s.pos = len(shown)
+	s.source = files
+}
+
+func (s *shuffleSelector) clone() imageSelector {
+	c := *s
+	c.random = s.random.copy()
+	return &c
+}
+
+// sequentialSelector walks the library in path order so each folder plays through as a story
+type sequentialSelector struct {
+	last string
+}
+
+func (s *sequentialSelector) next(files []string) string {
+	if len(files) == 0 {
+		return selectRandomImage(files)
+	}
+
+	/*
+		The position is found from the last path shown rather than an index, so the walk carries on
+		from the right place when the list of files changes underneath it.
+	*/
+	i := sort.SearchStrings(files, s.last)
+	if i < len(files) && files[i] == s.last {
+		i++
+	}
+	if i >= len(files) {
+		i = 0
+	}
+
+	s.last = files[i]
+	return s.last
+}
+
+func (s *sequentialSelector) clone() imageSelector {
+	c := *s
+	return &c
+}
+
+func (s *sequentialSelector) groupOf(image, imageDirectory string) string {
+	album, err := filepath.Rel(imageDirectory, filepath.Dir(image))
+	if err != nil {
+		return filepath.Dir(image)
+	}
+	return filepath.ToSlash(album)
+}
+
+func (s *sequentialSelector) introFor(image, imageDirectory string, library []string) (*folderIntro, string) {
+	return buildFolderIntro(image, imageDirectory, library)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// testFiles returns n made up image paths, in order
+func testFiles(n int) []string {
+	files := make([]string, n)
+	for i := range files {
+		files[i] = fmt.Sprintf("/photos/%03d.jpg", i)
+	}
+	return files
+}
+
+// nextImages returns the next n images chosen by a selector
+func nextImages(selector imageSelector, files []string, n int) []string {
+	images := make([]string, n)
+	for i := range images {
+		images[i] = selector.next(files)
+	}
+	return images
+}
+
+func TestShuffleSelector(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  int
+		cycles int
+	}{
+		{name: "one image", files: 1, cycles: 3},
+		{name: "two images", files: 2, cycles: 20},
+		{name: "many images", files: 50, cycles: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := testFiles(tt.files)
+			selector := &shuffleSelector{random: newSelectionRandom(1)}
+			var last string
+			for cycle := range tt.cycles {
+				images := nextImages(selector, files, len(files))
+				if len(files) > 1 && images[0] == last {
+					t.Errorf("cycle %d starts with %s, the last image of the cycle before", cycle, last)
+				}
+				last = images[len(images)-1]
+				slices.Sort(images)
+				if !slices.Equal(images, files) {
+					t.Fatalf("cycle %d showed %v, want every image once", cycle, images)
+				}
+			}
+		})
+	}
+
+}
+
+func TestShuffleSelectorReconcile(t *testing.T) {
+	tests := []struct {
+		name          string
+		order         []string
+		pos           int
+		files         []string
+		wantShown     []string // still counted as shown this cycle, in order
+		wantRemaining []string // still to be shown this cycle, in any order
+	}{
+		{
+			name:          "unchanged",
+			order:         []string{"a", "b", "c", "d"},
+			pos:           2,
+			files:         []string{"a", "b", "c", "d"},
+			wantShown:     []string{"a", "b"},
+			wantRemaining: []string{"c", "d"},
+		},
+		{
+			name:          "new images join the rest of the cycle",
+			order:         []string{"a", "b", "c"},
+			pos:           2,
+			files:         []string{"a", "b", "c", "d", "e"},
+			wantShown:     []string{"a", "b"},
+			wantRemaining: []string{"c", "d", "e"},
+		},
+		{
+			name:          "removed images are dropped",
+			order:         []string{"a", "b", "c", "d"},
+			pos:           2,
+			files:         []string{"b", "d"},
+			wantShown:     []string{"b"},
+			wantRemaining: []string{"d"},
+		},
+		{
+			name:          "added and removed",
+			order:         []string{"d", "a", "c", "b"},
+			pos:           3,
+			files:         []string{"b", "c", "e"},
+			wantShown:     []string{"c"},
+			wantRemaining: []string{"b", "e"},
+		},
+		{
+			name:          "finished cycle",
+			order:         []string{"b", "a"},
+			pos:           2,
+			files:         []string{"a", "b", "c"},
+			wantShown:     []string{"b", "a"},
+			wantRemaining: []string{"c"},
+		},
+		{
+			name:          "first library",
+			files:         []string{"a", "b", "c"},
+			wantRemaining: []string{"a", "b", "c"},
+		},
+		{
+			name:          "everything removed",
+			order:         []string{"a", "b"},
+			pos:           1,
+			files:         []string{"c"},
+			wantRemaining: []string{"c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector := &shuffleSelector{order: tt.order, pos: tt.pos, source: tt.order, random: newSelectionRandom(1)}
+			selector.reconcile(tt.files)
+
+			if got := selector.order[:selector.pos]; !slices.Equal(got, tt.wantShown) && len(got)+len(tt.wantShown) > 0 {
+				t.Errorf("shown = %v, want %v", got, tt.wantShown)
+			}
+			remaining := slices.Clone(selector.order[selector.pos:])
+			slices.Sort(remaining)
+			if !slices.Equal(remaining, tt.wantRemaining) {
+				t.Errorf("remaining = %v, want %v", remaining, tt.wantRemaining)
+			}
+			if len(selector.source) != len(tt.files) || &selector.source[0] != &tt.files[0] {
+				t.Errorf("source = %v, want the new files", selector.source)
+			}
+		})
+	}
+}
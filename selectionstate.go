@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// selectionStatePath is where the position of the selector is persisted so a restart carries on where it left off
+const selectionStatePath = "./randompic-selection.json"
+
+// selectionStateSaveInterval limits how often the position is written while running, to spare SD cards
+const selectionStateSaveInterval = time.Minute
+
+// persistentSelector is implemented by selectors with a position worth keeping across restarts
+type persistentSelector interface {
+	imageSelector
+	saveState() ([]byte, error)
+	restoreState(data []byte) error
+}
+
+// savedSelection is the persisted position of a selector, only restored into a selector of the same kind
+type savedSelection struct {
+	Kind  string          `json:"kind"`
+	Saved time.Time       `json:"saved"`
+	State json.RawMessage `json:"state"`
+}
+
+// selectionStateSaved is when the position was last written, guarded by selectorMutex
+var selectionStateSaved time.Time
+
+// saveSelectionState writes the position of the active selector to disk, at most once a minute unless force is set
+func saveSelectionState(force bool) {
+	selectorMutex.Lock()
+	defer selectorMutex.Unlock()
+
+	selector, ok := activeSelector.(persistentSelector)
+	if !ok || (!force && time.Since(selectionStateSaved) < selectionStateSaveInterval) {
+		return
+	}
+
+	state, err := selector.saveState()
+	if err != nil {
+		log.Printf("Error encoding selection state: %v", err)
+		return
+	}
+	data, err := json.Marshal(savedSelection{Kind: fmt.Sprintf("%T", selector), Saved: time.Now(), State: state})
+	if err != nil {
+		log.Printf("Error encoding selection state: %v", err)
+		return
+	}
+	if err := writeFileAtomic(selectionStatePath, data); err != nil {
+		log.Printf("Error saving selection state: %v", err)
+		return
+	}
+	selectionStateSaved = time.Now()
+}
+
+// restoreSelectionState loads the saved position into selector when it was saved by the same kind of selector
+func restoreSelectionState(selector imageSelector) {
+	persistent, ok := selector.(persistentSelector)
+	if !ok {
+		return
+	}
+
+	data, err := os.ReadFile(selectionStatePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading selection state: %v", err)
+		}
+		return
+	}
+	var saved savedSelection
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Error parsing selection state: %v", err)
+		return
+	}
+	if saved.Kind != fmt.Sprintf("%T", selector) {
+		// the selection mode has changed since it was saved
+		return
+	}
+
+	selectorMutex.Lock()
+	defer selectorMutex.Unlock()
+	if err := persistent.restoreState(saved.State); err != nil {
+		log.Printf("Error restoring selection state: %v", err)
+		return
+	}
+	log.Printf("Carrying on the slideshow from where it was at %s", saved.Saved.Format(time.RFC3339))
+}
+
+// lastShownState is the persisted position of the sequential and cluster selectors
+type lastShownState struct {
+	Last string `json:"last"`
+}
+
+func (s *sequentialSelector) saveState() ([]byte, error) {
+	return json.Marshal(lastShownState{Last: s.last})
+}
+
+func (s *sequentialSelector) restoreState(data []byte) error {
+	var state lastShownState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	s.last = state.Last
+	return nil
+}
+
+func (s *clusterSelector) saveState() ([]byte, error) {
+	return json.Marshal(lastShownState{Last: s.last})
+}
+
+func (s *clusterSelector) restoreState(data []byte) error {
+	var state lastShownState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	s.last = state.Last
+	return nil
+}
+
+// shuffleState is the persisted cycle of the shuffle selector, the list of files is reconciled against
+// the library on the first selection after it is restored
+type shuffleState struct {
+	Order []string `json:"order"`
+	Pos   int      `json:"pos"`
+}
+
+func (s *shuffleSelector) saveState() ([]byte, error) {
+	return json.Marshal(shuffleState{Order: s.order, Pos: s.pos})
+}
+
+func (s *shuffleSelector) restoreState(data []byte) error {
+	var state shuffleState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	s.order, s.pos, s.source = state.Order, min(state.Pos, len(state.Order)), nil
+	return nil
+}
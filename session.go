@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie      = "randompic_session"
+	sessionIdleTimeout = time.Hour // a screen's slideshow is forgotten once it hasn't loaded the page for this long
+	maxSessions        = 256       // the oldest slideshows are forgotten beyond this, e.g. for clients without cookies
+	sessionDueEarly    = 500 * time.Millisecond
+)
+
+// screenSession is the slideshow of one browser when independentScreens is set
+type screenSession struct {
+	selector imageSelector
+	current  slide
+	shownAt  time.Time
+	lastSeen time.Time
+}
+
+var (
+	sessions      = map[string]*screenSession{}
+	sessionsMutex sync.Mutex // To ensure thread-safe access to `sessions` and the state of each session
+)
+
+// sessionID returns the browser's session, issuing a new session cookie when it has none
+func sessionID(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// sessionSlide returns the slide a browser should show in its own slideshow, moving it on to a newly chosen
+// image once it has been shown for the display interval.  Pages the browser shows with different query overrides
+// each have their own slideshow, told apart by variant.
+func sessionSlide(w http.ResponseWriter, r *http.Request, config *Config, variant string) slide {
+	id := sessionID(w, r) + variant
+	files, _ := rotationFiles(config)
+	now := time.Now()
+
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	pruneSessions(now)
+
+	session := sessions[id]
+	if session == nil {
+		session = &screenSession{selector: newImageSelector(config)}
+		if sequential, ok := session.selector.(*sequentialSelector); ok {
+			// start each screen at a different point, or screens playing the library in order would match
+			sequential.last = selectRandomImage(files)
+		}
+		sessions[id] = session
+	}
+	session.lastSeen = now
+
+	// the page asks again once the interval is up, so allow for it arriving a little early
+	due := session.shownAt.Add(time.Duration(pageDisplaySeconds(config))*time.Second - sessionDueEarly)
+	if session.current.Image == "" || !now.Before(due) || !stillShowable(session.current.Image, config) {
+		if image := session.selector.next(files); image != "" {
+			session.current = slide{Image: image}
+			session.shownAt = now
+			recordShown(image)
+		}
+	}
+	return session.current
+}
+
+// pruneSessions forgets the slideshows of browsers that have gone away, the caller must hold sessionsMutex
+func pruneSessions(now time.Time) {
+	var oldestID string
+	var oldest time.Time
+	for id, session := range sessions {
+		if now.Sub(session.lastSeen) > sessionIdleTimeout {
+			delete(sessions, id)
+		} else if oldestID == "" || session.lastSeen.Before(oldest) {
+			oldestID, oldest = id, session.lastSeen
+		}
+	}
+	if len(sessions) >= maxSessions {
+		delete(sessions, oldestID)
+	}
+}
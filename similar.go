@@ -0,0 +1,457 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"math/bits"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	similarHashesPath       = "./randompic-similar.json"
+	defaultSimilarDistance  = 6
+	similarHashImageSize    = 64  // longest side in pixels of the copy a photo is hashed from
+	similarHashSaveInterval = 100 // photos hashed between saves, so a long first pass isn't lost on a restart
+	similarHashGridWidth    = 9
+	similarHashGridHeight   = 8
+	maxSimilarDistance      = 32
+	similarUnhashable       = "" // recorded for a photo that couldn't be decoded, so it isn't tried again
+)
+
+// NearDuplicateConfig groups visually near identical photos and shows one photo of each group
+type NearDuplicateConfig struct {
+	MaxDistance int `json:"maxDistance" desc:"How many of the 64 bits of two photos' perceptual hashes may differ for them to count as the same shot, higher groups more loosely" default:"6"`
+}
+
+// similarHash is the perceptual hash of a photo.  Size and ModTime are recorded so a changed photo is hashed again.
+type similarHash struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	Hash    string `json:"hash"` // 16 hex digits, empty when the photo couldn't be decoded
+}
+
+// similarHashes are the perceptual hashes of the photos, keyed by absolute path
+var similarHashes = &jsonStore[similarHash]{path: similarHashesPath, what: "perceptual hashes"}
+
+var similarHashing atomic.Bool // set while photos are being hashed in the background
+
+var (
+	similarGroups      [][]string    // each group of near identical photos found in the library, the one shown first
+	similarPhotos      *similarIndex // the hashed photos of the library, so a photo added later is only compared with those sharing a band
+	similarGroupsMutex sync.Mutex    // To ensure thread-safe access to `similarGroups` and `similarPhotos`
+)
+
+// perceptualHash returns the difference hash of a photo: it is shrunk to a grid of 9 by 8 shades of grey and each
+// bit records whether a cell is brighter than the one to its right.  Re-saved, rescaled and slightly changed copies
+// of a photo get the same or nearly the same hash.
+func perceptualHash(file string) (uint64, error) {
+	width, height, err := probeDimensions(file)
+	if err != nil {
+		return 0, err
+	}
+	scale := min(1, float64(similarHashImageSize)/float64(max(width, height, 1)))
+	small, err := renderFrame(file, max(int(float64(width)*scale), 1), max(int(float64(height)*scale), 1), "contain")
+	if err != nil {
+		return 0, err
+	}
+
+	// average the shades of grey of the pixels in each cell of the grid
+	var grid [similarHashGridHeight][similarHashGridWidth]float64
+	var counts [similarHashGridHeight][similarHashGridWidth]int
+	smallWidth, smallHeight := small.Rect.Dx(), small.Rect.Dy()
+	for y := range smallHeight {
+		row := y * similarHashGridHeight / smallHeight
+		for x := range smallWidth {
+			column := x * similarHashGridWidth / smallWidth
+			i := small.PixOffset(x, y)
+			grid[row][column] += 0.299*float64(small.Pix[i]) + 0.587*float64(small.Pix[i+1]) + 0.114*float64(small.Pix[i+2])
+			counts[row][column]++
+		}
+	}
+
+	var hash uint64
+	for row := range similarHashGridHeight {
+		for column := range similarHashGridWidth - 1 {
+			left := grid[row][column] / float64(max(counts[row][column], 1))
+			right := grid[row][column+1] / float64(max(counts[row][column+1], 1))
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// cachedSimilarHash returns the perceptual hash of a photo when it has been hashed since it last changed
+func cachedSimilarHash(file string) (hash uint64, hashed, ok bool) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, false, false
+	}
+	cached, found := similarHashes.get(file)
+	if !found || cached.Size != info.Size() || cached.ModTime != info.ModTime().Unix() {
+		return 0, false, false
+	}
+	if cached.Hash == similarUnhashable {
+		return 0, true, false
+	}
+	hash, err = strconv.ParseUint(cached.Hash, 16, 64)
+	return hash, true, err == nil
+}
+
+// hashSimilarPhotos works out the perceptual hashes of photos in the background, one at a time as it decodes each
+// photo, and calls done once it is done so the new hashes are grouped
+func hashSimilarPhotos(files []string, done func()) {
+	if !similarHashing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		start := time.Now()
+		hashed := 0
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			entry := similarHash{Size: info.Size(), ModTime: info.ModTime().Unix(), Hash: similarUnhashable}
+			if hash, err := perceptualHash(file); err != nil {
+				log.Printf("Error hashing %s for near duplicates: %v", file, err)
+			} else {
+				entry.Hash = fmt.Sprintf("%016x", hash)
+			}
+
+			similarHashes.set(file, entry)
+			hashed++
+			if hashed%similarHashSaveInterval == 0 {
+				saveSimilarHashes()
+			}
+		}
+		saveSimilarHashes()
+		log.Printf("Hashed %d photos to find near duplicates in %s", hashed, time.Since(start).Round(time.Second))
+		similarHashing.Store(false)
+		done()
+	}()
+}
+
+// groupSimilar returns the groups of photos whose hashes differ by at most maxDistance bits, including photos
+// that are only near identical through others, as in a burst.  The hash is split into maxDistance+1 bands, and
+// as two photos within maxDistance must match exactly in at least one band only photos sharing a band are compared.
+func groupSimilar(hashes map[string]uint64, maxDistance int) [][]string {
+	files := make([]string, 0, len(hashes))
+	for file := range hashes {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	parent := make([]int, len(files))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	bands := maxDistance + 1
+	for band := range bands {
+		buckets := map[uint64][]int{}
+		for i, file := range files {
+			key := similarBand(hashes[file], band, bands)
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, bucket := range buckets {
+			for a := range bucket {
+				for _, b := range bucket[a+1:] {
+					i, j := bucket[a], b
+					if root(i) != root(j) && bits.OnesCount64(hashes[files[i]]^hashes[files[j]]) <= maxDistance {
+						parent[root(i)] = root(j)
+					}
+				}
+			}
+		}
+	}
+
+	members := map[int][]string{}
+	for i, file := range files {
+		members[root(i)] = append(members[root(i)], file)
+	}
+	var groups [][]string
+	for _, group := range members {
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// similarBand returns the bits of a hash in one of bands equal bands
+func similarBand(hash uint64, band, bands int) uint64 {
+	from, to := band*64/bands, (band+1)*64/bands
+	return hash >> from & (uint64(1)<<(to-from) - 1)
+}
+
+// similarIndex holds the perceptual hashes of photos by band, the way groupSimilar compares them, so the photos
+// near identical to one more photo are found without comparing it with every photo
+type similarIndex struct {
+	maxDistance int
+	hashes      map[string]uint64
+	bands       []map[uint64][]string
+}
+
+func newSimilarIndex(hashes map[string]uint64, maxDistance int) *similarIndex {
+	index := &similarIndex{maxDistance: maxDistance, hashes: map[string]uint64{}, bands: make([]map[uint64][]string, maxDistance+1)}
+	for band := range index.bands {
+		index.bands[band] = map[uint64][]string{}
+	}
+	for file, hash := range hashes {
+		index.add(file, hash)
+	}
+	return index
+}
+
+// add records the hash of a photo, replacing the one recorded before
+func (index *similarIndex) add(file string, hash uint64) {
+	if old, ok := index.hashes[file]; ok && old == hash {
+		return
+	}
+	index.hashes[file] = hash
+	for band, buckets := range index.bands {
+		key := similarBand(hash, band, len(index.bands))
+		buckets[key] = append(buckets[key], file)
+	}
+}
+
+// near returns the other photos whose hashes differ from hash by at most maxDistance bits
+func (index *similarIndex) near(file string, hash uint64) []string {
+	var near []string
+	seen := map[string]bool{file: true}
+	for band, buckets := range index.bands {
+		for _, other := range buckets[similarBand(hash, band, len(index.bands))] {
+			if !seen[other] && bits.OnesCount64(index.hashes[other]^hash) <= index.maxDistance {
+				near = append(near, other)
+			}
+			seen[other] = true
+		}
+	}
+	return near
+}
+
+// similarDistance returns the configured maxDistance, or the default when it is invalid
+func similarDistance(config *NearDuplicateConfig) int {
+	if config.MaxDistance <= 0 || config.MaxDistance > maxSimilarDistance {
+		return defaultSimilarDistance
+	}
+	return config.MaxDistance
+}
+
+// collapseSimilar keeps one photo of each group of near identical photos in the library, the largest
+// file as it usually holds the most detail.  Photos not hashed yet are kept as they are and hashed in the background.
+func collapseSimilar(files []string, config *NearDuplicateConfig) []string {
+	maxDistance := similarDistance(config)
+
+	hashes := map[string]uint64{}
+	var unhashed []string
+	for _, file := range files {
+		if isVideo(file) {
+			continue
+		}
+		hash, hashed, ok := cachedSimilarHash(file)
+		if !hashed {
+			unhashed = append(unhashed, file)
+		} else if ok {
+			hashes[file] = hash
+		}
+	}
+	if len(unhashed) > 0 {
+		log.Printf("Hashing %d photos in the background to find near duplicates", len(unhashed))
+		hashSimilarPhotos(unhashed, func() { rescanLibrary() })
+	}
+
+	groups := groupSimilar(hashes, maxDistance)
+	size := func(file string) int64 {
+		if info, err := os.Stat(file); err == nil {
+			return info.Size()
+		}
+		return 0
+	}
+	for _, group := range groups {
+		slices.SortFunc(group, func(a, b string) int {
+			return cmp.Or(cmp.Compare(size(b), size(a)), cmp.Compare(a, b))
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	index := newSimilarIndex(hashes, maxDistance)
+	similarGroupsMutex.Lock()
+	similarGroups, similarPhotos = groups, index
+	similarGroupsMutex.Unlock()
+	if len(groups) == 0 {
+		return files
+	}
+
+	drop := map[string]bool{}
+	for _, group := range groups {
+		for _, file := range group[1:] {
+			drop[file] = true
+		}
+	}
+	kept := make([]string, 0, len(files)-len(drop))
+	for _, file := range files {
+		if !drop[file] {
+			kept = append(kept, file)
+		}
+	}
+	log.Printf("Left out %d near duplicates of %d photos in the library", len(drop), len(groups))
+	return kept
+}
+
+// addSimilar records which of the photos added to the library since the last scan are near identical to photos
+// already in it, comparing each only with the photos sharing a band of its hash and changing only the groups it joins.
+// It returns the added photos to show, the largest of each group, and the photos shown until now that an added photo
+// takes the place of.  Photos not hashed yet are kept as they are and hashed in the background.
+func addSimilar(added []string, config *Config) (kept, replaced []string) {
+	maxDistance := similarDistance(config.NearDuplicates)
+	similarGroupsMutex.Lock()
+	defer similarGroupsMutex.Unlock()
+	if similarPhotos == nil || similarPhotos.maxDistance != maxDistance {
+		similarPhotos = newSimilarIndex(nil, maxDistance)
+	}
+
+	// copy the groups so readers holding the old ones are unaffected
+	groups := slices.Clone(similarGroups)
+	library := currentLibrary()
+	size := func(file string) int64 {
+		hash, _ := similarHashes.get(file)
+		return hash.Size
+	}
+	var unhashed []string
+	left := 0
+	for _, file := range added {
+		if isVideo(file) {
+			kept = append(kept, file)
+			continue
+		}
+		hash, hashed, ok := cachedSimilarHash(file)
+		if !hashed {
+			// a photo that has gone since is left for the next scan
+			if _, err := os.Stat(file); err == nil {
+				unhashed = append(unhashed, file)
+			}
+		}
+		if !ok {
+			kept = append(kept, file)
+			continue
+		}
+
+		// photos no longer in the library only count when they were left out as near duplicates, not as copies
+		var near []string
+		for _, other := range similarPhotos.near(file, hash) {
+			_, shown := slices.BinarySearch(library, other)
+			grouped := slices.ContainsFunc(groups, func(group []string) bool { return slices.Contains(group, other) })
+			if _, err := os.Stat(other); (shown || grouped) && err == nil && !excludedFile(other, config) {
+				near = append(near, other)
+			}
+		}
+		similarPhotos.add(file, hash)
+		if len(near) == 0 {
+			kept = append(kept, file)
+			continue
+		}
+
+		// merge the groups of the photos it is near identical to, whose first photos were the ones shown, and
+		// the photos in no group yet
+		group := append(near, file)
+		var shown []string
+		merged := map[string]bool{}
+		for i := 0; i < len(groups); i++ {
+			if slices.ContainsFunc(groups[i], func(member string) bool { return slices.Contains(near, member) }) {
+				for _, member := range groups[i] {
+					merged[member] = true
+				}
+				group = append(group, groups[i]...)
+				shown = append(shown, groups[i][0])
+				groups = slices.Delete(groups, i, i+1)
+				i--
+			}
+		}
+		for _, other := range near {
+			if !merged[other] {
+				shown = append(shown, other)
+			}
+		}
+		slices.Sort(group)
+		group = slices.Compact(group)
+		slices.SortFunc(group, func(a, b string) int {
+			return cmp.Or(cmp.Compare(size(b), size(a)), cmp.Compare(a, b))
+		})
+		groups = append(groups, group)
+
+		if group[0] == file {
+			kept = append(kept, file)
+		} else {
+			left++
+		}
+		for _, other := range shown {
+			if other != group[0] {
+				replaced = append(replaced, other)
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	similarGroups = groups
+	if len(unhashed) > 0 {
+		log.Printf("Hashing %d added photos in the background to find near duplicates", len(unhashed))
+		hashSimilarPhotos(unhashed, func() { collapseHashedPhotos(unhashed) })
+	}
+	if left > 0 {
+		log.Printf("Left out %d added near duplicates of photos in the library", left)
+	}
+	return kept, replaced
+}
+
+// collapseHashedPhotos leaves out the photos added to the library that turn out to be near duplicates once they
+// have been hashed
+func collapseHashedPhotos(files []string) {
+	config, err := loadConfig(configPath)
+	if err != nil || config.NearDuplicates == nil {
+		return
+	}
+	kept, removed := addSimilar(files, config)
+	for _, file := range files {
+		if !slices.Contains(kept, file) {
+			removed = append(removed, file)
+		}
+	}
+	if len(removed) > 0 {
+		updateLibrary(nil, removed, nil)
+	}
+}
+
+// nearDuplicatesProblems checks the near duplicates settings, which fall back to the default distance when invalid
+func nearDuplicatesProblems(config *Config) []string {
+	if config.NearDuplicates == nil {
+		return nil
+	}
+	if distance := config.NearDuplicates.MaxDistance; distance < 0 || distance > maxSimilarDistance {
+		return []string{fmt.Sprintf("nearDuplicates maxDistance must be between 0 and %d, %d is used", maxSimilarDistance, defaultSimilarDistance)}
+	}
+	return nil
+}
+
+// saveSimilarHashes writes the perceptual hashes to disk when they have changed
+func saveSimilarHashes() {
+	if err := similarHashes.save(); err != nil {
+		log.Printf("Error saving perceptual hashes: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// sniffLength is how much of a file is read to tell what it holds, as much as http.DetectContentType looks at
+const sniffLength = 512
+
+// heifBrands are the ISO base media file brands of HEIC and HEIF photos
+var heifBrands = []string{"heic", "heix", "heim", "heis", "mif1", "msf1"}
+
+// sniffContentType returns the MIME type of a file from its first bytes, adding the formats the app shows that
+// http.DetectContentType doesn't know: HEIC, AVIF, QuickTime and the TIFF structure RAW files are built on
+func sniffContentType(header []byte) string {
+	if mime := http.DetectContentType(header); mime != "application/octet-stream" {
+		return mime
+	}
+	switch {
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return "image/tiff"
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		brand := string(header[8:12])
+		switch {
+		case strings.HasPrefix(brand, "avi"):
+			return "image/avif"
+		case strings.HasPrefix(brand, "qt"):
+			return "video/quicktime"
+		case strings.HasPrefix(brand, "iso"), strings.HasPrefix(brand, "M4V"):
+			return "video/mp4"
+		}
+		for _, heif := range heifBrands {
+			if brand == heif {
+				return "image/heic"
+			}
+		}
+	}
+	return "application/octet-stream"
+}
+
+// sniffedShowable reports whether the first bytes of a file show it holds what the slideshow can show as the kind
+// of file its extension makes it: a HEIC or RAW photo, a clip, or otherwise an image browsers show, whatever its
+// extension or without one
+func sniffedShowable(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false
+	}
+
+	mime := sniffContentType(header[:n])
+	switch {
+	case isHEIC(file):
+		return mime == "image/heic"
+	case isRAW(file):
+		return mime == "image/tiff"
+	case isVideo(file):
+		return strings.HasPrefix(mime, "video/")
+	}
+	// browsers can't show HEIC or TIFF, and a clip without its extension would be put in an image
+	return strings.HasPrefix(mime, "image/") && mime != "image/heic" && mime != "image/tiff"
+}
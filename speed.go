@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"randompic/client"
+)
+
+const (
+	defaultSpeedRampSeconds     = 30
+	defaultSpeedDurationMinutes = 60
+)
+
+// speedChange temporarily changes the display interval, ramping smoothly to the new interval and back again
+type speedChange = client.SpeedChange
+
+var (
+	activeSpeed  *speedChange
+	speedMutex   sync.Mutex               // To ensure thread-safe access to `activeSpeed`
+	speedChanged = make(chan struct{}, 1) // wakes the image updater so a new speed applies straight away
+)
+
+var (
+	nextSlideAt    time.Time  // when the image updater next moves on, zero while paused or held until released
+	nextSlideMutex sync.Mutex // To ensure thread-safe access to `nextSlideAt`
+)
+
+// setNextSlideAt records when the image updater next moves on
+func setNextSlideAt(at time.Time) {
+	nextSlideMutex.Lock()
+	nextSlideAt = at
+	nextSlideMutex.Unlock()
+}
+
+// getNextSlideAt returns when the image updater next moves on, zero when it isn't known
+func getNextSlideAt() time.Time {
+	nextSlideMutex.Lock()
+	defer nextSlideMutex.Unlock()
+	return nextSlideAt
+}
+
+// notifySpeedChanged wakes the image updater without blocking when it is already due to wake
+func notifySpeedChanged() {
+	select {
+	case speedChanged <- struct{}{}:
+	default:
+	}
+}
+
+// currentSpeed returns the active speed change, clearing it once it has ramped back to the normal interval
+func currentSpeed() *speedChange {
+	speedMutex.Lock()
+	defer speedMutex.Unlock()
+
+	if activeSpeed != nil && time.Now().After(activeSpeed.Until.Add(time.Duration(activeSpeed.RampSeconds*float64(time.Second)))) {
+		log.Println("Speed change ended, returning to the normal display interval")
+		activeSpeed = nil
+	}
+	return activeSpeed
+}
+
+// effectiveInterval returns the display interval at the given time, moving linearly between the normal
+// interval and the requested one over the ramp at either end of a speed change
+func effectiveInterval(normal time.Duration, now time.Time) time.Duration {
+	change := currentSpeed()
+	if change == nil {
+		return normal
+	}
+
+	target := time.Duration(change.IntervalSeconds * float64(time.Second))
+	ramp := time.Duration(change.RampSeconds * float64(time.Second))
+
+	var progress float64 // 0 at the normal interval, 1 at the requested interval
+	switch {
+	case now.Before(change.Start):
+		progress = 0
+	case ramp > 0 && now.Before(change.Start.Add(ramp)):
+		progress = float64(now.Sub(change.Start)) / float64(ramp)
+	case !now.After(change.Until):
+		progress = 1
+	case ramp > 0:
+		progress = 1 - float64(now.Sub(change.Until))/float64(ramp)
+	}
+	progress = min(max(progress, 0), 1)
+
+	return normal + time.Duration(float64(target-normal)*progress)
+}
+
+// waitForNextSlide sleeps until the slide shown at shownAt has been displayed for the current interval.  It returns
+// early with reload set when the profile is switched, or with the requested step when the slideshow is navigated
+// through the API.  While a speed change is active the interval is checked every second so a ramp takes effect part
+// way through a long slide.  The wait is held while the slideshow is paused or the image is held, restarting the
+// interval once resumed or released.  A profile switch during a hold is applied once the hold ends.
+func waitForNextSlide(shownAt time.Time, interval func() time.Duration) (reload bool, step int) {
+	for {
+		if hold := currentHold(); hold != nil {
+			var expired <-chan time.Time // never fires when held until released
+			if hold.Until != nil {
+				expired = time.After(time.Until(*hold.Until))
+				setNextSlideAt(hold.Until.Add(interval()))
+			} else {
+				setNextSlideAt(time.Time{})
+			}
+			select {
+			case <-expired:
+			case <-pauseChanged:
+			}
+			if currentHold() == nil {
+				shownAt = time.Now()
+			}
+			continue
+		}
+
+		if slideshowPaused.Load() {
+			setNextSlideAt(time.Time{})
+			select {
+			case <-pauseChanged:
+				if !slideshowPaused.Load() {
+					shownAt = time.Now()
+				}
+			case <-profileSwitched:
+				return true, 0
+			case step := <-navigateRequests:
+				return false, step
+			}
+			continue
+		}
+
+		due := shownAt.Add(interval())
+		setNextSlideAt(due)
+		remaining := time.Until(due)
+		if remaining <= 0 {
+			return false, 0
+		}
+		if currentSpeed() != nil {
+			remaining = min(remaining, time.Second)
+		}
+
+		select {
+		case <-time.After(remaining):
+		case <-speedChanged:
+		case <-pauseChanged:
+		case <-profileSwitched:
+			return true, 0
+		case step := <-navigateRequests:
+			return false, step
+		}
+	}
+}
+
+// speedHandler reads (GET), starts (POST) or ends (DELETE) a temporary change of the display interval
+func speedHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, currentSpeed())
+
+	case http.MethodDelete:
+		// ramp back from wherever the interval currently is rather than jumping straight back
+		speedMutex.Lock()
+		if activeSpeed != nil && time.Now().Before(activeSpeed.Until) {
+			activeSpeed.Until = time.Now()
+		}
+		speedMutex.Unlock()
+		notifySpeedChanged()
+		log.Println("Speed change cancelled")
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodPost:
+		var req client.SpeedRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.IntervalSeconds < 1 {
+			http.Error(w, "intervalSeconds must be at least 1", http.StatusBadRequest)
+			return
+		}
+
+		change := &speedChange{
+			IntervalSeconds: req.IntervalSeconds,
+			RampSeconds:     defaultSpeedRampSeconds,
+			Start:           time.Now(),
+		}
+		if req.RampSeconds != nil && *req.RampSeconds >= 0 {
+			change.RampSeconds = *req.RampSeconds
+		}
+		duration := req.DurationMinutes
+		if duration <= 0 {
+			duration = defaultSpeedDurationMinutes
+		}
+		change.Until = change.Start.Add(time.Duration(duration * float64(time.Minute)))
+
+		speedMutex.Lock()
+		activeSpeed = change
+		speedMutex.Unlock()
+		notifySpeedChanged()
+		log.Printf("Display interval changing to %gs until %s", change.IntervalSeconds, change.Until.Format(time.RFC3339))
+
+		writeJSON(w, change)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
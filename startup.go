@@ -0,0 +1,72 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// startupImageLast is the startupImage value that shows the image displayed last before the app was stopped
+const startupImageLast = "last"
+
+// startupImageFile is the image shown while the library loads, resolved once when the app starts
+var startupImageFile string
+
+// resolveStartupImage finds the file named by startupImage, empty when none is configured or it can't be read
+func resolveStartupImage(config *Config) string {
+	file := config.StartupImage
+	switch {
+	case file == "":
+		return ""
+	case file == startupImageLast:
+		if file = mostRecentlyShown(); file == "" {
+			return ""
+		}
+	case !filepath.IsAbs(file):
+		file = filepath.Join(config.ImageDirectory, filepath.FromSlash(file))
+	}
+
+	if isPrivate(file, config.ImageDirectory) {
+		return ""
+	}
+	// on a cold start a network share may not be mounted yet, so an image on it might not be there
+	if _, err := os.Stat(file); err != nil {
+		log.Printf("Not showing a startup image: %v", err)
+		return ""
+	}
+	return file
+}
+
+// renderLoadingPage serves the page shown until the first scan of the image directory has completed,
+// over the startup image when there is one
+func renderLoadingPage(w http.ResponseWriter, messages *messageCatalog) {
+	tmplParsed, err := template.New("loading.html").Funcs(messages.funcs()).ParseFS(staticFiles, "static/loading.html")
+	if err != nil {
+		http.Error(w, "Error parsing template: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error parsing template: %v", err)
+		return
+	}
+
+	data := struct {
+		ImageURL string
+	}{}
+	if startupImageFile != "" {
+		data.ImageURL = "/startup-image"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmplParsed.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+// startupImageHandler serves the startup image, which may be outside the image directory
+func startupImageHandler(w http.ResponseWriter, r *http.Request) {
+	if startupImageFile == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, startupImageFile)
+}
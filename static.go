@@ -0,0 +1,31 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// staticFiles holds everything built into the app from static: the page templates and their themes, the message
+// catalogs, and the stylesheets, scripts, icons and fonts the pages load from /static/
+//
+//go:embed static
+var staticFiles embed.FS
+
+// staticHandler serves the stylesheets, scripts, icons and fonts built into the app.  The pages in static are
+// templates written out by their own handlers and the message catalogs are given to the pages that need them, so
+// neither is served as it is, and directories aren't listed.
+func staticHandler() http.Handler {
+	files := http.FileServerFS(staticFiles)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		info, err := fs.Stat(staticFiles, name)
+		if err != nil || info.IsDir() || path.Ext(name) == ".html" || strings.HasPrefix(name, "static/messages/") {
+			http.NotFound(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
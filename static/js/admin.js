@@ -0,0 +1,449 @@
+// Script of the admin page, which sets language and messages before loading it
+
+// Text in the configured language, with {0}, {1}... replaced by the arguments that follow the key
+function t(key) {
+    var text = messages.hasOwnProperty(key) ? messages[key] : key;
+    for (var i = 1; i < arguments.length; i++) {
+        text = text.split("{" + (i - 1) + "}").join(arguments[i]);
+    }
+    return text;
+}
+
+// Shows the message of an API error response
+function alertError(resp) {
+    return resp.json().then(function (body) { alert(body.error); });
+}
+
+var suggestedPreset = null;
+
+function validateConfig() {
+    var text = document.getElementById("config-text").value;
+    var request = text ? fetch("/api/config/validate", { method: "POST", body: text }) : fetch("/api/config/validate");
+    request.then(function (resp) { return resp.json(); }).then(function (result) {
+        document.getElementById("config-result").textContent = result.valid ? t("admin.config.valid") : result.problems.join("\n");
+    });
+}
+
+function schemaRows(table, fields, prefix) {
+    fields.forEach(function (field) {
+        var row = table.insertRow();
+        var type = field.type === "array" && field.items ? field.items.type + "[]" : field.type;
+        var details = field.description || "";
+        if (field.enum) {
+            details += " (" + field.enum.join(", ") + ")";
+        }
+        if (field.default !== undefined) {
+            details += " " + t("admin.config.default", JSON.stringify(field.default));
+        }
+        if (field.secret) {
+            details += " " + t("admin.config.secret");
+        }
+        [prefix + field.name + (field.required ? " *" : ""), type, details].forEach(function (text) {
+            row.insertCell().textContent = text;
+        });
+        var nested = field.fields || (field.items && field.items.fields);
+        if (nested) {
+            schemaRows(table, nested, prefix + field.name + ".");
+        }
+    });
+}
+
+function loadSchema() {
+    fetch("/api/config/schema").then(function (resp) { return resp.json(); }).then(function (schema) {
+        schemaRows(document.getElementById("config-options"), schema.fields, "");
+    });
+}
+
+function saveNote() {
+    var album = encodeURIComponent(document.getElementById("note-album").value);
+    var body = {
+        text: document.getElementById("note-text").value,
+        audio: document.getElementById("note-audio").value
+    };
+    fetch("/api/notes?album=" + album, { method: "POST", body: JSON.stringify(body) }).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        alert(t("admin.notes.saved"));
+    });
+}
+
+function deleteNote() {
+    var album = encodeURIComponent(document.getElementById("note-album").value);
+    fetch("/api/notes?album=" + album, { method: "DELETE" }).then(function () {
+        alert(t("admin.notes.removed"));
+    });
+}
+
+function refreshHealth() {
+    fetch("/healthz").then(function (resp) { return resp.json(); }).then(function (health) {
+        var key = "admin.health." + health.directory.state;
+        var message = messages.hasOwnProperty(key) ? messages[key] : health.directory.state;
+        if (health.directory.error) {
+            message += " (" + health.directory.error + ")";
+        }
+        if (health.lastScan.state && health.lastScan.state !== "ok") {
+            message += " " + t("admin.health.scanFailed", health.lastScan.error);
+        }
+        message += " " + t("admin.health.images", health.images);
+        document.getElementById("library-health").textContent = message;
+    });
+}
+
+function rescanLibrary() {
+    var status = document.getElementById("library-status");
+    status.textContent = t("admin.library.rescanning");
+    fetch("/api/rescan", { method: "POST" }).then(function (resp) { return resp.json(); }).then(function (result) {
+        status.textContent = t("admin.library.rescanned", result.images);
+        refreshHealth();
+    });
+}
+
+function collectCaches() {
+    var status = document.getElementById("library-status");
+    status.textContent = t("admin.library.cleaning");
+    fetch("/api/gc", { method: "POST" }).then(function (resp) { return resp.json(); }).then(function (result) {
+        if (result.lastSkipped) {
+            status.textContent = t("admin.library.cleanSkipped", result.lastSkipped);
+        } else {
+            status.textContent = t("admin.library.cleaned", result.lastRemoved, Math.round(result.lastReclaimedBytes / 1024));
+        }
+    });
+}
+
+function analyzeAspect() {
+    var screen = encodeURIComponent(document.getElementById("aspect-screen").value);
+    var resolution = encodeURIComponent(document.getElementById("aspect-resolution").value);
+    fetch("/api/aspect?screen=" + screen + "&resolution=" + resolution).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        return resp.json().then(function (report) {
+            var lines = [
+                t("admin.aspect.summary", report.images, report.portrait, report.landscape, report.square, report.unknown)
+            ];
+            report.buckets.forEach(function (bucket) {
+                lines.push("  " + bucket.label + ": " + bucket.count);
+            });
+            lines.push(t("admin.aspect.medianCrop", Math.round(report.medianCrop * 100)));
+            if (report.current) {
+                lines.push(t("admin.aspect.current", report.current.fitMode, Math.round(report.current.maxCrop * 100)));
+            }
+            lines.push(t("admin.aspect.suggested", report.suggested.fitMode, Math.round(report.suggested.maxCrop * 100)));
+            document.getElementById("aspect-report").textContent = lines.join("\n");
+            suggestedPreset = report.suggested;
+            document.getElementById("aspect-apply").disabled = false;
+        });
+    });
+}
+
+function applyAspect() {
+    var screen = encodeURIComponent(document.getElementById("aspect-screen").value);
+    fetch("/api/aspect?screen=" + screen, { method: "POST", body: JSON.stringify(suggestedPreset) }).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        analyzeAspect();
+    });
+}
+
+function refreshHistory() {
+    fetch("/api/history?limit=10").then(function (resp) { return resp.json(); }).then(function (entries) {
+        var list = document.getElementById("history-list");
+        list.innerHTML = "";
+        entries.forEach(function (entry) {
+            var item = document.createElement("li");
+            var link = document.createElement("a");
+            link.href = entry.url;
+            link.target = "_blank";
+            link.textContent = entry.image;
+            item.appendChild(link);
+            var shownAt = new Date(entry.shownAt).toLocaleTimeString(language);
+            item.appendChild(document.createTextNode(" " + (entry.kind === "photo" ?
+                t("admin.history.shownAt", shownAt) : t("admin.history.shownAtKind", shownAt, entry.kind))));
+            list.appendChild(item);
+        });
+    });
+}
+
+function navigate(direction) {
+    fetch("/api/" + direction, { method: "POST" }).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        // the rotation moves on in the background, give it a moment before listing the new slide
+        setTimeout(refreshHistory, 500);
+    });
+}
+
+function showHold(hold) {
+    var status = document.getElementById("hold-status");
+    if (!hold) {
+        status.textContent = t("admin.hold.none");
+    } else if (hold.until) {
+        status.textContent = t("admin.hold.until", hold.image, new Date(hold.until).toLocaleTimeString(language));
+    } else {
+        status.textContent = t("admin.hold.released", hold.image);
+    }
+}
+
+function refreshHold() {
+    fetch("/api/hold").then(function (resp) { return resp.json(); }).then(showHold);
+}
+
+function holdImage() {
+    var minutes = document.getElementById("hold-minutes").value;
+    var body = minutes === "" ? {} : { minutes: parseFloat(minutes) };
+    fetch("/api/hold", { method: "POST", body: JSON.stringify(body) }).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        return resp.json().then(showHold);
+    });
+}
+
+function releaseImage() {
+    fetch("/api/hold", { method: "DELETE" }).then(refreshHold);
+}
+
+function refreshBlacklist() {
+    fetch("/api/blacklist").then(function (resp) { return resp.json(); }).then(function (images) {
+        var list = document.getElementById("blacklist-list");
+        list.innerHTML = "";
+        images.forEach(function (image) {
+            var item = document.createElement("li");
+            item.appendChild(document.createTextNode(image + " "));
+            var button = document.createElement("button");
+            button.textContent = t("admin.blacklist.showAgain");
+            button.onclick = function () {
+                fetch("/api/blacklist?image=" + encodeURIComponent(image), { method: "DELETE" }).then(refreshBlacklist);
+            };
+            item.appendChild(button);
+            list.appendChild(item);
+        });
+    });
+}
+
+function refreshPrivate() {
+    fetch("/api/private").then(function (resp) { return resp.json(); }).then(function (images) {
+        var list = document.getElementById("private-list");
+        list.innerHTML = "";
+        images.forEach(function (image) {
+            var item = document.createElement("li");
+            item.appendChild(document.createTextNode(image + " "));
+            var button = document.createElement("button");
+            button.textContent = t("admin.private.makePublic");
+            button.onclick = function () {
+                fetch("/api/private?image=" + encodeURIComponent(image), { method: "DELETE" }).then(refreshPrivate);
+            };
+            item.appendChild(button);
+            list.appendChild(item);
+        });
+    });
+}
+
+function refreshFavorites() {
+    fetch("/api/favorites").then(function (resp) { return resp.json(); }).then(function (images) {
+        var list = document.getElementById("favorites-list");
+        list.innerHTML = "";
+        images.forEach(function (image) {
+            var item = document.createElement("li");
+            item.appendChild(document.createTextNode(image + " "));
+            var button = document.createElement("button");
+            button.textContent = t("admin.favorites.unstar");
+            button.onclick = function () {
+                fetch("/api/favorites?image=" + encodeURIComponent(image), { method: "DELETE" }).then(refreshFavorites);
+            };
+            item.appendChild(button);
+            list.appendChild(item);
+        });
+    });
+}
+
+function starCurrent() {
+    fetch("/api/favorites", { method: "POST" }).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        refreshFavorites();
+    });
+}
+
+function blacklistCurrent() {
+    if (!confirm(t("admin.blacklist.confirm"))) {
+        return;
+    }
+    fetch("/api/blacklist", { method: "POST" }).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        refreshBlacklist();
+refreshPrivate();
+        setTimeout(refreshHistory, 500);
+    });
+}
+
+function makeCurrentPrivate() {
+    fetch("/api/private", { method: "POST" }).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        refreshPrivate();
+        refreshFavorites();
+        setTimeout(refreshHistory, 500);
+    });
+}
+
+function refreshProfiles() {
+    fetch("/api/profiles").then(function (resp) { return resp.json(); }).then(function (result) {
+        var select = document.getElementById("profile-select");
+        select.innerHTML = "";
+        [""].concat(result.profiles).forEach(function (name) {
+            var option = document.createElement("option");
+            option.value = name;
+            option.textContent = name || t("admin.profile.main");
+            option.selected = name === result.active;
+            select.appendChild(option);
+        });
+        document.getElementById("profile-status").textContent = result.active ?
+            t("admin.profile.using", result.active) : t("admin.profile.usingMain");
+    });
+}
+
+function switchProfile() {
+    var body = { profile: document.getElementById("profile-select").value };
+    fetch("/api/profiles", { method: "POST", body: JSON.stringify(body) }).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        refreshHistory();
+refreshProfiles();
+refreshSpeed();
+    });
+}
+
+function showSpeed(speed) {
+    var status = document.getElementById("speed-status");
+    if (!speed) {
+        status.textContent = t("admin.speed.normal");
+        return;
+    }
+    status.textContent = t("admin.speed.changed", speed.intervalSeconds, new Date(speed.until).toLocaleTimeString(language));
+}
+
+function refreshSpeed() {
+    fetch("/api/speed").then(function (resp) { return resp.json(); }).then(showSpeed);
+}
+
+function changeSpeed() {
+    var body = {
+        intervalSeconds: parseFloat(document.getElementById("speed-interval").value),
+        durationMinutes: parseFloat(document.getElementById("speed-duration").value),
+        rampSeconds: parseFloat(document.getElementById("speed-ramp").value)
+    };
+    fetch("/api/speed", { method: "POST", body: JSON.stringify(body) }).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        return resp.json().then(showSpeed);
+    });
+}
+
+function resetSpeed() {
+    fetch("/api/speed", { method: "DELETE" }).then(function () {
+        document.getElementById("speed-status").textContent = t("admin.speed.returning");
+    });
+}
+
+function previewSelection() {
+    var album = encodeURIComponent(document.getElementById("preview-album").value);
+    var count = encodeURIComponent(document.getElementById("preview-count").value);
+    fetch("/api/preview?count=" + count + "&album=" + album).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        return resp.json().then(function (result) {
+            var list = document.getElementById("preview-list");
+            list.innerHTML = "";
+            result.images.forEach(function (image) {
+                var item = document.createElement("li");
+                var link = document.createElement("a");
+                link.href = image.url;
+                link.target = "_blank";
+                link.textContent = image.path;
+                item.appendChild(link);
+                list.appendChild(item);
+            });
+            if (result.images.length === 0) {
+                var item = document.createElement("li");
+                item.textContent = t("admin.preview.none");
+                list.appendChild(item);
+            }
+        });
+    });
+}
+
+function loadAccessibility() {
+    var screen = encodeURIComponent(document.getElementById("aspect-screen").value);
+    fetch("/api/accessibility?screen=" + screen).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        return resp.json().then(function (setting) {
+            document.getElementById("accessibility-enabled").checked = setting.enabled;
+        });
+    });
+}
+
+function saveAccessibility() {
+    var screen = encodeURIComponent(document.getElementById("aspect-screen").value);
+    var body = { enabled: document.getElementById("accessibility-enabled").checked };
+    fetch("/api/accessibility?screen=" + screen, { method: "POST", body: JSON.stringify(body) }).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        alert(t("admin.accessibility.saved"));
+    });
+}
+
+function showEvent(event) {
+    var status = document.getElementById("event-status");
+    if (!event) {
+        status.textContent = t("admin.event.none");
+        return;
+    }
+    status.textContent = t("admin.event.running", event.imageCount, event.album, new Date(event.until).toLocaleString(language));
+}
+
+function refreshEvent() {
+    fetch("/api/event").then(function (resp) { return resp.json(); }).then(showEvent);
+}
+
+function startEvent() {
+    var body = {
+        album: document.getElementById("event-album").value,
+        intervalSeconds: parseInt(document.getElementById("event-interval").value, 10) || 0,
+        banner: document.getElementById("event-banner").value,
+        until: document.getElementById("event-until").value
+    };
+    fetch("/api/event", { method: "POST", body: JSON.stringify(body) }).then(function (resp) {
+        if (!resp.ok) {
+            return alertError(resp);
+        }
+        return resp.json().then(showEvent);
+    });
+}
+
+function stopEvent() {
+    fetch("/api/event", { method: "DELETE" }).then(refreshEvent);
+}
+
+refreshHistory();
+refreshHold();
+refreshBlacklist();
+refreshFavorites();
+refreshProfiles();
+refreshSpeed();
+refreshEvent();
+refreshHealth();
+loadSchema();
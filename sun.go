@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// LocationConfig is where the frame is, used to work out sunrise and sunset for times of day such as sunset+30m
+type LocationConfig struct {
+	Latitude  float64 `json:"latitude" desc:"Latitude in degrees, negative south of the equator"`
+	Longitude float64 `json:"longitude" desc:"Longitude in degrees, negative west of Greenwich"`
+}
+
+// dayTime is a time of day, either fixed or relative to sunrise or sunset so it follows the seasons
+type dayTime struct {
+	minute int           // minutes since midnight, for a fixed time
+	sun    string        // sunrise or sunset, empty for a fixed time
+	offset time.Duration // added to sunrise or sunset
+}
+
+// parseDayTime reads a HH:MM time, or sunrise or sunset with an optional offset such as sunset+30m or sunrise-1h
+func parseDayTime(value string) (dayTime, error) {
+	for _, sun := range []string{"sunrise", "sunset"} {
+		rest, ok := strings.CutPrefix(value, sun)
+		if !ok {
+			continue
+		}
+		t := dayTime{sun: sun}
+		if rest != "" {
+			offset, err := time.ParseDuration(rest)
+			if err != nil || rest[0] != '+' && rest[0] != '-' {
+				return dayTime{}, fmt.Errorf("invalid time %q, expected an offset such as %s+30m", value, sun)
+			}
+			t.offset = offset
+		}
+		return t, nil
+	}
+
+	minute, err := parseClockTime(value)
+	if err != nil {
+		return dayTime{}, fmt.Errorf("invalid time %q, expected HH:MM, sunrise or sunset", value)
+	}
+	return dayTime{minute: minute}, nil
+}
+
+// on returns the time in minutes since midnight on the day of now
+func (t dayTime) on(now time.Time, location *LocationConfig) (int, error) {
+	if t.sun == "" {
+		return t.minute, nil
+	}
+	if location == nil {
+		return 0, fmt.Errorf("%s needs location to be set", t.sun)
+	}
+	sunrise, sunset := sunTimes(location, now)
+	at := sunrise
+	if t.sun == "sunset" {
+		at = sunset
+	}
+	at = at.Add(t.offset).In(now.Location())
+	return at.Hour()*60 + at.Minute(), nil
+}
+
+// dayWindow returns the start and end of a window between two times of day, in minutes since midnight on the day
+// of now
+func dayWindow(start, end string, location *LocationConfig, now time.Time) (int, int, error) {
+	var minutes [2]int
+	for i, value := range []string{start, end} {
+		t, err := parseDayTime(value)
+		if err != nil {
+			return 0, 0, err
+		}
+		if minutes[i], err = t.on(now, location); err != nil {
+			return 0, 0, err
+		}
+	}
+	return minutes[0], minutes[1], nil
+}
+
+// sunTimes returns sunrise and sunset on the day of now, using the sunrise equation.  Where the sun doesn't set
+// all day the day runs from midnight to midnight, and where it doesn't rise sunrise and sunset are both at noon.
+func sunTimes(location *LocationConfig, now time.Time) (sunrise, sunset time.Time) {
+	const j2000 = 2451545.0 // Julian date of noon on 1 January 2000
+	rad := math.Pi / 180
+
+	noon := time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, now.Location())
+	julian := float64(noon.Unix())/86400 + 2440587.5
+	day := math.Round(julian - j2000 + 0.0008)
+
+	meanNoon := day - location.Longitude/360
+	anomaly := math.Mod(357.5291+0.98560028*meanNoon, 360)
+	center := 1.9148*math.Sin(anomaly*rad) + 0.0200*math.Sin(2*anomaly*rad) + 0.0003*math.Sin(3*anomaly*rad)
+	longitude := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := j2000 + meanNoon + 0.0053*math.Sin(anomaly*rad) - 0.0069*math.Sin(2*longitude*rad)
+	declination := math.Asin(math.Sin(longitude*rad) * math.Sin(23.4397*rad))
+
+	latitude := location.Latitude * rad
+	cosHourAngle := (math.Sin(-0.833*rad) - math.Sin(latitude)*math.Sin(declination)) / (math.Cos(latitude) * math.Cos(declination))
+	switch {
+	case cosHourAngle < -1:
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		return midnight, midnight.Add(24*time.Hour - time.Minute)
+	case cosHourAngle > 1:
+		return noon, noon
+	}
+	hourAngle := math.Acos(cosHourAngle) / rad
+
+	fromJulian := func(julian float64) time.Time {
+		return time.Unix(int64(math.Round((julian-2440587.5)*86400)), 0).In(now.Location())
+	}
+	return fromJulian(transit - hourAngle/360), fromJulian(transit + hourAngle/360)
+}
+
+// sunTimeProblems lists the times relative to sunrise or sunset in the config, which can't be worked out without a
+// location
+func sunTimeProblems(config *Config) []string {
+	if config.Location != nil {
+		return nil
+	}
+
+	var fields [][2]string // option name and value
+	if config.PowerSchedule != nil {
+		fields = append(fields, [2]string{"powerSchedule.onTime", config.PowerSchedule.OnTime}, [2]string{"powerSchedule.offTime", config.PowerSchedule.OffTime})
+	}
+	if config.QuietHours != nil {
+		fields = append(fields, [2]string{"quietHours.start", config.QuietHours.Start}, [2]string{"quietHours.end", config.QuietHours.End})
+	}
+	for i, schedule := range config.Schedules {
+		fields = append(fields, [2]string{fmt.Sprintf("schedules[%d].start", i), schedule.Start}, [2]string{fmt.Sprintf("schedules[%d].end", i), schedule.End})
+	}
+
+	var problems []string
+	for _, field := range fields {
+		if t, err := parseDayTime(field[1]); err == nil && t.sun != "" {
+			problems = append(problems, fmt.Sprintf("%s is relative to %s, which needs location to be set", field[0], t.sun))
+		}
+	}
+	return problems
+}
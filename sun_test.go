@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDayTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    dayTime
+		wantErr bool
+	}{
+		{value: "07:30", want: dayTime{minute: 7*60 + 30}},
+		{value: "00:00", want: dayTime{}},
+		{value: "23:59", want: dayTime{minute: 23*60 + 59}},
+		{value: "sunrise", want: dayTime{sun: "sunrise"}},
+		{value: "sunset", want: dayTime{sun: "sunset"}},
+		{value: "sunset+30m", want: dayTime{sun: "sunset", offset: 30 * time.Minute}},
+		{value: "sunrise-1h15m", want: dayTime{sun: "sunrise", offset: -75 * time.Minute}},
+		{value: "sunset30m", wantErr: true},
+		{value: "sunset+soon", wantErr: true},
+		{value: "24:00", wantErr: true},
+		{value: "7.30", wantErr: true},
+		{value: "noon", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseDayTime(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDayTime(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseDayTime(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSunTimes(t *testing.T) {
+	sydney := time.FixedZone("AEDT", 11*60*60)
+	tests := []struct {
+		name            string
+		location        LocationConfig
+		now             time.Time
+		sunrise, sunset time.Time
+	}{
+		{
+			name:     "London at midsummer",
+			location: LocationConfig{Latitude: 51.5, Longitude: -0.13},
+			now:      time.Date(2024, 6, 21, 9, 0, 0, 0, time.UTC),
+			sunrise:  time.Date(2024, 6, 21, 3, 43, 0, 0, time.UTC),
+			sunset:   time.Date(2024, 6, 21, 20, 21, 0, 0, time.UTC),
+		},
+		{
+			name:     "the equator at the equinox",
+			location: LocationConfig{},
+			now:      time.Date(2024, 3, 20, 9, 0, 0, 0, time.UTC),
+			sunrise:  time.Date(2024, 3, 20, 6, 4, 0, 0, time.UTC),
+			sunset:   time.Date(2024, 3, 20, 18, 11, 0, 0, time.UTC),
+		},
+		{
+			name:     "Sydney in its own time zone",
+			location: LocationConfig{Latitude: -33.87, Longitude: 151.21},
+			now:      time.Date(2024, 12, 21, 9, 0, 0, 0, sydney),
+			sunrise:  time.Date(2024, 12, 21, 5, 41, 0, 0, sydney),
+			sunset:   time.Date(2024, 12, 21, 20, 5, 0, 0, sydney),
+		},
+		{
+			name:     "midnight sun",
+			location: LocationConfig{Latitude: 69.65, Longitude: 18.96},
+			now:      time.Date(2024, 6, 21, 9, 0, 0, 0, time.UTC),
+			sunrise:  time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC),
+			sunset:   time.Date(2024, 6, 21, 23, 59, 0, 0, time.UTC),
+		},
+		{
+			name:     "polar night",
+			location: LocationConfig{Latitude: 69.65, Longitude: 18.96},
+			now:      time.Date(2024, 12, 21, 9, 0, 0, 0, time.UTC),
+			sunrise:  time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC),
+			sunset:   time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sunrise, sunset := sunTimes(&tt.location, tt.now)
+			// the sunrise equation is good to a minute or two
+			if diff := sunrise.Sub(tt.sunrise).Abs(); diff > 2*time.Minute {
+				t.Errorf("sunrise = %v, want %v", sunrise, tt.sunrise)
+			}
+			if diff := sunset.Sub(tt.sunset).Abs(); diff > 2*time.Minute {
+				t.Errorf("sunset = %v, want %v", sunset, tt.sunset)
+			}
+			if sunrise.Location() != tt.now.Location() {
+				t.Errorf("sunrise is in %v, want %v", sunrise.Location(), tt.now.Location())
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// defaultTheme is the look of the slideshow page when the config doesn't choose one
+const defaultTheme = "classic"
+
+var (
+	templateOverrideProblem      string     // last reported problem with the config's templatePath, so it is logged once
+	templateOverrideProblemMutex sync.Mutex // To ensure thread-safe access to `templateOverrideProblem`
+)
+
+// themeNames returns the names of the built in themes.  static/index.html is the slideshow page, and each theme is a
+// file in static/themes redefining the blocks of the page that make up its look.
+func themeNames() []string {
+	files, _ := staticFiles.ReadDir("static/themes")
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(file.Name(), ".html"))
+	}
+	return names
+}
+
+// pageTemplate parses the slideshow page with a theme's blocks in place of the page's own, writing its text from
+// the catalog.  An unknown theme, already reported when the config was checked, falls back to the default.
+func pageTemplate(theme string, messages *messageCatalog) (*template.Template, error) {
+	if !slices.Contains(themeNames(), theme) {
+		theme = defaultTheme
+	}
+	return template.New("index.html").Funcs(messages.funcs()).ParseFS(staticFiles,
+		"static/index.html", path.Join("static/themes", theme+".html"))
+}
+
+// pageTemplateFor returns the slideshow page for the config, the template at templatePath when one is set and the
+// embedded page otherwise.  The template is read on every page load so edits show on the next slide, and the
+// embedded page is used while it can't be read or parsed.
+func pageTemplateFor(config *Config, messages *messageCatalog) (*template.Template, error) {
+	if config.TemplatePath != "" {
+		tmplParsed, err := customPageTemplate(config.TemplatePath, config.Theme, messages)
+		reportTemplateOverride(config.TemplatePath, err)
+		if err == nil {
+			return tmplParsed, nil
+		}
+	}
+	return pageTemplate(config.Theme, messages)
+}
+
+// customPageTemplate parses the template at file in place of the embedded page, alongside the theme's blocks so
+// it can include them with {{template "theme-style" .}}
+func customPageTemplate(file, theme string, messages *messageCatalog) (*template.Template, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	tmplParsed, err := pageTemplate(theme, messages)
+	if err != nil {
+		return nil, err
+	}
+	return tmplParsed.New(filepath.Base(file)).Parse(string(content))
+}
+
+// reportTemplateOverride logs a problem with the template at templatePath when it first appears, and when it has
+// been fixed, rather than on every page load
+func reportTemplateOverride(file string, err error) {
+	problem := ""
+	if err != nil {
+		problem = err.Error()
+	}
+
+	templateOverrideProblemMutex.Lock()
+	defer templateOverrideProblemMutex.Unlock()
+	if problem == templateOverrideProblem {
+		return
+	}
+	if problem != "" {
+		log.Printf("Error loading the page template %s, showing the built in page: %v", file, err)
+	} else {
+		log.Printf("Showing the page template %s", file)
+	}
+	templateOverrideProblem = problem
+}
+
+// templateProblems reports a templatePath that can't be read or parsed
+func templateProblems(config *Config) []string {
+	if config.TemplatePath == "" {
+		return nil
+	}
+	if _, err := customPageTemplate(config.TemplatePath, config.Theme, messagesFor(config.Language)); err != nil {
+		return []string{fmt.Sprintf("templatePath can't be used, the built in page is shown instead: %v", err)}
+	}
+	return nil
+}
+
+// exifOverlayFor returns how the details of each photo are shown, the info theme shows them whether or not the
+// config asks for them, with coordinates rather than place names so the theme alone never looks anything up online
+func exifOverlayFor(config *Config) *ExifOverlayConfig {
+	if config.ExifOverlay == nil && config.Theme == "info" {
+		return &ExifOverlayConfig{ShowExposure: true, Location: "coordinates"}
+	}
+	return config.ExifOverlay
+}
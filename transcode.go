@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transcodeTimeout bounds how long an encoder may take over a single photo
+const transcodeTimeout = time.Minute
+
+// TranscodeConfig sends scaled down photos in smaller formats to browsers that accept them
+type TranscodeConfig struct {
+	Formats []string `json:"formats" desc:"Formats sent to browsers that accept them, the first one accepted is used" enum:"avif,webp"`
+	Quality int      `json:"quality" desc:"Quality of the transcoded photos, from 1 to 100" default:"75"`
+}
+
+// imageEncoder is an external command encoding a JPEG in another format
+type imageEncoder struct {
+	mimeType string
+	command  string
+	args     func(quality int, in, out string) []string
+}
+
+// imageEncoders are the formats photos can be transcoded to, by their name in the config
+var imageEncoders = map[string]imageEncoder{
+	"avif": {"image/avif", "avifenc", func(quality int, in, out string) []string {
+		return []string{"-q", strconv.Itoa(quality), in, out}
+	}},
+	"webp": {"image/webp", "cwebp", func(quality int, in, out string) []string {
+		return []string{"-quiet", "-q", strconv.Itoa(quality), in, "-o", out}
+	}},
+}
+
+// acceptedFormat returns the first of the configured formats the request accepts and can be encoded, empty to send
+// a JPEG
+func acceptedFormat(r *http.Request, cfg *TranscodeConfig) string {
+	accepted := map[string]bool{}
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil || params["q"] != "" && strings.Trim(params["q"], "0.") == "" {
+			continue
+		}
+		accepted[mediaType] = true
+	}
+	for _, format := range cfg.Formats {
+		format = strings.ToLower(format)
+		encoder, ok := imageEncoders[format]
+		if !ok || !accepted[encoder.mimeType] {
+			continue
+		}
+		if _, err := exec.LookPath(encoder.command); err == nil {
+			return format
+		}
+	}
+	return ""
+}
+
+// transcode returns the copy in format, encoding it from the JPEG copy, which must already be cached, when it isn't
+// cached or is out of date.  It is cached alongside the JPEG and counts towards the same size limit.
+func (c *resizedCopy) transcode(format string, quality int, cacheMB int64) (string, error) {
+	target := strings.TrimSuffix(c.cached, ".jpg") + "." + format
+	if info, err := os.Stat(target); err == nil && !info.ModTime().Before(c.modTime) {
+		now := time.Now()
+		os.Chtimes(target, now, now)
+		return target, nil
+	}
+
+	if quality <= 0 || quality > 100 {
+		quality = 75
+	}
+	encoder := imageEncoders[format]
+	partial := strings.TrimSuffix(c.cached, ".jpg") + ".partial." + format
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, encoder.command, encoder.args(quality, c.cached, partial)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(partial)
+		return "", fmt.Errorf("%s failed: %v: %s", encoder.command, err, strings.TrimSpace(string(output)))
+	}
+	info, err := os.Stat(partial)
+	if err == nil {
+		err = os.Rename(partial, target)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	addToResizeCache(info.Size(), cacheMB)
+	return target, nil
+}
+
+// transcodeProblems reports formats that can't be sent as their encoder isn't installed
+func transcodeProblems(config *Config) []string {
+	if config.Transcode == nil {
+		return nil
+	}
+	var problems []string
+	if config.MaxImageWidth <= 0 && config.MaxImageHeight <= 0 {
+		problems = append(problems, "transcode only affects scaled down photos, set maxImageWidth or maxImageHeight")
+	}
+	for _, format := range config.Transcode.Formats {
+		if encoder, ok := imageEncoders[strings.ToLower(format)]; ok {
+			if _, err := exec.LookPath(encoder.command); err != nil {
+				problems = append(problems, fmt.Sprintf("transcode to %s needs %s to be installed", format, encoder.command))
+			}
+		}
+	}
+	return problems
+}
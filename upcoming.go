@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"randompic/client"
+)
+
+var (
+	upcomingSlide      slide
+	upcomingSlideMutex sync.Mutex // To ensure thread-safe access to `upcomingSlide`
+)
+
+// setUpcomingSlide records the slide the updater will show next
+func setUpcomingSlide(s slide) {
+	upcomingSlideMutex.Lock()
+	defer upcomingSlideMutex.Unlock()
+	upcomingSlide = s
+}
+
+// getUpcomingSlide returns the slide the updater will show next, empty before the first slide
+func getUpcomingSlide() slide {
+	upcomingSlideMutex.Lock()
+	defer upcomingSlideMutex.Unlock()
+	return upcomingSlide
+}
+
+// stillShowable reports whether an image chosen ahead of time can still be shown, it may have been deleted,
+// marked private or blacklisted since
+func stillShowable(file string, config *Config) bool {
+	if file == "" {
+		return true
+	}
+	if isPrivate(file, config.ImageDirectory) || isBlacklisted(file, config.ImageDirectory) || isProblemFile(file) {
+		return false
+	}
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// upcomingHandler returns the slide that will be shown next, so a browser or other display can load it early
+func upcomingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	upcoming := getUpcomingSlide()
+	if upcoming.Image == "" || !stillShowable(upcoming.Image, config) {
+		http.Error(w, "The next slide hasn't been chosen", http.StatusConflict)
+		return
+	}
+
+	entry := newHistoryEntry(upcoming, config)
+	resp := client.Upcoming{Image: entry.Image, URL: entry.URL, Kind: entry.Kind}
+	if at := getNextSlideAt(); !at.IsZero() && !rotationPaused.Load() {
+		resp.At = &at
+	}
+	writeJSON(w, resp)
+}
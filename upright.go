@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+const (
+	uprightCacheDir    = "./randompic-upright"
+	uprightJPEGQuality = 90
+)
+
+// uprightImageURL returns the URL the page shows a photo from when correctOrientation is set: the turned copy for a
+// photo whose EXIF orientation says it is stored sideways, upside down or mirrored, the photo itself otherwise
+func uprightImageURL(file, imageDirectory string) string {
+	if meta, err := imageMetadataCache().get(file); err != nil || meta.Orientation <= 1 {
+		return imageURL(file, imageDirectory)
+	}
+	return "/upright?path=" + url.QueryEscape(relativeImagePath(file, imageDirectory))
+}
+
+// uprightImageHandler serves a photo from the image directory turned the way its EXIF orientation says it is
+// meant to be seen, for browsers that ignore the tag.  The copy has no EXIF data so browsers that do honour the tag
+// don't turn it a second time.  Copies are cached on disk and made again when the photo changes, photos that are
+// already upright are served as they are.
+func uprightImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		http.Error(w, "Error loading config: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error loading config: %v", err)
+		return
+	}
+
+	relative := r.URL.Query().Get("path")
+	if relative == "" {
+		http.Error(w, "The path parameter is required", http.StatusBadRequest)
+		return
+	}
+	file := imageDirectoryFile(config.ImageDirectory, relative)
+	if isPrivate(file, config.ImageDirectory) {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	if exifOrientation(file) <= 1 {
+		if !browserShowable(file) {
+			serveConverted(w, r, file, config.ImageDirectory)
+		} else {
+			setImageCacheHeaders(w, info, "")
+			http.ServeFile(w, r, file)
+		}
+		return
+	}
+
+	setImageCacheHeaders(w, info, "upright")
+	w.Header().Set("Content-Type", "image/jpeg")
+	cached := imageCopyPath(uprightCacheDir, relativeImagePath(file, config.ImageDirectory))
+	if cachedInfo, err := os.Stat(cached); err == nil && !cachedInfo.ModTime().Before(info.ModTime()) {
+		http.ServeFile(w, r, cached)
+		return
+	}
+
+	upright, err := uprightImage(file)
+	if err != nil {
+		http.Error(w, "Error reading image: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error turning %s upright: %v", file, err)
+		quarantineFile(file, "upright", err)
+		return
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, upright, &jpeg.Options{Quality: uprightJPEGQuality}); err != nil {
+		http.Error(w, "Error encoding image: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("Error encoding the upright copy of %s: %v", file, err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(cached), 0o755); err == nil {
+		if err := os.WriteFile(cached, encoded.Bytes(), 0o644); err != nil {
+			log.Printf("Error caching the upright copy of %s: %v", file, err)
+		}
+	}
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(encoded.Bytes()))
+}
+
+// uprightImage decodes a photo at full size, turned the way its EXIF orientation says
+func uprightImage(file string) (*image.RGBA, error) {
+	width, height, err := probeDimensions(file)
+	if err != nil {
+		return nil, err
+	}
+	return renderFrame(file, width, height, "contain")
+}
+
+// exifOrientation returns the EXIF orientation of a photo, 1 (upright) when it has none
+func exifOrientation(file string) int {
+	if exif, err := readExif(file); err == nil && exif.Orientation >= 1 && exif.Orientation <= 8 {
+		return exif.Orientation
+	}
+	return 1
+}
+
+// orientImage turns and mirrors img the way the EXIF orientation says the photo is meant to be seen.  Orientations
+// 5 to 8 turn the photo on its side, swapping its width and height.
+func orientImage(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	outWidth, outHeight := width, height
+	if orientation >= 5 {
+		outWidth, outHeight = height, width
+	}
+
+	// source returns the pixel of img shown at x, y of the turned image
+	source := func(x, y int) (int, int) {
+		switch orientation {
+		case 2: // mirrored
+			return width - 1 - x, y
+		case 3: // upside down
+			return width - 1 - x, height - 1 - y
+		case 4: // mirrored upside down
+			return x, height - 1 - y
+		case 5: // mirrored and turned a quarter anticlockwise
+			return y, x
+		case 6: // turned a quarter anticlockwise, so it is turned clockwise to show
+			return y, height - 1 - x
+		case 7: // mirrored and turned a quarter clockwise
+			return width - 1 - y, height - 1 - x
+		default: // 8, turned a quarter clockwise, so it is turned anticlockwise to show
+			return width - 1 - y, x
+		}
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+	for y := range outHeight {
+		for x := range outWidth {
+			sx, sy := source(x, y)
+			i := img.PixOffset(img.Rect.Min.X+sx, img.Rect.Min.Y+sy)
+			copy(out.Pix[out.PixOffset(x, y):out.PixOffset(x, y)+4], img.Pix[i:i+4])
+		}
+	}
+	return out
+}
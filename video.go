@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultVideoMaxSeconds is how long a clip plays at most when the config doesn't say
+const defaultVideoMaxSeconds = 60
+
+// videoExtensions are the clips played in the slideshow, MP4 and QuickTime files which browsers can play
+var videoExtensions = []string{".m4v", ".mov", ".mp4"}
+
+// isVideo reports whether a file is a video clip rather than a photo
+func isVideo(file string) bool {
+	return slices.Contains(videoExtensions, strings.ToLower(filepath.Ext(file)))
+}
+
+// mp4Box is the header of a box of an MP4 or QuickTime file
+type mp4Box struct {
+	kind         string
+	start, end   int64 // of the box's contents
+	headerLength int64
+}
+
+// readMP4Box reads the header of the box at offset, fileSize bounding a box that runs to the end of the file
+func readMP4Box(f *os.File, offset, fileSize int64) (mp4Box, error) {
+	header := make([]byte, 16)
+	if _, err := f.ReadAt(header[:8], offset); err != nil {
+		return mp4Box{}, err
+	}
+	box := mp4Box{kind: string(header[4:8]), headerLength: 8}
+	size := int64(binary.BigEndian.Uint32(header))
+	switch size {
+	case 0: // the box runs to the end of the file
+		size = fileSize - offset
+	case 1: // the size follows the type as 64 bits
+		if _, err := f.ReadAt(header[8:], offset+8); err != nil {
+			return mp4Box{}, err
+		}
+		size = int64(binary.BigEndian.Uint64(header[8:]))
+		box.headerLength = 16
+	}
+	if size < box.headerLength || offset+size > fileSize {
+		return mp4Box{}, errors.New("invalid box size")
+	}
+	box.start, box.end = offset+box.headerLength, offset+size
+	return box, nil
+}
+
+// findMP4Box returns the first box of a kind between start and end
+func findMP4Box(f *os.File, start, end, fileSize int64, kind string) (mp4Box, bool) {
+	for offset := start; offset+8 <= end; {
+		box, err := readMP4Box(f, offset, fileSize)
+		if err != nil {
+			return mp4Box{}, false
+		}
+		if box.kind == kind {
+			return box, true
+		}
+		offset = box.end
+	}
+	return mp4Box{}, false
+}
+
+// probeVideo reads the dimensions and length of a clip from its movie header and the header of its video track,
+// without reading the video itself
+func probeVideo(file string) (width, height int, length time.Duration, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	// the movie box is at the start of files made for streaming, and after the video data otherwise
+	moov, ok := findMP4Box(f, 0, info.Size(), info.Size(), "moov")
+	if !ok {
+		return 0, 0, 0, errors.New("no movie header found")
+	}
+	if mvhd, ok := findMP4Box(f, moov.start, moov.end, info.Size(), "mvhd"); ok {
+		header := make([]byte, 32)
+		if n, _ := f.ReadAt(header, mvhd.start); n >= 20 {
+			var timescale, duration uint64
+			if header[0] == 1 && n >= 32 { // version 1 has 64 bit times
+				timescale, duration = uint64(binary.BigEndian.Uint32(header[20:])), binary.BigEndian.Uint64(header[24:])
+			} else {
+				timescale, duration = uint64(binary.BigEndian.Uint32(header[12:])), uint64(binary.BigEndian.Uint32(header[16:]))
+			}
+			if timescale > 0 {
+				length = time.Duration(duration * uint64(time.Second) / timescale)
+			}
+		}
+	}
+
+	// the track header ends with its width and height, which are 0 for the audio track
+	for offset := moov.start; offset+8 <= moov.end; {
+		trak, ok := findMP4Box(f, offset, moov.end, info.Size(), "trak")
+		if !ok {
+			break
+		}
+		offset = trak.end
+		tkhd, ok := findMP4Box(f, trak.start, trak.end, info.Size(), "tkhd")
+		if !ok || tkhd.end-tkhd.start < 8 {
+			continue
+		}
+		size := make([]byte, 8)
+		if _, err := f.ReadAt(size, tkhd.end-8); err != nil && err != io.EOF {
+			continue
+		}
+		width, height = int(binary.BigEndian.Uint32(size)>>16), int(binary.BigEndian.Uint32(size[4:])>>16)
+		if width > 0 && height > 0 {
+			break
+		}
+	}
+	return width, height, length, nil
+}
+
+// videoInterval returns how long a clip is shown: until it ends, or at most videoMaxSeconds
+func videoInterval(file string, config *Config) time.Duration {
+	limit := time.Duration(config.VideoMaxSeconds) * time.Second
+	if limit <= 0 {
+		limit = defaultVideoMaxSeconds * time.Second
+	}
+	meta, err := imageMetadataCache().get(file)
+	if err != nil || meta.VideoMs <= 0 {
+		return limit
+	}
+	return min(time.Duration(meta.VideoMs)*time.Millisecond, limit)
+}
+
+// slideInterval returns how long a slide is shown, the interval for a photo but the length of a clip or, when
+// animations are extended, at least a whole loop of an animation
+func slideInterval(interval time.Duration, file string, config *Config) time.Duration {
+	if file != "" && isVideo(file) {
+		return videoInterval(file, config)
+	}
+	return animatedInterval(interval, file, config)
+}
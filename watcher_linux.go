@@ -0,0 +1,212 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchEvents are the inotify events that add or remove images from the library
+const watchEvents = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_DELETE_SELF
+
+// watchSettleDelay batches changes together so a sync of many photos results in a single library update
+const watchSettleDelay = 2 * time.Second
+
+// directoryWatcher keeps the library up to date using inotify watches on every directory of the image tree
+type directoryWatcher struct {
+	fd    int
+	mu    sync.Mutex
+	paths map[int]string // watch descriptor to directory
+
+	added       map[string]bool
+	removed     map[string]bool
+	removedDirs map[string]bool
+	timer       *time.Timer
+}
+
+// watchImageDirectory starts watching the image directory and applies changes to the library incrementally
+func watchImageDirectory(root string) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+
+	w := &directoryWatcher{
+		fd:          fd,
+		paths:       map[int]string{},
+		added:       map[string]bool{},
+		removed:     map[string]bool{},
+		removedDirs: map[string]bool{},
+	}
+	if err := w.addTree(root, false); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	log.Printf("Watching %d directories for changes", len(w.paths))
+
+	go w.run()
+	return nil
+}
+
+// addTree watches dir and all of its sub directories, queueing the files found when scanFiles is set
+func (w *directoryWatcher) addTree(dir string, scanFiles bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			if scanFiles {
+				w.queue(path, true)
+			}
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, watchEvents)
+		if err != nil {
+			log.Printf("Unable to watch %s: %v", path, err)
+			return nil
+		}
+		w.mu.Lock()
+		w.paths[wd] = path
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+// run reads inotify events until the watcher fails
+func (w *directoryWatcher) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			log.Printf("Directory watcher stopped: %v", err)
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			w.handle(int(event.Wd), event.Mask, name)
+		}
+	}
+}
+
+// handle processes a single inotify event
+func (w *directoryWatcher) handle(wd int, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		// events were lost, fall back to a full rescan
+		log.Println("Directory watcher event queue overflowed, rescanning")
+		go rescanLibrary()
+		return
+	}
+
+	w.mu.Lock()
+	dir, ok := w.paths[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+	}
+	w.mu.Unlock()
+	if !ok || name == "" {
+		return
+	}
+	path := filepath.Join(dir, name)
+
+	switch {
+	case mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		// a new directory may already contain files by the time the watch is added
+		if err := w.addTree(path, true); err != nil {
+			log.Printf("Unable to watch %s: %v", path, err)
+		}
+	case mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		w.mu.Lock()
+		w.removedDirs[path] = true
+		w.mu.Unlock()
+		w.schedule()
+	case mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0:
+		w.queue(path, true)
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		w.queue(path, false)
+	}
+}
+
+// queue records a file being added or removed and schedules the library update
+func (w *directoryWatcher) queue(path string, added bool) {
+	w.mu.Lock()
+	if added {
+		w.added[path] = true
+		delete(w.removed, path)
+	} else {
+		w.removed[path] = true
+		delete(w.added, path)
+	}
+	w.mu.Unlock()
+	w.schedule()
+}
+
+// schedule applies the queued changes once no further events have arrived for watchSettleDelay
+func (w *directoryWatcher) schedule() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(watchSettleDelay, w.flush)
+}
+
+// flush filters the queued files and applies them to the library
+func (w *directoryWatcher) flush() {
+	w.mu.Lock()
+	var added, removed, removedDirs []string
+	for path := range w.added {
+		added = append(added, path)
+	}
+	for path := range w.removed {
+		removed = append(removed, path)
+	}
+	for path := range w.removedDirs {
+		removedDirs = append(removedDirs, path)
+	}
+	w.added = map[string]bool{}
+	w.removed = map[string]bool{}
+	w.removedDirs = map[string]bool{}
+	w.mu.Unlock()
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		log.Printf("Failed to load configuration: %v", err)
+		return
+	}
+
+	// files that were removed again before the update are skipped
+	var existing []string
+	for _, path := range added {
+		if _, err := os.Stat(path); err == nil {
+			existing = append(existing, path)
+		}
+	}
+
+	// modified files that no longer pass the filters are removed
+	included := filterImages(existing, config)
+	for _, path := range existing {
+		if !contains(included, path) {
+			removed = append(removed, path)
+		}
+	}
+	addToLibrary(included, removed, removedDirs, config)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// watchImageDirectory is only supported on linux
+func watchImageDirectory(root string) error {
+	return fmt.Errorf("watching the image directory is only supported on linux")
+}
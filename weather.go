@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWeatherRefresh = 30 * time.Minute
+	minWeatherRefresh     = 10 * time.Minute // both providers only update their readings every 10 to 15 minutes
+	weatherRetry          = 5 * time.Minute
+	weatherStaleAfter     = 3 // refresh intervals after which a reading that couldn't be updated is hidden
+)
+
+// WeatherConfig shows the current temperature and conditions over the photo, fetched by the server so screens never
+// call the weather service themselves
+type WeatherConfig struct {
+	Provider       string `json:"provider" desc:"Weather service to fetch the conditions from" enum:"openmeteo,openweathermap" required:"true"`
+	APIKey         string `json:"apiKey" desc:"API key for openweathermap, not needed with openmeteo" secret:"true"`
+	Units          string `json:"units" desc:"Show the temperature in Celsius (metric) or Fahrenheit (imperial)" default:"metric" enum:"metric,imperial"`
+	RefreshMinutes int    `json:"refreshMinutes" desc:"Minutes between fetches of the current conditions, at least 10" default:"30"`
+	Position       string `json:"position" desc:"Corner of the screen the weather is shown in" default:"top-right" enum:"top-left,top-right,bottom-left,bottom-right"`
+}
+
+// weatherReport is the current conditions as read from a weather service
+type weatherReport struct {
+	Temperature float64 // in the configured units
+	Condition   string  // one of the keys of weatherIcons
+	Night       bool
+	FetchedAt   time.Time
+}
+
+// weatherProvider is implemented by each supported weather service
+type weatherProvider interface {
+	current(ctx context.Context, location *LocationConfig, imperial bool) (weatherReport, error)
+}
+
+// newWeatherProvider returns the weather service implementation for the configured provider
+func newWeatherProvider(cfg *WeatherConfig) (weatherProvider, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "openmeteo":
+		return openMeteo{}, nil
+	case "openweathermap":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("weather apiKey is not set")
+		}
+		return openWeatherMap{apiKey: cfg.APIKey}, nil
+	default:
+		return nil, fmt.Errorf("unsupported weather provider %q", cfg.Provider)
+	}
+}
+
+var (
+	currentWeather      *weatherReport
+	currentWeatherMutex sync.Mutex // To ensure thread-safe access to `currentWeather`
+)
+
+var weatherHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// weatherRefresh returns the time between fetches of the current conditions
+func weatherRefresh(cfg *WeatherConfig) time.Duration {
+	if cfg.RefreshMinutes <= 0 {
+		return defaultWeatherRefresh
+	}
+	return max(time.Duration(cfg.RefreshMinutes)*time.Minute, minWeatherRefresh)
+}
+
+// runWeather fetches the current conditions each refresh interval for as long as the app runs, retrying sooner
+// when the weather service can't be reached
+func runWeather(cfg *WeatherConfig, location *LocationConfig) {
+	provider, err := newWeatherProvider(cfg)
+	if err != nil {
+		log.Printf("Weather disabled: %v", err)
+		return
+	}
+	if location == nil {
+		log.Printf("Weather disabled: it needs location to be set")
+		return
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), weatherHTTPClient.Timeout)
+		report, err := provider.current(ctx, location, cfg.Units == "imperial")
+		cancel()
+		if err != nil {
+			log.Printf("Error fetching the weather from %s: %v", cfg.Provider, err)
+			time.Sleep(min(weatherRetry, weatherRefresh(cfg)))
+			continue
+		}
+
+		report.FetchedAt = time.Now()
+		currentWeatherMutex.Lock()
+		currentWeather = &report
+		currentWeatherMutex.Unlock()
+		time.Sleep(weatherRefresh(cfg))
+	}
+}
+
+// weatherOverlay is the weather the page shows over the photo
+type weatherOverlay struct {
+	Position    string // class placing the weather on screen
+	Temperature string // rounded, with its unit
+	Icon        string
+	Description string
+}
+
+// weatherOverlayFor returns the weather to show on the page, nil until the conditions have been fetched or once
+// they are too old to show
+func weatherOverlayFor(cfg *WeatherConfig, messages *messageCatalog) *weatherOverlay {
+	currentWeatherMutex.Lock()
+	report := currentWeather
+	currentWeatherMutex.Unlock()
+	if report == nil || time.Since(report.FetchedAt) > weatherStaleAfter*weatherRefresh(cfg) {
+		return nil
+	}
+
+	overlay := &weatherOverlay{Position: cfg.Position, Description: messages.text("weather." + report.Condition)}
+	switch overlay.Position {
+	case "top-left", "top-right", "bottom-left", "bottom-right":
+	default:
+		overlay.Position = "top-right"
+	}
+	unit := "°C"
+	if cfg.Units == "imperial" {
+		unit = "°F"
+	}
+	overlay.Temperature = fmt.Sprintf("%d%s", int(math.Round(report.Temperature)), unit)
+
+	icons := weatherIcons[report.Condition]
+	overlay.Icon = icons[0]
+	if report.Night && icons[1] != "" {
+		overlay.Icon = icons[1]
+	}
+	return overlay
+}
+
+// weatherIcons maps each condition to its icon by day and by night, empty by night when the day icon is used for
+// both
+var weatherIcons = map[string][2]string{
+	"clear":         {"☀️", "🌙"},
+	"partly-cloudy": {"⛅", "☁️"},
+	"cloudy":        {"☁️", ""},
+	"fog":           {"🌫️", ""},
+	"drizzle":       {"🌦️", "🌧️"},
+	"rain":          {"🌧️", ""},
+	"snow":          {"🌨️", ""},
+	"thunderstorm":  {"⛈️", ""},
+}
+
+// weatherProblems lists the weather settings that can't work as configured
+func weatherProblems(config *Config) []string {
+	if config.Weather == nil {
+		return nil
+	}
+	var problems []string
+	if config.Location == nil {
+		problems = append(problems, "weather needs location to be set")
+	}
+	if strings.EqualFold(config.Weather.Provider, "openweathermap") && config.Weather.APIKey == "" {
+		problems = append(problems, "weather.apiKey must be set to use openweathermap")
+	}
+	return problems
+}
+
+// weatherGet fetches a weather service's JSON response into reply
+func weatherGet(ctx context.Context, rawURL string, reply any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := weatherHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("weather service returned status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// openMeteo reads the current conditions from Open-Meteo, which needs no API key
+type openMeteo struct{}
+
+func (openMeteo) current(ctx context.Context, location *LocationConfig, imperial bool) (weatherReport, error) {
+	query := url.Values{
+		"latitude":  {fmt.Sprint(location.Latitude)},
+		"longitude": {fmt.Sprint(location.Longitude)},
+		"current":   {"temperature_2m,weather_code,is_day"},
+	}
+	if imperial {
+		query.Set("temperature_unit", "fahrenheit")
+	}
+
+	var reply struct {
+		Current *struct {
+			Temperature float64 `json:"temperature_2m"`
+			WeatherCode int     `json:"weather_code"`
+			IsDay       int     `json:"is_day"`
+		} `json:"current"`
+	}
+	if err := weatherGet(ctx, "https://api.open-meteo.com/v1/forecast?"+query.Encode(), &reply); err != nil {
+		return weatherReport{}, err
+	}
+	if reply.Current == nil {
+		return weatherReport{}, fmt.Errorf("response has no current conditions")
+	}
+
+	return weatherReport{
+		Temperature: reply.Current.Temperature,
+		Condition:   wmoCondition(reply.Current.WeatherCode),
+		Night:       reply.Current.IsDay == 0,
+	}, nil
+}
+
+// wmoCondition returns the condition for a WMO weather code, as used by Open-Meteo
+func wmoCondition(code int) string {
+	switch {
+	case code == 0:
+		return "clear"
+	case code <= 2:
+		return "partly-cloudy"
+	case code == 3:
+		return "cloudy"
+	case code == 45 || code == 48:
+		return "fog"
+	case code >= 51 && code <= 57:
+		return "drizzle"
+	case code >= 61 && code <= 67, code >= 80 && code <= 82:
+		return "rain"
+	case code >= 71 && code <= 77, code == 85 || code == 86:
+		return "snow"
+	case code >= 95:
+		return "thunderstorm"
+	default:
+		return "cloudy"
+	}
+}
+
+// openWeatherMap reads the current conditions from OpenWeatherMap's current weather API
+type openWeatherMap struct {
+	apiKey string
+}
+
+func (p openWeatherMap) current(ctx context.Context, location *LocationConfig, imperial bool) (weatherReport, error) {
+	query := url.Values{
+		"lat":   {fmt.Sprint(location.Latitude)},
+		"lon":   {fmt.Sprint(location.Longitude)},
+		"appid": {p.apiKey},
+		"units": {"metric"},
+	}
+	if imperial {
+		query.Set("units", "imperial")
+	}
+
+	var reply struct {
+		Weather []struct {
+			ID   int    `json:"id"`
+			Icon string `json:"icon"`
+		} `json:"weather"`
+		Main struct {
+			Temp float64 `json:"temp"`
+		} `json:"main"`
+	}
+	if err := weatherGet(ctx, "https://api.openweathermap.org/data/2.5/weather?"+query.Encode(), &reply); err != nil {
+		return weatherReport{}, err
+	}
+	if len(reply.Weather) == 0 {
+		return weatherReport{}, fmt.Errorf("response has no current conditions")
+	}
+
+	// condition codes are grouped by hundreds, see https://openweathermap.org/weather-conditions
+	weather := reply.Weather[0]
+	condition := "cloudy"
+	switch id := weather.ID; {
+	case id >= 200 && id < 300:
+		condition = "thunderstorm"
+	case id >= 300 && id < 400:
+		condition = "drizzle"
+	case id >= 500 && id < 600:
+		condition = "rain"
+	case id >= 600 && id < 700:
+		condition = "snow"
+	case id >= 700 && id < 800:
+		condition = "fog"
+	case id == 800:
+		condition = "clear"
+	case id == 801 || id == 802:
+		condition = "partly-cloudy"
+	}
+	return weatherReport{
+		Temperature: reply.Main.Temp,
+		Condition:   condition,
+		Night:       strings.HasSuffix(weather.Icon, "n"),
+	}, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the client's key to prove the server understood the handshake (RFC 6455 section 1.3)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	websocketOpText  = 0x1
+	websocketOpClose = 0x8
+	websocketOpPing  = 0x9
+	websocketOpPong  = 0xA
+)
+
+const (
+	websocketWriteTimeout = 10 * time.Second
+	websocketPingInterval = 30 * time.Second // keeps idle connections open through proxies and finds dead ones
+	websocketMaxFrame     = 64 << 10         // browsers only send control frames, anything larger is an error
+)
+
+// websocketConn is a server side WebSocket connection, only ever sending text messages
+type websocketConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	send    chan []byte
+	closed  chan struct{}
+	once    sync.Once
+	writeMu sync.Mutex // To ensure frames are written one at a time
+}
+
+// upgradeWebSocket completes the WebSocket handshake and takes over the connection
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("not a WebSocket request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(hash[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocketConn{
+		conn:   conn,
+		reader: rw.Reader,
+		send:   make(chan []byte, slideListenerQueue),
+		closed: make(chan struct{}),
+	}, nil
+}
+
+// headerContains reports whether a comma separated header includes a token, ignoring case
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeFrame sends one unfragmented frame, servers never mask their frames
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readFrame reads one frame from the browser, unmasking its payload
+func (c *websocketConn) readFrame() (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > websocketMaxFrame {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", length)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+// close shuts the connection, safe to call more than once
+func (c *websocketConn) close() {
+	c.once.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// readLoop answers pings and closes from the browser until the connection ends, ignoring anything else it sends
+func (c *websocketConn) readLoop() {
+	defer c.close()
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case websocketOpClose:
+			c.writeFrame(websocketOpClose, payload[:min(len(payload), 2)])
+			return
+		case websocketOpPing:
+			if err := c.writeFrame(websocketOpPong, payload); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeLoop sends queued messages and keeps the connection alive with pings
+func (c *websocketConn) writeLoop() {
+	defer c.close()
+	ping := time.NewTicker(websocketPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case message := <-c.send:
+			if err := c.writeFrame(websocketOpText, message); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := c.writeFrame(websocketOpPing, nil); err != nil {
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// websocketHandler pushes a message to the browser each time the slide changes, so every screen swaps at once
+// rather than each reloading on its own timer
+func websocketHandler(w http.ResponseWriter, r *http.Request) {
+	album, ok := listenerAlbum(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		http.Error(w, "Error opening WebSocket: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	addSlideListener(conn.send, album, conn.close)
+	defer removeSlideListener(conn.send)
+
+	go conn.writeLoop()
+	conn.readLoop()
+}